@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// AuthClient calls the MFA login server, which checks a password and then a
+// one-time password printed on its console
+type AuthClient struct {
+	caller *caller
+}
+
+// NewAuthClient creates a client for the login server at baseURL
+func NewAuthClient(baseURL string, options Options) *AuthClient {
+	return &AuthClient{caller: newCaller(baseURL, options)}
+}
+
+// credentialsRequest is the body of /api/register and /api/login
+type credentialsRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// verifyOTPRequest is the body of /api/verify-otp
+type verifyOTPRequest struct {
+	Username string `json:"username"`
+	OTP      string `json:"otp"`
+}
+
+// result is the {"success", "message"} answer of the login and document servers
+type result struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// Register creates a user
+func (c *AuthClient) Register(ctx context.Context, username, password string) error {
+	return c.post(ctx, "/api/register", credentialsRequest{Username: username, Password: password})
+}
+
+// Login checks the password and makes the server issue a one-time password for VerifyOTP
+func (c *AuthClient) Login(ctx context.Context, username, password string) error {
+	return c.post(ctx, "/api/login", credentialsRequest{Username: username, Password: password})
+}
+
+// VerifyOTP completes a login with the one-time password
+func (c *AuthClient) VerifyOTP(ctx context.Context, username, otp string) error {
+	return c.post(ctx, "/api/verify-otp", verifyOTPRequest{Username: username, OTP: otp})
+}
+
+// post sends a call that changes server state, so it is never retried
+func (c *AuthClient) post(ctx context.Context, path string, body interface{}) error {
+	// Refusals come back as 400 or 401 with the reason in message
+	var resp result
+	if _, err := c.caller.do(ctx, call{method: http.MethodPost, path: path, json: body}, &resp); err != nil {
+		return err
+	}
+	if !resp.Success {
+		return refusal(http.StatusOK, resp.Message)
+	}
+	return nil
+}
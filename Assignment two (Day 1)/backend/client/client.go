@@ -0,0 +1,223 @@
+// Package client is a typed Go SDK for the servers in this repository: the
+// MFA login server (AuthClient), this JWT server (TokenClient), the document
+// access server (DocumentClient) and the SSO mock (SSOClient). Every call
+// takes a context, idempotent calls are retried with backoff, calls that need
+// a token get one from a TokenSource that refreshes it, and failures come back
+// as *Error values that errors.Is can match against ErrUnauthorized and friends.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options configures a client. The zero value is usable.
+type Options struct {
+	// HTTPClient sends the requests; a client with a 30 second timeout when nil
+	HTTPClient *http.Client
+
+	// MaxRetries is how often an idempotent call is retried after a network
+	// error, 429 or a 5xx other than 501; 3 when zero, and no retries when negative
+	MaxRetries int
+
+	// MinBackoff and MaxBackoff bound the exponential backoff between retries
+	// (200ms and 5s when zero). A Retry-After header overrides it.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// TokenSource supplies the bearer token for calls that need one
+	TokenSource TokenSource
+
+	// AdminKey is sent in X-Admin-Key by TokenClient's admin calls
+	AdminKey string
+}
+
+// caller sends requests to one server on behalf of the typed clients
+type caller struct {
+	baseURL string
+	options Options
+}
+
+// newCaller fills in the option defaults
+func newCaller(baseURL string, options Options) *caller {
+	if options.HTTPClient == nil {
+		options.HTTPClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	if options.MaxRetries == 0 {
+		options.MaxRetries = 3
+	}
+	if options.MinBackoff == 0 {
+		options.MinBackoff = 200 * time.Millisecond
+	}
+	if options.MaxBackoff == 0 {
+		options.MaxBackoff = 5 * time.Second
+	}
+	return &caller{baseURL: strings.TrimSuffix(baseURL, "/"), options: options}
+}
+
+// call describes one API request
+type call struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+
+	// json or form is the request body
+	json interface{}
+	form url.Values
+
+	// idempotent calls may be retried
+	idempotent bool
+
+	// auth sends the token source's token; a 401 refreshes it and tries once more
+	auth bool
+}
+
+// do sends the call and decodes a successful JSON response into out, which may
+// be nil. It returns the response headers, e.g. for an ETag.
+func (c *caller) do(ctx context.Context, req call, out interface{}) (http.Header, error) {
+	var body []byte
+	contentType := ""
+	switch {
+	case req.json != nil:
+		data, err := json.Marshal(req.json)
+		if err != nil {
+			return nil, fmt.Errorf("encoding %s %s request: %w", req.method, req.path, err)
+		}
+		body, contentType = data, "application/json"
+	case req.form != nil:
+		body, contentType = []byte(req.form.Encode()), "application/x-www-form-urlencoded"
+	}
+
+	refreshed := false
+	for attempt := 0; ; attempt++ {
+		token, err := c.token(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.send(ctx, req, token, body, contentType)
+		if err == nil && resp.StatusCode == http.StatusUnauthorized && req.auth && !refreshed {
+			// The token may have been revoked or rotated early; fetch a new one
+			// once. Only the rejected token is dropped, so concurrent calls that
+			// were refused together share the one refresh.
+			if invalidator, ok := c.options.TokenSource.(interface{ Invalidate(token string) }); ok {
+				drain(resp)
+				invalidator.Invalidate(token)
+				refreshed = true
+				attempt--
+				continue
+			}
+		}
+
+		if req.idempotent && attempt < c.options.MaxRetries && retryable(resp, err) && ctx.Err() == nil {
+			wait := c.backoff(attempt, resp)
+			if resp != nil {
+				drain(resp)
+			}
+			select {
+			case <-time.After(wait):
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return resp.Header, decodeError(resp)
+		}
+		if out != nil && resp.StatusCode != http.StatusNoContent {
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+				return resp.Header, fmt.Errorf("decoding %s %s response: %w", req.method, req.path, err)
+			}
+		}
+		return resp.Header, nil
+	}
+}
+
+// token gets the bearer token for a call that needs one
+func (c *caller) token(ctx context.Context, req call) (string, error) {
+	if !req.auth {
+		return "", nil
+	}
+	if c.options.TokenSource == nil {
+		return "", fmt.Errorf("%s %s needs a token, but the client has no TokenSource", req.method, req.path)
+	}
+	token, err := c.options.TokenSource.Token(ctx)
+	if err != nil {
+		return "", fmt.Errorf("getting a token for %s %s: %w", req.method, req.path, err)
+	}
+	return token, nil
+}
+
+// send makes one attempt at the call, with token when it needs one
+func (c *caller) send(ctx context.Context, req call, token string, body []byte, contentType string) (*http.Response, error) {
+	target := c.baseURL + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range req.header {
+		httpReq.Header[name] = values
+	}
+	if contentType != "" {
+		httpReq.Header.Set("Content-Type", contentType)
+	}
+	httpReq.Header.Set("Accept", "application/json")
+
+	if req.auth {
+		httpReq.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return c.options.HTTPClient.Do(httpReq)
+}
+
+// retryable reports whether an attempt failed in a way worth retrying
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented
+}
+
+// backoff returns how long to wait before the next attempt: the server's
+// Retry-After when it sent one, otherwise exponential backoff with full jitter
+func (c *caller) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			return min(time.Duration(seconds)*time.Second, c.options.MaxBackoff)
+		}
+	}
+	ceiling := min(c.options.MinBackoff<<attempt, c.options.MaxBackoff)
+	return c.options.MinBackoff/2 + rand.N(ceiling-c.options.MinBackoff/2+1)
+}
+
+// drain discards the rest of a response so its connection can be reused
+func drain(resp *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+}
+
+// adminHeader carries the admin key for the token server's /admin endpoints
+func (c *caller) adminHeader() http.Header {
+	return http.Header{"X-Admin-Key": {c.options.AdminKey}}
+}
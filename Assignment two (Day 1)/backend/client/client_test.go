@@ -0,0 +1,344 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/handlers"
+	"jwt-auth-system/backend/middleware"
+	"jwt-auth-system/backend/repo"
+	"jwt-auth-system/backend/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testServer runs the real handlers of this server's user routes
+type testServer struct {
+	*httptest.Server
+	users *repo.UserRepository
+
+	// fail, when set, answers a request instead of the handlers; returning
+	// false passes the request on
+	fail func(w http.ResponseWriter, r *http.Request) bool
+	mu   sync.Mutex
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	key, err := services.GenerateSigningKey(services.AlgHS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := repo.NewUserRepository()
+	permissions := services.DefaultPermissionCatalog()
+	attributes := services.DefaultAttributeSchema()
+	versions := services.NewTokenVersions(users, repo.NewServiceAccountRepository(), 0)
+	config := services.DefaultTokenConfig()
+	config.Versions, config.Roles, config.Attributes = versions, permissions, attributes
+	tokens := services.NewJWTServiceWithConfig(services.NewKeyring(key, config.MaxLifetime()), config)
+	credentials := services.NewCredentials("test")
+
+	authHandler := handlers.NewAuthHandler(tokens, users, permissions, attributes, credentials, nil, false)
+	userHandler := handlers.NewUserHandler(users, versions, permissions, attributes, credentials, repo.NewAuditLog())
+	authenticator := middleware.NewAuthenticator(tokens, middleware.Options{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/register", authHandler.RegisterUser)
+	mux.HandleFunc("/generate", authHandler.GenerateToken)
+	mux.HandleFunc("/validate", authHandler.ValidateToken)
+	mux.HandleFunc("/me", authenticator.Authenticate(authHandler.Me))
+	mux.HandleFunc("/users/{username}", authenticator.Authenticate(userHandler.User))
+	mux.HandleFunc("/users/{username}/otp", authenticator.Authenticate(authenticator.RequireACR("mfa")(userHandler.EnrollOTP)))
+
+	server := &testServer{users: users}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		fail := server.fail
+		server.mu.Unlock()
+		if fail != nil && fail(w, r) {
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// failFirst makes the server answer the first n requests with status and
+// counts every request it receives
+func (s *testServer) failFirst(n int, status int, retryAfter string) *atomic.Int32 {
+	var requests atomic.Int32
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = func(w http.ResponseWriter, r *http.Request) bool {
+		if requests.Add(1) > int32(n) {
+			return false
+		}
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		http.Error(w, http.StatusText(status), status)
+		return true
+	}
+	return &requests
+}
+
+// register creates a user with a password and the given role
+func (s *testServer) register(t *testing.T, client *TokenClient, username, role string) {
+	t.Helper()
+
+	if _, err := client.Register(context.Background(), domain.RegisterRequest{
+		Username:   username,
+		Password:   "correct horse battery",
+		Attributes: domain.Attributes{"designation": "dev", "age": 30},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.users.UpdateUser(username, func(user *domain.User) error {
+		user.Role = role
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// token generates a token for a user registered with register
+func token(t *testing.T, client *TokenClient, username, scope string) string {
+	t.Helper()
+
+	resp, err := client.GenerateToken(context.Background(), domain.GenerateRequest{Username: username, Password: "correct horse battery", Scope: scope})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Token
+}
+
+// fastRetries retries quickly enough for tests
+var fastRetries = Options{MinBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+
+func TestRetries(t *testing.T) {
+	server := newTestServer(t)
+	setup := NewTokenClient(server.URL, Options{})
+	server.register(t, setup, "alice", "user")
+	aliceToken := token(t, setup, "alice", "")
+
+	tests := []struct {
+		name       string
+		failures   int
+		status     int
+		retryAfter string
+		maxRetries int
+		call       func(client *TokenClient) error
+		requests   int32
+		want       error
+	}{
+		{name: "503 is retried", failures: 2, status: http.StatusServiceUnavailable, requests: 3},
+		{name: "500 is retried", failures: 1, status: http.StatusInternalServerError, requests: 2},
+		{name: "429 is retried after Retry-After", failures: 1, status: http.StatusTooManyRequests, retryAfter: "0", requests: 2},
+		{name: "501 is not retried", failures: 1, status: http.StatusNotImplemented, requests: 1, want: ErrServer},
+		{name: "retries give up", failures: 10, status: http.StatusBadGateway, maxRetries: 2, requests: 3, want: ErrServer},
+		{name: "retries can be turned off", failures: 1, status: http.StatusTooManyRequests, maxRetries: -1, requests: 1, want: ErrRateLimited},
+		{name: "a non-idempotent call is not retried", failures: 1, status: http.StatusServiceUnavailable, requests: 1, want: ErrServer,
+			call: func(client *TokenClient) error {
+				_, err := client.GenerateToken(context.Background(), domain.GenerateRequest{Username: "alice", Password: "correct horse battery"})
+				return err
+			}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := fastRetries
+			options.MaxRetries = test.maxRetries
+			options.TokenSource = StaticToken(aliceToken)
+			client := NewTokenClient(server.URL, options)
+			call := test.call
+			if call == nil {
+				call = func(client *TokenClient) error {
+					claims, err := client.Me(context.Background())
+					if err == nil && claims.Username != "alice" {
+						t.Errorf("Me = %s, want alice", claims.Username)
+					}
+					return err
+				}
+			}
+
+			requests := server.failFirst(test.failures, test.status, test.retryAfter)
+			if err := call(client); !errors.Is(err, test.want) || (test.want == nil && err != nil) {
+				t.Errorf("error = %v, want %v", err, test.want)
+			}
+			if got := requests.Load(); got != test.requests {
+				t.Errorf("server got %d requests, want %d", got, test.requests)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := newCaller("http://localhost", Options{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second})
+	retryAfter := func(value string) *http.Response {
+		return &http.Response{Header: http.Header{"Retry-After": {value}}}
+	}
+
+	if wait := c.backoff(0, retryAfter("0")); wait != 0 {
+		t.Errorf("backoff with Retry-After: 0 = %s, want 0", wait)
+	}
+	if wait := c.backoff(0, retryAfter("30")); wait != time.Second {
+		t.Errorf("backoff with Retry-After: 30 = %s, want MaxBackoff", wait)
+	}
+	for attempt := 0; attempt < 6; attempt++ {
+		ceiling := min(100*time.Millisecond<<attempt, time.Second)
+		for i := 0; i < 50; i++ {
+			if wait := c.backoff(attempt, nil); wait < 50*time.Millisecond || wait > ceiling {
+				t.Fatalf("backoff(%d) = %s, want between 50ms and %s", attempt, wait, ceiling)
+			}
+		}
+	}
+}
+
+func TestRefreshOnUnauthorizedIsSingleFlight(t *testing.T) {
+	server := newTestServer(t)
+	setup := NewTokenClient(server.URL, Options{})
+	server.register(t, setup, "alice", "user")
+
+	var fetches atomic.Int32
+	source := NewRefreshingTokenSource(func(ctx context.Context) (string, time.Time, error) {
+		fetches.Add(1)
+		resp, err := setup.GenerateToken(ctx, domain.GenerateRequest{Username: "alice", Password: "correct horse battery"})
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		return resp.Token, time.Time{}, nil
+	})
+	options := fastRetries
+	options.TokenSource = source
+	client := NewTokenClient(server.URL, options)
+	if _, err := client.Me(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Logging the user out rejects the cached token on every call at once
+	if _, err := server.users.RevokeUserTokens("alice"); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.Me(context.Background()); err != nil {
+				t.Errorf("Me after the refresh: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := fetches.Load(); got != 2 {
+		t.Errorf("token fetched %d times, want 2 (the first token and one refresh)", got)
+	}
+}
+
+func TestRefreshKeepsNewerToken(t *testing.T) {
+	tokens := []string{"first", "second", "third"}
+	var fetches int
+	source := NewRefreshingTokenSource(func(context.Context) (string, time.Time, error) {
+		fetches++
+		return tokens[fetches-1], time.Time{}, nil
+	})
+
+	first, _ := source.Token(context.Background())
+	source.Invalidate(first)
+	second, _ := source.Token(context.Background())
+	// A call that was refused with the first token must not drop the second
+	source.Invalidate(first)
+	if token, _ := source.Token(context.Background()); token != second || fetches != 2 {
+		t.Errorf("Token = %q after %d fetches, want %q after 2", token, fetches, second)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	server := newTestServer(t)
+	setup := NewTokenClient(server.URL, Options{})
+	server.register(t, setup, "alice", "user")
+	server.register(t, setup, "bob", "user")
+	server.register(t, setup, "root", "admin")
+	aliceToken := token(t, setup, "alice", "")
+	adminToken := token(t, setup, "root", "users:read users:write")
+
+	as := func(token string) *TokenClient {
+		options := fastRetries
+		options.TokenSource = StaticToken(token)
+		return NewTokenClient(server.URL, options)
+	}
+	alice, admin := as(aliceToken), as(adminToken)
+
+	tests := []struct {
+		name      string
+		call      func() error
+		want      error
+		status    int
+		challenge string
+	}{
+		{"invalid token", func() error {
+			_, err := as("not-a-token").Me(context.Background())
+			return err
+		}, ErrUnauthorized, http.StatusUnauthorized, `Bearer realm="jwt-auth-system", error="invalid_token"`},
+		{"another user without users:read", func() error {
+			_, _, err := alice.GetUser(context.Background(), "bob")
+			return err
+		}, ErrForbidden, http.StatusForbidden, ""},
+		{"unknown user", func() error {
+			_, _, err := admin.GetUser(context.Background(), "carol")
+			return err
+		}, ErrNotFound, http.StatusNotFound, ""},
+		{"user taken", func() error {
+			_, err := setup.Register(context.Background(), domain.RegisterRequest{
+				Username:   "alice",
+				Password:   "another password",
+				Attributes: domain.Attributes{"designation": "dev", "age": 30},
+			})
+			return err
+		}, ErrConflict, http.StatusConflict, ""},
+		{"stale ETag", func() error {
+			_, etag, err := alice.GetUser(context.Background(), "alice")
+			if err != nil {
+				return err
+			}
+			// Someone else changes the user in between
+			if _, err := server.users.UpdateUser("alice", func(user *domain.User) error {
+				user.Attributes["designation"] = "lead"
+				return nil
+			}); err != nil {
+				return err
+			}
+			_, _, err = alice.UpdateUser(context.Background(), "alice", etag, domain.UpdateUserRequest{Attributes: domain.Attributes{"designation": "dev"}})
+			return err
+		}, ErrPreconditionFailed, http.StatusPreconditionFailed, ""},
+		{"step-up", func() error {
+			_, err := alice.EnrollOTP(context.Background(), "alice")
+			return err
+		}, ErrStepUpRequired, http.StatusUnauthorized, `error="insufficient_user_authentication"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.call()
+			if !errors.Is(err, test.want) {
+				t.Fatalf("error = %v, want %v", err, test.want)
+			}
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("error %v is not an *Error", err)
+			}
+			if apiErr.StatusCode != test.status {
+				t.Errorf("status = %d, want %d", apiErr.StatusCode, test.status)
+			}
+			if !strings.Contains(apiErr.Challenge, test.challenge) {
+				t.Errorf("challenge = %q, want it to contain %q", apiErr.Challenge, test.challenge)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// DocumentClient calls the document access server, which lets owners and
+// admins read documents
+type DocumentClient struct {
+	caller *caller
+}
+
+// NewDocumentClient creates a client for the document server at baseURL
+func NewDocumentClient(baseURL string, options Options) *DocumentClient {
+	return &DocumentClient{caller: newCaller(baseURL, options)}
+}
+
+// accessRequest is the body of /api/access
+type accessRequest struct {
+	Username   string `json:"username"`
+	DocumentID int    `json:"documentId"`
+}
+
+// accessResponse is the answer of /api/access
+type accessResponse struct {
+	result
+	Content string `json:"content,omitempty"`
+}
+
+// Access returns the content of a document the user may read. Unknown users
+// and documents match ErrNotFound, and other people's documents ErrForbidden.
+func (c *DocumentClient) Access(ctx context.Context, username string, documentID int) (string, error) {
+	var resp accessResponse
+	req := call{method: http.MethodPost, path: "/api/access", json: accessRequest{Username: username, DocumentID: documentID}, idempotent: true}
+	if _, err := c.caller.do(ctx, req, &resp); err != nil {
+		return "", err
+	}
+	if !resp.Success {
+		return "", refusal(http.StatusOK, resp.Message)
+	}
+	return resp.Content, nil
+}
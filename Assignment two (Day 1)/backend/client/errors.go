@@ -0,0 +1,131 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Kinds of failure that errors.Is matches an *Error against
+var (
+	ErrBadRequest         = errors.New("bad request")
+	ErrUnauthorized       = errors.New("unauthorized")
+	ErrForbidden          = errors.New("forbidden")
+	ErrNotFound           = errors.New("not found")
+	ErrConflict           = errors.New("conflict")
+	ErrPreconditionFailed = errors.New("precondition failed")
+	ErrRateLimited        = errors.New("rate limited")
+	ErrServer             = errors.New("server error")
+
+	// ErrStepUpRequired means the user must authenticate again, at a higher
+	// level or more recently, before retrying (RFC 9470)
+	ErrStepUpRequired = errors.New("step-up authentication required")
+)
+
+// Error is a failed API call, decoded from whichever error format the server uses
+type Error struct {
+	// StatusCode is the HTTP status; servers that answer failures with 200 and
+	// "success": false keep their 200 here
+	StatusCode int
+
+	// Code and Description are the OAuth or Bearer error and error_description
+	Code        string
+	Description string
+
+	// Message is the body of a plain-text or {"message": ...} error
+	Message string
+
+	// Challenge is the WWW-Authenticate header, which says what a retry needs
+	Challenge string
+
+	kind error
+}
+
+// Error describes the failure
+func (e *Error) Error() string {
+	detail := e.Message
+	switch {
+	case e.Code != "" && e.Description != "":
+		detail = e.Code + ": " + e.Description
+	case e.Code != "":
+		detail = e.Code
+	case detail == "":
+		detail = http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("%d %s", e.StatusCode, detail)
+}
+
+// Is matches the kind of failure, e.g. errors.Is(err, ErrNotFound)
+func (e *Error) Is(target error) bool {
+	if target == ErrStepUpRequired {
+		return e.Code == "insufficient_user_authentication"
+	}
+	return target == e.kind
+}
+
+// decodeError reads an error response
+func decodeError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	e := &Error{
+		StatusCode: resp.StatusCode,
+		Challenge:  resp.Header.Get("WWW-Authenticate"),
+		kind:       kindOf(resp.StatusCode),
+	}
+
+	var body struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+		Message     string `json:"message"`
+	}
+	if json.Unmarshal(data, &body) == nil {
+		e.Code, e.Description, e.Message = body.Error, body.Description, body.Message
+	} else {
+		e.Message = strings.TrimSpace(string(data))
+	}
+	return e
+}
+
+// refusal reports a {"success": false, "message": ...} answer, which the
+// login and document servers send instead of an error status. The message
+// decides the kind, since the status does not.
+func refusal(status int, message string) error {
+	kind := kindOf(status)
+	lower := strings.ToLower(message)
+	switch {
+	case strings.Contains(lower, "not found"):
+		kind = ErrNotFound
+	case strings.Contains(lower, "denied"):
+		kind = ErrForbidden
+	case strings.Contains(lower, "invalid request"):
+		kind = ErrBadRequest
+	case kind == nil:
+		kind = ErrBadRequest
+	}
+	return &Error{StatusCode: status, Message: message, kind: kind}
+}
+
+// kindOf maps a status to its kind of failure
+func kindOf(status int) error {
+	switch {
+	case status == http.StatusBadRequest:
+		return ErrBadRequest
+	case status == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case status == http.StatusForbidden:
+		return ErrForbidden
+	case status == http.StatusNotFound:
+		return ErrNotFound
+	case status == http.StatusConflict:
+		return ErrConflict
+	case status == http.StatusPreconditionFailed:
+		return ErrPreconditionFailed
+	case status == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case status >= 500:
+		return ErrServer
+	}
+	return nil
+}
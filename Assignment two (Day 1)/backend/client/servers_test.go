@@ -0,0 +1,441 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The login, document and SSO servers are separate modules, so these test
+// servers reproduce their handlers: the same routes, status codes and bodies.
+
+// loginServer reproduces the MFA login server of Assignment one (Day 1)
+type loginServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	passwords map[string]string
+	otps      map[string]string
+	expiry    map[string]time.Time
+}
+
+func newLoginServer(t *testing.T) *loginServer {
+	t.Helper()
+
+	server := &loginServer{passwords: map[string]string{}, otps: map[string]string{}, expiry: map[string]time.Time{}}
+	respond := func(w http.ResponseWriter, status int, success bool, message string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(result{Success: success, Message: message})
+	}
+	type loginBody struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		OTP      string `json:"otp"`
+	}
+	handle := func(serve func(w http.ResponseWriter, req loginBody)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			var req loginBody
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				respond(w, http.StatusBadRequest, false, "Invalid request body")
+				return
+			}
+			server.mu.Lock()
+			defer server.mu.Unlock()
+			serve(w, req)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/register", handle(func(w http.ResponseWriter, req loginBody) {
+		switch _, exists := server.passwords[req.Username]; {
+		case len(req.Password) < 6:
+			respond(w, http.StatusBadRequest, false, "password must be at least 6 characters")
+		case exists:
+			respond(w, http.StatusBadRequest, false, "user already exists")
+		default:
+			server.passwords[req.Username] = req.Password
+			respond(w, http.StatusOK, true, "User registered successfully")
+		}
+	}))
+	mux.HandleFunc("/api/login", handle(func(w http.ResponseWriter, req loginBody) {
+		if stored, exists := server.passwords[req.Username]; !exists || stored != req.Password {
+			respond(w, http.StatusUnauthorized, false, "Invalid credentials")
+			return
+		}
+		server.otps[req.Username] = "123456"
+		server.expiry[req.Username] = time.Now().Add(5 * time.Minute)
+		respond(w, http.StatusOK, true, "Password verified. OTP sent to terminal.")
+	}))
+	mux.HandleFunc("/api/verify-otp", handle(func(w http.ResponseWriter, req loginBody) {
+		otp, exists := server.otps[req.Username]
+		switch {
+		case !exists:
+			respond(w, http.StatusUnauthorized, false, "invalid OTP")
+			return
+		case time.Now().After(server.expiry[req.Username]):
+			delete(server.otps, req.Username)
+			respond(w, http.StatusUnauthorized, false, "OTP has expired")
+			return
+		case otp != req.OTP:
+			respond(w, http.StatusUnauthorized, false, "invalid OTP")
+			return
+		}
+		delete(server.otps, req.Username)
+		respond(w, http.StatusOK, true, "Login successful!")
+	}))
+
+	server.Server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// otp returns the one-time password the server printed for username
+func (s *loginServer) otp(username string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.otps[username]
+}
+
+// expire makes the pending one-time password of username out of date
+func (s *loginServer) expire(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expiry[username] = time.Now().Add(-time.Second)
+}
+
+func TestAuthClient(t *testing.T) {
+	server := newLoginServer(t)
+	client := NewAuthClient(server.URL, fastRetries)
+	ctx := context.Background()
+
+	if err := client.Register(ctx, "alice", "secret1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		call func() error
+		want error
+	}{
+		{"short password", func() error { return client.Register(ctx, "bob", "short") }, ErrBadRequest},
+		{"existing user", func() error { return client.Register(ctx, "alice", "secret1") }, ErrBadRequest},
+		{"wrong password", func() error { return client.Login(ctx, "alice", "wrong-password") }, ErrUnauthorized},
+		{"unknown user", func() error { return client.Login(ctx, "mallory", "secret1") }, ErrUnauthorized},
+		{"OTP before logging in", func() error { return client.VerifyOTP(ctx, "alice", "123456") }, ErrUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.call(); !errors.Is(err, test.want) {
+				t.Errorf("error = %v, want %v", err, test.want)
+			}
+		})
+	}
+
+	t.Run("login with a one-time password", func(t *testing.T) {
+		if err := client.Login(ctx, "alice", "secret1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.VerifyOTP(ctx, "alice", "000000"); !errors.Is(err, ErrUnauthorized) {
+			t.Errorf("VerifyOTP with a wrong OTP = %v, want %v", err, ErrUnauthorized)
+		}
+		otp := server.otp("alice")
+		if err := client.VerifyOTP(ctx, "alice", otp); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.VerifyOTP(ctx, "alice", otp); !errors.Is(err, ErrUnauthorized) {
+			t.Errorf("VerifyOTP with a used OTP = %v, want %v", err, ErrUnauthorized)
+		}
+	})
+
+	t.Run("expired one-time password", func(t *testing.T) {
+		if err := client.Login(ctx, "alice", "secret1"); err != nil {
+			t.Fatal(err)
+		}
+		server.expire("alice")
+		var apiErr *Error
+		err := client.VerifyOTP(ctx, "alice", server.otp("alice"))
+		if !errors.As(err, &apiErr) || !errors.Is(err, ErrUnauthorized) || apiErr.Message != "OTP has expired" {
+			t.Errorf("VerifyOTP with an expired OTP = %v, want 401 OTP has expired", err)
+		}
+	})
+}
+
+// newDocumentServer reproduces the document access server of Assignment one
+// (Day 2), which answers every request with 200, and counts the requests
+func newDocumentServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	roles := map[string]string{"userA": "user", "userB": "user", "admin": "admin"}
+	documents := map[int]struct{ owner, content string }{
+		1: {"userA", "A's secret"},
+		2: {"userB", "B's secret"},
+		3: {"userA", "Another secret from A"},
+	}
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path != "/api/access" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+
+		var req accessRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			json.NewEncoder(w).Encode(result{Message: "Invalid request body"})
+			return
+		}
+		role, exists := roles[req.Username]
+		if !exists {
+			json.NewEncoder(w).Encode(result{Message: "User not found!"})
+			return
+		}
+		document, exists := documents[req.DocumentID]
+		if !exists {
+			json.NewEncoder(w).Encode(result{Message: "Document not found!"})
+			return
+		}
+		if role != "admin" && document.owner != req.Username {
+			json.NewEncoder(w).Encode(result{Message: "Access Denied"})
+			return
+		}
+		json.NewEncoder(w).Encode(accessResponse{result: result{Success: true, Message: "Access Granted!"}, Content: document.content})
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestDocumentClient(t *testing.T) {
+	server, requests := newDocumentServer(t)
+	client := NewDocumentClient(server.URL, fastRetries)
+
+	tests := []struct {
+		name       string
+		username   string
+		documentID int
+		content    string
+		want       error
+	}{
+		{"owner", "userA", 3, "Another secret from A", nil},
+		{"admin reads any document", "admin", 2, "B's secret", nil},
+		{"someone else's document", "userB", 1, "", ErrForbidden},
+		{"unknown user", "mallory", 1, "", ErrNotFound},
+		{"unknown document", "userA", 9, "", ErrNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests.Store(0)
+			content, err := client.Access(context.Background(), test.username, test.documentID)
+			if !errors.Is(err, test.want) || (test.want == nil && err != nil) {
+				t.Fatalf("Access error = %v, want %v", err, test.want)
+			}
+			if content != test.content {
+				t.Errorf("Access = %q, want %q", content, test.content)
+			}
+			// Refusals arrive with 200, and are answers rather than failures to retry
+			var apiErr *Error
+			if errors.As(err, &apiErr) && apiErr.StatusCode != http.StatusOK {
+				t.Errorf("StatusCode = %d, want the server's 200", apiErr.StatusCode)
+			}
+			if got := requests.Load(); got != 1 {
+				t.Errorf("server got %d requests, want 1", got)
+			}
+		})
+	}
+}
+
+// newSSOServer reproduces the SSO mock of Assignment two (Day 2), and counts
+// the requests
+func newSSOServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var (
+		mu    sync.Mutex
+		codes = map[string]string{}
+		next  int
+	)
+	encode := func(w http.ResponseWriter, body interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	}
+	segment := func(value interface{}) string {
+		data, _ := json.Marshal(value)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	wellFormed := func(token string) bool {
+		parts := strings.Split(token, ".")
+		if len(parts) != 3 {
+			return false
+		}
+		for _, part := range parts {
+			if _, err := base64.RawURLEncoding.DecodeString(part); part == "" || err != nil {
+				return false
+			}
+		}
+		return true
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/auth/redirect", func(w http.ResponseWriter, r *http.Request) {
+		encode(w, SSORedirect{Message: "Redirecting to Identity Provider...", RedirectURL: "/login.html"})
+	})
+	mux.HandleFunc("POST /api/auth/login", func(w http.ResponseWriter, r *http.Request) {
+		var creds credentialsRequest
+		if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if creds.Username == "" || creds.Password == "" {
+			http.Error(w, "Username and password required", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		next++
+		code := "code" + strings.Repeat("x", next)
+		codes[code] = creds.Username
+		mu.Unlock()
+		encode(w, AuthCode{Message: "Credentials validated successfully", Code: code, ExpiresIn: 300})
+	})
+	mux.HandleFunc("POST /api/auth/token", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Code string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		// Codes are single use
+		mu.Lock()
+		username, exists := codes[req.Code]
+		delete(codes, req.Code)
+		mu.Unlock()
+		if !exists {
+			http.Error(w, "invalid authorization code", http.StatusUnauthorized)
+			return
+		}
+		header := segment(map[string]string{"alg": "HS256", "typ": "JWT"})
+		claims := map[string]interface{}{"sub": "user_1", "email": username + "@example.com", "name": username, "exp": time.Now().Add(time.Hour).Unix()}
+		signature := segment("signature")
+		encode(w, SSOTokens{
+			IDToken:     header + "." + segment(claims) + "." + signature,
+			AccessToken: header + "." + segment(claims) + "." + signature,
+			ExpiresIn:   3600,
+			TokenType:   "Bearer",
+		})
+	})
+	mux.HandleFunc("POST /api/auth/verify", func(w http.ResponseWriter, r *http.Request) {
+		var req SSOTokens
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		var verification SSOVerification
+		verification.Verified = true
+		check := func(name string, passed bool) {
+			status := "passed"
+			if !passed {
+				status, verification.Verified = "failed", false
+			}
+			verification.Checks = append(verification.Checks, struct {
+				Check   string `json:"check"`
+				Status  string `json:"status"`
+				Message string `json:"message,omitempty"`
+			}{Check: name, Status: status})
+		}
+		check("Token not empty", req.IDToken != "" && req.AccessToken != "")
+		check("ID Token JWT structure", wellFormed(req.IDToken))
+		check("Access Token JWT structure", wellFormed(req.AccessToken))
+		verification.Message = "Token Verified Successfully"
+		if !verification.Verified {
+			verification.Message = "Invalid Token"
+		}
+		encode(w, verification)
+	})
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestSSOClient(t *testing.T) {
+	server, requests := newSSOServer(t)
+	client := NewSSOClient(server.URL, fastRetries)
+	ctx := context.Background()
+
+	redirect, err := client.Redirect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redirect.RedirectURL != "/login.html" {
+		t.Errorf("RedirectURL = %q, want /login.html", redirect.RedirectURL)
+	}
+
+	code, err := client.Login(ctx, "alice", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code.Code == "" || code.ExpiresIn != 300 {
+		t.Errorf("Login = %+v", code)
+	}
+	tokens, err := client.ExchangeCode(ctx, code.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.TokenType != "Bearer" || tokens.IDToken == "" || tokens.AccessToken == "" {
+		t.Errorf("ExchangeCode = %+v", tokens)
+	}
+	verification, err := client.VerifyTokens(ctx, tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verification.Verified || len(verification.Checks) == 0 {
+		t.Errorf("VerifyTokens = %+v, want verified", verification)
+	}
+
+	t.Run("a used code is refused once", func(t *testing.T) {
+		requests.Store(0)
+		if _, err := client.ExchangeCode(ctx, code.Code); !errors.Is(err, ErrUnauthorized) {
+			t.Errorf("ExchangeCode with a used code = %v, want %v", err, ErrUnauthorized)
+		}
+		if got := requests.Load(); got != 1 {
+			t.Errorf("server got %d requests, want 1", got)
+		}
+	})
+
+	t.Run("missing password", func(t *testing.T) {
+		if _, err := client.Login(ctx, "alice", ""); !errors.Is(err, ErrBadRequest) {
+			t.Errorf("Login without a password = %v, want %v", err, ErrBadRequest)
+		}
+	})
+
+	t.Run("malformed tokens are a failed check, not an error", func(t *testing.T) {
+		verification, err := client.VerifyTokens(ctx, &SSOTokens{IDToken: "not-a-jwt", AccessToken: tokens.AccessToken})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if verification.Verified || verification.Message != "Invalid Token" {
+			t.Errorf("VerifyTokens = %+v, want unverified", verification)
+		}
+	})
+}
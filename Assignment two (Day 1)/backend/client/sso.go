@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// SSOClient calls the SSO mock, which walks through the authorization code
+// flow: redirect to the identity provider, log in for a code, exchange the
+// code for tokens and verify them
+type SSOClient struct {
+	caller *caller
+}
+
+// NewSSOClient creates a client for the SSO server at baseURL
+func NewSSOClient(baseURL string, options Options) *SSOClient {
+	return &SSOClient{caller: newCaller(baseURL, options)}
+}
+
+// SSORedirect tells the browser where the identity provider's login page is
+type SSORedirect struct {
+	Message     string `json:"message"`
+	RedirectURL string `json:"redirect_url"`
+}
+
+// AuthCode is a one-time authorization code for ExchangeCode
+type AuthCode struct {
+	Message   string `json:"message"`
+	Code      string `json:"auth_code"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+// SSOTokens are the tokens an authorization code is exchanged for
+type SSOTokens struct {
+	IDToken     string `json:"id_token"`
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+	TokenType   string `json:"token_type"`
+}
+
+// SSOVerification is the result of each check VerifyTokens made
+type SSOVerification struct {
+	Verified bool `json:"verified"`
+	Checks   []struct {
+		Check   string `json:"check"`
+		Status  string `json:"status"`
+		Message string `json:"message,omitempty"`
+	} `json:"checks"`
+	Message string `json:"message"`
+}
+
+// Redirect starts the flow
+func (c *SSOClient) Redirect(ctx context.Context) (*SSORedirect, error) {
+	var resp SSORedirect
+	if _, err := c.caller.do(ctx, call{method: http.MethodGet, path: "/api/auth/redirect", idempotent: true}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Login checks the credentials at the identity provider and returns an authorization code
+func (c *SSOClient) Login(ctx context.Context, username, password string) (*AuthCode, error) {
+	var resp AuthCode
+	req := call{method: http.MethodPost, path: "/api/auth/login", json: credentialsRequest{Username: username, Password: password}}
+	if _, err := c.caller.do(ctx, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ExchangeCode trades an authorization code for tokens. Codes work once, so
+// this is never retried; a used or expired code matches ErrUnauthorized.
+func (c *SSOClient) ExchangeCode(ctx context.Context, code string) (*SSOTokens, error) {
+	var resp SSOTokens
+	req := call{method: http.MethodPost, path: "/api/auth/token", json: map[string]string{"code": code}}
+	if _, err := c.caller.do(ctx, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// VerifyTokens checks an ID token and access token. Tokens that fail a check
+// are not an error: the verification lists what failed.
+func (c *SSOClient) VerifyTokens(ctx context.Context, tokens *SSOTokens) (*SSOVerification, error) {
+	var resp SSOVerification
+	body := map[string]string{"id_token": tokens.IDToken, "access_token": tokens.AccessToken}
+	if _, err := c.caller.do(ctx, call{method: http.MethodPost, path: "/api/auth/verify", json: body, idempotent: true}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
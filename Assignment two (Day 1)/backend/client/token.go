@@ -0,0 +1,286 @@
+package client
+
+import (
+	"context"
+	"jwt-auth-system/backend/domain"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// RFC 6749 and RFC 8693 grant and token type identifiers the token endpoint takes
+const (
+	grantTypeClientCredentials = "client_credentials"
+	grantTypeTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeAccessToken       = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// TokenClient calls this JWT server
+type TokenClient struct {
+	caller *caller
+}
+
+// NewTokenClient creates a client for the JWT server at baseURL, e.g. http://localhost:8080
+func NewTokenClient(baseURL string, options Options) *TokenClient {
+	return &TokenClient{caller: newCaller(baseURL, options)}
+}
+
+// Validation is the answer of /validate
+type Validation struct {
+	Valid   bool               `json:"valid"`
+	Message string             `json:"message"`
+	Claims  *domain.ClaimsData `json:"claims,omitempty"`
+}
+
+// UserQuery selects a page of users; Filters match attributes exactly
+type UserQuery struct {
+	Page    int
+	PerPage int
+	Role    string
+	Filters map[string]string
+}
+
+// ClientCredentialsRequest asks for a service account token
+type ClientCredentialsRequest struct {
+	ServiceAccountID string
+	APIKey           string
+	Audience         string
+	Scope            string
+}
+
+// ClientAuth authenticates an OAuth client at /token, /introspect and /revoke
+type ClientAuth struct {
+	ClientID     string
+	ClientSecret string
+}
+
+// ExchangeRequest is an RFC 8693 token exchange; ActorToken is optional
+type ExchangeRequest struct {
+	SubjectToken string
+	ActorToken   string
+	Audience     string
+	Scope        string
+}
+
+// Register creates a user
+func (c *TokenClient) Register(ctx context.Context, req domain.RegisterRequest) (*domain.RegisterResponse, error) {
+	var resp domain.RegisterResponse
+	if _, err := c.caller.do(ctx, call{method: http.MethodPost, path: "/register", json: req}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GenerateToken issues a token for a user. It is not retried, since a
+// one-time password may only be used once.
+func (c *TokenClient) GenerateToken(ctx context.Context, req domain.GenerateRequest) (*domain.GenerateResponse, error) {
+	var resp domain.GenerateResponse
+	if _, err := c.caller.do(ctx, call{method: http.MethodPost, path: "/generate", json: req}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ValidateToken asks the server whether token is valid. An invalid token is not
+// an error: the Validation says why it failed.
+func (c *TokenClient) ValidateToken(ctx context.Context, token string) (*Validation, error) {
+	var resp Validation
+	req := call{method: http.MethodPost, path: "/validate", json: domain.ValidateRequest{Token: token}, idempotent: true}
+	if _, err := c.caller.do(ctx, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Me returns the claims of the client's token
+func (c *TokenClient) Me(ctx context.Context) (*domain.ClaimsData, error) {
+	var resp domain.ClaimsData
+	if _, err := c.caller.do(ctx, call{method: http.MethodGet, path: "/me", idempotent: true, auth: true}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListUsers returns a page of users; the token needs the users:read scope
+func (c *TokenClient) ListUsers(ctx context.Context, query UserQuery) (*domain.UserListResponse, error) {
+	values := url.Values{}
+	if query.Page > 0 {
+		values.Set("page", strconv.Itoa(query.Page))
+	}
+	if query.PerPage > 0 {
+		values.Set("per_page", strconv.Itoa(query.PerPage))
+	}
+	if query.Role != "" {
+		values.Set("role", query.Role)
+	}
+	for name, value := range query.Filters {
+		values.Set(name, value)
+	}
+
+	var resp domain.UserListResponse
+	req := call{method: http.MethodGet, path: "/users", query: values, idempotent: true, auth: true}
+	if _, err := c.caller.do(ctx, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetUser returns a user and its ETag, which UpdateUser needs
+func (c *TokenClient) GetUser(ctx context.Context, username string) (*domain.User, string, error) {
+	var user domain.User
+	header, err := c.caller.do(ctx, call{method: http.MethodGet, path: userPath(username), idempotent: true, auth: true}, &user)
+	if err != nil {
+		return nil, "", err
+	}
+	return &user, header.Get("ETag"), nil
+}
+
+// UpdateUser changes a user that still has the given ETag, returning the
+// changed user and its new ETag. A user changed in between gives ErrPreconditionFailed.
+func (c *TokenClient) UpdateUser(ctx context.Context, username, etag string, req domain.UpdateUserRequest) (*domain.User, string, error) {
+	var user domain.User
+	header, err := c.caller.do(ctx, call{
+		method: http.MethodPatch,
+		path:   userPath(username),
+		header: http.Header{"If-Match": {etag}},
+		json:   req,
+		auth:   true,
+	}, &user)
+	if err != nil {
+		return nil, "", err
+	}
+	return &user, header.Get("ETag"), nil
+}
+
+// DeleteUser deletes a user; the token needs the users:write scope
+func (c *TokenClient) DeleteUser(ctx context.Context, username string) error {
+	_, err := c.caller.do(ctx, call{method: http.MethodDelete, path: userPath(username), idempotent: true, auth: true}, nil)
+	return err
+}
+
+// EnrollOTP enrolls an authenticator app for the token's own user. The token
+// must be fresh, or the error matches ErrStepUpRequired.
+func (c *TokenClient) EnrollOTP(ctx context.Context, username string) (*domain.OTPEnrollment, error) {
+	var resp domain.OTPEnrollment
+	if _, err := c.caller.do(ctx, call{method: http.MethodPost, path: userPath(username) + "/otp", auth: true}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// JWKS returns the public keys that verify the server's tokens
+func (c *TokenClient) JWKS(ctx context.Context) (*domain.JWKSet, error) {
+	var resp domain.JWKSet
+	if _, err := c.caller.do(ctx, call{method: http.MethodGet, path: "/.well-known/jwks.json", idempotent: true}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ClientCredentials gets a service account token with the client credentials grant
+func (c *TokenClient) ClientCredentials(ctx context.Context, req ClientCredentialsRequest) (*domain.TokenResponse, error) {
+	form := url.Values{"grant_type": {grantTypeClientCredentials}}
+	setIf(form, "audience", req.Audience)
+	setIf(form, "scope", req.Scope)
+	return c.token(ctx, ClientAuth{ClientID: req.ServiceAccountID, ClientSecret: req.APIKey}, form)
+}
+
+// ExchangeToken swaps a token for one addressed to another audience (RFC 8693)
+func (c *TokenClient) ExchangeToken(ctx context.Context, auth ClientAuth, req ExchangeRequest) (*domain.TokenResponse, error) {
+	form := url.Values{
+		"grant_type":         {grantTypeTokenExchange},
+		"subject_token":      {req.SubjectToken},
+		"subject_token_type": {tokenTypeAccessToken},
+		"audience":           {req.Audience},
+	}
+	if req.ActorToken != "" {
+		form.Set("actor_token", req.ActorToken)
+		form.Set("actor_token_type", tokenTypeAccessToken)
+	}
+	setIf(form, "scope", req.Scope)
+	return c.token(ctx, auth, form)
+}
+
+// token calls the token endpoint. Failed attempts issue nothing, so they are retried.
+func (c *TokenClient) token(ctx context.Context, auth ClientAuth, form url.Values) (*domain.TokenResponse, error) {
+	var resp domain.TokenResponse
+	req := call{method: http.MethodPost, path: "/token", header: basicAuth(auth), form: form, idempotent: true}
+	if _, err := c.caller.do(ctx, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Introspect asks about a token on behalf of a resource server (RFC 7662)
+func (c *TokenClient) Introspect(ctx context.Context, auth ClientAuth, token string) (*domain.IntrospectionResponse, error) {
+	var resp domain.IntrospectionResponse
+	req := call{method: http.MethodPost, path: "/introspect", header: basicAuth(auth), form: url.Values{"token": {token}}, idempotent: true}
+	if _, err := c.caller.do(ctx, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Revoke revokes a token (RFC 7009); revoking twice is harmless
+func (c *TokenClient) Revoke(ctx context.Context, auth ClientAuth, token string) error {
+	req := call{method: http.MethodPost, path: "/revoke", header: basicAuth(auth), form: url.Values{"token": {token}}, idempotent: true}
+	_, err := c.caller.do(ctx, req, nil)
+	return err
+}
+
+// ServiceAccountTokenSource returns a token source that gets service account
+// tokens with the client credentials grant and renews them before they expire
+func (c *TokenClient) ServiceAccountTokenSource(req ClientCredentialsRequest) *RefreshingTokenSource {
+	return NewRefreshingTokenSource(func(ctx context.Context) (string, time.Time, error) {
+		resp, err := c.ClientCredentials(ctx, req)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		return resp.AccessToken, time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second), nil
+	})
+}
+
+// UserTokenSource returns a token source that generates user tokens with req
+// and renews them before they expire. It suits users who log in with a
+// password only, since a one-time password cannot be sent again.
+func (c *TokenClient) UserTokenSource(req domain.GenerateRequest) *RefreshingTokenSource {
+	return NewRefreshingTokenSource(func(ctx context.Context) (string, time.Time, error) {
+		resp, err := c.GenerateToken(ctx, req)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		return resp.Token, tokenExpiry(resp.Token), nil
+	})
+}
+
+// tokenExpiry reads exp from a JWT without verifying it, which is enough to
+// schedule a refresh. Other formats return the zero time.
+func tokenExpiry(token string) time.Time {
+	claims := &jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil || claims.ExpiresAt == nil {
+		return time.Time{}
+	}
+	return claims.ExpiresAt.Time
+}
+
+// userPath is the path of a user's resource
+func userPath(username string) string {
+	return "/users/" + url.PathEscape(username)
+}
+
+// basicAuth encodes OAuth client credentials as RFC 6749 section 2.3.1 asks
+func basicAuth(auth ClientAuth) http.Header {
+	req, _ := http.NewRequest(http.MethodPost, "/", nil)
+	req.SetBasicAuth(url.QueryEscape(auth.ClientID), url.QueryEscape(auth.ClientSecret))
+	return http.Header{"Authorization": req.Header["Authorization"]}
+}
+
+// setIf sets a form field when value is not empty
+func setIf(form url.Values, name, value string) {
+	if value != "" {
+		form.Set(name, value)
+	}
+}
@@ -0,0 +1,207 @@
+package client
+
+import (
+	"context"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/services"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// The calls below use the token server's /admin endpoints and need Options.AdminKey
+
+// CreateClient registers an OAuth client; the secret is only returned here
+func (c *TokenClient) CreateClient(ctx context.Context, req domain.CreateClientRequest) (*domain.CreateClientResponse, error) {
+	var resp domain.CreateClientResponse
+	if _, err := c.admin(ctx, http.MethodPost, "/admin/clients", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// AdminUpdateUser changes any field of a user, including role and disabled
+func (c *TokenClient) AdminUpdateUser(ctx context.Context, username string, req domain.UpdateUserRequest) (*domain.User, error) {
+	var user domain.User
+	if _, err := c.admin(ctx, http.MethodPatch, "/admin"+userPath(username), req, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// LogoutUser invalidates every token the user holds
+func (c *TokenClient) LogoutUser(ctx context.Context, username string) error {
+	_, err := c.admin(ctx, http.MethodPost, "/admin"+userPath(username)+"/logout", nil, nil)
+	return err
+}
+
+// ResetOTP removes the user's authenticator app
+func (c *TokenClient) ResetOTP(ctx context.Context, username string) error {
+	_, err := c.admin(ctx, http.MethodDelete, "/admin"+userPath(username)+"/otp", nil, nil)
+	return err
+}
+
+// AssignRole gives a user a role from the catalog
+func (c *TokenClient) AssignRole(ctx context.Context, username, role string) (*domain.User, error) {
+	var user domain.User
+	if _, err := c.admin(ctx, http.MethodPut, "/admin"+userPath(username)+"/role", domain.AssignRoleRequest{Role: role}, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// RevokeRole puts a user back on the default role
+func (c *TokenClient) RevokeRole(ctx context.Context, username string) (*domain.User, error) {
+	var user domain.User
+	if _, err := c.admin(ctx, http.MethodDelete, "/admin"+userPath(username)+"/role", nil, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ListRoles returns the role catalog
+func (c *TokenClient) ListRoles(ctx context.Context) ([]domain.Role, error) {
+	var roles []domain.Role
+	if _, err := c.admin(ctx, http.MethodGet, "/admin/roles", nil, &roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// CreateRole adds a role to the catalog
+func (c *TokenClient) CreateRole(ctx context.Context, role domain.Role) (*domain.Role, error) {
+	var created domain.Role
+	if _, err := c.admin(ctx, http.MethodPost, "/admin/roles", role, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// DeleteRole removes a role nobody holds
+func (c *TokenClient) DeleteRole(ctx context.Context, name string) error {
+	_, err := c.admin(ctx, http.MethodDelete, "/admin/roles/"+url.PathEscape(name), nil, nil)
+	return err
+}
+
+// Audit returns audit entries, newest first, optionally only those about target
+func (c *TokenClient) Audit(ctx context.Context, target string, limit int) ([]domain.AuditEntry, error) {
+	query := url.Values{}
+	setIf(query, "target", target)
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	var entries []domain.AuditEntry
+	req := call{method: http.MethodGet, path: "/admin/audit", query: query, header: c.caller.adminHeader(), idempotent: true}
+	if _, err := c.caller.do(ctx, req, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// CreateServiceAccount creates a service account without keys
+func (c *TokenClient) CreateServiceAccount(ctx context.Context, req domain.CreateServiceAccountRequest) (*domain.ServiceAccount, error) {
+	var account domain.ServiceAccount
+	if _, err := c.admin(ctx, http.MethodPost, "/admin/service-accounts", req, &account); err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// ListServiceAccounts returns every service account
+func (c *TokenClient) ListServiceAccounts(ctx context.Context) ([]domain.ServiceAccount, error) {
+	var accounts []domain.ServiceAccount
+	if _, err := c.admin(ctx, http.MethodGet, "/admin/service-accounts", nil, &accounts); err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// GetServiceAccount returns a service account
+func (c *TokenClient) GetServiceAccount(ctx context.Context, id string) (*domain.ServiceAccount, error) {
+	var account domain.ServiceAccount
+	if _, err := c.admin(ctx, http.MethodGet, serviceAccountPath(id), nil, &account); err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// DeleteServiceAccount deletes a service account and its keys
+func (c *TokenClient) DeleteServiceAccount(ctx context.Context, id string) error {
+	_, err := c.admin(ctx, http.MethodDelete, serviceAccountPath(id), nil, nil)
+	return err
+}
+
+// ListAPIKeys returns a service account's keys, without their secrets
+func (c *TokenClient) ListAPIKeys(ctx context.Context, accountID string) ([]domain.APIKey, error) {
+	var keys []domain.APIKey
+	if _, err := c.admin(ctx, http.MethodGet, serviceAccountPath(accountID)+"/keys", nil, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// CreateAPIKey issues an API key; the key itself is only returned here
+func (c *TokenClient) CreateAPIKey(ctx context.Context, accountID string, req domain.CreateAPIKeyRequest) (*domain.CreateAPIKeyResponse, error) {
+	var resp domain.CreateAPIKeyResponse
+	if _, err := c.admin(ctx, http.MethodPost, serviceAccountPath(accountID)+"/keys", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RotateAPIKey replaces a key, keeping the old one usable for grace
+func (c *TokenClient) RotateAPIKey(ctx context.Context, accountID, keyID string, grace time.Duration) (*domain.CreateAPIKeyResponse, error) {
+	req := domain.RotateAPIKeyRequest{}
+	if grace > 0 {
+		req.GracePeriod = grace.String()
+	}
+
+	var resp domain.CreateAPIKeyResponse
+	if _, err := c.admin(ctx, http.MethodPost, serviceAccountPath(accountID)+"/keys/"+url.PathEscape(keyID)+"/rotate", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RevokeAPIKey revokes a key at once
+func (c *TokenClient) RevokeAPIKey(ctx context.Context, accountID, keyID string) error {
+	_, err := c.admin(ctx, http.MethodDelete, serviceAccountPath(accountID)+"/keys/"+url.PathEscape(keyID), nil, nil)
+	return err
+}
+
+// ListSigningKeys returns the signing keys and their states
+func (c *TokenClient) ListSigningKeys(ctx context.Context) ([]services.KeyInfo, error) {
+	var keys []services.KeyInfo
+	if _, err := c.admin(ctx, http.MethodGet, "/admin/keys", nil, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RotateSigningKey starts signing with a new key and returns the keys afterwards
+func (c *TokenClient) RotateSigningKey(ctx context.Context) ([]services.KeyInfo, error) {
+	var keys []services.KeyInfo
+	if _, err := c.admin(ctx, http.MethodPost, "/admin/keys/rotate", nil, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// admin calls an /admin endpoint with the admin key. Reads and deletes are retried.
+func (c *TokenClient) admin(ctx context.Context, method, path string, body, out interface{}) (http.Header, error) {
+	req := call{
+		method:     method,
+		path:       path,
+		header:     c.caller.adminHeader(),
+		json:       body,
+		idempotent: method == http.MethodGet || method == http.MethodDelete || method == http.MethodPut,
+	}
+	return c.caller.do(ctx, req, out)
+}
+
+// serviceAccountPath is the path of a service account's resource
+func serviceAccountPath(id string) string {
+	return "/admin/service-accounts/" + url.PathEscape(id)
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// RefreshMargin is how long before expiry a RefreshingTokenSource fetches a new token
+const RefreshMargin = 30 * time.Second
+
+// TokenSource supplies bearer tokens for calls that need one
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticToken returns a TokenSource that always supplies token
+func StaticToken(token string) TokenSource {
+	return staticToken(token)
+}
+
+type staticToken string
+
+func (t staticToken) Token(context.Context) (string, error) {
+	return string(t), nil
+}
+
+// FetchFunc obtains a new token and says when it expires; a zero expiry means
+// the token is used until a server rejects it
+type FetchFunc func(ctx context.Context) (token string, expiresAt time.Time, err error)
+
+// RefreshingTokenSource caches a token and fetches a new one shortly before it
+// expires, or after a server rejected it. It is safe for concurrent use.
+type RefreshingTokenSource struct {
+	fetch FetchFunc
+
+	token     string
+	expiresAt time.Time
+	mu        sync.Mutex
+}
+
+// NewRefreshingTokenSource creates a token source that fetches tokens with fetch
+func NewRefreshingTokenSource(fetch FetchFunc) *RefreshingTokenSource {
+	return &RefreshingTokenSource{fetch: fetch}
+}
+
+// Token returns the cached token, fetching a new one when there is none or it
+// is about to expire
+func (s *RefreshingTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && (s.expiresAt.IsZero() || time.Until(s.expiresAt) > RefreshMargin) {
+		return s.token, nil
+	}
+
+	token, expiresAt, err := s.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		return "", errors.New("token source fetched an empty token")
+	}
+	s.token, s.expiresAt = token, expiresAt
+	return token, nil
+}
+
+// Invalidate drops the cached token if it is still token, the one a server
+// rejected, so the next call fetches a new one. A token fetched since then is
+// kept, which makes calls refused at the same time refresh only once.
+func (s *RefreshingTokenSource) Invalidate(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token == token {
+		s.token = ""
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"jwt-auth-system/backend/services"
+	"os"
+	"time"
+)
+
+// config holds the command-line flags; some default to environment variables
+type config struct {
+	// Token format and signing keys
+	format       string
+	algorithm    string
+	rotateEvery  time.Duration
+	keyFile      string
+	keyID        string
+	keystorePath string
+	devMode      bool
+
+	// Token claims and lifetimes
+	issuer               string
+	audiences            string
+	defaultAudience      string
+	leeway               time.Duration
+	encryptFor           string
+	decryptionKey        string
+	opaqueAudiences      string
+	opaqueSliding        time.Duration
+	serviceTokenLifetime time.Duration
+	userCacheTTL         time.Duration
+
+	// Sender-constrained tokens: DPoP and mutual TLS
+	enableDPoP bool
+	dpopNonce  bool
+	tlsAddr    string
+	tlsCert    string
+	tlsKey     string
+	clientCA   string
+
+	// HTTP API
+	adminKey    string
+	publicURL   string
+	trustProxy  bool
+	cookieAuth  bool
+	corsOrigins string
+
+	// Policy files and the Envoy ext_authz listener
+	attributeSchema   string
+	permissionsFile   string
+	exchangePolicy    string
+	forwardAuthPolicy string
+	extAuthzAddr      string
+}
+
+// parseFlags reads the command line into a config
+func parseFlags() config {
+	var cfg config
+	flag.StringVar(&cfg.format, "format", "jwt", "token format: jwt, paseto-public (needs -alg EdDSA) or paseto-local (needs an HS256 key of at least 32 bytes)")
+	flag.StringVar(&cfg.algorithm, "alg", services.AlgHS256, "token signing algorithm (HS256, RS256, PS256, ES256, EdDSA)")
+	flag.DurationVar(&cfg.rotateEvery, "rotate-every", 0, "rotate the signing key on this interval (0 disables scheduled rotation)")
+	flag.StringVar(&cfg.keyFile, "key-file", os.Getenv("JWT_KEY_FILE"), "PEM private key to sign with")
+	flag.StringVar(&cfg.keyID, "key-id", os.Getenv("JWT_KEY_ID"), "key ID of the JWT_SECRET key (\""+services.DefaultHMACKeyID+"\" when unset)")
+	flag.StringVar(&cfg.keystorePath, "keystore", "keystore.json", "encrypted keystore file, created on first run")
+	flag.BoolVar(&cfg.devMode, "dev", false, "allow the built-in development secret and unpersisted keys")
+
+	flag.StringVar(&cfg.issuer, "issuer", "jwt-auth-system", "iss claim written to and required in tokens")
+	flag.StringVar(&cfg.audiences, "audiences", "", "accepted audiences with optional lifetimes, e.g. api=5m,reports=1h")
+	flag.StringVar(&cfg.defaultAudience, "default-audience", "", "audience used when /generate names none")
+	flag.DurationVar(&cfg.leeway, "leeway", 30*time.Second, "clock skew allowed when checking exp and nbf")
+	flag.StringVar(&cfg.encryptFor, "encrypt-for", "", "encrypt tokens per audience, e.g. reports=RSA-OAEP-256,hr=/keys/hr.pub.pem")
+	flag.StringVar(&cfg.decryptionKey, "decryption-key", "", "PEM private key for encrypted tokens addressed to this server")
+	flag.StringVar(&cfg.opaqueAudiences, "opaque-audiences", "", "comma-separated audiences that get opaque reference tokens")
+	flag.DurationVar(&cfg.opaqueSliding, "opaque-sliding", 0, "expire opaque tokens after this long unused (0 disables sliding expiry)")
+	flag.DurationVar(&cfg.serviceTokenLifetime, "service-token-lifetime", 5*time.Minute, "longest lifetime of tokens issued to service accounts")
+	flag.DurationVar(&cfg.userCacheTTL, "user-cache-ttl", 10*time.Second, "how long token version lookups are cached (0 looks the user up on every validation)")
+
+	flag.BoolVar(&cfg.enableDPoP, "dpop", false, "accept DPoP proofs and issue sender-constrained (cnf.jkt) tokens")
+	flag.BoolVar(&cfg.dpopNonce, "dpop-nonce", false, "require DPoP proofs to carry a server-provided nonce")
+	flag.StringVar(&cfg.tlsAddr, "tls-addr", "", "also serve HTTPS on this address, e.g. :8443, requesting client certificates")
+	flag.StringVar(&cfg.tlsCert, "tls-cert", "", "PEM server certificate for -tls-addr")
+	flag.StringVar(&cfg.tlsKey, "tls-key", "", "PEM server private key for -tls-addr")
+	flag.StringVar(&cfg.clientCA, "client-ca", "", "PEM CA bundle that client certificates must chain to")
+
+	flag.StringVar(&cfg.adminKey, "admin-key", os.Getenv("ADMIN_API_KEY"), "key required in X-Admin-Key for /admin endpoints")
+	flag.StringVar(&cfg.publicURL, "public-url", os.Getenv("PUBLIC_URL"), "scheme and host clients reach the server at, e.g. https://auth.example.com, for DPoP htu checks and SCIM locations")
+	flag.BoolVar(&cfg.trustProxy, "trust-proxy", false, "take the scheme and host from X-Forwarded-Proto and X-Forwarded-Host when -public-url is unset")
+	flag.BoolVar(&cfg.cookieAuth, "cookie-auth", false, "hand tokens to browsers in __Host- HttpOnly cookies and require CSRF tokens")
+	flag.StringVar(&cfg.corsOrigins, "cors-origins", "", "comma-separated origins allowed to call the API from browsers (any origin when unset, unless -cookie-auth)")
+
+	flag.StringVar(&cfg.attributeSchema, "attribute-schema", "", "JSON schema of user attributes (designation and age when unset)")
+	flag.StringVar(&cfg.permissionsFile, "permissions", "", "JSON catalog mapping roles to permissions (built-in catalog when unset)")
+	flag.StringVar(&cfg.exchangePolicy, "exchange-policy", "", "JSON policy of allowed token exchanges (none allowed when unset)")
+	flag.StringVar(&cfg.forwardAuthPolicy, "forward-auth-policy", "", "JSON rules for /forward-auth (any valid token is enough when unset)")
+	flag.StringVar(&cfg.extAuthzAddr, "ext-authz-addr", "", "also serve the Envoy ext_authz gRPC API on this address, e.g. :9001")
+	flag.Parse()
+	return cfg
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"jwt-auth-system/backend/services"
+	"strings"
+	"time"
+)
+
+// runDecode prints a token's header and claims without checking the signature
+func runDecode(args []string) error {
+	flags := flag.NewFlagSet("decode", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Println("Usage: jwtctl decode [token]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	token, err := readToken(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case services.IsOpaqueToken(token):
+		fmt.Println("Opaque reference token: its claims live on the server, use /introspect")
+		return nil
+	case strings.HasPrefix(token, "v4."):
+		fmt.Println("PASETO token: verify it with the server's /validate")
+		return nil
+	}
+
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 && len(segments) != 5 {
+		return fmt.Errorf("not a compact JWT or JWE (%d segments)", len(segments))
+	}
+
+	var header map[string]interface{}
+	if err := decodeSegment(segments[0], &header); err != nil {
+		return fmt.Errorf("header: %w", err)
+	}
+	fmt.Println("Header:")
+	printJSON(header)
+
+	if len(segments) == 5 {
+		fmt.Println("Encrypted token (JWE): the claims can only be read by the recipient")
+		return nil
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(segments[1], &claims); err != nil {
+		return fmt.Errorf("claims: %w", err)
+	}
+	fmt.Println("Claims:")
+	printJSON(claims)
+
+	printTimes(claims)
+	fmt.Println("Signature not verified; use jwtctl verify")
+	return nil
+}
+
+// decodeSegment decodes one base64url JSON segment of a compact token
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// printTimes prints iat, nbf and exp as dates and flags tokens that are expired or not yet valid
+func printTimes(claims map[string]interface{}) {
+	now := time.Now()
+	for _, name := range []string{"iat", "nbf", "exp"} {
+		seconds, ok := claims[name].(float64)
+		if !ok {
+			continue
+		}
+		at := time.Unix(int64(seconds), 0)
+		status := ""
+		switch {
+		case name == "exp" && now.After(at):
+			status = fmt.Sprintf(" (EXPIRED %s ago)", now.Sub(at).Round(time.Second))
+		case name == "exp":
+			status = fmt.Sprintf(" (expires in %s)", at.Sub(now).Round(time.Second))
+		case name == "nbf" && now.Before(at):
+			status = fmt.Sprintf(" (NOT YET VALID for %s)", at.Sub(now).Round(time.Second))
+		}
+		fmt.Printf("%s: %s%s\n", name, at.Format(time.RFC3339), status)
+	}
+	if _, ok := claims["exp"]; !ok {
+		fmt.Println("exp: missing, the server rejects tokens without an expiry")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"jwt-auth-system/backend/services"
+	"time"
+)
+
+// runKeys manages the entries of an encrypted keystore
+func runKeys(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("keys needs a subcommand: list, generate, rotate or jwks")
+	}
+	action := args[0]
+
+	flags := flag.NewFlagSet("keys "+action, flag.ExitOnError)
+	keystorePath := flags.String("keystore", "keystore.json", "encrypted keystore file")
+	algorithm := flags.String("alg", services.AlgHS256, "algorithm for generate (HS256, RS256, PS256, ES256, EdDSA)")
+	window := flags.Duration("window", services.TokenLifetime+30*time.Second, "how long the old key keeps verifying after rotate")
+	flags.Usage = func() {
+		fmt.Println("Usage: jwtctl keys <list|generate|rotate|jwks> [flags]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args[1:])
+
+	passphrase, err := services.ReadSecret("KEYSTORE_PASSPHRASE")
+	if err != nil {
+		return err
+	}
+	if passphrase == "" {
+		return fmt.Errorf("set KEYSTORE_PASSPHRASE or KEYSTORE_PASSPHRASE_FILE")
+	}
+	keystore := services.NewKeystore(*keystorePath, passphrase)
+
+	if action == "generate" {
+		if keystore.Exists() {
+			return fmt.Errorf("%s already exists; use rotate to add a key", *keystorePath)
+		}
+		key, err := services.GenerateSigningKey(*algorithm)
+		if err != nil {
+			return err
+		}
+		if err := keystore.Save(services.NewKeyring(key, *window).Snapshot()); err != nil {
+			return err
+		}
+		fmt.Printf("Generated %s key %s in %s\n", key.Algorithm, key.KeyID, *keystorePath)
+		return nil
+	}
+
+	keys, err := keystore.Load()
+	if err != nil {
+		return err
+	}
+	keyring, err := services.NewKeyringFromKeys(keys, *window)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "list":
+		printJSON(keyring.Keys())
+	case "jwks":
+		printJSON(keyring.JWKS())
+	case "rotate":
+		key, err := keyring.Rotate()
+		if err != nil {
+			return err
+		}
+		if err := keystore.Save(keyring.Snapshot()); err != nil {
+			return err
+		}
+		fmt.Printf("Rotated to %s key %s; the previous key verifies for %s\n", key.Algorithm, key.KeyID, *window)
+	default:
+		return fmt.Errorf("unknown keys subcommand %q", action)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+// Command jwtctl mints, decodes and verifies tokens offline and manages keystore
+// entries, using the same services as the server.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const usage = `Usage: jwtctl <command> [flags]
+
+Commands:
+  decode [token]      print the header and claims without verifying, and flag expiry
+  verify [token]      verify against -secret, -key (PEM) or -jwks (JWKS file)
+  sign -claims file   sign a claims JSON file with -secret, -key or -keystore
+  keys <list|generate|rotate|jwks> -keystore file
+                      manage keystore entries (passphrase from KEYSTORE_PASSPHRASE)
+
+Tokens are read from stdin when not given as an argument.
+Run "jwtctl <command> -h" for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "decode":
+		err = runDecode(os.Args[2:])
+	case "verify":
+		err = runVerify(os.Args[2:])
+	case "sign":
+		err = runSign(os.Args[2:])
+	case "keys":
+		err = runKeys(os.Args[2:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "jwtctl: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "jwtctl:", err)
+		os.Exit(1)
+	}
+}
+
+// readToken returns the token given as an argument, or the first line of stdin
+func readToken(args []string) (string, error) {
+	if len(args) > 0 && args[0] != "-" {
+		return strings.TrimSpace(args[0]), nil
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	token := strings.TrimSpace(line)
+	if token == "" {
+		return "", fmt.Errorf("no token given")
+	}
+	return token, nil
+}
+
+// printJSON pretty-prints v to stdout
+func printJSON(v interface{}) {
+	data, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Println(string(data))
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/services"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// keyFlags are the key source flags shared by sign and verify
+type keyFlags struct {
+	secret   *string
+	keyID    *string
+	keyFile  *string
+	alg      *string
+	jwksFile *string
+	keystore *string
+}
+
+// addKeyFlags registers the key source flags; withJWKS adds -jwks, which can only verify
+func addKeyFlags(flags *flag.FlagSet, withJWKS bool) keyFlags {
+	secret, _ := services.ReadSecret("JWT_SECRET")
+	keys := keyFlags{
+		secret:   flags.String("secret", secret, "HS256 shared secret (defaults to JWT_SECRET or JWT_SECRET_FILE)"),
+		keyID:    flags.String("kid", os.Getenv("JWT_KEY_ID"), "key ID of the -secret, as configured on the server"),
+		keyFile:  flags.String("key", "", "PEM private key, or public key to verify with"),
+		alg:      flags.String("alg", services.AlgRS256, "algorithm of the -key PEM key (RS256, PS256, ES256, EdDSA)"),
+		keystore: flags.String("keystore", "", "encrypted keystore file (passphrase from KEYSTORE_PASSPHRASE)"),
+	}
+	if withJWKS {
+		keys.jwksFile = flags.String("jwks", "", "JWKS file, e.g. saved from /.well-known/jwks.json")
+	}
+	return keys
+}
+
+// keyring builds a keyring from whichever key source was given
+func (k keyFlags) keyring() (*services.Keyring, error) {
+	switch {
+	case k.jwksFile != nil && *k.jwksFile != "":
+		return loadJWKS(*k.jwksFile)
+
+	case *k.keyFile != "":
+		key, err := services.LoadPEMSigningKey(*k.keyFile, *k.alg)
+		if err != nil {
+			return nil, err
+		}
+		return services.NewKeyring(key, 0), nil
+
+	case *k.keystore != "":
+		passphrase, err := services.ReadSecret("KEYSTORE_PASSPHRASE")
+		if err != nil {
+			return nil, err
+		}
+		keys, err := services.NewKeystore(*k.keystore, passphrase).Load()
+		if err != nil {
+			return nil, err
+		}
+		return services.NewKeyringFromKeys(keys, 0)
+
+	case *k.secret != "":
+		return services.NewKeyring(services.NewHMACSigningKey(*k.keyID, *k.secret), 0), nil
+
+	default:
+		return nil, fmt.Errorf("no key given: use -secret, -key, -keystore or -jwks")
+	}
+}
+
+// loadJWKS reads a JWKS file into a verify-only keyring, skipping keys it cannot use
+func loadJWKS(path string) (*services.Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var set domain.JWKSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	var keys []*services.SigningKey
+	for _, jwk := range set.Keys {
+		key, err := services.VerificationKeyFromJWK(jwk)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "jwtctl: skipping key %s: %v\n", jwk.Kid, err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s contains no usable keys", path)
+	}
+	return services.NewVerificationKeyring(keys), nil
+}
+
+// runVerify checks a token's signature and claims exactly as the server does
+func runVerify(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	keys := addKeyFlags(flags, true)
+	issuer := flags.String("issuer", "jwt-auth-system", "required iss claim (empty to skip the check)")
+	audience := flags.String("audience", "", "required aud claim")
+	leeway := flags.Duration("leeway", 30*time.Second, "clock skew allowed when checking exp and nbf")
+	decryptionKey := flags.String("decryption-key", "", "PEM private key for encrypted (JWE) tokens")
+	flags.Usage = func() {
+		fmt.Println("Usage: jwtctl verify [flags] [token]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	token, err := readToken(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	keyring, err := keys.keyring()
+	if err != nil {
+		return err
+	}
+
+	config := services.DefaultTokenConfig()
+	config.Issuer = *issuer
+	config.Leeway = *leeway
+	if *decryptionKey != "" {
+		key, err := services.LoadPEMEncryptionKey(*decryptionKey)
+		if err != nil {
+			return err
+		}
+		config.DecryptionKeys = append(config.DecryptionKeys, key)
+	}
+
+	jwtService := services.NewJWTServiceWithConfig(keyring, config)
+	var claims *domain.Claims
+	if *audience != "" {
+		claims, err = jwtService.ValidateTokenForAudience(token, *audience)
+	} else {
+		claims, err = jwtService.ValidateToken(token)
+	}
+	if err != nil {
+		return err
+	}
+
+	printJSON(claims)
+	return nil
+}
+
+// runSign signs a claims file, filling in iat and exp when they are missing
+func runSign(args []string) error {
+	flags := flag.NewFlagSet("sign", flag.ExitOnError)
+	keys := addKeyFlags(flags, false)
+	claimsFile := flags.String("claims", "", "JSON file with the claims to sign")
+	lifetime := flags.Duration("lifetime", services.TokenLifetime, "exp to set when the claims have none")
+	flags.Usage = func() {
+		fmt.Println("Usage: jwtctl sign -claims file [flags]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *claimsFile == "" {
+		return fmt.Errorf("-claims is required")
+	}
+	data, err := os.ReadFile(*claimsFile)
+	if err != nil {
+		return err
+	}
+	claims := &domain.Claims{}
+	if err := json.Unmarshal(data, claims); err != nil {
+		return fmt.Errorf("parsing %s: %w", *claimsFile, err)
+	}
+
+	now := time.Now()
+	if claims.IssuedAt == nil {
+		claims.IssuedAt = jwt.NewNumericDate(now)
+	}
+	if claims.ExpiresAt == nil {
+		claims.ExpiresAt = jwt.NewNumericDate(now.Add(*lifetime))
+	}
+
+	keyring, err := keys.keyring()
+	if err != nil {
+		return err
+	}
+
+	token, err := services.NewJWTServiceWithKeyring(keyring).SignClaims(claims)
+	if err != nil {
+		return err
+	}
+	fmt.Println(token)
+	return nil
+}
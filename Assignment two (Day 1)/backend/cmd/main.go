@@ -1,23 +1,69 @@
 package main
 
 import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"jwt-auth-system/backend/extauthz"
 	"jwt-auth-system/backend/handlers"
+	"jwt-auth-system/backend/middleware"
 	"jwt-auth-system/backend/repo"
 	"jwt-auth-system/backend/services"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
 )
 
-// enableCORS is a middleware to enable CORS for all routes
-func enableCORS(next http.HandlerFunc) http.HandlerFunc {
+// corsMiddleware returns a middleware that enables CORS for a route. Without
+// cookies any origin may call the API; with cookies only the listed origins
+// may, and they may send credentials. Other origins get no CORS headers, so
+// browsers keep them from reading responses.
+func corsMiddleware(origins []string, credentials bool) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			switch {
+			case !credentials && len(origins) == 0:
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			case origin != "" && slices.Contains(origins, origin):
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Add("Vary", "Origin")
+				if credentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, DPoP, If-Match, "+middleware.CSRFHeaderName)
+			w.Header().Set("Access-Control-Expose-Headers", "DPoP-Nonce, ETag")
+
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			next(w, r)
+		}
+	}
+}
+
+// requireAdminKey is a middleware that only lets requests carrying the admin key through
+func requireAdminKey(adminKey string, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		if adminKey == "" {
+			http.Error(w, "Admin API is disabled", http.StatusForbidden)
+			return
+		}
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
+		provided := r.Header.Get("X-Admin-Key")
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(adminKey)) != 1 {
+			http.Error(w, "Invalid admin key", http.StatusUnauthorized)
 			return
 		}
 
@@ -25,16 +71,192 @@ func enableCORS(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// mutualTLSConfig asks for client certificates signed by the CA bundle but still
+// admits clients without one, which then authenticate as usual
+func mutualTLSConfig(caFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s contains no CA certificates", caFile)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	return config, nil
+}
+
 func main() {
+	cfg := parseFlags()
+
+	// Load key material from the environment or mounted files
+	secret, err := services.ReadSecret("JWT_SECRET")
+	if err != nil {
+		log.Fatal(err)
+	}
+	passphrase, err := services.ReadSecret("KEYSTORE_PASSPHRASE")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tokenConfig := services.DefaultTokenConfig()
+	tokenConfig.Issuer = cfg.issuer
+	tokenConfig.DefaultAudience = cfg.defaultAudience
+	tokenConfig.Leeway = cfg.leeway
+	tokenConfig.Audiences, err = services.ParseAudienceLifetimes(cfg.audiences)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tokenConfig.EncryptFor, err = services.ParseEncryptionRecipients(cfg.encryptFor)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.decryptionKey != "" {
+		key, err := services.LoadPEMEncryptionKey(cfg.decryptionKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tokenConfig.DecryptionKeys = append(tokenConfig.DecryptionKeys, key)
+	}
+
 	// Initialize repository
 	userRepo := repo.NewUserRepository()
+	clientRepo := repo.NewClientRepository()
+	tokenStore := repo.NewTokenStore()
+	serviceAccountRepo := repo.NewServiceAccountRepository()
+
+	// Tokens carry the user's token version, so account changes invalidate them
+	tokenVersions := services.NewTokenVersions(userRepo, serviceAccountRepo, cfg.userCacheTTL)
+	tokenConfig.Versions = tokenVersions
+
+	// Roles come from the managed catalog; tokens are only issued for roles in it
+	permissions := services.DefaultPermissionCatalog()
+	if cfg.permissionsFile != "" {
+		permissions, err = services.LoadPermissionCatalog(cfg.permissionsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	tokenConfig.Roles = permissions
+	userRepo.CheckRoles(permissions.CheckRole)
+
+	// User attributes and which of them go into tokens come from the attribute schema
+	attributes := services.DefaultAttributeSchema()
+	if cfg.attributeSchema != "" {
+		attributes, err = services.LoadAttributeSchema(cfg.attributeSchema)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	tokenConfig.Attributes = attributes
+	auditLog := repo.NewAuditLog()
 
 	// Initialize services
-	secretKey := "my-secret-key-change-in-production"
-	jwtService := services.NewJWTService(secretKey)
+	keyring, err := services.LoadKeyring(services.KeySource{
+		Algorithm:          cfg.algorithm,
+		PEMFile:            cfg.keyFile,
+		Secret:             secret,
+		SecretKeyID:        cfg.keyID,
+		KeystorePath:       cfg.keystorePath,
+		KeystorePassphrase: passphrase,
+		DevMode:            cfg.devMode,
+	}, tokenConfig.MaxLifetime())
+	if err != nil {
+		log.Fatal("Refusing to start: ", err)
+	}
+	var selfContained services.TokenService
+	switch cfg.format {
+	case "jwt":
+		selfContained = services.NewJWTServiceWithConfig(keyring, tokenConfig)
+	case "paseto-public", "paseto-local":
+		selfContained, err = services.NewPasetoService(strings.TrimPrefix(cfg.format, "paseto-"), keyring, tokenConfig)
+		if err != nil {
+			log.Fatal("Refusing to start: ", err)
+		}
+	default:
+		log.Fatalf("Unknown token format: %s", cfg.format)
+	}
+	clientService := services.NewClientService(clientRepo)
+
+	// Self-contained tokens by default, opaque reference tokens where configured
+	opaqueService := services.NewOpaqueService(tokenStore, tokenConfig, cfg.opaqueSliding)
+	var opaqueFor []string
+	for _, audience := range strings.Split(cfg.opaqueAudiences, ",") {
+		if audience = strings.TrimSpace(audience); audience != "" {
+			opaqueFor = append(opaqueFor, audience)
+		}
+	}
+	tokenService := services.NewTokenRouter(selfContained, opaqueService, tokenConfig, clientService, opaqueFor)
+	stopCleanup := tokenService.StartCleanup(time.Minute)
+	defer stopCleanup()
+
+	policy := &services.ExchangePolicy{}
+	if cfg.exchangePolicy != "" {
+		policy, err = services.LoadExchangePolicy(cfg.exchangePolicy)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	exchangeService := services.NewExchangeService(tokenService, tokenConfig, policy, permissions)
+	serviceAccountService := services.NewServiceAccountService(serviceAccountRepo, tokenService, tokenConfig, cfg.serviceTokenLifetime)
+
+	if cfg.rotateEvery > 0 {
+		if !keyring.CanRotate() {
+			log.Fatal("Refusing to start: -rotate-every needs keys from the keystore, not a PEM file or JWT_SECRET")
+		}
+		stopRotation := keyring.StartRotation(cfg.rotateEvery)
+		defer stopRotation()
+	}
+
+	var dpopVerifier *services.DPoPVerifier
+	if cfg.enableDPoP {
+		dpopVerifier = services.NewDPoPVerifier(cfg.dpopNonce, cfg.leeway)
+	}
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(jwtService, userRepo)
+	credentials := services.NewCredentials(cfg.issuer)
+	authHandler := handlers.NewAuthHandler(tokenService, userRepo, permissions, attributes, credentials, dpopVerifier, cfg.cookieAuth)
+	keyHandler := handlers.NewKeyHandler(keyring)
+	userHandler := handlers.NewUserHandler(userRepo, tokenVersions, permissions, attributes, credentials, auditLog)
+	roleHandler := handlers.NewRoleHandler(permissions, userRepo, auditLog)
+	serviceAccountHandler := handlers.NewServiceAccountHandler(serviceAccountService, tokenVersions, auditLog)
+	oauthHandler := handlers.NewOAuthHandler(tokenService, clientService, exchangeService, serviceAccountService, dpopVerifier)
+	authenticatorOptions := middleware.Options{DPoP: dpopVerifier}
+	if cfg.cookieAuth {
+		authenticatorOptions.CookieName = middleware.TokenCookieName
+		authenticatorOptions.CSRF = true
+	}
+	authenticator := middleware.NewAuthenticator(tokenService, authenticatorOptions)
+
+	// Reverse proxies ask /forward-auth whether to pass requests on to the apps behind them
+	var forwardAuth *services.ForwardAuthPolicy
+	if cfg.forwardAuthPolicy != "" {
+		forwardAuth, err = services.LoadForwardAuthPolicy(cfg.forwardAuthPolicy)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	forwardAuthHandler := handlers.NewForwardAuthHandler(authenticator, forwardAuth)
+	scimHandler := handlers.NewSCIMHandler(userRepo, tokenVersions, permissions, attributes, serviceAccountService, auditLog)
+
+	var allowedOrigins []string
+	for _, origin := range strings.Split(cfg.corsOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			allowedOrigins = append(allowedOrigins, origin)
+		}
+	}
+	enableCORS := corsMiddleware(allowedOrigins, cfg.cookieAuth)
+	publicOrigin, err := middleware.PublicOrigin(cfg.publicURL, cfg.trustProxy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	handler := publicOrigin(http.DefaultServeMux)
 
 	// Setup routes
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -43,10 +265,79 @@ func main() {
 	http.HandleFunc("/register", enableCORS(authHandler.RegisterUser))
 	http.HandleFunc("/generate", enableCORS(authHandler.GenerateToken))
 	http.HandleFunc("/validate", enableCORS(authHandler.ValidateToken))
+	if cfg.cookieAuth {
+		http.HandleFunc("/logout", enableCORS(authHandler.Logout))
+	}
+	http.HandleFunc("/me", enableCORS(authenticator.Authenticate(authHandler.Me)))
+	http.HandleFunc("/users", enableCORS(authenticator.Authenticate(authenticator.RequireScopes("users:read")(userHandler.ListUsers))))
+	http.HandleFunc("/users/{username}", enableCORS(authenticator.Authenticate(userHandler.User)))
+	http.HandleFunc("/users/{username}/otp", enableCORS(authenticator.Authenticate(authenticator.MaxAuthAge(5*time.Minute)(userHandler.EnrollOTP))))
+	http.HandleFunc("/forward-auth", forwardAuthHandler.ForwardAuth)
+	http.HandleFunc("/scim/v2/Users", scimHandler.Authenticate(scimHandler.Users))
+	http.HandleFunc("/scim/v2/Users/{id}", scimHandler.Authenticate(scimHandler.User))
+	http.HandleFunc("/scim/v2/Groups", scimHandler.Authenticate(scimHandler.Groups))
+	http.HandleFunc("/scim/v2/Groups/{id}", scimHandler.Authenticate(scimHandler.Group))
+	// SCIM discovery is unauthenticated on purpose: clients read it to learn how to
+	// authenticate (RFC 7644 section 4). It reveals the role names and the attribute schema.
+	http.HandleFunc("/scim/v2/ServiceProviderConfig", scimHandler.ServiceProviderConfig)
+	http.HandleFunc("/scim/v2/ResourceTypes", scimHandler.ResourceTypes)
+	http.HandleFunc("/scim/v2/ResourceTypes/{id}", scimHandler.ResourceType)
+	http.HandleFunc("/scim/v2/Schemas", scimHandler.Schemas)
+	http.HandleFunc("/scim/v2/Schemas/{id}", scimHandler.Schema)
+	if cfg.format == "jwt" {
+		http.HandleFunc("/.well-known/jwks.json", enableCORS(keyHandler.JWKS))
+	}
+	http.HandleFunc("/introspect", oauthHandler.Introspect)
+	http.HandleFunc("/revoke", oauthHandler.Revoke)
+	http.HandleFunc("/token", oauthHandler.Token)
+	http.HandleFunc("/admin/clients", requireAdminKey(cfg.adminKey, oauthHandler.CreateClient))
+	http.HandleFunc("/admin/users/{username}", requireAdminKey(cfg.adminKey, userHandler.UpdateUser))
+	http.HandleFunc("/admin/users/{username}/logout", requireAdminKey(cfg.adminKey, userHandler.RevokeTokens))
+	http.HandleFunc("/admin/users/{username}/otp", requireAdminKey(cfg.adminKey, userHandler.OTP))
+	http.HandleFunc("/admin/users/{username}/role", requireAdminKey(cfg.adminKey, userHandler.Role))
+	http.HandleFunc("/admin/roles", requireAdminKey(cfg.adminKey, roleHandler.Roles))
+	http.HandleFunc("/admin/roles/{name}", requireAdminKey(cfg.adminKey, roleHandler.DeleteRole))
+	http.HandleFunc("/admin/audit", requireAdminKey(cfg.adminKey, roleHandler.Audit))
+	http.HandleFunc("/admin/service-accounts", requireAdminKey(cfg.adminKey, serviceAccountHandler.Accounts))
+	http.HandleFunc("/admin/service-accounts/{id}", requireAdminKey(cfg.adminKey, serviceAccountHandler.Account))
+	http.HandleFunc("/admin/service-accounts/{id}/keys", requireAdminKey(cfg.adminKey, serviceAccountHandler.Keys))
+	http.HandleFunc("/admin/service-accounts/{id}/keys/{key_id}", requireAdminKey(cfg.adminKey, serviceAccountHandler.RevokeKey))
+	http.HandleFunc("/admin/service-accounts/{id}/keys/{key_id}/rotate", requireAdminKey(cfg.adminKey, serviceAccountHandler.RotateKey))
+	http.HandleFunc("/admin/keys", requireAdminKey(cfg.adminKey, keyHandler.ListKeys))
+	http.HandleFunc("/admin/keys/rotate", requireAdminKey(cfg.adminKey, keyHandler.RotateKeys))
 
 	// Start server
 	fmt.Println("JWT Authentication Server")
 	fmt.Println("Server starting on http://localhost:8080")
-	
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	fmt.Printf("Token format: %s, signing algorithm: %s\n", cfg.format, keyring.Active().Algorithm)
+	if cfg.rotateEvery > 0 {
+		fmt.Printf("Signing key rotates every %s\n", cfg.rotateEvery)
+	}
+
+	if cfg.tlsAddr != "" {
+		tlsConfig, err := mutualTLSConfig(cfg.clientCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+		server := &http.Server{Addr: cfg.tlsAddr, Handler: handler, TLSConfig: tlsConfig}
+		fmt.Printf("HTTPS with client certificates on %s\n", cfg.tlsAddr)
+		go func() {
+			log.Fatal(server.ListenAndServeTLS(cfg.tlsCert, cfg.tlsKey))
+		}()
+	}
+
+	if cfg.extAuthzAddr != "" {
+		listener, err := net.Listen("tcp", cfg.extAuthzAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		grpcServer := grpc.NewServer()
+		extauthz.NewServer(forwardAuthHandler.ForwardAuth).Register(grpcServer)
+		fmt.Printf("Envoy ext_authz gRPC on %s\n", cfg.extAuthzAddr)
+		go func() {
+			log.Fatal(grpcServer.Serve(listener))
+		}()
+	}
+
+	log.Fatal(http.ListenAndServe(":8080", handler))
 }
@@ -0,0 +1,120 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Attributes are the organisation-specific user attributes declared by the
+// attribute schema, such as designation, department or clearance. In JSON they
+// sit inline next to the fixed fields of the type that holds them, so a user
+// still reads {"username": "harish", "role": "admin", "designation": "..."}.
+type Attributes map[string]interface{}
+
+// Clone returns a shallow copy that can be changed without touching the original
+func (a Attributes) Clone() Attributes {
+	if a == nil {
+		return nil
+	}
+	clone := make(Attributes, len(a))
+	for name, value := range a {
+		clone[name] = value
+	}
+	return clone
+}
+
+// String renders the attributes as a JSON object for console output
+func (a Attributes) String() string {
+	if len(a) == 0 {
+		return "{}"
+	}
+	data, err := json.Marshal(map[string]interface{}(a))
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// marshalInline marshals v and appends the attributes as extra members after
+// v's own fields; attributes named like a field of v are left out
+func marshalInline(v interface{}, attributes Attributes) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(attributes) == 0 {
+		return data, err
+	}
+
+	fields := jsonFieldNames(reflect.TypeOf(v))
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		if !fields[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	out := bytes.TrimSuffix(data, []byte("}"))
+	for _, name := range names {
+		key, _ := json.Marshal(name)
+		value, err := json.Marshal(attributes[name])
+		if err != nil {
+			return nil, err
+		}
+		if len(out) > 1 {
+			out = append(out, ',')
+		}
+		out = append(append(append(out, key...), ':'), value...)
+	}
+	return append(out, '}'), nil
+}
+
+// unmarshalInline unmarshals data into v and returns the members that are not
+// fields of v, or nil when there are none
+func unmarshalInline(data []byte, v interface{}) (Attributes, error) {
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+
+	var members map[string]interface{}
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, err
+	}
+	fields := jsonFieldNames(reflect.TypeOf(v).Elem())
+	var attributes Attributes
+	for name, value := range members {
+		if fields[name] {
+			continue
+		}
+		if attributes == nil {
+			attributes = make(Attributes)
+		}
+		attributes[name] = value
+	}
+	return attributes, nil
+}
+
+// jsonFieldNames lists the JSON member names of a struct type, including those of embedded structs
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			for embedded := range jsonFieldNames(field.Type) {
+				names[embedded] = true
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		names[name] = true
+	}
+	return names
+}
@@ -0,0 +1,105 @@
+package domain
+
+import "time"
+
+// Token formats a client can be issued
+const (
+	TokenFormatSelfContained = "self-contained"
+	TokenFormatOpaque        = "opaque"
+)
+
+// Client authentication methods (RFC 8705 adds tls_client_auth)
+const (
+	AuthMethodClientSecret  = "client_secret_basic"
+	AuthMethodTLSClientAuth = "tls_client_auth"
+)
+
+// Client is an OAuth client, such as a resource server, that authenticates with
+// a secret or a TLS client certificate
+type Client struct {
+	ID          string `json:"client_id"`
+	Name        string `json:"name"`
+	SecretHash  []byte `json:"-"`
+	TokenFormat string `json:"token_format"`
+	AuthMethod  string `json:"token_endpoint_auth_method"`
+	TLSAuth
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TLSAuth names the certificate a tls_client_auth client must present; the
+// first field set is the one that is matched
+type TLSAuth struct {
+	SubjectDN string `json:"tls_client_auth_subject_dn,omitempty"`
+	SANDNS    string `json:"tls_client_auth_san_dns,omitempty"`
+	SANURI    string `json:"tls_client_auth_san_uri,omitempty"`
+}
+
+// IsZero reports whether no certificate identity is configured
+func (t TLSAuth) IsZero() bool {
+	return t == TLSAuth{}
+}
+
+// CreateClientRequest represents the request to register a client
+type CreateClientRequest struct {
+	Name        string `json:"name"`
+	TokenFormat string `json:"token_format,omitempty"`
+	AuthMethod  string `json:"token_endpoint_auth_method,omitempty"`
+	TLSAuth
+}
+
+// CreateClientResponse represents the response after registering a client; the
+// secret is only ever shown here, and tls_client_auth clients get none
+type CreateClientResponse struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	Name         string `json:"name"`
+	TokenFormat  string `json:"token_format"`
+	AuthMethod   string `json:"token_endpoint_auth_method"`
+}
+
+// IntrospectionResponse represents an RFC 7662 token introspection response.
+// Inactive tokens are answered with {"active": false} and nothing else.
+type IntrospectionResponse struct {
+	Active     bool          `json:"active"`
+	Scope      string        `json:"scope,omitempty"`
+	ClientID   string        `json:"client_id,omitempty"`
+	Username   string        `json:"username,omitempty"`
+	TokenType  string        `json:"token_type,omitempty"`
+	Exp        int64         `json:"exp,omitempty"`
+	Iat        int64         `json:"iat,omitempty"`
+	Nbf        int64         `json:"nbf,omitempty"`
+	Sub        string        `json:"sub,omitempty"`
+	Aud        []string      `json:"aud,omitempty"`
+	Iss        string        `json:"iss,omitempty"`
+	Jti        string        `json:"jti,omitempty"`
+	Role       string        `json:"role,omitempty"`
+	Act        *Actor        `json:"act,omitempty"`
+	Cnf        *Confirmation `json:"cnf,omitempty"`
+	AMR        []string      `json:"amr,omitempty"`
+	ACR        string        `json:"acr,omitempty"`
+	AuthTime   int64         `json:"auth_time,omitempty"`
+	Attributes Attributes    `json:"-"`
+}
+
+// MarshalJSON writes the token's attributes inline with the other members
+func (r IntrospectionResponse) MarshalJSON() ([]byte, error) {
+	type plain IntrospectionResponse
+	return marshalInline(plain(r), r.Attributes)
+}
+
+// UnmarshalJSON reads every member that is not an introspection field as an attribute
+func (r *IntrospectionResponse) UnmarshalJSON(data []byte) error {
+	type plain IntrospectionResponse
+	attributes, err := unmarshalInline(data, (*plain)(r))
+	r.Attributes = attributes
+	return err
+}
+
+// TokenResponse represents a successful token endpoint response
+type TokenResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in"`
+	Scope           string `json:"scope,omitempty"`
+}
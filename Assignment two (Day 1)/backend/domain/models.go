@@ -1,46 +1,272 @@
 package domain
 
-import "github.com/golang-jwt/jwt/v5"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
 
 // User represents a registered user in the system
 type User struct {
-	Username    string `json:"username"`
-	Role        string `json:"role"`
-	Designation string `json:"designation"`
-	Age         int    `json:"age"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	Disabled bool   `json:"disabled,omitempty"`
+
+	// Attributes hold the fields declared by the attribute schema
+	Attributes Attributes `json:"-"`
+
+	// TokenVersion is the user's security stamp: it goes up when the role,
+	// disabled flag or credentials change, or on logout, invalidating tokens
+	// already issued
+	TokenVersion int `json:"token_version"`
+
+	// PasswordHash is the bcrypt hash of the user's password, if they set one
+	PasswordHash []byte `json:"-"`
+
+	// TOTPSecret is the base32 secret of the user's authenticator app, if enrolled
+	TOTPSecret string `json:"-"`
+}
+
+// MarshalJSON writes the attributes inline with the user's fields
+func (u User) MarshalJSON() ([]byte, error) {
+	type plain User
+	return marshalInline(plain(u), u.Attributes)
+}
+
+// UnmarshalJSON reads every member that is not a user field as an attribute
+func (u *User) UnmarshalJSON(data []byte) error {
+	type plain User
+	attributes, err := unmarshalInline(data, (*plain)(u))
+	u.Attributes = attributes
+	return err
+}
+
+// ETag returns a strong entity tag for the user's current state
+func (u *User) ETag() string {
+	data, _ := json.Marshal(u)
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
 }
 
 // Claims represents the JWT claims structure
 type Claims struct {
-	Username    string `json:"username"`
-	Role        string `json:"role"`
-	Designation string `json:"designation"`
-	Age         int    `json:"age"`
+	Username string        `json:"username,omitempty"`
+	Role     string        `json:"role,omitempty"`
+	Scope    string        `json:"scope,omitempty"`
+	ClientID string        `json:"client_id,omitempty"`
+	Act      *Actor        `json:"act,omitempty"`
+	Cnf      *Confirmation `json:"cnf,omitempty"`
+
+	// Attributes are the user's attributes projected into the token, written
+	// as top-level claims
+	Attributes Attributes `json:"-"`
+
+	// TokenVersion is the user's TokenVersion when the token was issued
+	TokenVersion int `json:"ver,omitempty"`
+
+	// AMR lists the authentication methods used (RFC 8176), ACR names the
+	// assurance level they add up to and AuthTime is when the user authenticated
+	AMR      []string         `json:"amr,omitempty"`
+	ACR      string           `json:"acr,omitempty"`
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// RegisterRequest represents the request to register a user
+// MarshalJSON writes the attributes as top-level claims
+func (c Claims) MarshalJSON() ([]byte, error) {
+	type plain Claims
+	return marshalInline(plain(c), c.Attributes)
+}
+
+// UnmarshalJSON reads every unregistered claim as an attribute
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	type plain Claims
+	attributes, err := unmarshalInline(data, (*plain)(c))
+	c.Attributes = attributes
+	return err
+}
+
+// Clone returns a deep copy of the claims, so a copy handed out by a token
+// store cannot change the stored claims
+func (c *Claims) Clone() *Claims {
+	clone := *c
+	clone.Attributes = c.Attributes.Clone()
+	clone.AMR = slices.Clone(c.AMR)
+	clone.Audience = slices.Clone(c.Audience)
+	if c.Cnf != nil {
+		cnf := *c.Cnf
+		clone.Cnf = &cnf
+	}
+	if c.Act != nil {
+		clone.Act = c.Act.Clone()
+	}
+	for _, date := range []**jwt.NumericDate{&clone.AuthTime, &clone.ExpiresAt, &clone.NotBefore, &clone.IssuedAt} {
+		if *date != nil {
+			copied := **date
+			*date = &copied
+		}
+	}
+	return &clone
+}
+
+// Authentication returns how and when the token's user authenticated, or nil
+// when the token does not say
+func (c *Claims) Authentication() *Authentication {
+	if c.AuthTime == nil {
+		return nil
+	}
+	return &Authentication{Methods: c.AMR, Level: c.ACR, Time: c.AuthTime.Time}
+}
+
+// Authentication records how and when a user authenticated, for the amr, acr and auth_time claims
+type Authentication struct {
+	Methods []string
+	Level   string
+	Time    time.Time
+}
+
+// Confirmation is the cnf claim binding a token to a key the client must prove it holds
+type Confirmation struct {
+	// JKT is the RFC 7638 thumbprint of the client's DPoP key (RFC 9449)
+	JKT string `json:"jkt,omitempty"`
+
+	// X5TS256 is the SHA-256 thumbprint of the client's TLS certificate (RFC 8705)
+	X5TS256 string `json:"x5t#S256,omitempty"`
+}
+
+// Actor is an RFC 8693 act claim: the party acting on the subject's behalf,
+// nesting the actors before it
+type Actor struct {
+	Subject string `json:"sub"`
+	Act     *Actor `json:"act,omitempty"`
+}
+
+// Clone returns a copy of the delegation chain
+func (a *Actor) Clone() *Actor {
+	clone := *a
+	if a.Act != nil {
+		clone.Act = a.Act.Clone()
+	}
+	return &clone
+}
+
+// Depth returns how many actors the delegation chain holds
+func (a *Actor) Depth() int {
+	depth := 0
+	for actor := a; actor != nil; actor = actor.Act {
+		depth++
+	}
+	return depth
+}
+
+// PrimaryAudience returns the first aud value, or "" when the token has no audience
+func (c *Claims) PrimaryAudience() string {
+	if len(c.Audience) == 0 {
+		return ""
+	}
+	return c.Audience[0]
+}
+
+// IsServiceAccount reports whether the token was issued to a service account
+// through the client credentials grant: it has no username, and sub is the client_id
+func (c *Claims) IsServiceAccount() bool {
+	return c.Username == "" && c.Subject != "" && c.Subject == c.ClientID
+}
+
+// Scopes returns the space-separated scope claim as a list
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// HasScope reports whether the token was granted scope
+func (c *Claims) HasScope(scope string) bool {
+	return slices.Contains(c.Scopes(), scope)
+}
+
+// RegisterRequest represents the request to register a user: the username and,
+// inline, the attributes. There is no role: self-registered users get the
+// catalog's default role.
 type RegisterRequest struct {
-	Username    string `json:"username"`
-	Role        string `json:"role"`
-	Designation string `json:"designation"`
-	Age         int    `json:"age"`
+	Username   string     `json:"username"`
+	Password   string     `json:"password,omitempty"`
+	Attributes Attributes `json:"-"`
+}
+
+// MarshalJSON writes the attributes inline with username and password
+func (r RegisterRequest) MarshalJSON() ([]byte, error) {
+	type plain RegisterRequest
+	return marshalInline(plain(r), r.Attributes)
+}
+
+// UnmarshalJSON reads every member other than username and password as an attribute
+func (r *RegisterRequest) UnmarshalJSON(data []byte) error {
+	type plain RegisterRequest
+	attributes, err := unmarshalInline(data, (*plain)(r))
+	r.Attributes = attributes
+	return err
+}
+
+// UpdateUserRequest represents a change to a user; omitted fields are left as
+// they are and attributes set to null are removed. Role and Disabled are
+// privileged and cannot be changed by the user themselves.
+type UpdateUserRequest struct {
+	Role       *string    `json:"role,omitempty"`
+	Disabled   *bool      `json:"disabled,omitempty"`
+	Attributes Attributes `json:"-"`
+}
+
+// MarshalJSON writes the attribute changes inline with role and disabled
+func (r UpdateUserRequest) MarshalJSON() ([]byte, error) {
+	type plain UpdateUserRequest
+	return marshalInline(plain(r), r.Attributes)
+}
+
+// UnmarshalJSON reads every member other than role and disabled as an attribute change
+func (r *UpdateUserRequest) UnmarshalJSON(data []byte) error {
+	type plain UpdateUserRequest
+	attributes, err := unmarshalInline(data, (*plain)(r))
+	r.Attributes = attributes
+	return err
+}
+
+// UserListResponse is one page of users
+type UserListResponse struct {
+	Users   []*User `json:"users"`
+	Page    int     `json:"page"`
+	PerPage int     `json:"per_page"`
+	Total   int     `json:"total"`
 }
 
 // RegisterResponse represents the response after registering a user
 type RegisterResponse struct {
 	Message  string `json:"message"`
 	Username string `json:"username"`
+	Role     string `json:"role"`
 }
 
-// GenerateRequest represents the request to generate a JWT
+// GenerateRequest represents the request to generate a JWT. Users with a
+// password must send it; an OTP from an enrolled authenticator app steps the
+// token up to multi-factor.
 type GenerateRequest struct {
 	Username string `json:"username"`
+	Password string `json:"password,omitempty"`
+	OTP      string `json:"otp,omitempty"`
+	Audience string `json:"audience,omitempty"`
+	Scope    string `json:"scope,omitempty"`
 }
 
 // GenerateResponse represents the response after generating a JWT
 type GenerateResponse struct {
-	Token string `json:"token"`
+	Token     string `json:"token,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+
+	// CSRFToken is set instead of Token when the token went into an HttpOnly cookie
+	CSRFToken string `json:"csrf_token,omitempty"`
 }
 
 // ValidateRequest represents the request to validate a JWT
@@ -57,8 +283,32 @@ type ValidateResponse struct {
 
 // ClaimsData represents the claims data returned in validation response
 type ClaimsData struct {
-	Username    string `json:"username"`
-	Role        string `json:"role"`
-	Designation string `json:"designation"`
-	Age         int    `json:"age"`
+	Username   string     `json:"username,omitempty"`
+	Role       string     `json:"role,omitempty"`
+	Scope      string     `json:"scope,omitempty"`
+	ClientID   string     `json:"client_id,omitempty"`
+	AMR        []string   `json:"amr,omitempty"`
+	ACR        string     `json:"acr,omitempty"`
+	AuthTime   int64      `json:"auth_time,omitempty"`
+	Attributes Attributes `json:"-"`
+}
+
+// OTPEnrollment represents a new authenticator app enrollment; the secret is only shown here
+type OTPEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// MarshalJSON writes the attributes inline with the other claims
+func (c ClaimsData) MarshalJSON() ([]byte, error) {
+	type plain ClaimsData
+	return marshalInline(plain(c), c.Attributes)
+}
+
+// UnmarshalJSON reads every member that is not a claim field as an attribute
+func (c *ClaimsData) UnmarshalJSON(data []byte) error {
+	type plain ClaimsData
+	attributes, err := unmarshalInline(data, (*plain)(c))
+	c.Attributes = attributes
+	return err
 }
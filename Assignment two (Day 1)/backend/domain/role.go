@@ -0,0 +1,23 @@
+package domain
+
+import "time"
+
+// Role is a role of the role catalog with the permissions (scopes) its tokens may carry
+type Role struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+// AssignRoleRequest represents an admin request to give a user a role
+type AssignRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// AuditEntry records one administrative change
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`
+	Action string    `json:"action"`
+	Target string    `json:"target"`
+	Detail string    `json:"detail,omitempty"`
+}
@@ -0,0 +1,186 @@
+package domain
+
+// SCIM 2.0 schema URNs (RFC 7643) and message URNs (RFC 7644)
+const (
+	SCIMUserSchema                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SCIMGroupSchema                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SCIMEnterpriseUserSchema        = "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"
+	SCIMServiceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SCIMResourceTypeSchema          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SCIMSchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+	SCIMListResponseSchema          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SCIMPatchOpSchema               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SCIMErrorSchema                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// SCIMUser is a user as a SCIM resource. The id and userName are both the
+// username; role and the schema's attributes sit in the enterprise extension.
+type SCIMUser struct {
+	Schemas    []string            `json:"schemas"`
+	ID         string              `json:"id,omitempty"`
+	UserName   string              `json:"userName"`
+	Active     *bool               `json:"active,omitempty"`
+	Password   string              `json:"password,omitempty"`
+	Groups     []SCIMMember        `json:"groups,omitempty"`
+	Enterprise *SCIMEnterpriseUser `json:"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User,omitempty"`
+	Meta       *SCIMMeta           `json:"meta,omitempty"`
+}
+
+// SCIMEnterpriseUser is the enterprise extension of a SCIM user: the role and,
+// inline, the attributes declared by the attribute schema
+type SCIMEnterpriseUser struct {
+	Role       string     `json:"role,omitempty"`
+	Attributes Attributes `json:"-"`
+}
+
+// MarshalJSON writes the attributes inline with the role
+func (e SCIMEnterpriseUser) MarshalJSON() ([]byte, error) {
+	type plain SCIMEnterpriseUser
+	return marshalInline(plain(e), e.Attributes)
+}
+
+// UnmarshalJSON reads every member other than role as an attribute
+func (e *SCIMEnterpriseUser) UnmarshalJSON(data []byte) error {
+	type plain SCIMEnterpriseUser
+	attributes, err := unmarshalInline(data, (*plain)(e))
+	e.Attributes = attributes
+	return err
+}
+
+// SCIMGroup is a role of the role catalog as a SCIM resource: the id and
+// displayName are the role name and the members are the users holding it
+type SCIMGroup struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []SCIMMember `json:"members,omitempty"`
+	Meta        *SCIMMeta    `json:"meta,omitempty"`
+}
+
+// SCIMMember refers to a group member or to a group a user belongs to
+type SCIMMember struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// SCIMMeta describes a SCIM resource; Version is its ETag
+type SCIMMeta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location,omitempty"`
+	Version      string `json:"version,omitempty"`
+}
+
+// SCIMListResponse is one page of resources; StartIndex counts from 1
+type SCIMListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+// SCIMPatchRequest is a SCIM PATCH body: operations applied in order, all or none
+type SCIMPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []SCIMPatchOperation `json:"Operations"`
+}
+
+// SCIMPatchOperation is one add, replace or remove. Without a path, the value
+// is an object whose members are each applied as a path.
+type SCIMPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// SCIMError is the body of every SCIM error response; Status is the HTTP status as a string
+type SCIMError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	SCIMType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// SCIMServiceProviderConfig tells SCIM clients which optional features are supported
+type SCIMServiceProviderConfig struct {
+	Schemas               []string                 `json:"schemas"`
+	Patch                 SCIMSupported            `json:"patch"`
+	Bulk                  SCIMBulk                 `json:"bulk"`
+	Filter                SCIMFilterSupport        `json:"filter"`
+	ChangePassword        SCIMSupported            `json:"changePassword"`
+	Sort                  SCIMSupported            `json:"sort"`
+	ETag                  SCIMSupported            `json:"etag"`
+	AuthenticationSchemes []SCIMAuthenticationType `json:"authenticationSchemes"`
+	Meta                  *SCIMMeta                `json:"meta,omitempty"`
+}
+
+// SCIMSupported says whether an optional feature is supported
+type SCIMSupported struct {
+	Supported bool `json:"supported"`
+}
+
+// SCIMBulk describes bulk support and its limits
+type SCIMBulk struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+// SCIMFilterSupport describes filter support and the largest page a query returns
+type SCIMFilterSupport struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+// SCIMAuthenticationType describes how SCIM clients authenticate
+type SCIMAuthenticationType struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Primary     bool   `json:"primary,omitempty"`
+}
+
+// SCIMResourceType describes an endpoint and the schemas of its resources
+type SCIMResourceType struct {
+	Schemas          []string              `json:"schemas"`
+	ID               string                `json:"id"`
+	Name             string                `json:"name"`
+	Endpoint         string                `json:"endpoint"`
+	Description      string                `json:"description,omitempty"`
+	Schema           string                `json:"schema"`
+	SchemaExtensions []SCIMSchemaExtension `json:"schemaExtensions,omitempty"`
+	Meta             *SCIMMeta             `json:"meta,omitempty"`
+}
+
+// SCIMSchemaExtension names an extension schema a resource type may carry
+type SCIMSchemaExtension struct {
+	Schema   string `json:"schema"`
+	Required bool   `json:"required"`
+}
+
+// SCIMSchema describes the attributes of a resource schema or extension
+type SCIMSchema struct {
+	Schemas     []string        `json:"schemas"`
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Attributes  []SCIMAttribute `json:"attributes"`
+	Meta        *SCIMMeta       `json:"meta,omitempty"`
+}
+
+// SCIMAttribute describes one attribute of a SCIM schema (RFC 7643 section 7)
+type SCIMAttribute struct {
+	Name            string          `json:"name"`
+	Type            string          `json:"type"`
+	MultiValued     bool            `json:"multiValued"`
+	Description     string          `json:"description,omitempty"`
+	Required        bool            `json:"required"`
+	CanonicalValues []interface{}   `json:"canonicalValues,omitempty"`
+	CaseExact       bool            `json:"caseExact"`
+	Mutability      string          `json:"mutability"`
+	Returned        string          `json:"returned"`
+	Uniqueness      string          `json:"uniqueness"`
+	ReferenceTypes  []string        `json:"referenceTypes,omitempty"`
+	SubAttributes   []SCIMAttribute `json:"subAttributes,omitempty"`
+}
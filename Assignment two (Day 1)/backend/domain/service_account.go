@@ -0,0 +1,63 @@
+package domain
+
+import "time"
+
+// ServiceAccount is a non-human principal, such as a batch job or another
+// service, that authenticates with API keys instead of a username
+type ServiceAccount struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// APIKey is a credential of a service account. Only the SHA-256 of the secret
+// is kept; Prefix is the non-secret start of the key that identifies it in
+// listings and logs.
+type APIKey struct {
+	ID               string     `json:"id"`
+	ServiceAccountID string     `json:"service_account_id"`
+	Prefix           string     `json:"prefix"`
+	Hash             []byte     `json:"-"`
+	Scopes           []string   `json:"scopes"`
+	CreatedAt        time.Time  `json:"created_at"`
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt       *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Usable reports whether the key is neither revoked nor expired at now
+func (k *APIKey) Usable(now time.Time) bool {
+	if k.RevokedAt != nil && !k.RevokedAt.After(now) {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+// CreateServiceAccountRequest represents the request to create a service account
+type CreateServiceAccountRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// CreateAPIKeyRequest represents the request to issue an API key; a key
+// without expires_at does not expire
+type CreateAPIKeyRequest struct {
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// RotateAPIKeyRequest represents the request to replace an API key. The old
+// key keeps working for the grace period, e.g. "1h", so callers can switch
+// over; without one it is revoked at once.
+type RotateAPIKeyRequest struct {
+	GracePeriod string     `json:"grace_period,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// CreateAPIKeyResponse represents a newly issued API key; the key itself is
+// only ever shown here
+type CreateAPIKeyResponse struct {
+	*APIKey
+	Key string `json:"api_key"`
+}
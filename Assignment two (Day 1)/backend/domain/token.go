@@ -0,0 +1,21 @@
+package domain
+
+import "time"
+
+// StoredToken is the server-side record behind an opaque token
+type StoredToken struct {
+	Claims *Claims
+
+	// IdleExpiresAt, when set, expires the token early if it goes unused
+	IdleExpiresAt time.Time
+}
+
+// Expired reports whether the token is past its idle expiry, or past its
+// absolute expiry allowing for leeway. The idle expiry is set by this server's
+// own clock, so it gets no leeway.
+func (t *StoredToken) Expired(now time.Time, leeway time.Duration) bool {
+	if t.Claims.ExpiresAt != nil && now.After(t.Claims.ExpiresAt.Add(leeway)) {
+		return true
+	}
+	return !t.IdleExpiresAt.IsZero() && now.After(t.IdleExpiresAt)
+}
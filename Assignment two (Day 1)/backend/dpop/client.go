@@ -0,0 +1,180 @@
+// Package dpop is a client helper for DPoP (RFC 9449): it holds the client's
+// proof key, signs a fresh proof for every request and picks up server nonces.
+package dpop
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"jwt-auth-system/backend/services"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Prover signs DPoP proofs with one key pair
+type Prover struct {
+	key   *services.SigningKey
+	nonce string
+	mu    sync.Mutex
+}
+
+// NewProver creates a prover for an existing key: ECDSA P-256 signs with ES256,
+// RSA with PS256 and Ed25519 with EdDSA
+func NewProver(private crypto.Signer) (*Prover, error) {
+	var algorithm string
+	switch private.(type) {
+	case *ecdsa.PrivateKey:
+		algorithm = services.AlgES256
+	case *rsa.PrivateKey:
+		algorithm = services.AlgPS256
+	case ed25519.PrivateKey:
+		algorithm = services.AlgEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported DPoP key type %T", private)
+	}
+
+	key, err := services.NewSigningKey(algorithm, private, private.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &Prover{key: key}, nil
+}
+
+// GenerateProver creates a prover with a fresh ES256 key
+func GenerateProver() (*Prover, error) {
+	key, err := services.GenerateSigningKey(services.AlgES256)
+	if err != nil {
+		return nil, err
+	}
+	return &Prover{key: key}, nil
+}
+
+// Thumbprint returns the key's RFC 7638 thumbprint, which bound tokens carry as cnf.jkt
+func (p *Prover) Thumbprint() string {
+	return p.key.KeyID
+}
+
+// SetNonce sets the server nonce to put in the following proofs
+func (p *Prover) SetNonce(nonce string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.nonce = nonce
+}
+
+// Proof signs a proof for one request. accessToken is the token sent with the
+// request, or "" when requesting a token.
+func (p *Prover) Proof(method, url, accessToken string) (string, error) {
+	jwk, _ := p.key.PublicJWK()
+	jwk.Kid, jwk.Use, jwk.Alg = "", "", ""
+
+	p.mu.Lock()
+	nonce := p.nonce
+	p.mu.Unlock()
+
+	claims := services.DPoPClaims{
+		Method: method,
+		URI:    strings.SplitN(strings.SplitN(url, "?", 2)[0], "#", 2)[0],
+		Nonce:  nonce,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       randomID(),
+			IssuedAt: jwt.NewNumericDate(time.Now()),
+		},
+	}
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		claims.ATH = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+
+	token := jwt.NewWithClaims(p.key.Method(), claims)
+	token.Header["typ"] = services.DPoPProofType
+	token.Header["jwk"] = jwk
+	return token.SignedString(p.key.PrivateKey)
+}
+
+// Do sends req with a DPoP proof, and with accessToken under the DPoP scheme
+// when one is given. When the server asks for a nonce, the request is retried
+// once with it; requests with a body must have GetBody set for that, as
+// http.NewRequest does for common body types.
+func (p *Prover) Do(client *http.Client, req *http.Request, accessToken string) (*http.Response, error) {
+	resp, err := p.send(client, req, accessToken)
+	if err != nil || !needsNonce(resp) {
+		return resp, err
+	}
+
+	resp.Body.Close()
+	if req.Body != nil {
+		if req.GetBody == nil {
+			return nil, fmt.Errorf("server requires a DPoP nonce but the request body cannot be replayed")
+		}
+		if req.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return p.send(client, req, accessToken)
+}
+
+// send attaches a fresh proof, sends the request and remembers any nonce in the response
+func (p *Prover) send(client *http.Client, req *http.Request, accessToken string) (*http.Response, error) {
+	proof, err := p.Proof(req.Method, req.URL.String(), accessToken)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("DPoP", proof)
+	if accessToken != "" {
+		req.Header.Set("Authorization", "DPoP "+accessToken)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if nonce := resp.Header.Get("DPoP-Nonce"); nonce != "" {
+		p.SetNonce(nonce)
+	}
+	return resp, nil
+}
+
+// needsNonce reports whether the server rejected the proof for lacking its nonce
+func needsNonce(resp *http.Response) bool {
+	if resp.Header.Get("DPoP-Nonce") == "" {
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		return strings.Contains(resp.Header.Get("WWW-Authenticate"), "use_dpop_nonce")
+	case http.StatusBadRequest:
+		// Put the body back so callers can still read it when there is no retry
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+
+		var body struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal(data, &body)
+		return body.Error == "use_dpop_nonce"
+	}
+	return false
+}
+
+// randomID returns a random proof identifier
+func randomID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(id)
+}
@@ -0,0 +1,250 @@
+package dpop
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/middleware"
+	"jwt-auth-system/backend/services"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testURL = "https://api.example.com/docs"
+
+// signProof signs a proof like Proof does, after modify has changed its claims
+func signProof(t *testing.T, p *Prover, accessToken string, modify func(claims *services.DPoPClaims)) string {
+	t.Helper()
+
+	jwk, _ := p.key.PublicJWK()
+	jwk.Kid, jwk.Use, jwk.Alg = "", "", ""
+	sum := sha256.Sum256([]byte(accessToken))
+	claims := services.DPoPClaims{
+		Method: http.MethodGet,
+		URI:    testURL,
+		ATH:    base64.RawURLEncoding.EncodeToString(sum[:]),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       randomID(),
+			IssuedAt: jwt.NewNumericDate(time.Now()),
+		},
+	}
+	modify(&claims)
+
+	token := jwt.NewWithClaims(p.key.Method(), claims)
+	token.Header["typ"] = services.DPoPProofType
+	token.Header["jwk"] = jwk
+	proof, err := token.SignedString(p.key.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proof
+}
+
+func TestVerifyProof(t *testing.T) {
+	prover, err := GenerateProver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := services.NewDPoPVerifier(false, 0)
+
+	tests := []struct {
+		name   string
+		modify func(claims *services.DPoPClaims)
+		want   error
+	}{
+		{"valid", func(claims *services.DPoPClaims) {}, nil},
+		{"htu differs only in case and query", func(claims *services.DPoPClaims) {
+			claims.URI = "HTTPS://API.example.com/docs?page=2"
+		}, nil},
+		{"wrong htm", func(claims *services.DPoPClaims) {
+			claims.Method = http.MethodPost
+		}, services.ErrInvalidDPoPProof},
+		{"wrong htu host", func(claims *services.DPoPClaims) {
+			claims.URI = "https://evil.example.com/docs"
+		}, services.ErrInvalidDPoPProof},
+		{"wrong htu path", func(claims *services.DPoPClaims) {
+			claims.URI = "https://api.example.com/admin"
+		}, services.ErrInvalidDPoPProof},
+		{"stale iat", func(claims *services.DPoPClaims) {
+			claims.IssuedAt = jwt.NewNumericDate(time.Now().Add(-10 * time.Minute))
+		}, services.ErrInvalidDPoPProof},
+		{"iat in the future", func(claims *services.DPoPClaims) {
+			claims.IssuedAt = jwt.NewNumericDate(time.Now().Add(10 * time.Minute))
+		}, services.ErrInvalidDPoPProof},
+		{"missing iat", func(claims *services.DPoPClaims) {
+			claims.IssuedAt = nil
+		}, services.ErrInvalidDPoPProof},
+		{"missing jti", func(claims *services.DPoPClaims) {
+			claims.ID = ""
+		}, services.ErrInvalidDPoPProof},
+		{"ath of another token", func(claims *services.DPoPClaims) {
+			sum := sha256.Sum256([]byte("another-token"))
+			claims.ATH = base64.RawURLEncoding.EncodeToString(sum[:])
+		}, services.ErrInvalidDPoPProof},
+		{"missing ath", func(claims *services.DPoPClaims) {
+			claims.ATH = ""
+		}, services.ErrInvalidDPoPProof},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proof := signProof(t, prover, "access-token", test.modify)
+			jkt, err := verifier.VerifyProof(proof, http.MethodGet, testURL, "access-token")
+			if !errors.Is(err, test.want) || (test.want == nil && err != nil) {
+				t.Fatalf("VerifyProof error = %v, want %v", err, test.want)
+			}
+			if err == nil && jkt != prover.Thumbprint() {
+				t.Errorf("jkt = %s, want %s", jkt, prover.Thumbprint())
+			}
+		})
+	}
+}
+
+func TestVerifyProofRejectsReplay(t *testing.T) {
+	prover, err := GenerateProver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := services.NewDPoPVerifier(false, 0)
+
+	proof, err := prover.Proof(http.MethodGet, testURL+"?page=1", "access-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.VerifyProof(proof, http.MethodGet, testURL, "access-token"); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if _, err := verifier.VerifyProof(proof, http.MethodGet, testURL, "access-token"); !errors.Is(err, services.ErrInvalidDPoPProof) {
+		t.Fatalf("replay error = %v, want %v", err, services.ErrInvalidDPoPProof)
+	}
+}
+
+func TestVerifyProofNonce(t *testing.T) {
+	prover, err := GenerateProver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := services.NewDPoPVerifier(true, 0)
+
+	proof, _ := prover.Proof(http.MethodGet, testURL, "")
+	if _, err := verifier.VerifyProof(proof, http.MethodGet, testURL, ""); !errors.Is(err, services.ErrUseDPoPNonce) {
+		t.Fatalf("without nonce error = %v, want %v", err, services.ErrUseDPoPNonce)
+	}
+
+	prover.SetNonce("forged-nonce")
+	proof, _ = prover.Proof(http.MethodGet, testURL, "")
+	if _, err := verifier.VerifyProof(proof, http.MethodGet, testURL, ""); !errors.Is(err, services.ErrUseDPoPNonce) {
+		t.Fatalf("forged nonce error = %v, want %v", err, services.ErrUseDPoPNonce)
+	}
+
+	prover.SetNonce(verifier.NewNonce())
+	proof, _ = prover.Proof(http.MethodGet, testURL, "")
+	if _, err := verifier.VerifyProof(proof, http.MethodGet, testURL, ""); err != nil {
+		t.Fatalf("with server nonce: %v", err)
+	}
+}
+
+// newProtectedServer serves a route behind the authenticator that accepts
+// DPoP-bound tokens, counting the requests it gets
+func newProtectedServer(t *testing.T, verifier *services.DPoPVerifier) (*httptest.Server, *services.JWTService, *int) {
+	t.Helper()
+
+	key, err := services.GenerateSigningKey(services.AlgHS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := services.NewJWTServiceWithKeyring(services.NewKeyring(key, time.Hour))
+	authenticator := middleware.NewAuthenticator(tokens, middleware.Options{DPoP: verifier})
+
+	requests := 0
+	protected := authenticator.Authenticate(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(middleware.UsernameFromContext(r.Context())))
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		protected(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server, tokens, &requests
+}
+
+func TestProverDoRetriesWithNonce(t *testing.T) {
+	prover, err := GenerateProver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, tokens, requests := newProtectedServer(t, services.NewDPoPVerifier(true, 0))
+
+	token, err := tokens.GenerateTokenWithOptions(&domain.User{Username: "alice", Role: "user"},
+		services.TokenOptions{Confirmation: &domain.Confirmation{JKT: prover.Thumbprint()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/docs", nil)
+	resp, err := prover.Do(server.Client(), req, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if *requests != 2 {
+		t.Errorf("server got %d requests, want the nonce challenge and the retry", *requests)
+	}
+
+	// The nonce from the last response is reused without another challenge
+	req, _ = http.NewRequest(http.MethodGet, server.URL+"/docs", nil)
+	resp, err = prover.Do(server.Client(), req, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || *requests != 3 {
+		t.Errorf("second request: status %d after %d requests, want 200 after 3", resp.StatusCode, *requests)
+	}
+}
+
+func TestBoundTokenNeedsItsKey(t *testing.T) {
+	prover, err := GenerateProver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := GenerateProver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, tokens, _ := newProtectedServer(t, services.NewDPoPVerifier(false, 0))
+
+	token, err := tokens.GenerateTokenWithOptions(&domain.User{Username: "alice", Role: "user"},
+		services.TokenOptions{Confirmation: &domain.Confirmation{JKT: prover.Thumbprint()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/docs", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("as a bearer token: status = %d, want 401", resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, server.URL+"/docs", nil)
+	resp, err = other.Do(server.Client(), req, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("with another key's proof: status = %d, want 401", resp.StatusCode)
+	}
+}
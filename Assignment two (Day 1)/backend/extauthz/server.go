@@ -0,0 +1,169 @@
+// Package extauthz is an Envoy ext_authz v3 gRPC authorization server. It runs
+// each Check through the same token validation and route rules as /forward-auth,
+// so a service mesh and an HTTP reverse proxy enforce identical policies.
+package extauthz
+
+import (
+	"bytes"
+	"context"
+	"jwt-auth-system/backend/handlers"
+	"net/http"
+	"net/url"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// deniedHeaders are the headers of a refusal that Envoy passes on to the client
+var deniedHeaders = []string{"WWW-Authenticate", "Location", "Content-Type", "DPoP-Nonce"}
+
+// Server implements the envoy.service.auth.v3.Authorization service
+type Server struct {
+	authv3.UnimplementedAuthorizationServer
+
+	check http.Handler
+}
+
+// NewServer creates an authorization server that decides with check, normally
+// ForwardAuthHandler.ForwardAuth
+func NewServer(check http.HandlerFunc) *Server {
+	return &Server{check: check}
+}
+
+// Register adds the server to a gRPC server
+func (s *Server) Register(grpcServer *grpc.Server) {
+	authv3.RegisterAuthorizationServer(grpcServer, s)
+}
+
+// Check decides whether Envoy may pass on the request described by req. Allowed
+// requests get the identity headers set, and any the client sent removed;
+// refused ones get the status, challenge and body to answer the client with.
+func (s *Server) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	attributes := req.GetAttributes().GetRequest().GetHttp()
+	if attributes == nil {
+		return denied(codes.InvalidArgument, http.StatusBadRequest, nil, "check request carries no HTTP attributes"), nil
+	}
+
+	forwarded, err := forwardedRequest(ctx, attributes)
+	if err != nil {
+		return denied(codes.InvalidArgument, http.StatusBadRequest, nil, err.Error()), nil
+	}
+
+	result := newRecorder()
+	s.check.ServeHTTP(result, forwarded)
+
+	if result.status != http.StatusOK {
+		code := codes.PermissionDenied
+		if result.status == http.StatusUnauthorized || result.status == http.StatusFound {
+			code = codes.Unauthenticated
+		}
+		return denied(code, result.status, result.header, result.body.String()), nil
+	}
+	return allowed(result.header), nil
+}
+
+// forwardedRequest describes the request Envoy is asking about the way a
+// reverse proxy describes it to /forward-auth
+func forwardedRequest(ctx context.Context, attributes *authv3.AttributeContext_HttpRequest) (*http.Request, error) {
+	scheme := attributes.GetScheme()
+	if scheme == "" {
+		scheme = "http"
+	}
+	path := attributes.GetPath()
+	if path == "" {
+		path = "/"
+	}
+
+	forwarded, err := http.NewRequestWithContext(ctx, attributes.GetMethod(), (&url.URL{Scheme: scheme, Host: attributes.GetHost()}).String()+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range attributes.GetHeaders() {
+		// Envoy includes HTTP/2 pseudo-headers such as :path; the attributes above cover them
+		if !strings.HasPrefix(name, ":") {
+			forwarded.Header.Set(name, value)
+		}
+	}
+	forwarded.Header.Set("X-Forwarded-Method", attributes.GetMethod())
+	forwarded.Header.Set("X-Forwarded-Proto", scheme)
+	forwarded.Header.Set("X-Forwarded-Host", attributes.GetHost())
+	forwarded.Header.Set("X-Forwarded-Uri", path)
+	return forwarded, nil
+}
+
+// allowed lets the request through with the identity headers from header
+func allowed(header http.Header) *authv3.CheckResponse {
+	ok := &authv3.OkHttpResponse{}
+	for _, name := range handlers.ForwardAuthHeaders {
+		if value := header.Get(name); value != "" {
+			ok.Headers = append(ok.Headers, headerOption(name, value))
+		} else {
+			ok.HeadersToRemove = append(ok.HeadersToRemove, strings.ToLower(name))
+		}
+	}
+
+	return &authv3.CheckResponse{
+		Status:       &status.Status{Code: int32(codes.OK)},
+		HttpResponse: &authv3.CheckResponse_OkResponse{OkResponse: ok},
+	}
+}
+
+// denied refuses the request, answering the client with httpStatus, the
+// relevant headers from header and body
+func denied(code codes.Code, httpStatus int, header http.Header, body string) *authv3.CheckResponse {
+	response := &authv3.DeniedHttpResponse{
+		Status: &typev3.HttpStatus{Code: typev3.StatusCode(httpStatus)},
+		Body:   body,
+	}
+	for _, name := range deniedHeaders {
+		if value := header.Get(name); value != "" {
+			response.Headers = append(response.Headers, headerOption(name, value))
+		}
+	}
+
+	return &authv3.CheckResponse{
+		Status:       &status.Status{Code: int32(code), Message: http.StatusText(httpStatus)},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{DeniedResponse: response},
+	}
+}
+
+// headerOption sets a header, replacing any value it already has
+func headerOption(name, value string) *corev3.HeaderValueOption {
+	return &corev3.HeaderValueOption{
+		Header:       &corev3.HeaderValue{Key: strings.ToLower(name), Value: value},
+		AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+	}
+}
+
+// recorder keeps the response the check handler writes
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newRecorder() *recorder {
+	return &recorder{header: make(http.Header)}
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *recorder) Write(body []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(body)
+}
@@ -0,0 +1,175 @@
+package extauthz
+
+import (
+	"context"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/dpop"
+	"jwt-auth-system/backend/handlers"
+	"jwt-auth-system/backend/middleware"
+	"jwt-auth-system/backend/services"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestClient serves check over an in-memory connection and returns a client for it
+func newTestClient(t *testing.T, check http.HandlerFunc) authv3.AuthorizationClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	NewServer(check).Register(grpcServer)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return authv3.NewAuthorizationClient(conn)
+}
+
+// checkRequest describes a request the way Envoy does, with lowercase header names
+func checkRequest(method, host, path string, headers map[string]string) *authv3.CheckRequest {
+	all := map[string]string{":method": method, ":path": path, ":authority": host}
+	for name, value := range headers {
+		all[name] = value
+	}
+	return &authv3.CheckRequest{Attributes: &authv3.AttributeContext{
+		Request: &authv3.AttributeContext_Request{Http: &authv3.AttributeContext_HttpRequest{
+			Method:  method,
+			Scheme:  "https",
+			Host:    host,
+			Path:    path,
+			Headers: all,
+		}},
+	}}
+}
+
+// headerValues flattens header options into a map
+func headerValues(options []*corev3.HeaderValueOption) map[string]string {
+	values := make(map[string]string, len(options))
+	for _, option := range options {
+		values[option.GetHeader().GetKey()] = option.GetHeader().GetValue()
+	}
+	return values
+}
+
+func TestCheck(t *testing.T) {
+	key, err := services.GenerateSigningKey(services.AlgHS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := services.NewJWTServiceWithKeyring(services.NewKeyring(key, time.Hour))
+	prover, err := dpop.GenerateProver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	issue := func(role string, cnf *domain.Confirmation) string {
+		token, err := tokens.GenerateTokenWithOptions(&domain.User{Username: "alice", Role: role}, services.TokenOptions{Confirmation: cnf})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	userToken := issue("user", nil)
+	dpopToken := issue("user", &domain.Confirmation{JKT: prover.Thumbprint()})
+	certToken := issue("user", &domain.Confirmation{X5TS256: "certificate-thumbprint"})
+	proof, err := prover.Proof(http.MethodGet, "https://app.example.com/docs", dpopToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := &services.ForwardAuthPolicy{
+		LoginURL: "https://login.example.com/?next={url}",
+		Rules:    []services.ForwardAuthRule{{PathPrefix: "/admin", Roles: []string{"admin"}}},
+	}
+	authenticator := middleware.NewAuthenticator(tokens, middleware.Options{DPoP: services.NewDPoPVerifier(false, 0)})
+	client := newTestClient(t, handlers.NewForwardAuthHandler(authenticator, policy).ForwardAuth)
+
+	tests := []struct {
+		name       string
+		path       string
+		headers    map[string]string
+		code       codes.Code
+		httpStatus int
+		want       map[string]string
+	}{
+		{"allowed", "/docs", map[string]string{"authorization": "Bearer " + userToken, "x-auth-role": "admin"}, codes.OK, 0,
+			map[string]string{"x-auth-user": "alice", "x-auth-role": "user"}},
+		{"no token", "/docs", nil, codes.Unauthenticated, http.StatusUnauthorized,
+			map[string]string{"www-authenticate": `Bearer realm="jwt-auth-system"`}},
+		{"role rule", "/admin/users", map[string]string{"authorization": "Bearer " + userToken}, codes.PermissionDenied, http.StatusForbidden, nil},
+		{"browser sent to log in", "/docs?page=2", map[string]string{"accept": "text/html"}, codes.Unauthenticated, http.StatusFound,
+			map[string]string{"location": "https://login.example.com/?next=https%3A%2F%2Fapp.example.com%2Fdocs%3Fpage%3D2"}},
+		{"DPoP-bound token as a bearer token", "/docs", map[string]string{"authorization": "Bearer " + dpopToken}, codes.Unauthenticated, http.StatusUnauthorized, nil},
+		{"DPoP-bound token without a proof", "/docs", map[string]string{"authorization": "DPoP " + dpopToken}, codes.Unauthenticated, http.StatusUnauthorized, nil},
+		{"DPoP-bound token with its proof", "/docs", map[string]string{"authorization": "DPoP " + dpopToken, "dpop": proof}, codes.OK, 0,
+			map[string]string{"x-auth-user": "alice"}},
+		{"certificate-bound token without the certificate", "/docs", map[string]string{"authorization": "Bearer " + certToken}, codes.Unauthenticated, http.StatusUnauthorized, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := client.Check(context.Background(), checkRequest(http.MethodGet, "app.example.com", test.path, test.headers))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code := codes.Code(resp.GetStatus().GetCode()); code != test.code {
+				t.Fatalf("code = %s, want %s", code, test.code)
+			}
+
+			var headers map[string]string
+			if test.code == codes.OK {
+				ok := resp.GetOkResponse()
+				headers = headerValues(ok.GetHeaders())
+				// The client's forged x-auth-role is overwritten, and headers without a value are removed
+				for _, removed := range ok.GetHeadersToRemove() {
+					if _, set := headers[removed]; set {
+						t.Errorf("%s is both set and removed", removed)
+					}
+				}
+				if len(ok.GetHeaders())+len(ok.GetHeadersToRemove()) != len(handlers.ForwardAuthHeaders) {
+					t.Errorf("allowed response covers %d identity headers, want %d", len(ok.GetHeaders())+len(ok.GetHeadersToRemove()), len(handlers.ForwardAuthHeaders))
+				}
+			} else {
+				denied := resp.GetDeniedResponse()
+				if status := int(denied.GetStatus().GetCode()); status != test.httpStatus {
+					t.Errorf("HTTP status = %d, want %d", status, test.httpStatus)
+				}
+				headers = headerValues(denied.GetHeaders())
+			}
+			for name, want := range test.want {
+				if got := headers[name]; got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckWithoutHTTPAttributes(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("check handler called without a request to check")
+	})
+
+	resp, err := client.Check(context.Background(), &authv3.CheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := codes.Code(resp.GetStatus().GetCode()); code != codes.InvalidArgument {
+		t.Errorf("code = %s, want %s", code, codes.InvalidArgument)
+	}
+}
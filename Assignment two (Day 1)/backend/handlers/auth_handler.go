@@ -2,24 +2,40 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/middleware"
 	"jwt-auth-system/backend/repo"
 	"jwt-auth-system/backend/services"
 	"net/http"
+	"strings"
 )
 
 // AuthHandler handles authentication-related HTTP requests
 type AuthHandler struct {
-	jwtService *services.JWTService
-	userRepo   *repo.UserRepository
+	jwtService  services.TokenService
+	userRepo    *repo.UserRepository
+	permissions *services.PermissionCatalog
+	attributes  *services.AttributeSchema
+	credentials *services.Credentials
+	dpop        *services.DPoPVerifier
+	cookieAuth  bool
 }
 
-// NewAuthHandler creates a new authentication handler
-func NewAuthHandler(jwtService *services.JWTService, userRepo *repo.UserRepository) *AuthHandler {
+// NewAuthHandler creates a new authentication handler; dpop may be nil to
+// disable DPoP-bound tokens. With cookieAuth, tokens are handed to browsers in
+// an HttpOnly cookie instead of the response body.
+func NewAuthHandler(jwtService services.TokenService, userRepo *repo.UserRepository, permissions *services.PermissionCatalog, attributes *services.AttributeSchema, credentials *services.Credentials, dpop *services.DPoPVerifier, cookieAuth bool) *AuthHandler {
 	return &AuthHandler{
-		jwtService: jwtService,
-		userRepo:   userRepo,
+		jwtService:  jwtService,
+		userRepo:    userRepo,
+		permissions: permissions,
+		attributes:  attributes,
+		credentials: credentials,
+		dpop:        dpop,
+		cookieAuth:  cookieAuth,
 	}
 }
 
@@ -37,17 +53,30 @@ func (h *AuthHandler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate required fields
-	if req.Username == "" || req.Role == "" || req.Designation == "" || req.Age <= 0 {
-		http.Error(w, "All fields are required (username, role, designation, age)", http.StatusBadRequest)
+	if req.Username == "" {
+		http.Error(w, "username is required", http.StatusBadRequest)
+		return
+	}
+
+	// Roles are only ever assigned by admins, so a role in the request is ignored
+	delete(req.Attributes, "role")
+	attributes, err := h.attributes.Validate(req.Attributes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	// Create user
 	user := &domain.User{
-		Username:    req.Username,
-		Role:        req.Role,
-		Designation: req.Designation,
-		Age:         req.Age,
+		Username:   req.Username,
+		Role:       h.permissions.DefaultRole,
+		Attributes: attributes,
+	}
+	if req.Password != "" {
+		if user.PasswordHash, err = services.HashPassword(req.Password); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	}
 
 	// Store user in repository
@@ -56,14 +85,15 @@ func (h *AuthHandler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fmt.Printf("User Registered: %s (Role: %s, Designation: %s, Age: %d)\n", 
-		user.Username, user.Role, user.Designation, user.Age)
+	fmt.Printf("User Registered: %s (Role: %s, Attributes: %s)\n",
+		user.Username, user.Role, user.Attributes)
 	fmt.Println("---")
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(domain.RegisterResponse{
 		Message:  "User registered successfully",
 		Username: user.Username,
+		Role:     user.Role,
 	})
 }
 
@@ -91,15 +121,86 @@ func (h *AuthHandler) GenerateToken(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "User not found. Please register first.", http.StatusNotFound)
 		return
 	}
+	if user.Disabled {
+		http.Error(w, "User account is disabled", http.StatusForbidden)
+		return
+	}
+
+	// The methods that succeed become the token's amr, acr and auth_time
+	authentication, err := h.credentials.Authenticate(user, req.Password, req.OTP)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// Without a password or code the username is all the caller proved, which
+	// is only enough for the default role
+	if len(authentication.Methods) == 0 && user.Role != h.permissions.DefaultRole {
+		http.Error(w, fmt.Sprintf("Role %s needs a password or one-time password; ask an admin to set one", user.Role), http.StatusUnauthorized)
+		return
+	}
 
-	token, err := h.jwtService.GenerateToken(user)
+	scopes, err := h.permissions.Grant(user.Role, strings.Fields(req.Scope))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// A DPoP proof or a TLS client certificate binds the token to the client's key
+	cnf, ok := dpopConfirmation(w, r, h.dpop)
+	if !ok {
+		return
+	}
+	cnf = services.CertificateConfirmation(cnf, services.PeerCertificate(r.TLS))
+
+	token, err := h.jwtService.GenerateTokenWithOptions(user, services.TokenOptions{
+		Audience:       req.Audience,
+		Scope:          strings.Join(scopes, " "),
+		Confirmation:   cnf,
+		Authentication: authentication,
+	})
+	if errors.Is(err, services.ErrUnknownAudience) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, services.ErrUnknownRole) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(domain.GenerateResponse{Token: token})
+
+	// DPoP-bound tokens need the DPoP scheme, so they are never put in a cookie
+	if h.cookieAuth && tokenType(cnf) == "Bearer" {
+		csrf := middleware.SetTokenCookies(w, token)
+		json.NewEncoder(w).Encode(domain.GenerateResponse{TokenType: "Bearer", CSRFToken: csrf})
+		return
+	}
+	json.NewEncoder(w).Encode(domain.GenerateResponse{Token: token, TokenType: tokenType(cnf)})
+}
+
+// Logout handles POST /logout in cookie mode: it revokes the cookie's token and
+// clears both cookies
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if token := middleware.TokenFromCookie(r); token != "" {
+		if !middleware.VerifyCSRF(r) {
+			middleware.WriteCSRFError(w)
+			return
+		}
+		h.jwtService.RevokeToken(token)
+	}
+	middleware.ClearTokenCookies(w)
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
 // ValidateToken handles token validation requests
@@ -110,17 +211,31 @@ func (h *AuthHandler) ValidateToken(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req domain.ValidateRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !(h.cookieAuth && errors.Is(err, io.EOF)) {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
+	// In cookie mode browsers validate the token they hold without ever seeing it
+	if req.Token == "" && h.cookieAuth {
+		if req.Token = middleware.TokenFromCookie(r); req.Token != "" && !middleware.VerifyCSRF(r) {
+			middleware.WriteCSRFError(w)
+			return
+		}
+	}
+
 	if req.Token == "" {
 		http.Error(w, "token is required", http.StatusBadRequest)
 		return
 	}
 
 	claims, err := h.jwtService.ValidateToken(req.Token)
+	if err == nil {
+		err = services.CheckConfirmation(claims, services.PeerCertificate(r.TLS))
+	}
+	if err == nil {
+		err = checkDPoPBinding(w, r, h.dpop, req.Token, claims)
+	}
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(domain.ValidateResponse{
@@ -134,11 +249,40 @@ func (h *AuthHandler) ValidateToken(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(domain.ValidateResponse{
 		Valid:   true,
 		Message: "Token Valid",
-		Claims: domain.ClaimsData{
-			Username:    claims.Username,
-			Role:        claims.Role,
-			Designation: claims.Designation,
-			Age:         claims.Age,
-		},
+		Claims:  claimsData(claims),
 	})
 }
+
+// Me returns the claims of the bearer token presented with the request
+func (h *AuthHandler) Me(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, ok := middleware.ClaimsFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(claimsData(claims))
+}
+
+// claimsData picks the claims that /validate and /me report
+func claimsData(claims *domain.Claims) domain.ClaimsData {
+	data := domain.ClaimsData{
+		Username:   claims.Username,
+		Role:       claims.Role,
+		Scope:      claims.Scope,
+		ClientID:   claims.ClientID,
+		AMR:        claims.AMR,
+		ACR:        claims.ACR,
+		Attributes: claims.Attributes,
+	}
+	if claims.AuthTime != nil {
+		data.AuthTime = claims.AuthTime.Unix()
+	}
+	return data
+}
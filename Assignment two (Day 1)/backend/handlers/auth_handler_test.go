@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/json"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/dpop"
+	"jwt-auth-system/backend/repo"
+	"jwt-auth-system/backend/services"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateChecksDPoPBinding(t *testing.T) {
+	key, err := services.GenerateSigningKey(services.AlgHS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := services.NewJWTServiceWithKeyring(services.NewKeyring(key, time.Hour))
+	verifier := services.NewDPoPVerifier(false, 0)
+	handler := NewAuthHandler(tokens, repo.NewUserRepository(), nil, nil, nil, verifier, false)
+
+	prover, err := dpop.GenerateProver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := dpop.GenerateProver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &domain.User{Username: "alice", Role: "user"}
+	bound, err := tokens.GenerateTokenWithOptions(user, services.TokenOptions{Confirmation: &domain.Confirmation{JKT: prover.Thumbprint()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unbound, err := tokens.GenerateToken(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proofFor := func(p *dpop.Prover, token string) string {
+		proof, err := p.Proof(http.MethodPost, "http://example.com/validate", token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return proof
+	}
+	tests := []struct {
+		name  string
+		token string
+		proof string
+		want  bool
+	}{
+		{"unbound token without proof", unbound, "", true},
+		{"bound token without proof", bound, "", false},
+		{"bound token with its key's proof", bound, proofFor(prover, bound), true},
+		{"bound token with another key's proof", bound, proofFor(other, bound), false},
+		{"bound token with a proof for another token", bound, proofFor(prover, unbound), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, _ := json.Marshal(domain.ValidateRequest{Token: test.token})
+			req := httptest.NewRequest(http.MethodPost, "http://example.com/validate", strings.NewReader(string(body)))
+			if test.proof != "" {
+				req.Header.Set("DPoP", test.proof)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ValidateToken(recorder, req)
+
+			var resp domain.ValidateResponse
+			if err := json.NewDecoder(recorder.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Valid != test.want {
+				t.Errorf("valid = %v (%s), want %v", resp.Valid, resp.Message, test.want)
+			}
+		})
+	}
+}
+
+func TestGenerateTokenNeedsCredentialForOtherRoles(t *testing.T) {
+	key, err := services.GenerateSigningKey(services.AlgHS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := services.NewJWTServiceWithKeyring(services.NewKeyring(key, time.Hour))
+	users := repo.NewUserRepository()
+	handler := NewAuthHandler(tokens, users, services.DefaultPermissionCatalog(), nil, services.NewCredentials("test"), nil, false)
+
+	hash, err := services.HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.RegisterUser(&domain.User{Username: "alice", Role: "user"})
+	users.RegisterUser(&domain.User{Username: "bob", Role: "admin"})
+	users.RegisterUser(&domain.User{Username: "carol", Role: "admin", PasswordHash: hash})
+	users.RegisterUser(&domain.User{Username: "dave", Role: "viewer", TOTPSecret: "JBSWY3DPEHPK3PXP"})
+
+	tests := []struct {
+		name     string
+		request  domain.GenerateRequest
+		want     int
+		wantRole string
+	}{
+		{"default role without a credential", domain.GenerateRequest{Username: "alice"}, http.StatusOK, "user"},
+		{"admin without a credential", domain.GenerateRequest{Username: "bob"}, http.StatusUnauthorized, ""},
+		{"admin with a password", domain.GenerateRequest{Username: "carol", Password: "correct horse"}, http.StatusOK, "admin"},
+		{"admin withholding the password", domain.GenerateRequest{Username: "carol"}, http.StatusUnauthorized, ""},
+		{"viewer with an authenticator app but no code", domain.GenerateRequest{Username: "dave"}, http.StatusUnauthorized, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, _ := json.Marshal(test.request)
+			req := httptest.NewRequest(http.MethodPost, "/generate", strings.NewReader(string(body)))
+			recorder := httptest.NewRecorder()
+			handler.GenerateToken(recorder, req)
+
+			if recorder.Code != test.want {
+				t.Fatalf("status = %d (%s), want %d", recorder.Code, strings.TrimSpace(recorder.Body.String()), test.want)
+			}
+			if test.want != http.StatusOK {
+				return
+			}
+			var resp domain.GenerateResponse
+			if err := json.NewDecoder(recorder.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			claims, err := tokens.ValidateToken(resp.Token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Role != test.wantRole {
+				t.Errorf("role = %q, want %q", claims.Role, test.wantRole)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/middleware"
+	"jwt-auth-system/backend/services"
+	"net/http"
+)
+
+// dpopConfirmation checks the DPoP proof sent with a token request and returns
+// the confirmation to bind the token to, or nil when no proof was sent. On
+// failure it writes the error response and returns false.
+func dpopConfirmation(w http.ResponseWriter, r *http.Request, verifier *services.DPoPVerifier) (*domain.Confirmation, bool) {
+	proofs := r.Header.Values("DPoP")
+	switch {
+	case len(proofs) == 0:
+		return nil, true
+	case verifier == nil:
+		writeOAuthError(w, http.StatusBadRequest, middleware.ErrorInvalidDPoPProof, "DPoP is not enabled on this server")
+		return nil, false
+	case len(proofs) > 1:
+		writeOAuthError(w, http.StatusBadRequest, middleware.ErrorInvalidDPoPProof, "only one DPoP proof is allowed")
+		return nil, false
+	}
+
+	jkt, err := verifier.VerifyProof(proofs[0], r.Method, middleware.RequestURL(r), "")
+	if errors.Is(err, services.ErrUseDPoPNonce) {
+		w.Header().Set("DPoP-Nonce", verifier.NewNonce())
+		writeOAuthError(w, http.StatusBadRequest, middleware.ErrorUseDPoPNonce, err.Error())
+		return nil, false
+	}
+	if err != nil {
+		writeOAuthError(w, http.StatusBadRequest, middleware.ErrorInvalidDPoPProof, err.Error())
+		return nil, false
+	}
+
+	if verifier.RequiresNonce() {
+		w.Header().Set("DPoP-Nonce", verifier.NewNonce())
+	}
+	return &domain.Confirmation{JKT: jkt}, true
+}
+
+// checkDPoPBinding requires a DPoP-bound token to come with a proof for this
+// request made with its key, as the middleware does for the DPoP scheme.
+// Unbound tokens need no proof.
+func checkDPoPBinding(w http.ResponseWriter, r *http.Request, verifier *services.DPoPVerifier, token string, claims *domain.Claims) error {
+	if claims.Cnf == nil || claims.Cnf.JKT == "" {
+		return nil
+	}
+	proofs := r.Header.Values("DPoP")
+	if verifier == nil || len(proofs) != 1 {
+		return fmt.Errorf("%w: the token is DPoP-bound and needs exactly one proof", services.ErrInvalidDPoPProof)
+	}
+
+	jkt, err := verifier.VerifyProof(proofs[0], r.Method, middleware.RequestURL(r), token)
+	if errors.Is(err, services.ErrUseDPoPNonce) {
+		w.Header().Set("DPoP-Nonce", verifier.NewNonce())
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if jkt != claims.Cnf.JKT {
+		return fmt.Errorf("%w: the token is bound to a different key", services.ErrInvalidDPoPProof)
+	}
+
+	if verifier.RequiresNonce() {
+		w.Header().Set("DPoP-Nonce", verifier.NewNonce())
+	}
+	return nil
+}
+
+// tokenType is the token_type to report for a token with the given confirmation;
+// certificate-bound tokens are still bearer tokens on the wire
+func tokenType(cnf *domain.Confirmation) string {
+	if cnf != nil && cnf.JKT != "" {
+		return "DPoP"
+	}
+	return "Bearer"
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"fmt"
+	"jwt-auth-system/backend/middleware"
+	"jwt-auth-system/backend/services"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ForwardAuthHeaders are the identity headers an allowed request is answered
+// with. Proxies must drop any of them the client sent itself.
+var ForwardAuthHeaders = []string{"X-Auth-User", "X-Auth-Subject", "X-Auth-Role", "X-Auth-Scope", "X-Auth-Client-Id", "X-Auth-ACR"}
+
+// ForwardAuthHandler answers the authorization subrequests reverse proxies
+// (nginx auth_request, Traefik forwardAuth, Caddy forward_auth) make before
+// passing a request on to an app that knows nothing about tokens
+type ForwardAuthHandler struct {
+	authenticator *middleware.Authenticator
+	policy        *services.ForwardAuthPolicy
+}
+
+// NewForwardAuthHandler creates a new forward-auth handler; without a policy
+// every request needs just a valid token
+func NewForwardAuthHandler(authenticator *middleware.Authenticator, policy *services.ForwardAuthPolicy) *ForwardAuthHandler {
+	return &ForwardAuthHandler{
+		authenticator: authenticator,
+		policy:        policy,
+	}
+}
+
+// ForwardAuth handles /forward-auth. The proxy describes the original request
+// in X-Forwarded-Method, -Proto, -Host and -Uri (X-Original-Method and
+// X-Original-URI also work) and copies its other headers, so the token comes
+// from its Authorization header or cookie. Any method is accepted, since
+// proxies differ in the one they use. Allowed requests get 200 with X-Auth-*
+// headers for the proxy to pass on; the rest get 401 or 403, or a redirect to
+// the login URL for browsers without a valid token.
+func (h *ForwardAuthHandler) ForwardAuth(w http.ResponseWriter, r *http.Request) {
+	original, err := forwardedRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rule := h.policy.Match(original.Host, original.URL.Path, original.Method)
+	if rule != nil && rule.Public {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	next := allowForwarded
+	if rule != nil {
+		// Guards run outermost first: role, then scopes, then acr
+		if rule.ACR != "" {
+			next = h.authenticator.RequireACR(rule.ACR)(next)
+		}
+		if len(rule.Scopes) > 0 {
+			next = h.authenticator.RequireScopes(rule.Scopes...)(next)
+		}
+		if len(rule.Roles) > 0 {
+			next = h.authenticator.RequireRole(rule.Roles...)(next)
+		}
+	}
+
+	if location := h.policy.LoginRedirect(middleware.RequestURL(original) + queryOf(original)); location != "" && wantsLoginRedirect(r) {
+		w = &loginRedirectWriter{ResponseWriter: w, location: location}
+	}
+	h.authenticator.Authenticate(next)(w, original)
+}
+
+// allowForwarded answers an allowed request with the identity for the app
+func allowForwarded(w http.ResponseWriter, r *http.Request) {
+	claims, _ := middleware.ClaimsFromContext(r.Context())
+
+	user := claims.Username
+	if user == "" {
+		user = claims.Subject
+	}
+	w.Header().Set("X-Auth-User", user)
+	w.Header().Set("X-Auth-Subject", claims.Subject)
+	if claims.Role != "" {
+		w.Header().Set("X-Auth-Role", claims.Role)
+	}
+	if claims.Scope != "" {
+		w.Header().Set("X-Auth-Scope", claims.Scope)
+	}
+	if claims.ClientID != "" {
+		w.Header().Set("X-Auth-Client-Id", claims.ClientID)
+	}
+	if claims.ACR != "" {
+		w.Header().Set("X-Auth-ACR", claims.ACR)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// forwardedRequest rebuilds the request the proxy is asking about, so the
+// authenticator checks CSRF and DPoP proofs against the original method and URL
+func forwardedRequest(r *http.Request) (*http.Request, error) {
+	method := firstHeader(r, "X-Forwarded-Method", "X-Original-Method")
+	if method == "" {
+		method = r.Method
+	}
+	origin := middleware.ForwardedOrigin(r)
+	_, host, _ := strings.Cut(origin, "://")
+	uri := firstHeader(r, "X-Forwarded-Uri", "X-Original-URI")
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+
+	target, err := url.ParseRequestURI(uri)
+	if err != nil || !strings.HasPrefix(target.Path, "/") {
+		return nil, fmt.Errorf("invalid forwarded URI %q", uri)
+	}
+
+	// The forwarded headers are the point of a forward-auth subrequest, so
+	// they are trusted here whether or not the server trusts proxies. Its TLS
+	// connection, if any, is the proxy's, so certificate-bound tokens are refused.
+	original := r.Clone(middleware.WithProxiedConnection(middleware.WithOrigin(r.Context(), origin)))
+	original.Method = strings.ToUpper(method)
+	original.Host = host
+	original.URL = target
+	original.RequestURI = uri
+	return original, nil
+}
+
+// firstHeader returns the first of the headers that is set
+func firstHeader(r *http.Request, names ...string) string {
+	for _, name := range names {
+		if value := r.Header.Get(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// queryOf returns the request's query with its leading ?, or ""
+func queryOf(r *http.Request) string {
+	if r.URL.RawQuery == "" {
+		return ""
+	}
+	return "?" + r.URL.RawQuery
+}
+
+// wantsLoginRedirect reports whether the caller is a browser that can follow a
+// redirect to a login page, rather than an API client expecting a 401
+func wantsLoginRedirect(r *http.Request) bool {
+	return r.Header.Get("Authorization") == "" && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// loginRedirectWriter turns a 401 into a 302 to the login page, dropping the
+// challenge and its body
+type loginRedirectWriter struct {
+	http.ResponseWriter
+	location   string
+	redirected bool
+}
+
+func (w *loginRedirectWriter) WriteHeader(status int) {
+	if status != http.StatusUnauthorized {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	header := w.Header()
+	header.Del("WWW-Authenticate")
+	header.Del("Content-Type")
+	header.Set("Location", w.location)
+	w.redirected = true
+	w.ResponseWriter.WriteHeader(http.StatusFound)
+}
+
+func (w *loginRedirectWriter) Write(body []byte) (int, error) {
+	if w.redirected {
+		return len(body), nil
+	}
+	return w.ResponseWriter.Write(body)
+}
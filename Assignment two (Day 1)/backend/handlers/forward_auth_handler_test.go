@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/middleware"
+	"jwt-auth-system/backend/services"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// forwardAuthProxy is a reverse proxy in front of app that asks authURL about
+// every request, the way Traefik's forwardAuth does: refusals go back to the
+// client as they are, and allowed requests reach the app with the X-Auth-*
+// headers of the answer in place of any the client sent
+func forwardAuthProxy(t *testing.T, authURL string, app *url.URL) http.Handler {
+	t.Helper()
+
+	proxy := httputil.NewSingleHostReverseProxy(app)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subrequest, _ := http.NewRequest(http.MethodGet, authURL, nil)
+		subrequest.Header = r.Header.Clone()
+		subrequest.Header.Set("X-Forwarded-Method", r.Method)
+		subrequest.Header.Set("X-Forwarded-Proto", "https")
+		subrequest.Header.Set("X-Forwarded-Host", r.Host)
+		subrequest.Header.Set("X-Forwarded-Uri", r.RequestURI)
+
+		answer, err := http.DefaultTransport.RoundTrip(subrequest)
+		if err != nil {
+			t.Errorf("forward-auth subrequest: %v", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer answer.Body.Close()
+
+		if answer.StatusCode != http.StatusOK {
+			for _, name := range []string{"Location", "WWW-Authenticate"} {
+				if value := answer.Header.Get(name); value != "" {
+					w.Header().Set(name, value)
+				}
+			}
+			w.WriteHeader(answer.StatusCode)
+			io.Copy(w, answer.Body)
+			return
+		}
+
+		for _, name := range ForwardAuthHeaders {
+			r.Header.Del(name)
+			if value := answer.Header.Get(name); value != "" {
+				r.Header.Set(name, value)
+			}
+		}
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+func TestForwardAuthBehindReverseProxy(t *testing.T) {
+	key, err := services.GenerateSigningKey(services.AlgHS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := services.NewJWTServiceWithKeyring(services.NewKeyring(key, time.Hour))
+	issue := func(role string) string {
+		token, err := tokens.GenerateToken(&domain.User{Username: role + "-user", Role: role})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	userToken, adminToken := issue("user"), issue("admin")
+
+	policy := &services.ForwardAuthPolicy{
+		LoginURL: "https://login.example.com/?next={url}",
+		Rules: []services.ForwardAuthRule{
+			{Hosts: []string{"admin.example.com"}, Roles: []string{"admin"}},
+			{Hosts: []string{"::1"}, Roles: []string{"admin"}},
+			{PathPrefix: "/admin", Roles: []string{"admin"}},
+			{Methods: []string{http.MethodDelete}, Roles: []string{"admin"}},
+			{PathPrefix: "/public", Public: true},
+		},
+	}
+	forwardAuth := NewForwardAuthHandler(middleware.NewAuthenticator(tokens, middleware.Options{}), policy)
+	authServer := httptest.NewServer(http.HandlerFunc(forwardAuth.ForwardAuth))
+	defer authServer.Close()
+
+	// The app answers with the identity headers it was given
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, name := range ForwardAuthHeaders {
+			if value := r.Header.Get(name); value != "" {
+				w.Header().Set("Seen-"+name, value)
+			}
+		}
+	}))
+	defer app.Close()
+	appURL, _ := url.Parse(app.URL)
+
+	proxy := httptest.NewServer(forwardAuthProxy(t, authServer.URL+"/forward-auth", appURL))
+	defer proxy.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	tests := []struct {
+		name     string
+		method   string
+		host     string
+		path     string
+		token    string
+		headers  map[string]string
+		want     int
+		location string
+		seen     map[string]string
+	}{
+		{name: "valid token", path: "/docs", token: userToken, want: http.StatusOK,
+			seen: map[string]string{"X-Auth-User": "user-user", "X-Auth-Subject": "user-user", "X-Auth-Role": "user"}},
+		{name: "no token", path: "/docs", want: http.StatusUnauthorized},
+		{name: "public path without a token", path: "/public/index.html", want: http.StatusOK},
+		{name: "host rule refuses a user", host: "admin.example.com", path: "/docs", token: userToken, want: http.StatusForbidden},
+		{name: "host rule allows an admin", host: "admin.example.com", path: "/docs", token: adminToken, want: http.StatusOK,
+			seen: map[string]string{"X-Auth-Role": "admin"}},
+		{name: "host rule with a port", host: "admin.example.com:8443", path: "/docs", token: userToken, want: http.StatusForbidden},
+		{name: "IPv6 host rule with a port", host: "[::1]:8443", path: "/docs", token: userToken, want: http.StatusForbidden},
+		{name: "IPv6 host rule without a port", host: "[::1]", path: "/docs", token: userToken, want: http.StatusForbidden},
+		{name: "IPv6 host rule allows an admin", host: "[::1]:8443", path: "/docs", token: adminToken, want: http.StatusOK},
+		{name: "other IPv6 host", host: "[::2]:8443", path: "/docs", token: userToken, want: http.StatusOK},
+		{name: "path prefix", path: "/admin/users", token: userToken, want: http.StatusForbidden},
+		{name: "dot segments cannot leave the public prefix", path: "/public/../admin/users", want: http.StatusUnauthorized},
+		{name: "dot segments cannot dodge the admin prefix", path: "/public/../admin/users", token: userToken, want: http.StatusForbidden},
+		{name: "prefix covers no longer names", path: "/administrator", token: userToken, want: http.StatusOK},
+		{name: "method rule", method: http.MethodDelete, path: "/docs", token: userToken, want: http.StatusForbidden},
+		{name: "browser without a token is sent to log in", path: "/docs?page=2", headers: map[string]string{"Accept": "text/html"},
+			want: http.StatusFound, location: "https://login.example.com/?next=" + url.QueryEscape("https://app.example.com/docs?page=2")},
+		{name: "client with a bad token gets the challenge", path: "/docs", token: "not-a-token",
+			headers: map[string]string{"Accept": "text/html"}, want: http.StatusUnauthorized},
+		{name: "forged identity headers are replaced", path: "/docs", token: userToken, want: http.StatusOK,
+			headers: map[string]string{"X-Auth-User": "admin-user", "X-Auth-Role": "admin", "X-Auth-ACR": "mfa"},
+			seen:    map[string]string{"X-Auth-User": "user-user", "X-Auth-Role": "user", "X-Auth-ACR": ""}},
+		{name: "forged identity headers do not authenticate", path: "/docs",
+			headers: map[string]string{"X-Auth-User": "admin-user", "X-Auth-Role": "admin"}, want: http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			method := test.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req, err := http.NewRequest(method, proxy.URL+test.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Host = "app.example.com"
+			if test.host != "" {
+				req.Host = test.host
+			}
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			for name, value := range test.headers {
+				req.Header.Set(name, value)
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != test.want {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, test.want)
+			}
+			if location := resp.Header.Get("Location"); location != test.location {
+				t.Errorf("Location = %q, want %q", location, test.location)
+			}
+			if test.want == http.StatusUnauthorized && !strings.HasPrefix(resp.Header.Get("WWW-Authenticate"), "Bearer") {
+				t.Errorf("WWW-Authenticate = %q, want a Bearer challenge", resp.Header.Get("WWW-Authenticate"))
+			}
+			for name, want := range test.seen {
+				if got := resp.Header.Get("Seen-" + name); got != want {
+					t.Errorf("app saw %s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestForwardAuthDoesNotEchoClientIdentityHeaders(t *testing.T) {
+	key, err := services.GenerateSigningKey(services.AlgHS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := services.NewJWTServiceWithKeyring(services.NewKeyring(key, time.Hour))
+	token, err := tokens.GenerateToken(&domain.User{Username: "alice", Role: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewForwardAuthHandler(middleware.NewAuthenticator(tokens, middleware.Options{}), nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/forward-auth", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Forwarded-Uri", "/docs")
+	req.Header.Set("X-Auth-Client-Id", "forged-client")
+	req.Header.Set("X-Auth-ACR", "mfa")
+	recorder := httptest.NewRecorder()
+	handler.ForwardAuth(recorder, req)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", recorder.Code)
+	}
+	for _, name := range []string{"X-Auth-Client-Id", "X-Auth-ACR"} {
+		if value := recorder.Header().Get(name); value != "" {
+			t.Errorf("answer carries %s = %q from the client", name, value)
+		}
+	}
+	if user := recorder.Header().Get("X-Auth-User"); user != "alice" {
+		t.Errorf("X-Auth-User = %q, want alice", user)
+	}
+}
+
+func TestForwardAuthRefusesCertificateBoundTokens(t *testing.T) {
+	key, err := services.GenerateSigningKey(services.AlgHS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := services.NewJWTServiceWithKeyring(services.NewKeyring(key, time.Hour))
+	authenticator := middleware.NewAuthenticator(tokens, middleware.Options{})
+	handler := NewForwardAuthHandler(authenticator, nil)
+
+	// The proxy connects with the very certificate the token is bound to, which
+	// still proves nothing about the client behind it
+	cert := &x509.Certificate{Raw: []byte("proxy certificate")}
+	connection := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	token, err := tokens.GenerateTokenWithOptions(&domain.User{Username: "alice", Role: "user"}, services.TokenOptions{
+		Confirmation: services.CertificateConfirmation(nil, cert),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/forward-auth", nil)
+	req.TLS = connection
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Forwarded-Uri", "/docs")
+	recorder := httptest.NewRecorder()
+	handler.ForwardAuth(recorder, req)
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", recorder.Code)
+	}
+	if challenge := recorder.Header().Get("WWW-Authenticate"); !strings.Contains(challenge, "certificate-bound tokens cannot be checked through forward auth") {
+		t.Errorf("WWW-Authenticate = %q, want it to say why", challenge)
+	}
+
+	// Presented to the server directly over the same connection, the token works
+	req = httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.TLS = connection
+	req.Header.Set("Authorization", "Bearer "+token)
+	recorder = httptest.NewRecorder()
+	authenticator.Authenticate(func(w http.ResponseWriter, r *http.Request) {})(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("direct request status = %d (%s), want 200", recorder.Code, recorder.Header().Get("WWW-Authenticate"))
+	}
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"jwt-auth-system/backend/services"
+	"net/http"
+)
+
+// KeyHandler serves the public keys other services use to verify our tokens
+type KeyHandler struct {
+	keyring *services.Keyring
+}
+
+// NewKeyHandler creates a new key handler
+func NewKeyHandler(keyring *services.Keyring) *KeyHandler {
+	return &KeyHandler{
+		keyring: keyring,
+	}
+}
+
+// JWKS handles requests for /.well-known/jwks.json
+func (h *KeyHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(h.keyring.JWKS())
+}
+
+// ListKeys handles admin requests to list signing keys and their states
+func (h *KeyHandler) ListKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.keyring.Keys())
+}
+
+// RotateKeys handles admin requests to rotate the active signing key
+func (h *KeyHandler) RotateKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key, err := h.keyring.Rotate()
+	if errors.Is(err, services.ErrRotationUnavailable) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to rotate signing key", http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Printf("Signing key rotated on demand, new kid: %s\n", key.KeyID)
+	fmt.Println("---")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.keyring.Keys())
+}
@@ -0,0 +1,380 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/services"
+	"net/http"
+	"net/url"
+	"slices"
+)
+
+// RFC 6749 and RFC 8693 grant and token type identifiers
+const (
+	GrantTypeClientCredentials = "client_credentials"
+	GrantTypeTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+	TokenTypeAccessToken       = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeJWT               = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// OAuthHandler handles the OAuth 2.0 endpoints used by resource servers
+type OAuthHandler struct {
+	jwtService      services.TokenService
+	clientService   *services.ClientService
+	exchangeService *services.ExchangeService
+	serviceAccounts *services.ServiceAccountService
+	dpop            *services.DPoPVerifier
+}
+
+// NewOAuthHandler creates a new OAuth handler; dpop may be nil to disable DPoP-bound tokens
+func NewOAuthHandler(jwtService services.TokenService, clientService *services.ClientService, exchangeService *services.ExchangeService, serviceAccounts *services.ServiceAccountService, dpop *services.DPoPVerifier) *OAuthHandler {
+	return &OAuthHandler{
+		jwtService:      jwtService,
+		clientService:   clientService,
+		exchangeService: exchangeService,
+		serviceAccounts: serviceAccounts,
+		dpop:            dpop,
+	}
+}
+
+// CreateClient handles admin requests to register a client
+func (h *OAuthHandler) CreateClient(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req domain.CreateClientRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	switch req.TokenFormat {
+	case "":
+		req.TokenFormat = domain.TokenFormatSelfContained
+	case domain.TokenFormatSelfContained, domain.TokenFormatOpaque:
+	default:
+		http.Error(w, "token_format must be self-contained or opaque", http.StatusBadRequest)
+		return
+	}
+
+	switch req.AuthMethod {
+	case "":
+		req.AuthMethod = domain.AuthMethodClientSecret
+	case domain.AuthMethodClientSecret:
+	case domain.AuthMethodTLSClientAuth:
+		if req.TLSAuth.IsZero() {
+			http.Error(w, "tls_client_auth needs tls_client_auth_subject_dn, tls_client_auth_san_dns or tls_client_auth_san_uri", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "token_endpoint_auth_method must be client_secret_basic or tls_client_auth", http.StatusBadRequest)
+		return
+	}
+
+	client, secret, err := h.clientService.CreateClient(req)
+	if err != nil {
+		http.Error(w, "Failed to create client", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(domain.CreateClientResponse{
+		ClientID:     client.ID,
+		ClientSecret: secret,
+		Name:         client.Name,
+		TokenFormat:  client.TokenFormat,
+		AuthMethod:   client.AuthMethod,
+	})
+}
+
+// Introspect handles RFC 7662 token introspection requests
+func (h *OAuthHandler) Introspect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	token := r.PostFormValue("token")
+	if token == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	fmt.Printf("Introspection by client %s\n", client.ID)
+
+	// Inactive, expired, revoked and foreign tokens all look the same to the caller,
+	// and so do tokens for other clients, whose claims (decrypted, for JWE) are
+	// none of its business
+	response := domain.IntrospectionResponse{Active: false}
+	if claims, err := h.jwtService.ValidateToken(token); err == nil && isTokenParty(client, claims) {
+		response = domain.IntrospectionResponse{
+			Active:     true,
+			Scope:      claims.Scope,
+			ClientID:   claims.ClientID,
+			Username:   claims.Username,
+			TokenType:  tokenType(claims.Cnf),
+			Sub:        claims.Subject,
+			Aud:        claims.Audience,
+			Iss:        claims.Issuer,
+			Jti:        claims.ID,
+			Role:       claims.Role,
+			Act:        claims.Act,
+			Cnf:        claims.Cnf,
+			AMR:        claims.AMR,
+			ACR:        claims.ACR,
+			Attributes: claims.Attributes,
+		}
+		if claims.ExpiresAt != nil {
+			response.Exp = claims.ExpiresAt.Unix()
+		}
+		if claims.IssuedAt != nil {
+			response.Iat = claims.IssuedAt.Unix()
+		}
+		if claims.NotBefore != nil {
+			response.Nbf = claims.NotBefore.Unix()
+		}
+		if claims.AuthTime != nil {
+			response.AuthTime = claims.AuthTime.Unix()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Token handles token endpoint requests: the client credentials grant for
+// service accounts and the RFC 8693 token exchange grant for OAuth clients
+func (h *OAuthHandler) Token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch grantType := r.PostFormValue("grant_type"); grantType {
+	case GrantTypeClientCredentials:
+		h.clientCredentialsToken(w, r)
+	case GrantTypeTokenExchange:
+		client, ok := h.authenticateClient(w, r)
+		if !ok {
+			return
+		}
+		h.exchangeToken(w, r, client)
+	default:
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", fmt.Sprintf("grant_type %q is not supported", grantType))
+	}
+}
+
+// clientCredentialsToken handles the client credentials grant: the client ID is
+// a service account ID and the client secret one of its API keys
+func (h *OAuthHandler) clientCredentialsToken(w http.ResponseWriter, r *http.Request) {
+	accountID, apiKey, _ := clientCredentials(r)
+	req := services.ClientCredentialsRequest{
+		ServiceAccountID: accountID,
+		APIKey:           apiKey,
+		Audience:         r.PostFormValue("audience"),
+		Scope:            r.PostFormValue("scope"),
+	}
+
+	var ok bool
+	if req.Confirmation, ok = dpopConfirmation(w, r, h.dpop); !ok {
+		return
+	}
+	req.Confirmation = services.CertificateConfirmation(req.Confirmation, services.PeerCertificate(r.TLS))
+
+	result, err := h.serviceAccounts.ClientCredentials(req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidClient):
+			w.Header().Set("WWW-Authenticate", `Basic realm="jwt-auth-system"`)
+			writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		case errors.Is(err, services.ErrInvalidScope):
+			writeOAuthError(w, http.StatusBadRequest, "invalid_scope", err.Error())
+		case errors.Is(err, services.ErrUnknownAudience):
+			writeOAuthError(w, http.StatusBadRequest, "invalid_target", err.Error())
+		default:
+			writeOAuthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(domain.TokenResponse{
+		AccessToken: result.Token,
+		TokenType:   tokenType(req.Confirmation),
+		ExpiresIn:   int64(result.ExpiresIn.Seconds()),
+		Scope:       result.Scope,
+	})
+}
+
+// exchangeToken handles the token exchange grant
+func (h *OAuthHandler) exchangeToken(w http.ResponseWriter, r *http.Request, client *domain.Client) {
+	req := services.ExchangeRequest{
+		Client:       client,
+		SubjectToken: r.PostFormValue("subject_token"),
+		ActorToken:   r.PostFormValue("actor_token"),
+		Audience:     r.PostFormValue("audience"),
+		Scope:        r.PostFormValue("scope"),
+	}
+
+	switch {
+	case req.SubjectToken == "":
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "subject_token is required")
+		return
+	case !isAccessTokenType(r.PostFormValue("subject_token_type")):
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "unsupported subject_token_type")
+		return
+	case req.ActorToken != "" && !isAccessTokenType(r.PostFormValue("actor_token_type")):
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "unsupported actor_token_type")
+		return
+	case req.Audience == "":
+		writeOAuthError(w, http.StatusBadRequest, "invalid_target", "audience is required")
+		return
+	}
+	if requested := r.PostFormValue("requested_token_type"); requested != "" && requested != TokenTypeAccessToken {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "only access tokens can be issued")
+		return
+	}
+
+	var ok bool
+	if req.Confirmation, ok = dpopConfirmation(w, r, h.dpop); !ok {
+		return
+	}
+	req.Confirmation = services.CertificateConfirmation(req.Confirmation, services.PeerCertificate(r.TLS))
+
+	result, err := h.exchangeService.Exchange(req)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrExchangeDenied):
+			writeOAuthError(w, http.StatusBadRequest, "unauthorized_client", err.Error())
+		case errors.Is(err, services.ErrInvalidScope):
+			writeOAuthError(w, http.StatusBadRequest, "invalid_scope", err.Error())
+		case errors.Is(err, services.ErrUnknownAudience):
+			writeOAuthError(w, http.StatusBadRequest, "invalid_target", err.Error())
+		default:
+			writeOAuthError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(domain.TokenResponse{
+		AccessToken:     result.Token,
+		IssuedTokenType: TokenTypeAccessToken,
+		TokenType:       tokenType(result.Confirmation),
+		ExpiresIn:       int64(result.ExpiresIn.Seconds()),
+		Scope:           result.Scope,
+	})
+}
+
+// isAccessTokenType reports whether an RFC 8693 token type names a token this server issues
+func isAccessTokenType(tokenType string) bool {
+	return tokenType == TokenTypeAccessToken || tokenType == TokenTypeJWT
+}
+
+// Revoke handles RFC 7009 token revocation requests
+func (h *OAuthHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	token := r.PostFormValue("token")
+	if token == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	// Unknown and invalid tokens are still answered with 200; valid ones may only
+	// be revoked by the client they were issued to or addressed to (RFC 7009 section 2.1)
+	claims, err := h.jwtService.ValidateToken(token)
+	if err != nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !isTokenParty(client, claims) {
+		fmt.Printf("Client %s refused revocation of a token issued to %q for %v\n", client.ID, claims.ClientID, claims.Audience)
+		writeOAuthError(w, http.StatusBadRequest, "unauthorized_client", "the token was not issued to this client")
+		return
+	}
+
+	h.jwtService.RevokeToken(token)
+	w.WriteHeader(http.StatusOK)
+}
+
+// isTokenParty reports whether the client obtained the token or is one of its
+// audiences, named by client ID or name
+func isTokenParty(client *domain.Client, claims *domain.Claims) bool {
+	if claims.ClientID != "" && claims.ClientID == client.ID {
+		return true
+	}
+	return slices.Contains(claims.Audience, client.ID) || slices.Contains(claims.Audience, client.Name)
+}
+
+// authenticateClient checks HTTP Basic or form client credentials, or the TLS
+// client certificate when only a client_id is sent, and writes the error
+// response on failure
+func (h *OAuthHandler) authenticateClient(w http.ResponseWriter, r *http.Request) (*domain.Client, bool) {
+	clientID, clientSecret, ok := clientCredentials(r)
+
+	var client *domain.Client
+	var err error
+	if peer := services.PeerCertificate(r.TLS); !ok && clientSecret == "" && peer != nil {
+		client, err = h.clientService.AuthenticateCertificate(clientID, peer)
+	} else {
+		client, err = h.clientService.Authenticate(clientID, clientSecret)
+	}
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="jwt-auth-system"`)
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return nil, false
+	}
+	return client, true
+}
+
+// clientCredentials reads the client ID and secret from HTTP Basic
+// authentication or, failing that, the client_id and client_secret form
+// fields; basic reports which was used
+func clientCredentials(r *http.Request) (clientID, clientSecret string, basic bool) {
+	clientID, clientSecret, basic = r.BasicAuth()
+	if basic {
+		// RFC 6749 section 2.3.1: credentials are form-encoded before Basic encoding
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+		return clientID, clientSecret, true
+	}
+	return r.PostFormValue("client_id"), r.PostFormValue("client_secret"), false
+}
+
+// writeOAuthError writes an RFC 6749 error response
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":             code,
+		"error_description": description,
+	})
+}
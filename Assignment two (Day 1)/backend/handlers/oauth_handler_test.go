@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/repo"
+	"jwt-auth-system/backend/services"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIntrospectOnlyAnswersTheTokensParties(t *testing.T) {
+	key, err := services.GenerateSigningKey(services.AlgES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := services.DefaultTokenConfig()
+	config.Audiences = map[string]time.Duration{"api": 5 * time.Minute, "hr": 5 * time.Minute}
+	config.DefaultAudience = "api"
+	tokens := services.NewJWTServiceWithConfig(services.NewKeyring(key, time.Hour), config)
+	clients := services.NewClientService(repo.NewClientRepository())
+	handler := NewOAuthHandler(tokens, clients, nil, nil, nil)
+
+	type registered struct {
+		client *domain.Client
+		secret string
+	}
+	register := func(name string) registered {
+		client, secret, err := clients.CreateClient(domain.CreateClientRequest{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return registered{client, secret}
+	}
+	hr, reports, other := register("hr"), register("reports"), register("other")
+
+	user := &domain.User{
+		Username:   "alice",
+		Role:       "user",
+		Attributes: domain.Attributes{"designation": "dev"},
+	}
+	issue := func(opts services.TokenOptions) string {
+		token, err := tokens.GenerateTokenWithOptions(user, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	forHR := issue(services.TokenOptions{Audience: "hr"})
+	forReports := issue(services.TokenOptions{ClientID: reports.client.ID})
+	forSomeoneElse := issue(services.TokenOptions{Audience: "api", ClientID: "someone-else"})
+
+	tests := []struct {
+		name   string
+		caller registered
+		token  string
+		want   bool
+	}{
+		{"audience by client name", hr, forHR, true},
+		{"client the token was issued to", reports, forReports, true},
+		{"client of another audience", reports, forHR, false},
+		{"unrelated client", other, forHR, false},
+		{"audience client asking about another client's token", hr, forReports, false},
+		{"token for neither client", other, forSomeoneElse, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			form := url.Values{"token": {test.token}}
+			req := httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.SetBasicAuth(test.caller.client.ID, test.caller.secret)
+			recorder := httptest.NewRecorder()
+			handler.Introspect(recorder, req)
+
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", recorder.Code, recorder.Body)
+			}
+			var body map[string]interface{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body["active"] != test.want {
+				t.Fatalf("active = %v, want %v", body["active"], test.want)
+			}
+			if !test.want && len(body) != 1 {
+				t.Errorf("inactive response leaks %v", body)
+			}
+			if test.want && body["designation"] != "dev" {
+				t.Errorf("designation = %v, want the token's attribute", body["designation"])
+			}
+		})
+	}
+}
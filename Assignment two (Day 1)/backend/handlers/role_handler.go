@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/middleware"
+	"jwt-auth-system/backend/repo"
+	"jwt-auth-system/backend/services"
+	"net/http"
+	"strings"
+)
+
+// defaultAuditLimit is how many audit entries /admin/audit returns when no limit is given
+const defaultAuditLimit = 100
+
+// RoleHandler handles admin requests that manage the role catalog and read the audit log
+type RoleHandler struct {
+	permissions *services.PermissionCatalog
+	userRepo    *repo.UserRepository
+	auditLog    *repo.AuditLog
+}
+
+// NewRoleHandler creates a new role handler
+func NewRoleHandler(permissions *services.PermissionCatalog, userRepo *repo.UserRepository, auditLog *repo.AuditLog) *RoleHandler {
+	return &RoleHandler{
+		permissions: permissions,
+		userRepo:    userRepo,
+		auditLog:    auditLog,
+	}
+}
+
+// Roles handles GET /admin/roles to list the catalog and POST /admin/roles to add a role
+func (h *RoleHandler) Roles(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.permissions.ListRoles())
+
+	case http.MethodPost:
+		var req domain.Role
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		err := h.permissions.AddRole(req.Name, req.Permissions)
+		switch {
+		case errors.Is(err, services.ErrRoleExists):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		audit(h.auditLog, r, "role.create", req.Name, "permissions: "+strings.Join(req.Permissions, " "))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(domain.Role{Name: req.Name, Permissions: h.permissions.Permissions(req.Name)})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// DeleteRole handles DELETE /admin/roles/{name}; roles still held by users cannot be deleted
+func (h *RoleHandler) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.PathValue("name")
+	err := h.userRepo.WithoutHolders(name, func() error {
+		return h.permissions.DeleteRole(name)
+	})
+	switch {
+	case errors.Is(err, services.ErrUnknownRole):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, repo.ErrRoleHeld):
+		http.Error(w, err.Error()+"; assign them another role first", http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	audit(h.auditLog, r, "role.delete", name, "")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Audit handles GET /admin/audit, newest first, optionally filtered by target and capped with limit
+func (h *RoleHandler) Audit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit, err := queryInt(r.URL.Query().Get("limit"), defaultAuditLimit)
+	if err != nil || limit < 1 {
+		http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.auditLog.Recent(r.URL.Query().Get("target"), limit))
+}
+
+// audit records an administrative change and prints it to the console. The
+// actor is the token's user or service account, or "admin-key" for requests
+// authorised by X-Admin-Key.
+func audit(auditLog *repo.AuditLog, r *http.Request, action, target, detail string) {
+	actor := "admin-key"
+	if claims, ok := middleware.ClaimsFromContext(r.Context()); ok {
+		actor = claims.Username
+		if claims.IsServiceAccount() {
+			actor = claims.Subject
+		}
+	}
+
+	entry := auditLog.Record(domain.AuditEntry{
+		Actor:  actor,
+		Action: action,
+		Target: target,
+		Detail: detail,
+	})
+
+	fmt.Printf("Audit: %s %s %s by %s", entry.Time.Format("2006-01-02T15:04:05Z"), entry.Action, entry.Target, entry.Actor)
+	if entry.Detail != "" {
+		fmt.Printf(" (%s)", entry.Detail)
+	}
+	fmt.Println()
+	fmt.Println("---")
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"fmt"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/services"
+	"net/http"
+	"slices"
+)
+
+// scimAttributeTypes maps attribute schema types to SCIM attribute types
+var scimAttributeTypes = map[string]string{
+	services.AttributeString:  "string",
+	services.AttributeInteger: "integer",
+	services.AttributeNumber:  "decimal",
+	services.AttributeBoolean: "boolean",
+}
+
+// The discovery endpoints below describe the service and need no API key, so
+// clients can find out how to authenticate. Filters are refused with 403, as
+// RFC 7644 section 4 asks.
+
+// ServiceProviderConfig handles GET /scim/v2/ServiceProviderConfig
+func (h *SCIMHandler) ServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	if !checkDiscovery(w, r) {
+		return
+	}
+
+	writeSCIM(w, http.StatusOK, domain.SCIMServiceProviderConfig{
+		Schemas:        []string{domain.SCIMServiceProviderConfigSchema},
+		Patch:          domain.SCIMSupported{Supported: true},
+		Bulk:           domain.SCIMBulk{},
+		Filter:         domain.SCIMFilterSupport{Supported: true, MaxResults: maxPerPage},
+		ChangePassword: domain.SCIMSupported{Supported: true},
+		Sort:           domain.SCIMSupported{},
+		ETag:           domain.SCIMSupported{Supported: true},
+		AuthenticationSchemes: []domain.SCIMAuthenticationType{{
+			Type:        "oauthbearertoken",
+			Name:        "API key",
+			Description: fmt.Sprintf("A service account API key with the %s scope, sent as a bearer token", SCIMScope),
+			Primary:     true,
+		}},
+		Meta: &domain.SCIMMeta{
+			ResourceType: "ServiceProviderConfig",
+			Location:     scimBaseURL(r) + "/ServiceProviderConfig",
+		},
+	})
+}
+
+// ResourceTypes handles GET /scim/v2/ResourceTypes
+func (h *SCIMHandler) ResourceTypes(w http.ResponseWriter, r *http.Request) {
+	if !checkDiscovery(w, r) {
+		return
+	}
+
+	var resources []interface{}
+	for _, resourceType := range h.resourceTypes(r) {
+		resources = append(resources, resourceType)
+	}
+	writeSCIMDiscoveryList(w, resources)
+}
+
+// ResourceType handles GET /scim/v2/ResourceTypes/{id}
+func (h *SCIMHandler) ResourceType(w http.ResponseWriter, r *http.Request) {
+	if !checkDiscovery(w, r) {
+		return
+	}
+
+	for _, resourceType := range h.resourceTypes(r) {
+		if resourceType.ID == r.PathValue("id") {
+			writeSCIM(w, http.StatusOK, resourceType)
+			return
+		}
+	}
+	writeSCIMError(w, http.StatusNotFound, "", fmt.Sprintf("resource type %q not found", r.PathValue("id")))
+}
+
+// Schemas handles GET /scim/v2/Schemas
+func (h *SCIMHandler) Schemas(w http.ResponseWriter, r *http.Request) {
+	if !checkDiscovery(w, r) {
+		return
+	}
+
+	var resources []interface{}
+	for _, schema := range h.schemas(r) {
+		resources = append(resources, schema)
+	}
+	writeSCIMDiscoveryList(w, resources)
+}
+
+// Schema handles GET /scim/v2/Schemas/{id}, where the id is the schema URN
+func (h *SCIMHandler) Schema(w http.ResponseWriter, r *http.Request) {
+	if !checkDiscovery(w, r) {
+		return
+	}
+
+	for _, schema := range h.schemas(r) {
+		if schema.ID == r.PathValue("id") {
+			writeSCIM(w, http.StatusOK, schema)
+			return
+		}
+	}
+	writeSCIMError(w, http.StatusNotFound, "", fmt.Sprintf("schema %q not found", r.PathValue("id")))
+}
+
+// resourceTypes describes the Users and Groups endpoints
+func (h *SCIMHandler) resourceTypes(r *http.Request) []domain.SCIMResourceType {
+	base := scimBaseURL(r)
+	return []domain.SCIMResourceType{
+		{
+			Schemas:          []string{domain.SCIMResourceTypeSchema},
+			ID:               "User",
+			Name:             "User",
+			Endpoint:         "/Users",
+			Description:      "User accounts",
+			Schema:           domain.SCIMUserSchema,
+			SchemaExtensions: []domain.SCIMSchemaExtension{{Schema: domain.SCIMEnterpriseUserSchema, Required: len(h.attributes.Required) > 0}},
+			Meta:             &domain.SCIMMeta{ResourceType: "ResourceType", Location: base + "/ResourceTypes/User"},
+		},
+		{
+			Schemas:     []string{domain.SCIMResourceTypeSchema},
+			ID:          "Group",
+			Name:        "Group",
+			Endpoint:    "/Groups",
+			Description: "Roles of the role catalog, whose members are the users holding them",
+			Schema:      domain.SCIMGroupSchema,
+			Meta:        &domain.SCIMMeta{ResourceType: "ResourceType", Location: base + "/ResourceTypes/Group"},
+		},
+	}
+}
+
+// schemas describes the user, group and enterprise extension schemas; the
+// extension lists role and every attribute of the attribute schema
+func (h *SCIMHandler) schemas(r *http.Request) []domain.SCIMSchema {
+	base := scimBaseURL(r)
+	meta := func(id string) *domain.SCIMMeta {
+		return &domain.SCIMMeta{ResourceType: "Schema", Location: base + "/Schemas/" + id}
+	}
+	reference := func(name, referenceType string) domain.SCIMAttribute {
+		attribute := scimAttribute(name, "reference", false, "readOnly")
+		attribute.ReferenceTypes = []string{referenceType}
+		return attribute
+	}
+
+	userName := scimAttribute("userName", "string", true, "readWrite")
+	userName.CaseExact, userName.Uniqueness = true, "server"
+	password := scimAttribute("password", "string", false, "writeOnly")
+	password.Returned = "never"
+	groups := scimAttribute("groups", "complex", false, "readOnly")
+	groups.MultiValued = true
+	groups.Description = "The group of the user's role; it changes with the role"
+	groups.SubAttributes = []domain.SCIMAttribute{
+		scimAttribute("value", "string", false, "readOnly"),
+		reference("$ref", "Group"),
+		scimAttribute("display", "string", false, "readOnly"),
+	}
+
+	displayName := scimAttribute("displayName", "string", true, "immutable")
+	displayName.CaseExact, displayName.Uniqueness = true, "server"
+	displayName.Description = "The role name"
+	members := scimAttribute("members", "complex", false, "readWrite")
+	members.MultiValued = true
+	members.Description = "The users holding the role"
+	members.SubAttributes = []domain.SCIMAttribute{
+		scimAttribute("value", "string", true, "immutable"),
+		reference("$ref", "User"),
+		scimAttribute("display", "string", false, "readOnly"),
+	}
+
+	role := scimAttribute("role", "string", false, "readWrite")
+	role.CaseExact = true
+	role.Description = fmt.Sprintf("The user's role; %q when not given", h.permissions.DefaultRole)
+	for _, catalogRole := range h.permissions.ListRoles() {
+		role.CanonicalValues = append(role.CanonicalValues, catalogRole.Name)
+	}
+	enterprise := []domain.SCIMAttribute{role}
+	for _, name := range h.attributes.Names() {
+		definition := h.attributes.Properties[name]
+		attribute := scimAttribute(name, scimAttributeTypes[definition.Type], false, "readWrite")
+		attribute.Description = definition.Description
+		attribute.CanonicalValues = definition.Enum
+		attribute.Required = slices.Contains(h.attributes.Required, name)
+		enterprise = append(enterprise, attribute)
+	}
+
+	return []domain.SCIMSchema{
+		{
+			Schemas:     []string{domain.SCIMSchemaSchema},
+			ID:          domain.SCIMUserSchema,
+			Name:        "User",
+			Description: "User account",
+			Attributes: []domain.SCIMAttribute{
+				userName,
+				scimAttribute("active", "boolean", false, "readWrite"),
+				password,
+				groups,
+			},
+			Meta: meta(domain.SCIMUserSchema),
+		},
+		{
+			Schemas:     []string{domain.SCIMSchemaSchema},
+			ID:          domain.SCIMGroupSchema,
+			Name:        "Group",
+			Description: "Role of the role catalog",
+			Attributes:  []domain.SCIMAttribute{displayName, members},
+			Meta:        meta(domain.SCIMGroupSchema),
+		},
+		{
+			Schemas:     []string{domain.SCIMSchemaSchema},
+			ID:          domain.SCIMEnterpriseUserSchema,
+			Name:        "EnterpriseUser",
+			Description: "Role and organisation attributes of a user",
+			Attributes:  enterprise,
+			Meta:        meta(domain.SCIMEnterpriseUserSchema),
+		},
+	}
+}
+
+// scimAttribute describes a single-valued attribute that is returned by default and not unique
+func scimAttribute(name, attributeType string, required bool, mutability string) domain.SCIMAttribute {
+	return domain.SCIMAttribute{
+		Name:       name,
+		Type:       attributeType,
+		Required:   required,
+		Mutability: mutability,
+		Returned:   "default",
+		Uniqueness: "none",
+	}
+}
+
+// checkDiscovery allows only GET without a filter on the discovery endpoints
+func checkDiscovery(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if r.URL.Query().Has("filter") {
+		writeSCIMError(w, http.StatusForbidden, "", "discovery endpoints cannot be filtered")
+		return false
+	}
+	return true
+}
+
+// writeSCIMDiscoveryList answers with every resource of a discovery endpoint as one page
+func writeSCIMDiscoveryList(w http.ResponseWriter, resources []interface{}) {
+	writeSCIM(w, http.StatusOK, domain.SCIMListResponse{
+		Schemas:      []string{domain.SCIMListResponseSchema},
+		TotalResults: len(resources),
+		StartIndex:   1,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
@@ -0,0 +1,666 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/middleware"
+	"jwt-auth-system/backend/repo"
+	"jwt-auth-system/backend/services"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SCIMScope is the API key scope that grants access to the SCIM endpoints
+const SCIMScope = "scim"
+
+// scimContentType is the media type of SCIM requests and responses (RFC 7644 section 8.1)
+const scimContentType = "application/scim+json"
+
+var (
+	// errSCIMInvalidValue is returned when a SCIM resource holds a value that cannot be stored
+	errSCIMInvalidValue = errors.New("invalid value")
+
+	// errSCIMMutability is returned when a request changes an attribute that cannot change
+	errSCIMMutability = errors.New("attribute cannot be changed")
+)
+
+// SCIMHandler serves SCIM 2.0 provisioning (RFC 7643, RFC 7644) under
+// /scim/v2. Users map onto the user repository and Groups onto the role
+// catalog: a group's members are the users holding its role, so each user is
+// in exactly one group.
+type SCIMHandler struct {
+	userRepo        *repo.UserRepository
+	versions        *services.TokenVersions
+	permissions     *services.PermissionCatalog
+	attributes      *services.AttributeSchema
+	serviceAccounts *services.ServiceAccountService
+	auditLog        *repo.AuditLog
+}
+
+// NewSCIMHandler creates a new SCIM handler; versions may be nil when tokens are not versioned
+func NewSCIMHandler(userRepo *repo.UserRepository, versions *services.TokenVersions, permissions *services.PermissionCatalog, attributes *services.AttributeSchema, serviceAccounts *services.ServiceAccountService, auditLog *repo.AuditLog) *SCIMHandler {
+	return &SCIMHandler{
+		userRepo:        userRepo,
+		versions:        versions,
+		permissions:     permissions,
+		attributes:      attributes,
+		serviceAccounts: serviceAccounts,
+		auditLog:        auditLog,
+	}
+}
+
+// Authenticate is a middleware that only lets requests through that carry a
+// service account API key with the scim scope as a bearer token. The account
+// is recorded as the actor of audit entries.
+func (h *SCIMHandler) Authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scheme, apiKey, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(apiKey) == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+			writeSCIMError(w, http.StatusUnauthorized, "", "a bearer API key is required")
+			return
+		}
+
+		account, key, err := h.serviceAccounts.AuthenticateKey(strings.TrimSpace(apiKey))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="scim", error="invalid_token"`)
+			writeSCIMError(w, http.StatusUnauthorized, "", "invalid API key")
+			return
+		}
+		if !slices.Contains(key.Scopes, SCIMScope) {
+			writeSCIMError(w, http.StatusForbidden, "", fmt.Sprintf("API key %s lacks the %s scope", key.Prefix, SCIMScope))
+			return
+		}
+
+		claims := &domain.Claims{Scope: strings.Join(key.Scopes, " "), ClientID: account.ID}
+		claims.Subject = account.ID
+		next(w, r.WithContext(middleware.WithClaims(r.Context(), claims)))
+	}
+}
+
+// Users handles GET /scim/v2/Users, filtered with filter and paginated with
+// startIndex and count, and POST /scim/v2/Users to provision a user
+func (h *SCIMHandler) Users(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		users, _ := h.userRepo.ListUsers("", nil, 0, math.MaxInt)
+		resources := make([]map[string]interface{}, 0, len(users))
+		for _, user := range users {
+			resources = append(resources, scimObject(h.toSCIMUser(r, user)))
+		}
+		writeSCIMList(w, r, resources)
+
+	case http.MethodPost:
+		var resource domain.SCIMUser
+		if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+			writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", "Invalid request body: "+err.Error())
+			return
+		}
+		username := strings.TrimSpace(resource.UserName)
+		if username == "" {
+			writeSCIMError(w, http.StatusBadRequest, "invalidValue", "userName is required")
+			return
+		}
+
+		user := &domain.User{Username: username}
+		if err := h.applySCIMUser(user, resource); err != nil {
+			writeSCIMFailure(w, err)
+			return
+		}
+		err := h.userRepo.RegisterUser(user)
+		if errors.Is(err, services.ErrUnknownRole) {
+			writeSCIMFailure(w, err)
+			return
+		}
+		if err != nil {
+			writeSCIMError(w, http.StatusConflict, "uniqueness", err.Error())
+			return
+		}
+		audit(h.auditLog, r, "scim.user.create", user.Username, "role "+user.Role)
+
+		fmt.Printf("SCIM User Provisioned: %s (Role: %s, Attributes: %s)\n",
+			user.Username, user.Role, user.Attributes)
+		fmt.Println("---")
+
+		scimUser := h.toSCIMUser(r, user)
+		w.Header().Set("Location", scimUser.Meta.Location)
+		writeSCIMResource(w, http.StatusCreated, scimUser, scimUser.Meta.Version)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// User handles GET, PUT, PATCH and DELETE on /scim/v2/Users/{id}, where the id
+// is the username. PUT replaces the user: a role left out becomes the default
+// role, attributes left out are removed and a password left out is kept.
+// Changes honour If-Match; role, active and password changes invalidate the
+// user's existing tokens.
+func (h *SCIMHandler) User(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("id")
+	ifMatch := r.Header.Get("If-Match")
+
+	switch r.Method {
+	case http.MethodGet:
+		user, err := h.userRepo.GetUser(username)
+		if err != nil {
+			writeSCIMFailure(w, err)
+			return
+		}
+		scimUser := h.toSCIMUser(r, user)
+		writeSCIMResource(w, http.StatusOK, scimUser, scimUser.Meta.Version)
+
+	case http.MethodPut:
+		var resource domain.SCIMUser
+		if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+			writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", "Invalid request body: "+err.Error())
+			return
+		}
+		h.updateUser(w, r, "scim.user.replace", func(user *domain.User) error {
+			return h.applySCIMUser(user, resource)
+		})
+
+	case http.MethodPatch:
+		var patch domain.SCIMPatchRequest
+		if err := decodeSCIMPatch(r, &patch); err != nil {
+			writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+			return
+		}
+		h.updateUser(w, r, "scim.user.update", func(user *domain.User) error {
+			resource := scimObject(h.toSCIMUser(r, user))
+			if err := services.ApplySCIMPatch(resource, patch.Operations); err != nil {
+				return err
+			}
+			var patched domain.SCIMUser
+			if err := fromSCIMObject(resource, &patched); err != nil {
+				return err
+			}
+			return h.applySCIMUser(user, patched)
+		})
+
+	case http.MethodDelete:
+		err := h.userRepo.DeleteUser(username, func(user *domain.User) error {
+			if ifMatch != "" && !matchesETag(ifMatch, user.ETag()) {
+				return errPreconditionFailed
+			}
+			return nil
+		})
+		if err != nil {
+			writeSCIMFailure(w, err)
+			return
+		}
+		h.forget(username)
+		audit(h.auditLog, r, "scim.user.delete", username, "")
+
+		fmt.Printf("SCIM User Deprovisioned: %s\n", username)
+		fmt.Println("---")
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// updateUser changes the user named in the path with change and answers with the result
+func (h *SCIMHandler) updateUser(w http.ResponseWriter, r *http.Request, action string, change func(user *domain.User) error) {
+	ifMatch := r.Header.Get("If-Match")
+
+	var before domain.User
+	user, err := h.userRepo.UpdateUser(r.PathValue("id"), func(user *domain.User) error {
+		if ifMatch != "" && !matchesETag(ifMatch, user.ETag()) {
+			return errPreconditionFailed
+		}
+		before = *user
+		return change(user)
+	})
+	if err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+	h.forget(user.Username)
+	audit(h.auditLog, r, action, user.Username, describeChanges(h.attributes, &before, user))
+
+	scimUser := h.toSCIMUser(r, user)
+	writeSCIMResource(w, http.StatusOK, scimUser, scimUser.Meta.Version)
+}
+
+// applySCIMUser copies a SCIM user onto user. Renaming is not supported, since
+// the username is the id.
+func (h *SCIMHandler) applySCIMUser(user *domain.User, resource domain.SCIMUser) error {
+	if name := strings.TrimSpace(resource.UserName); name != "" && name != user.Username {
+		return fmt.Errorf("%w: userName cannot be changed from %q", errSCIMMutability, user.Username)
+	}
+
+	role := h.permissions.DefaultRole
+	var attributes domain.Attributes
+	if resource.Enterprise != nil {
+		if resource.Enterprise.Role != "" {
+			role = resource.Enterprise.Role
+		}
+		attributes = resource.Enterprise.Attributes
+	}
+	if !h.permissions.HasRole(role) {
+		return fmt.Errorf("%w: unknown role %q", errSCIMInvalidValue, role)
+	}
+	attributes, err := h.attributes.Validate(attributes)
+	if err != nil {
+		return err
+	}
+
+	if resource.Password != "" {
+		hash, err := services.HashPassword(resource.Password)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errSCIMInvalidValue, err)
+		}
+		user.PasswordHash = hash
+	}
+	user.Role = role
+	user.Attributes = attributes
+	user.Disabled = resource.Active != nil && !*resource.Active
+	return nil
+}
+
+// toSCIMUser renders a user as a SCIM resource; its group is its role
+func (h *SCIMHandler) toSCIMUser(r *http.Request, user *domain.User) domain.SCIMUser {
+	base := scimBaseURL(r)
+	active := !user.Disabled
+	return domain.SCIMUser{
+		Schemas:  []string{domain.SCIMUserSchema, domain.SCIMEnterpriseUserSchema},
+		ID:       user.Username,
+		UserName: user.Username,
+		Active:   &active,
+		Groups: []domain.SCIMMember{{
+			Value:   user.Role,
+			Display: user.Role,
+			Ref:     base + "/Groups/" + url.PathEscape(user.Role),
+		}},
+		Enterprise: &domain.SCIMEnterpriseUser{Role: user.Role, Attributes: user.Attributes},
+		Meta: &domain.SCIMMeta{
+			ResourceType: "User",
+			Location:     base + "/Users/" + url.PathEscape(user.Username),
+			Version:      user.ETag(),
+		},
+	}
+}
+
+// Groups handles GET /scim/v2/Groups, filtered and paginated like Users, and
+// POST /scim/v2/Groups to add a role without permissions to the catalog
+func (h *SCIMHandler) Groups(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		roles := h.permissions.ListRoles()
+		resources := make([]map[string]interface{}, 0, len(roles))
+		for _, role := range roles {
+			resources = append(resources, scimObject(h.toSCIMGroup(r, role.Name)))
+		}
+		writeSCIMList(w, r, resources)
+
+	case http.MethodPost:
+		var resource domain.SCIMGroup
+		if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+			writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", "Invalid request body: "+err.Error())
+			return
+		}
+		if err := h.checkMembers(resource.Members); err != nil {
+			writeSCIMFailure(w, err)
+			return
+		}
+
+		name := strings.TrimSpace(resource.DisplayName)
+		err := h.permissions.AddRole(name, nil)
+		switch {
+		case errors.Is(err, services.ErrRoleExists):
+			writeSCIMError(w, http.StatusConflict, "uniqueness", err.Error())
+			return
+		case err != nil:
+			writeSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
+			return
+		}
+		added, _, err := h.setMembers(name, resource.Members)
+		if err != nil {
+			writeSCIMFailure(w, err)
+			return
+		}
+		audit(h.auditLog, r, "scim.group.create", name, describeMembers(added, nil))
+
+		group := h.toSCIMGroup(r, name)
+		w.Header().Set("Location", group.Meta.Location)
+		writeSCIMResource(w, http.StatusCreated, group, group.Meta.Version)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Group handles GET, PUT, PATCH and DELETE on /scim/v2/Groups/{id}, where the
+// id is the role name. Changing the members assigns the role to users who
+// join and puts users who leave back on the default role. Groups cannot be
+// renamed, and the default role's group cannot lose members directly.
+func (h *SCIMHandler) Group(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("id")
+	if !h.permissions.HasRole(name) {
+		writeSCIMError(w, http.StatusNotFound, "", fmt.Sprintf("group %q not found", name))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		group := h.toSCIMGroup(r, name)
+		writeSCIMResource(w, http.StatusOK, group, group.Meta.Version)
+
+	case http.MethodPut:
+		var resource domain.SCIMGroup
+		if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+			writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", "Invalid request body: "+err.Error())
+			return
+		}
+		h.updateGroup(w, r, "scim.group.replace", resource)
+
+	case http.MethodPatch:
+		var patch domain.SCIMPatchRequest
+		if err := decodeSCIMPatch(r, &patch); err != nil {
+			writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", err.Error())
+			return
+		}
+		resource := scimObject(h.toSCIMGroup(r, name))
+		if err := services.ApplySCIMPatch(resource, patch.Operations); err != nil {
+			writeSCIMFailure(w, err)
+			return
+		}
+		var patched domain.SCIMGroup
+		if err := fromSCIMObject(resource, &patched); err != nil {
+			writeSCIMFailure(w, err)
+			return
+		}
+		h.updateGroup(w, r, "scim.group.update", patched)
+
+	case http.MethodDelete:
+		err := h.userRepo.WithoutHolders(name, func() error {
+			return h.permissions.DeleteRole(name)
+		})
+		if errors.Is(err, repo.ErrRoleHeld) {
+			writeSCIMError(w, http.StatusConflict, "", fmt.Sprintf("group %q still has members; remove them first", name))
+			return
+		}
+		if err != nil {
+			writeSCIMError(w, http.StatusConflict, "", err.Error())
+			return
+		}
+		audit(h.auditLog, r, "scim.group.delete", name, "")
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// updateGroup makes the group named in the path hold exactly the members of
+// resource and answers with the result
+func (h *SCIMHandler) updateGroup(w http.ResponseWriter, r *http.Request, action string, resource domain.SCIMGroup) {
+	name := r.PathValue("id")
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !matchesETag(ifMatch, h.toSCIMGroup(r, name).Meta.Version) {
+		writeSCIMFailure(w, errPreconditionFailed)
+		return
+	}
+	if displayName := strings.TrimSpace(resource.DisplayName); displayName != "" && displayName != name {
+		writeSCIMFailure(w, fmt.Errorf("%w: groups cannot be renamed", errSCIMMutability))
+		return
+	}
+	if err := h.checkMembers(resource.Members); err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+
+	added, removed, err := h.setMembers(name, resource.Members)
+	if err != nil {
+		writeSCIMFailure(w, err)
+		return
+	}
+	audit(h.auditLog, r, action, name, describeMembers(added, removed))
+
+	group := h.toSCIMGroup(r, name)
+	writeSCIMResource(w, http.StatusOK, group, group.Meta.Version)
+}
+
+// checkMembers checks that every member is an existing user
+func (h *SCIMHandler) checkMembers(members []domain.SCIMMember) error {
+	for _, member := range members {
+		if !h.userRepo.UserExists(member.Value) {
+			return fmt.Errorf("%w: member %q is not a user", errSCIMInvalidValue, member.Value)
+		}
+	}
+	return nil
+}
+
+// setMembers gives the role to every member that lacks it and the default role
+// to every holder that is no longer a member
+func (h *SCIMHandler) setMembers(role string, members []domain.SCIMMember) (added, removed []string, err error) {
+	wanted := make(map[string]bool, len(members))
+	for _, member := range members {
+		wanted[member.Value] = true
+	}
+	holders, _ := h.userRepo.ListUsers(role, nil, 0, math.MaxInt)
+	for _, holder := range holders {
+		if !wanted[holder.Username] {
+			removed = append(removed, holder.Username)
+		}
+		delete(wanted, holder.Username)
+	}
+	if len(removed) > 0 && role == h.permissions.DefaultRole {
+		return nil, nil, fmt.Errorf("%w: users leave the default group %q by joining another group", errSCIMMutability, role)
+	}
+	for username := range wanted {
+		added = append(added, username)
+	}
+	slices.Sort(added)
+
+	assign := func(username, role string) error {
+		_, err := h.userRepo.UpdateUser(username, func(user *domain.User) error {
+			user.Role = role
+			return nil
+		})
+		h.forget(username)
+		return err
+	}
+	for _, username := range added {
+		if err := assign(username, role); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, username := range removed {
+		if err := assign(username, h.permissions.DefaultRole); err != nil {
+			return nil, nil, err
+		}
+	}
+	return added, removed, nil
+}
+
+// toSCIMGroup renders a role as a SCIM group; its version changes whenever its members do
+func (h *SCIMHandler) toSCIMGroup(r *http.Request, role string) domain.SCIMGroup {
+	base := scimBaseURL(r)
+	holders, _ := h.userRepo.ListUsers(role, nil, 0, math.MaxInt)
+
+	members := make([]domain.SCIMMember, 0, len(holders))
+	digest := sha256.New()
+	digest.Write([]byte(role))
+	for _, holder := range holders {
+		members = append(members, domain.SCIMMember{
+			Value:   holder.Username,
+			Display: holder.Username,
+			Ref:     base + "/Users/" + url.PathEscape(holder.Username),
+		})
+		digest.Write([]byte{0})
+		digest.Write([]byte(holder.Username))
+	}
+
+	return domain.SCIMGroup{
+		Schemas:     []string{domain.SCIMGroupSchema},
+		ID:          role,
+		DisplayName: role,
+		Members:     members,
+		Meta: &domain.SCIMMeta{
+			ResourceType: "Group",
+			Location:     base + "/Groups/" + url.PathEscape(role),
+			Version:      `"` + hex.EncodeToString(digest.Sum(nil)[:16]) + `"`,
+		},
+	}
+}
+
+// forget drops the cached token version so the change applies to the next request
+func (h *SCIMHandler) forget(username string) {
+	if h.versions != nil {
+		h.versions.Forget(username)
+	}
+}
+
+// describeMembers lists the members a group change added and removed
+func describeMembers(added, removed []string) string {
+	var changes []string
+	if len(added) > 0 {
+		changes = append(changes, "added "+strings.Join(added, ", "))
+	}
+	if len(removed) > 0 {
+		changes = append(changes, "removed "+strings.Join(removed, ", "))
+	}
+	if len(changes) == 0 {
+		return "no changes"
+	}
+	return strings.Join(changes, "; ")
+}
+
+// decodeSCIMPatch reads a PatchOp body
+func decodeSCIMPatch(r *http.Request, patch *domain.SCIMPatchRequest) error {
+	if err := json.NewDecoder(r.Body).Decode(patch); err != nil {
+		return fmt.Errorf("invalid request body: %v", err)
+	}
+	if !slices.Contains(patch.Schemas, domain.SCIMPatchOpSchema) {
+		return fmt.Errorf("schemas must contain %s", domain.SCIMPatchOpSchema)
+	}
+	if len(patch.Operations) == 0 {
+		return errors.New("the Operations list is empty")
+	}
+	return nil
+}
+
+// writeSCIMList answers with the page of resources that match the filter
+// parameter, selected with startIndex (from 1) and count
+func writeSCIMList(w http.ResponseWriter, r *http.Request, resources []map[string]interface{}) {
+	query := r.URL.Query()
+	startIndex, err := queryInt(query.Get("startIndex"), 1)
+	if err != nil {
+		writeSCIMError(w, http.StatusBadRequest, "invalidValue", "startIndex must be a number")
+		return
+	}
+	count, err := queryInt(query.Get("count"), maxPerPage)
+	if err != nil {
+		writeSCIMError(w, http.StatusBadRequest, "invalidValue", "count must be a number")
+		return
+	}
+	// Out-of-range values are clamped rather than refused (RFC 7644 section 3.4.2.4)
+	startIndex = max(startIndex, 1)
+	count = min(max(count, 0), maxPerPage)
+
+	if expression := query.Get("filter"); expression != "" {
+		filter, err := services.ParseSCIMFilter(expression)
+		if err != nil {
+			writeSCIMFailure(w, err)
+			return
+		}
+		resources = slices.DeleteFunc(resources, func(resource map[string]interface{}) bool {
+			return !filter.Matches(resource)
+		})
+	}
+
+	total := len(resources)
+	page := make([]interface{}, 0, count)
+	for i := startIndex - 1; i < total && len(page) < count; i++ {
+		page = append(page, resources[i])
+	}
+	writeSCIM(w, http.StatusOK, domain.SCIMListResponse{
+		Schemas:      []string{domain.SCIMListResponseSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(page),
+		Resources:    page,
+	})
+}
+
+// writeSCIMFailure answers a failed SCIM request with the matching status and scimType
+func writeSCIMFailure(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, repo.ErrUserNotFound):
+		writeSCIMError(w, http.StatusNotFound, "", "User not found")
+	case errors.Is(err, errPreconditionFailed):
+		writeSCIMError(w, http.StatusPreconditionFailed, "", "resource has changed since it was read; fetch it again")
+	case errors.Is(err, services.ErrInvalidFilter):
+		writeSCIMError(w, http.StatusBadRequest, "invalidFilter", err.Error())
+	case errors.Is(err, services.ErrInvalidPath):
+		writeSCIMError(w, http.StatusBadRequest, "invalidPath", err.Error())
+	case errors.Is(err, services.ErrNoTarget):
+		writeSCIMError(w, http.StatusBadRequest, "noTarget", err.Error())
+	case errors.Is(err, errSCIMMutability):
+		writeSCIMError(w, http.StatusBadRequest, "mutability", err.Error())
+	case errors.Is(err, services.ErrInvalidPatch), errors.Is(err, services.ErrInvalidAttribute), errors.Is(err, services.ErrUnknownRole), errors.Is(err, errSCIMInvalidValue):
+		writeSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
+	default:
+		writeSCIMError(w, http.StatusInternalServerError, "", "Failed to update resource")
+	}
+}
+
+// writeSCIMError answers with a SCIM error body
+func writeSCIMError(w http.ResponseWriter, status int, scimType, detail string) {
+	writeSCIM(w, status, domain.SCIMError{
+		Schemas:  []string{domain.SCIMErrorSchema},
+		Status:   strconv.Itoa(status),
+		SCIMType: scimType,
+		Detail:   detail,
+	})
+}
+
+// writeSCIMResource answers with one resource and its ETag
+func writeSCIMResource(w http.ResponseWriter, status int, resource interface{}, etag string) {
+	w.Header().Set("ETag", etag)
+	writeSCIM(w, status, resource)
+}
+
+// writeSCIM answers with a SCIM JSON body
+func writeSCIM(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", scimContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// scimBaseURL is the absolute URL of /scim/v2 as the client reached it, for meta.location
+func scimBaseURL(r *http.Request) string {
+	return middleware.RequestOrigin(r) + "/scim/v2"
+}
+
+// scimObject renders a resource as a JSON object, the form filters and patches work on
+func scimObject(resource interface{}) map[string]interface{} {
+	data, _ := json.Marshal(resource)
+	var object map[string]interface{}
+	json.Unmarshal(data, &object)
+	return object
+}
+
+// fromSCIMObject reads a patched JSON object back into a resource
+func fromSCIMObject(object map[string]interface{}, resource interface{}) error {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errSCIMInvalidValue, err)
+	}
+	if err := json.Unmarshal(data, resource); err != nil {
+		return fmt.Errorf("%w: %v", errSCIMInvalidValue, err)
+	}
+	return nil
+}
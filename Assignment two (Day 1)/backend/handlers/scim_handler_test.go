@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/repo"
+	"jwt-auth-system/backend/services"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scimTest serves the SCIM routes with an API key that has the scim scope
+type scimTest struct {
+	t       *testing.T
+	mux     *http.ServeMux
+	apiKey  string
+	users   *repo.UserRepository
+	tokens  *services.JWTService
+	catalog *services.PermissionCatalog
+}
+
+func newSCIMTest(t *testing.T) *scimTest {
+	t.Helper()
+
+	key, err := services.GenerateSigningKey(services.AlgHS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := repo.NewUserRepository()
+	accounts := repo.NewServiceAccountRepository()
+	catalog := services.DefaultPermissionCatalog()
+	users.CheckRoles(catalog.CheckRole)
+	versions := services.NewTokenVersions(users, accounts, time.Minute)
+	config := services.DefaultTokenConfig()
+	config.Versions, config.Roles = versions, catalog
+	tokens := services.NewJWTServiceWithConfig(services.NewKeyring(key, config.MaxLifetime()), config)
+
+	serviceAccounts := services.NewServiceAccountService(accounts, tokens, config, time.Hour)
+	account, err := serviceAccounts.CreateAccount(domain.CreateServiceAccountRequest{Name: "provisioner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, apiKey, err := serviceAccounts.CreateKey(account.ID, []string{SCIMScope}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, username := range []string{"alice", "bob"} {
+		users.RegisterUser(&domain.User{Username: username, Role: "user", Attributes: domain.Attributes{"designation": "dev", "age": 30}})
+	}
+
+	handler := NewSCIMHandler(users, versions, catalog, services.DefaultAttributeSchema(), serviceAccounts, repo.NewAuditLog())
+	mux := http.NewServeMux()
+	mux.HandleFunc("/scim/v2/Users", handler.Authenticate(handler.Users))
+	mux.HandleFunc("/scim/v2/Users/{id}", handler.Authenticate(handler.User))
+	mux.HandleFunc("/scim/v2/Groups", handler.Authenticate(handler.Groups))
+	mux.HandleFunc("/scim/v2/Groups/{id}", handler.Authenticate(handler.Group))
+	mux.HandleFunc("/scim/v2/ServiceProviderConfig", handler.ServiceProviderConfig)
+	mux.HandleFunc("/scim/v2/Schemas", handler.Schemas)
+	return &scimTest{t: t, mux: mux, apiKey: apiKey, users: users, tokens: tokens, catalog: catalog}
+}
+
+// do sends a SCIM request and decodes the answer into out when it is not nil
+func (s *scimTest) do(method, target, ifMatch, body string, out interface{}) *httptest.ResponseRecorder {
+	s.t.Helper()
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	req.Header.Set("Content-Type", scimContentType)
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	recorder := httptest.NewRecorder()
+	s.mux.ServeHTTP(recorder, req)
+	if out != nil && recorder.Code < 300 {
+		if err := json.NewDecoder(recorder.Body).Decode(out); err != nil {
+			s.t.Fatal(err)
+		}
+	}
+	return recorder
+}
+
+// patch builds a PatchOp body from operations
+func patch(operations ...domain.SCIMPatchOperation) string {
+	data, _ := json.Marshal(domain.SCIMPatchRequest{Schemas: []string{domain.SCIMPatchOpSchema}, Operations: operations})
+	return string(data)
+}
+
+// scimErrorType reads the scimType of an error answer
+func scimErrorType(recorder *httptest.ResponseRecorder) string {
+	var body domain.SCIMError
+	json.Unmarshal(recorder.Body.Bytes(), &body)
+	return body.SCIMType
+}
+
+func TestSCIMListFilter(t *testing.T) {
+	s := newSCIMTest(t)
+
+	tests := []struct {
+		resource string
+		filter   string
+		want     int
+		scimType string
+	}{
+		{"Users", `userName eq "ALICE"`, 1, ""},
+		{"Users", `userName sw "a" or userName sw "b"`, 2, ""},
+		{"Users", `groups[value eq "user"] and not (userName eq "bob")`, 1, ""},
+		{"Users", `urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:age gt 40`, 0, ""},
+		{"Users", `active eq true`, 2, ""},
+		{"Groups", `members[value eq "alice"]`, 1, ""},
+		{"Groups", `displayName eq "viewer"`, 1, ""},
+		{"Users", `userName eq`, 0, "invalidFilter"},
+		{"Users", `userName zz "alice"`, 0, "invalidFilter"},
+	}
+	for _, test := range tests {
+		t.Run(test.resource+" "+test.filter, func(t *testing.T) {
+			var list domain.SCIMListResponse
+			recorder := s.do(http.MethodGet, "/scim/v2/"+test.resource+"?filter="+url.QueryEscape(test.filter), "", "", &list)
+			if test.scimType != "" {
+				if recorder.Code != http.StatusBadRequest || scimErrorType(recorder) != test.scimType {
+					t.Fatalf("answer = %d %s, want 400 %s", recorder.Code, recorder.Body, test.scimType)
+				}
+				return
+			}
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status = %d (%s), want 200", recorder.Code, recorder.Body)
+			}
+			if list.TotalResults != test.want {
+				t.Errorf("totalResults = %d, want %d", list.TotalResults, test.want)
+			}
+		})
+	}
+}
+
+func TestSCIMUserIfMatch(t *testing.T) {
+	s := newSCIMTest(t)
+	designation := func(value string) string {
+		return patch(domain.SCIMPatchOperation{Op: "replace", Path: domain.SCIMEnterpriseUserSchema + ":designation", Value: value})
+	}
+
+	read := s.do(http.MethodGet, "/scim/v2/Users/alice", "", "", nil)
+	etag := read.Header().Get("ETag")
+	if read.Code != http.StatusOK || etag == "" {
+		t.Fatalf("GET = %d with ETag %q", read.Code, etag)
+	}
+
+	var user domain.SCIMUser
+	changed := s.do(http.MethodPatch, "/scim/v2/Users/alice", etag, designation("lead"), &user)
+	if changed.Code != http.StatusOK {
+		t.Fatalf("PATCH with the current ETag = %d (%s)", changed.Code, changed.Body)
+	}
+	newETag := changed.Header().Get("ETag")
+	if newETag == etag || newETag != user.Meta.Version {
+		t.Errorf("ETag after the change = %q (meta.version %q), old ETag %q", newETag, user.Meta.Version, etag)
+	}
+	if user.Enterprise.Attributes["designation"] != "lead" {
+		t.Errorf("designation = %v, want lead", user.Enterprise.Attributes["designation"])
+	}
+
+	// The old ETag no longer matches anything that changes the user
+	if recorder := s.do(http.MethodPatch, "/scim/v2/Users/alice", etag, designation("manager"), nil); recorder.Code != http.StatusPreconditionFailed {
+		t.Errorf("PATCH with a stale ETag = %d, want 412", recorder.Code)
+	}
+	put := `{"schemas": ["` + domain.SCIMUserSchema + `"], "userName": "alice", "active": false}`
+	if recorder := s.do(http.MethodPut, "/scim/v2/Users/alice", etag, put, nil); recorder.Code != http.StatusPreconditionFailed {
+		t.Errorf("PUT with a stale ETag = %d, want 412", recorder.Code)
+	}
+	if recorder := s.do(http.MethodDelete, "/scim/v2/Users/alice", etag, "", nil); recorder.Code != http.StatusPreconditionFailed {
+		t.Errorf("DELETE with a stale ETag = %d, want 412", recorder.Code)
+	}
+	if stored, _ := s.users.GetUser("alice"); stored.Attributes["designation"] != "lead" || stored.Disabled {
+		t.Errorf("refused changes were stored: %+v", stored)
+	}
+
+	if recorder := s.do(http.MethodPatch, "/scim/v2/Users/alice", `"other", `+newETag, designation("manager"), nil); recorder.Code != http.StatusOK {
+		t.Errorf("PATCH with the current ETag in a list = %d (%s), want 200", recorder.Code, recorder.Body)
+	}
+	if recorder := s.do(http.MethodDelete, "/scim/v2/Users/bob", "*", "", nil); recorder.Code != http.StatusNoContent {
+		t.Errorf("DELETE with If-Match: * = %d, want 204", recorder.Code)
+	}
+}
+
+func TestSCIMGroupMembershipInvalidatesTokens(t *testing.T) {
+	s := newSCIMTest(t)
+	issue := func(username string) string {
+		user, _ := s.users.GetUser(username)
+		token, err := s.tokens.GenerateToken(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	aliceToken, bobToken := issue("alice"), issue("bob")
+
+	read := s.do(http.MethodGet, "/scim/v2/Groups/viewer", "", "", nil)
+	etag := read.Header().Get("ETag")
+
+	// alice joins viewer, which takes her out of user
+	var group domain.SCIMGroup
+	join := patch(domain.SCIMPatchOperation{Op: "add", Path: "members", Value: []interface{}{map[string]interface{}{"value": "alice"}}})
+	recorder := s.do(http.MethodPatch, "/scim/v2/Groups/viewer", etag, join, &group)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("adding a member = %d (%s)", recorder.Code, recorder.Body)
+	}
+	if len(group.Members) != 1 || group.Members[0].Value != "alice" {
+		t.Errorf("members = %+v, want alice", group.Members)
+	}
+	if user, _ := s.users.GetUser("alice"); user.Role != "viewer" {
+		t.Errorf("alice's role = %s, want viewer", user.Role)
+	}
+	if _, err := s.tokens.ValidateToken(aliceToken); !errors.Is(err, services.ErrStaleToken) {
+		t.Errorf("alice's old token after joining a group: %v, want %v", err, services.ErrStaleToken)
+	}
+	if _, err := s.tokens.ValidateToken(bobToken); err != nil {
+		t.Errorf("bob's token after alice joined a group: %v", err)
+	}
+
+	// The group's version changed with its members, so the old ETag is stale
+	if recorder.Header().Get("ETag") == etag {
+		t.Error("group ETag unchanged after a membership change")
+	}
+	leave := patch(domain.SCIMPatchOperation{Op: "remove", Path: `members[value eq "alice"]`})
+	if recorder := s.do(http.MethodPatch, "/scim/v2/Groups/viewer", etag, leave, nil); recorder.Code != http.StatusPreconditionFailed {
+		t.Fatalf("PATCH with a stale group ETag = %d, want 412", recorder.Code)
+	}
+
+	aliceToken = issue("alice")
+	var emptied domain.SCIMGroup
+	if recorder := s.do(http.MethodPatch, "/scim/v2/Groups/viewer", "", leave, &emptied); recorder.Code != http.StatusOK {
+		t.Fatalf("removing a member by value path = %d (%s)", recorder.Code, recorder.Body)
+	}
+	if len(emptied.Members) != 0 {
+		t.Errorf("members = %+v, want none", emptied.Members)
+	}
+	if user, _ := s.users.GetUser("alice"); user.Role != s.catalog.DefaultRole {
+		t.Errorf("alice's role after leaving = %s, want %s", user.Role, s.catalog.DefaultRole)
+	}
+	if _, err := s.tokens.ValidateToken(aliceToken); !errors.Is(err, services.ErrStaleToken) {
+		t.Errorf("alice's token after leaving a group: %v, want %v", err, services.ErrStaleToken)
+	}
+
+	// An unmatched value path changes nobody
+	if recorder := s.do(http.MethodPatch, "/scim/v2/Groups/viewer", "", leave, nil); recorder.Code != http.StatusBadRequest || scimErrorType(recorder) != "noTarget" {
+		t.Errorf("removing a member that is not there = %d %s, want 400 noTarget", recorder.Code, scimErrorType(recorder))
+	}
+	if _, err := s.tokens.ValidateToken(bobToken); err != nil {
+		t.Errorf("bob's token after group changes that left him alone: %v", err)
+	}
+}
+
+func TestSCIMDiscoveryNeedsNoAPIKey(t *testing.T) {
+	s := newSCIMTest(t)
+
+	tests := []struct {
+		method string
+		target string
+		want   int
+	}{
+		{http.MethodGet, "/scim/v2/ServiceProviderConfig", http.StatusOK},
+		{http.MethodGet, "/scim/v2/Schemas", http.StatusOK},
+		{http.MethodGet, "/scim/v2/Schemas?filter=" + url.QueryEscape(`id eq "x"`), http.StatusForbidden},
+		{http.MethodPost, "/scim/v2/Schemas", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/scim/v2/Users", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.method+" "+test.target, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			s.mux.ServeHTTP(recorder, httptest.NewRequest(test.method, test.target, nil))
+			if recorder.Code != test.want {
+				t.Errorf("status = %d, want %d", recorder.Code, test.want)
+			}
+		})
+	}
+}
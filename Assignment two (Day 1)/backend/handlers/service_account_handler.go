@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/repo"
+	"jwt-auth-system/backend/services"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ServiceAccountHandler handles admin requests that manage service accounts and their API keys
+type ServiceAccountHandler struct {
+	serviceAccounts *services.ServiceAccountService
+	versions        *services.TokenVersions
+	auditLog        *repo.AuditLog
+}
+
+// NewServiceAccountHandler creates a new service account handler
+func NewServiceAccountHandler(serviceAccounts *services.ServiceAccountService, versions *services.TokenVersions, auditLog *repo.AuditLog) *ServiceAccountHandler {
+	return &ServiceAccountHandler{
+		serviceAccounts: serviceAccounts,
+		versions:        versions,
+		auditLog:        auditLog,
+	}
+}
+
+// Accounts handles GET /admin/service-accounts to list service accounts and POST to create one
+func (h *ServiceAccountHandler) Accounts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.serviceAccounts.ListAccounts())
+
+	case http.MethodPost:
+		var req domain.CreateServiceAccountRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(req.Name) == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+
+		account, err := h.serviceAccounts.CreateAccount(req)
+		if err != nil {
+			http.Error(w, "Failed to create service account", http.StatusInternalServerError)
+			return
+		}
+		audit(h.auditLog, r, "service_account.create", account.ID, "name: "+account.Name)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(account)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Account handles GET and DELETE on /admin/service-accounts/{id}; deleting an
+// account deletes its keys and invalidates the tokens it holds
+func (h *ServiceAccountHandler) Account(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	switch r.Method {
+	case http.MethodGet:
+		account, err := h.serviceAccounts.GetAccount(id)
+		if err != nil {
+			http.Error(w, "Service account not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(account)
+
+	case http.MethodDelete:
+		if err := h.serviceAccounts.DeleteAccount(id); err != nil {
+			http.Error(w, "Service account not found", http.StatusNotFound)
+			return
+		}
+		if h.versions != nil {
+			h.versions.ForgetServiceAccount(id)
+		}
+		audit(h.auditLog, r, "service_account.delete", id, "")
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Keys handles GET /admin/service-accounts/{id}/keys to list the account's
+// keys and POST to issue a new one, which is shown only in this response
+func (h *ServiceAccountHandler) Keys(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	switch r.Method {
+	case http.MethodGet:
+		keys, err := h.serviceAccounts.ListKeys(id)
+		if err != nil {
+			http.Error(w, "Service account not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(keys)
+
+	case http.MethodPost:
+		var req domain.CreateAPIKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		key, secret, err := h.serviceAccounts.CreateKey(id, req.Scopes, req.ExpiresAt)
+		if !checkKeyWrite(w, err) {
+			return
+		}
+		audit(h.auditLog, r, "api_key.create", id, "key "+key.Prefix+", scopes: "+strings.Join(key.Scopes, " "))
+		writeNewKey(w, key, secret)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// RevokeKey handles DELETE /admin/service-accounts/{id}/keys/{key_id}
+func (h *ServiceAccountHandler) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.PathValue("id")
+	key, err := h.serviceAccounts.RevokeKey(id, r.PathValue("key_id"))
+	if !checkKeyWrite(w, err) {
+		return
+	}
+	audit(h.auditLog, r, "api_key.revoke", id, "key "+key.Prefix)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RotateKey handles POST /admin/service-accounts/{id}/keys/{key_id}/rotate,
+// issuing a key with the same scopes and revoking the old one after the grace period
+func (h *ServiceAccountHandler) RotateKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req domain.RotateAPIKeyRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	var grace time.Duration
+	if req.GracePeriod != "" {
+		var err error
+		if grace, err = time.ParseDuration(req.GracePeriod); err != nil || grace < 0 {
+			http.Error(w, "grace_period must be a duration such as 1h", http.StatusBadRequest)
+			return
+		}
+	}
+
+	id, oldKeyID := r.PathValue("id"), r.PathValue("key_id")
+	key, secret, err := h.serviceAccounts.RotateKey(id, oldKeyID, grace, req.ExpiresAt)
+	if !checkKeyWrite(w, err) {
+		return
+	}
+	audit(h.auditLog, r, "api_key.rotate", id, "key "+services.APIKeyPrefix+oldKeyID+" replaced by "+key.Prefix+", grace period "+grace.String())
+	writeNewKey(w, key, secret)
+}
+
+// checkKeyWrite answers a failed key change: 404 for unknown accounts or keys,
+// 400 for anything the request got wrong
+func checkKeyWrite(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, repo.ErrServiceAccountNotFound), errors.Is(err, repo.ErrAPIKeyNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+	return false
+}
+
+// writeNewKey answers with a new key and its secret, which must not be cached
+func writeNewKey(w http.ResponseWriter, key *domain.APIKey, secret string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(domain.CreateAPIKeyResponse{APIKey: key, Key: secret})
+}
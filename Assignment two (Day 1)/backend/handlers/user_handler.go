@@ -0,0 +1,466 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/middleware"
+	"jwt-auth-system/backend/repo"
+	"jwt-auth-system/backend/services"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// defaultPerPage is the page size of /users when per_page is not given
+	defaultPerPage = 20
+
+	// maxPerPage caps the page size of /users
+	maxPerPage = 100
+)
+
+var (
+	// errPreconditionFailed is returned when If-Match does not match the stored user
+	errPreconditionFailed = errors.New("user has changed since it was read; fetch it again")
+
+	// errOTPEnrolled is returned when enrolling a second authenticator app
+	errOTPEnrolled = errors.New("an authenticator app is already enrolled; an admin must reset it first")
+)
+
+// UserHandler handles requests that read and change users and their tokens
+type UserHandler struct {
+	userRepo    *repo.UserRepository
+	versions    *services.TokenVersions
+	permissions *services.PermissionCatalog
+	attributes  *services.AttributeSchema
+	credentials *services.Credentials
+	auditLog    *repo.AuditLog
+}
+
+// NewUserHandler creates a new user handler; versions may be nil when tokens are not versioned
+func NewUserHandler(userRepo *repo.UserRepository, versions *services.TokenVersions, permissions *services.PermissionCatalog, attributes *services.AttributeSchema, credentials *services.Credentials, auditLog *repo.AuditLog) *UserHandler {
+	return &UserHandler{
+		userRepo:    userRepo,
+		versions:    versions,
+		credentials: credentials,
+		permissions: permissions,
+		attributes:  attributes,
+		auditLog:    auditLog,
+	}
+}
+
+// ListUsers handles GET /users, paginated with page and per_page and filtered
+// by role and by any attribute, e.g. ?department=eng. It must run behind
+// RequireScopes("users:read").
+func (h *UserHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	page, err := queryInt(query.Get("page"), 1)
+	if err != nil || page < 1 {
+		http.Error(w, "page must be a positive number", http.StatusBadRequest)
+		return
+	}
+	perPage, err := queryInt(query.Get("per_page"), defaultPerPage)
+	if err != nil || perPage < 1 || perPage > maxPerPage {
+		http.Error(w, fmt.Sprintf("per_page must be between 1 and %d", maxPerPage), http.StatusBadRequest)
+		return
+	}
+
+	filters := make(map[string]string)
+	for name := range query {
+		switch {
+		case name == "page" || name == "per_page" || name == "role":
+		case h.attributes.Has(name):
+			filters[name] = query.Get(name)
+		default:
+			http.Error(w, fmt.Sprintf("cannot filter by %q", name), http.StatusBadRequest)
+			return
+		}
+	}
+
+	users, total := h.userRepo.ListUsers(query.Get("role"), filters, (page-1)*perPage, perPage)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(domain.UserListResponse{
+		Users:   users,
+		Page:    page,
+		PerPage: perPage,
+		Total:   total,
+	})
+}
+
+// User handles GET, PATCH and DELETE on /users/{username}. Users may read and
+// edit the non-privileged fields of their own record; anything else needs the
+// users:read or users:write scope. It must run inside Authenticate.
+func (h *UserHandler) User(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.ClaimsFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	username := r.PathValue("username")
+	self := claims.Username == username
+
+	switch r.Method {
+	case http.MethodGet:
+		if !self && !claims.HasScope("users:read") {
+			http.Error(w, "Reading other users requires the users:read scope", http.StatusForbidden)
+			return
+		}
+		user, err := h.userRepo.GetUser(username)
+		if err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		writeUser(w, user)
+
+	case http.MethodPatch:
+		if !self && !claims.HasScope("users:write") {
+			http.Error(w, "Changing other users requires the users:write scope", http.StatusForbidden)
+			return
+		}
+		h.update(w, r, username, claims.HasScope("users:write"), true)
+
+	case http.MethodDelete:
+		if !claims.HasScope("users:write") {
+			http.Error(w, "Deleting users requires the users:write scope", http.StatusForbidden)
+			return
+		}
+		h.delete(w, r, username)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// UpdateUser handles PATCH /admin/users/{username}; role and disabled changes invalidate the user's existing tokens
+func (h *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.update(w, r, r.PathValue("username"), true, false)
+}
+
+// RevokeTokens handles POST /admin/users/{username}/logout, invalidating every token issued to the user
+func (h *UserHandler) RevokeTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, err := h.userRepo.RevokeUserTokens(r.PathValue("username"))
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	h.forget(user.Username)
+	audit(h.auditLog, r, "user.logout", user.Username, fmt.Sprintf("token version %d", user.TokenVersion))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EnrollOTP handles POST /users/{username}/otp, letting users enroll an
+// authenticator app for their own account. The token must come from a
+// password login, since without a password anyone could log in as the user
+// and take over the second factor; users without one ask an admin. The secret
+// is shown once, and the change invalidates the user's existing tokens. An
+// enrolled app can only be replaced after an admin resets it.
+func (h *UserHandler) EnrollOTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, ok := middleware.ClaimsFromContext(r.Context())
+	username := r.PathValue("username")
+	if !ok || claims.Username != username {
+		http.Error(w, "Users can only enroll an authenticator app for themselves", http.StatusForbidden)
+		return
+	}
+	if !slices.Contains(claims.AMR, services.AMRPassword) {
+		http.Error(w, "Enrolling an authenticator app needs a token from a password login; set a password or ask an admin", http.StatusForbidden)
+		return
+	}
+
+	h.enrollOTP(w, r, username)
+}
+
+// OTP handles POST and DELETE on /admin/users/{username}/otp: POST enrolls an
+// authenticator app for a user who has none, e.g. one without a password, and
+// DELETE removes the user's app so they can enroll a new one
+func (h *UserHandler) OTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		h.enrollOTP(w, r, r.PathValue("username"))
+	case http.MethodDelete:
+		h.resetOTP(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// enrollOTP gives the user a new authenticator app secret and answers with it
+func (h *UserHandler) enrollOTP(w http.ResponseWriter, r *http.Request, username string) {
+	enrollment := h.credentials.NewTOTPEnrollment(username)
+	user, err := h.userRepo.UpdateUser(username, func(user *domain.User) error {
+		if user.TOTPSecret != "" {
+			return errOTPEnrolled
+		}
+		user.TOTPSecret = enrollment.Secret
+		return nil
+	})
+	if errors.Is(err, errOTPEnrolled) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if !h.checkWrite(w, err) {
+		return
+	}
+	h.forget(user.Username)
+	audit(h.auditLog, r, "user.otp.enroll", user.Username, "")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+// resetOTP removes the user's authenticator app
+func (h *UserHandler) resetOTP(w http.ResponseWriter, r *http.Request) {
+	user, err := h.userRepo.UpdateUser(r.PathValue("username"), func(user *domain.User) error {
+		user.TOTPSecret = ""
+		return nil
+	})
+	if !h.checkWrite(w, err) {
+		return
+	}
+	h.forget(user.Username)
+	audit(h.auditLog, r, "user.otp.reset", user.Username, "")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Role handles PUT and DELETE on /admin/users/{username}/role: PUT assigns the
+// role in the body, DELETE revokes the user's role back to the default role
+func (h *UserHandler) Role(w http.ResponseWriter, r *http.Request) {
+	var role string
+	switch r.Method {
+	case http.MethodPut:
+		var req domain.AssignRoleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		role = req.Role
+	case http.MethodDelete:
+		role = h.permissions.DefaultRole
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.permissions.HasRole(role) {
+		http.Error(w, fmt.Sprintf("unknown role %q", role), http.StatusBadRequest)
+		return
+	}
+
+	var previous string
+	user, err := h.userRepo.UpdateUser(r.PathValue("username"), func(user *domain.User) error {
+		previous = user.Role
+		user.Role = role
+		return nil
+	})
+	if !h.checkWrite(w, err) {
+		return
+	}
+	h.forget(user.Username)
+
+	action := "user.role.assign"
+	if r.Method == http.MethodDelete {
+		action = "user.role.revoke"
+	}
+	audit(h.auditLog, r, action, user.Username, fmt.Sprintf("from %s to %s", previous, user.Role))
+
+	writeUser(w, user)
+}
+
+// update applies a PATCH body to a user. Without privileged only attributes
+// that are not read-only may change; with requireMatch the request must carry If-Match.
+func (h *UserHandler) update(w http.ResponseWriter, r *http.Request, username string, privileged, requireMatch bool) {
+	var req domain.UpdateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !privileged && (req.Role != nil || req.Disabled != nil) {
+		http.Error(w, "role and disabled can only be changed with the users:write scope", http.StatusForbidden)
+		return
+	}
+	if err := h.validateUpdate(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ifMatch := r.Header.Get("If-Match")
+	if requireMatch && ifMatch == "" {
+		http.Error(w, "If-Match with the user's ETag is required", http.StatusPreconditionRequired)
+		return
+	}
+
+	var before domain.User
+	user, err := h.userRepo.UpdateUser(username, func(user *domain.User) error {
+		if ifMatch != "" && !matchesETag(ifMatch, user.ETag()) {
+			return errPreconditionFailed
+		}
+		before = *user
+		if len(req.Attributes) > 0 {
+			attributes, err := h.attributes.Apply(user.Attributes, req.Attributes, !privileged)
+			if err != nil {
+				return err
+			}
+			user.Attributes = attributes
+		}
+		if req.Role != nil {
+			user.Role = *req.Role
+		}
+		if req.Disabled != nil {
+			user.Disabled = *req.Disabled
+		}
+		return nil
+	})
+	if !h.checkWrite(w, err) {
+		return
+	}
+	h.forget(user.Username)
+	audit(h.auditLog, r, "user.update", user.Username, describeChanges(h.attributes, &before, user))
+
+	writeUser(w, user)
+}
+
+// delete removes a user whose ETag matches If-Match
+func (h *UserHandler) delete(w http.ResponseWriter, r *http.Request, username string) {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		http.Error(w, "If-Match with the user's ETag is required", http.StatusPreconditionRequired)
+		return
+	}
+
+	err := h.userRepo.DeleteUser(username, func(user *domain.User) error {
+		if !matchesETag(ifMatch, user.ETag()) {
+			return errPreconditionFailed
+		}
+		return nil
+	})
+	if !h.checkWrite(w, err) {
+		return
+	}
+	h.forget(username)
+	audit(h.auditLog, r, "user.delete", username, "")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateUpdate checks the role being assigned; attributes are checked against the schema on update
+func (h *UserHandler) validateUpdate(req domain.UpdateUserRequest) error {
+	if req.Role != nil {
+		if *req.Role == "" {
+			return errors.New("role cannot be empty")
+		}
+		if !h.permissions.HasRole(*req.Role) {
+			return fmt.Errorf("unknown role %q", *req.Role)
+		}
+	}
+	return nil
+}
+
+// checkWrite answers a failed repository write and reports whether it succeeded
+func (h *UserHandler) checkWrite(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, repo.ErrUserNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, errPreconditionFailed):
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+	case errors.Is(err, services.ErrReadOnlyAttribute):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrInvalidAttribute), errors.Is(err, services.ErrUnknownRole):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Failed to update user", http.StatusInternalServerError)
+	}
+	return false
+}
+
+// describeChanges lists the fields that differ between two versions of a user,
+// without the values of sensitive attributes
+func describeChanges(attributes *services.AttributeSchema, before, after *domain.User) string {
+	var changes []string
+	if before.Role != after.Role {
+		changes = append(changes, fmt.Sprintf("role from %s to %s", before.Role, after.Role))
+	}
+	for _, name := range attributes.Names() {
+		old, had := before.Attributes[name]
+		updated, has := after.Attributes[name]
+		switch {
+		case had == has && fmt.Sprint(old) == fmt.Sprint(updated):
+		case attributes.Properties[name].Sensitive:
+			changes = append(changes, name+" changed")
+		case !has:
+			changes = append(changes, fmt.Sprintf("%s removed", name))
+		case !had:
+			changes = append(changes, fmt.Sprintf("%s set to %v", name, updated))
+		default:
+			changes = append(changes, fmt.Sprintf("%s from %v to %v", name, old, updated))
+		}
+	}
+	if before.Disabled != after.Disabled {
+		changes = append(changes, fmt.Sprintf("disabled from %t to %t", before.Disabled, after.Disabled))
+	}
+	if len(changes) == 0 {
+		return "no changes"
+	}
+	return strings.Join(changes, ", ")
+}
+
+// forget drops the cached token version so the change applies to the next request
+func (h *UserHandler) forget(username string) {
+	if h.versions != nil {
+		h.versions.Forget(username)
+	}
+}
+
+// writeUser sends a user with its ETag
+func writeUser(w http.ResponseWriter, user *domain.User) {
+	w.Header().Set("ETag", user.ETag())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// matchesETag reports whether an If-Match header accepts etag, using the
+// strong comparison RFC 9110 requires
+func matchesETag(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// queryInt parses an optional integer query parameter
+func queryInt(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
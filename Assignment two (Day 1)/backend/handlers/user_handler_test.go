@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/middleware"
+	"jwt-auth-system/backend/repo"
+	"jwt-auth-system/backend/services"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEnrollOTP(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller *domain.Claims
+		target string
+		admin  bool
+		want   int
+	}{
+		{"password login", &domain.Claims{Username: "alice", AMR: []string{services.AMRPassword}}, "alice", false, http.StatusCreated},
+		{"login without a password", &domain.Claims{Username: "alice"}, "alice", false, http.StatusForbidden},
+		{"another user's account", &domain.Claims{Username: "bob", AMR: []string{services.AMRPassword}}, "alice", false, http.StatusForbidden},
+		{"admin for a user without a password", nil, "alice", true, http.StatusCreated},
+		{"admin for an unknown user", nil, "carol", true, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			users := repo.NewUserRepository()
+			users.RegisterUser(&domain.User{Username: "alice", Role: "user"})
+			users.RegisterUser(&domain.User{Username: "bob", Role: "user"})
+			handler := NewUserHandler(users, nil, services.DefaultPermissionCatalog(), nil, services.NewCredentials("test"), repo.NewAuditLog())
+
+			req := httptest.NewRequest(http.MethodPost, "/users/"+test.target+"/otp", nil)
+			req.SetPathValue("username", test.target)
+			if test.caller != nil {
+				req = req.WithContext(middleware.WithClaims(req.Context(), test.caller))
+			}
+			recorder := httptest.NewRecorder()
+			if test.admin {
+				handler.OTP(recorder, req)
+			} else {
+				handler.EnrollOTP(recorder, req)
+			}
+
+			if recorder.Code != test.want {
+				t.Fatalf("status = %d (%s), want %d", recorder.Code, recorder.Body, test.want)
+			}
+			user, _ := users.GetUser("alice")
+			if enrolled := user.TOTPSecret != ""; enrolled != (test.want == http.StatusCreated) {
+				t.Errorf("enrolled = %v after status %d", enrolled, recorder.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/services"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RFC 6750 error codes
+const (
+	ErrorInvalidRequest    = "invalid_request"
+	ErrorInvalidToken      = "invalid_token"
+	ErrorInsufficientScope = "insufficient_scope"
+
+	// RFC 9470 error code asking the client to have the user authenticate again
+	ErrorInsufficientUserAuthentication = "insufficient_user_authentication"
+
+	// RFC 9449 error codes
+	ErrorInvalidDPoPProof = "invalid_dpop_proof"
+	ErrorUseDPoPNonce     = "use_dpop_nonce"
+
+	// ErrorInvalidCSRFToken reports a cookie-authenticated request without the matching CSRF token
+	ErrorInvalidCSRFToken = "invalid_csrf_token"
+)
+
+// Options configures how the authenticator finds and reports on tokens
+type Options struct {
+	// Realm is reported in WWW-Authenticate challenges
+	Realm string
+
+	// CookieName, when set, is checked for a token if there is no Authorization
+	// header; TokenCookieName is the cookie this server sets
+	CookieName string
+
+	// CSRF, when set, requires state-changing requests authenticated by the
+	// cookie to carry the CSRF token as well (see VerifyCSRF)
+	CSRF bool
+
+	// Audience, when set, must appear in the token's aud claim
+	Audience string
+
+	// DPoP, when set, accepts sender-constrained tokens under the DPoP scheme.
+	// Tokens bound with cnf.jkt are rejected without it.
+	DPoP *services.DPoPVerifier
+}
+
+// Authenticator validates bearer tokens and stores their claims in the request context
+type Authenticator struct {
+	jwtService services.TokenVerifier
+	options    Options
+}
+
+// NewAuthenticator creates a new authenticator backed by a token verifier such as JWTService
+func NewAuthenticator(jwtService services.TokenVerifier, options Options) *Authenticator {
+	if options.Realm == "" {
+		options.Realm = "jwt-auth-system"
+	}
+	return &Authenticator{
+		jwtService: jwtService,
+		options:    options,
+	}
+}
+
+// Authenticate is a middleware that rejects requests without a valid token and
+// makes the token's claims available through ClaimsFromContext
+func (a *Authenticator) Authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, scheme, errCode, description := a.extractToken(r)
+		if token == "" {
+			a.challenge(w, http.StatusUnauthorized, errCode, description, "")
+			return
+		}
+		if scheme == "Cookie" && a.options.CSRF && !VerifyCSRF(r) {
+			WriteCSRFError(w)
+			return
+		}
+
+		claims, err := a.validate(token)
+		if err == nil && isProxied(r.Context()) && claims.Cnf != nil && claims.Cnf.X5TS256 != "" {
+			err = services.ErrCertificateUnavailable
+		} else if err == nil {
+			err = services.CheckConfirmation(claims, services.PeerCertificate(r.TLS))
+		}
+		if err != nil {
+			a.challenge(w, http.StatusUnauthorized, ErrorInvalidToken, err.Error(), "")
+			return
+		}
+
+		bound := claims.Cnf != nil && claims.Cnf.JKT != ""
+		if scheme == "DPoP" {
+			if !a.checkDPoP(w, r, token, claims) {
+				return
+			}
+		} else if bound {
+			// A stolen DPoP-bound token must not work as a plain bearer token
+			a.challenge(w, http.StatusUnauthorized, ErrorInvalidToken, "token is bound to a DPoP key and needs the DPoP scheme", "")
+			return
+		}
+
+		next(w, r.WithContext(WithClaims(r.Context(), claims)))
+	}
+}
+
+// RequireRole is a route guard that only lets through users holding one of the
+// given roles. It must run inside Authenticate.
+func (a *Authenticator) RequireRole(roles ...string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				a.challenge(w, http.StatusUnauthorized, "", "", "")
+				return
+			}
+
+			for _, role := range roles {
+				if claims.Role == role {
+					next(w, r)
+					return
+				}
+			}
+
+			a.challenge(w, http.StatusForbidden, ErrorInsufficientScope,
+				fmt.Sprintf("requires role %s", strings.Join(roles, " or ")), "")
+		}
+	}
+}
+
+// RequireScopes is a route guard that only lets through tokens granted every
+// one of the given scopes. It must run inside Authenticate.
+func (a *Authenticator) RequireScopes(scopes ...string) func(http.HandlerFunc) http.HandlerFunc {
+	required := strings.Join(scopes, " ")
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				a.challenge(w, http.StatusUnauthorized, "", "", "")
+				return
+			}
+
+			for _, scope := range scopes {
+				if !claims.HasScope(scope) {
+					a.challenge(w, http.StatusForbidden, ErrorInsufficientScope,
+						fmt.Sprintf("requires scope %s", required), required)
+					return
+				}
+			}
+
+			next(w, r)
+		}
+	}
+}
+
+// RequireACR is a route guard for sensitive operations that need the user to
+// have authenticated at the given level (see services.SatisfiesACR), such as
+// "mfa". Weaker tokens get an RFC 9470 step-up challenge naming the level in
+// acr_values. It must run inside Authenticate.
+func (a *Authenticator) RequireACR(acr string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				a.challenge(w, http.StatusUnauthorized, "", "", "")
+				return
+			}
+
+			if !services.SatisfiesACR(claims.ACR, acr) {
+				a.stepUpChallenge(w, fmt.Sprintf("requires authentication level %s", acr),
+					fmt.Sprintf(`acr_values="%s"`, sanitizeDescription(acr)))
+				return
+			}
+
+			next(w, r)
+		}
+	}
+}
+
+// MaxAuthAge is a route guard for sensitive operations that need the user to
+// have authenticated within maxAge. Older tokens, and tokens without auth_time,
+// get an RFC 9470 step-up challenge carrying max_age. It must run inside Authenticate.
+func (a *Authenticator) MaxAuthAge(maxAge time.Duration) func(http.HandlerFunc) http.HandlerFunc {
+	seconds := int64(maxAge.Seconds())
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				a.challenge(w, http.StatusUnauthorized, "", "", "")
+				return
+			}
+
+			if claims.AuthTime == nil || time.Since(claims.AuthTime.Time) > maxAge {
+				a.stepUpChallenge(w, fmt.Sprintf("requires authentication within the last %s", maxAge),
+					fmt.Sprintf("max_age=%d", seconds))
+				return
+			}
+
+			next(w, r)
+		}
+	}
+}
+
+// validate checks the token, pinning the audience when one is configured
+func (a *Authenticator) validate(token string) (*domain.Claims, error) {
+	if a.options.Audience != "" {
+		return a.jwtService.ValidateTokenForAudience(token, a.options.Audience)
+	}
+	return a.jwtService.ValidateToken(token)
+}
+
+// checkDPoP verifies the DPoP proof sent with a token and that the token is
+// bound to the proof key, writing the error response on failure
+func (a *Authenticator) checkDPoP(w http.ResponseWriter, r *http.Request, token string, claims *domain.Claims) bool {
+	proofs := r.Header.Values("DPoP")
+	if len(proofs) != 1 {
+		a.dpopChallenge(w, ErrorInvalidDPoPProof, "exactly one DPoP proof header is required")
+		return false
+	}
+
+	jkt, err := a.options.DPoP.VerifyProof(proofs[0], r.Method, RequestURL(r), token)
+	if errors.Is(err, services.ErrUseDPoPNonce) {
+		a.dpopChallenge(w, ErrorUseDPoPNonce, err.Error())
+		return false
+	}
+	if err != nil {
+		a.dpopChallenge(w, ErrorInvalidDPoPProof, err.Error())
+		return false
+	}
+
+	if claims.Cnf == nil || claims.Cnf.JKT != jkt {
+		a.dpopChallenge(w, ErrorInvalidToken, "token is not bound to the DPoP proof key")
+		return false
+	}
+
+	if a.options.DPoP.RequiresNonce() {
+		w.Header().Set("DPoP-Nonce", a.options.DPoP.NewNonce())
+	}
+	return true
+}
+
+// extractToken reads the token from the Authorization header, falling back to the cookie
+func (a *Authenticator) extractToken(r *http.Request) (token, scheme, errCode, description string) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, value, found := strings.Cut(header, " ")
+		value = strings.TrimSpace(value)
+		switch {
+		case found && value != "" && strings.EqualFold(scheme, "Bearer"):
+			return value, "Bearer", "", ""
+		case found && value != "" && strings.EqualFold(scheme, "DPoP") && a.options.DPoP != nil:
+			return value, "DPoP", "", ""
+		case a.options.DPoP != nil:
+			return "", "", ErrorInvalidRequest, "Authorization header must use the Bearer or DPoP scheme"
+		default:
+			return "", "", ErrorInvalidRequest, "Authorization header must use the Bearer scheme"
+		}
+	}
+
+	if a.options.CookieName != "" {
+		if cookie, err := r.Cookie(a.options.CookieName); err == nil && cookie.Value != "" {
+			return cookie.Value, "Cookie", "", ""
+		}
+	}
+
+	// No credentials at all: RFC 6750 says the challenge carries no error code
+	return "", "", "", ""
+}
+
+// challenge writes an RFC 6750 WWW-Authenticate error response, naming the
+// scope needed when one is given
+func (a *Authenticator) challenge(w http.ResponseWriter, status int, errCode, description, scope string) {
+	var extra []string
+	if scope != "" {
+		extra = append(extra, fmt.Sprintf(`scope="%s"`, sanitizeDescription(scope)))
+	}
+	a.writeChallenge(w, status, errCode, description, extra...)
+}
+
+// stepUpChallenge writes an RFC 9470 challenge telling the client to have the
+// user authenticate again, with acr_values or max_age saying how
+func (a *Authenticator) stepUpChallenge(w http.ResponseWriter, description, requirement string) {
+	a.writeChallenge(w, http.StatusUnauthorized, ErrorInsufficientUserAuthentication, description, requirement)
+}
+
+// writeChallenge writes the WWW-Authenticate header and JSON body of a Bearer
+// error response, appending any extra auth-params to the header
+func (a *Authenticator) writeChallenge(w http.ResponseWriter, status int, errCode, description string, extra ...string) {
+	params := []string{fmt.Sprintf("realm=%q", a.options.Realm)}
+	if errCode != "" {
+		params = append(params, fmt.Sprintf("error=%q", errCode))
+	}
+	if description != "" {
+		description = sanitizeDescription(description)
+		params = append(params, fmt.Sprintf(`error_description="%s"`, description))
+	}
+	params = append(params, extra...)
+	w.Header().Set("WWW-Authenticate", "Bearer "+strings.Join(params, ", "))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":             errCode,
+		"error_description": description,
+	})
+}
+
+// dpopChallenge writes an RFC 9449 DPoP error response, with a fresh nonce when
+// the client has to retry with one
+func (a *Authenticator) dpopChallenge(w http.ResponseWriter, errCode, description string) {
+	description = sanitizeDescription(description)
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`DPoP realm="%s", error="%s", error_description="%s", algs="%s"`,
+		a.options.Realm, errCode, description, strings.Join(services.DPoPAlgorithms, " ")))
+	if errCode == ErrorUseDPoPNonce {
+		w.Header().Set("DPoP-Nonce", a.options.DPoP.NewNonce())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":             errCode,
+		"error_description": description,
+	})
+}
+
+// sanitizeDescription drops characters RFC 6750 does not allow in error_description
+func sanitizeDescription(description string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return -1
+		}
+		return r
+	}, description)
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"context"
+	"jwt-auth-system/backend/domain"
+)
+
+// contextKey is unexported so no other package can collide with our context values
+type contextKey int
+
+const (
+	claimsKey contextKey = iota
+	proxiedKey
+)
+
+// WithClaims returns a copy of ctx carrying the validated token claims
+func WithClaims(ctx context.Context, claims *domain.Claims) context.Context {
+	return context.WithValue(ctx, claimsKey, claims)
+}
+
+// WithProxiedConnection returns a copy of ctx for a request relayed by a proxy,
+// such as a forward-auth subrequest, whose TLS connection is the proxy's and
+// so says nothing about the client's certificate
+func WithProxiedConnection(ctx context.Context) context.Context {
+	return context.WithValue(ctx, proxiedKey, true)
+}
+
+// isProxied reports whether WithProxiedConnection marked the request
+func isProxied(ctx context.Context) bool {
+	proxied, _ := ctx.Value(proxiedKey).(bool)
+	return proxied
+}
+
+// ClaimsFromContext returns the claims stored by Authenticate, if any
+func ClaimsFromContext(ctx context.Context) (*domain.Claims, bool) {
+	claims, ok := ctx.Value(claimsKey).(*domain.Claims)
+	return claims, ok && claims != nil
+}
+
+// UsernameFromContext returns the authenticated username, or "" if the request is unauthenticated
+func UsernameFromContext(ctx context.Context) string {
+	if claims, ok := ClaimsFromContext(ctx); ok {
+		return claims.Username
+	}
+	return ""
+}
+
+// RoleFromContext returns the authenticated user's role, or "" if the request is unauthenticated
+func RoleFromContext(ctx context.Context) string {
+	if claims, ok := ClaimsFromContext(ctx); ok {
+		return claims.Role
+	}
+	return ""
+}
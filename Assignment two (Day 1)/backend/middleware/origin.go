@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// originKey is the context key of the origin PublicOrigin or WithOrigin set
+type originKey struct{}
+
+// WithOrigin returns a copy of ctx in which requests are taken to have been
+// made to origin, a scheme and host such as https://auth.example.com
+func WithOrigin(ctx context.Context, origin string) context.Context {
+	return context.WithValue(ctx, originKey{}, origin)
+}
+
+// PublicOrigin returns a middleware that fixes the origin RequestURL rebuilds
+// request URLs with, which is what DPoP proofs are checked against. With a
+// base URL every request gets its scheme and host. Without one, trustProxy
+// takes them from X-Forwarded-Proto and X-Forwarded-Host, which only a proxy
+// that overwrites them may be trusted to set; otherwise the connection and
+// its Host header decide.
+func PublicOrigin(baseURL string, trustProxy bool) (func(http.Handler) http.Handler, error) {
+	var origin string
+	if baseURL != "" {
+		parsed, err := url.Parse(baseURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("public URL %q must be an absolute http or https URL", baseURL)
+		}
+		if strings.Trim(parsed.Path, "/") != "" || parsed.RawQuery != "" {
+			return nil, fmt.Errorf("public URL %q must not have a path or query", baseURL)
+		}
+		origin = parsed.Scheme + "://" + parsed.Host
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case origin != "":
+				r = r.WithContext(WithOrigin(r.Context(), origin))
+			case trustProxy:
+				r = r.WithContext(WithOrigin(r.Context(), ForwardedOrigin(r)))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// RequestOrigin returns the scheme and host a request was made to: the one
+// stored in its context, or else the connection's scheme and the Host header
+func RequestOrigin(r *http.Request) string {
+	if origin, ok := r.Context().Value(originKey{}).(string); ok {
+		return origin
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// RequestURL rebuilds the absolute URL of a request, without its query, as a
+// DPoP proof's htu claim names it
+func RequestURL(r *http.Request) string {
+	return RequestOrigin(r) + r.URL.EscapedPath()
+}
+
+// ForwardedOrigin is the origin a proxy reports in X-Forwarded-Proto and
+// X-Forwarded-Host, each falling back to what the connection says
+func ForwardedOrigin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := strings.ToLower(strings.TrimSpace(firstValue(r.Header.Get("X-Forwarded-Proto")))); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	host := r.Host
+	if forwarded := strings.TrimSpace(firstValue(r.Header.Get("X-Forwarded-Host"))); forwarded != "" {
+		host = forwarded
+	}
+	return scheme + "://" + host
+}
+
+// firstValue returns the first entry of a comma-separated header value, the
+// one the first proxy in the chain added
+func firstValue(value string) string {
+	first, _, _ := strings.Cut(value, ",")
+	return first
+}
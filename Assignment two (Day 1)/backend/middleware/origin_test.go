@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPublicOrigin(t *testing.T) {
+	tests := []struct {
+		name       string
+		baseURL    string
+		trustProxy bool
+		want       string
+	}{
+		{"forwarded headers ignored by default", "", false, "http://internal:8080/docs"},
+		{"forwarded headers trusted behind a proxy", "", true, "https://auth.example.com/docs"},
+		{"public URL wins over forwarded headers", "https://public.example.com/", true, "https://public.example.com/docs"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			publicOrigin, err := PublicOrigin(test.baseURL, test.trustProxy)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got string
+			handler := publicOrigin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = RequestURL(r)
+			}))
+			req := httptest.NewRequest(http.MethodGet, "http://internal:8080/docs?page=2", nil)
+			req.Header.Set("X-Forwarded-Proto", "https")
+			req.Header.Set("X-Forwarded-Host", "auth.example.com")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != test.want {
+				t.Errorf("RequestURL = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPublicOriginRejectsBadURLs(t *testing.T) {
+	for _, baseURL := range []string{"auth.example.com", "ftp://auth.example.com", "https://auth.example.com/api", "https://"} {
+		if _, err := PublicOrigin(baseURL, false); err == nil {
+			t.Errorf("PublicOrigin(%q) succeeded", baseURL)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package repo
+
+import (
+	"jwt-auth-system/backend/domain"
+	"sync"
+	"time"
+)
+
+// maxAuditEntries is how many audit entries are kept; older ones are dropped
+const maxAuditEntries = 10000
+
+// AuditLog keeps the most recent administrative changes in memory
+type AuditLog struct {
+	entries []domain.AuditEntry
+	mu      sync.RWMutex
+}
+
+// NewAuditLog creates a new audit log
+func NewAuditLog() *AuditLog {
+	return &AuditLog{}
+}
+
+// Record appends an entry, stamping it with the current time
+func (l *AuditLog) Record(entry domain.AuditEntry) domain.AuditEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry.Time = time.Now().UTC()
+	l.entries = append(l.entries, entry)
+	if len(l.entries) > maxAuditEntries {
+		l.entries = l.entries[len(l.entries)-maxAuditEntries:]
+	}
+	return entry
+}
+
+// Recent returns up to limit entries, newest first, optionally only those about target
+func (l *AuditLog) Recent(target string, limit int) []domain.AuditEntry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	entries := []domain.AuditEntry{}
+	for i := len(l.entries) - 1; i >= 0 && len(entries) < limit; i-- {
+		if target == "" || l.entries[i].Target == target {
+			entries = append(entries, l.entries[i])
+		}
+	}
+	return entries
+}
@@ -0,0 +1,46 @@
+package repo
+
+import (
+	"errors"
+	"jwt-auth-system/backend/domain"
+	"sync"
+)
+
+// ClientRepository handles OAuth client storage operations
+type ClientRepository struct {
+	clients map[string]*domain.Client
+	mu      sync.RWMutex
+}
+
+// NewClientRepository creates a new client repository instance
+func NewClientRepository() *ClientRepository {
+	return &ClientRepository{
+		clients: make(map[string]*domain.Client),
+	}
+}
+
+// RegisterClient stores a client in memory
+func (r *ClientRepository) RegisterClient(client *domain.Client) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.clients[client.ID]; exists {
+		return errors.New("client already exists")
+	}
+
+	r.clients[client.ID] = client
+	return nil
+}
+
+// GetClient retrieves a client by ID
+func (r *ClientRepository) GetClient(id string) (*domain.Client, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	client, exists := r.clients[id]
+	if !exists {
+		return nil, errors.New("client not found")
+	}
+
+	return client, nil
+}
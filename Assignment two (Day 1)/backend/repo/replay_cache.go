@@ -0,0 +1,44 @@
+package repo
+
+import (
+	"sync"
+	"time"
+)
+
+// ReplayCache remembers one-time identifiers, such as DPoP proof jtis, until they expire
+type ReplayCache struct {
+	seen      map[string]time.Time
+	lastClean time.Time
+	mu        sync.Mutex
+}
+
+// NewReplayCache creates a new replay cache instance
+func NewReplayCache() *ReplayCache {
+	return &ReplayCache{
+		seen:      make(map[string]time.Time),
+		lastClean: time.Now(),
+	}
+}
+
+// Remember records id until expiresAt and reports whether it was new. A false
+// result means the id has been used before and the request is a replay.
+func (r *ReplayCache) Remember(id string, expiresAt time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.lastClean) > time.Minute {
+		for seenID, expiry := range r.seen {
+			if now.After(expiry) {
+				delete(r.seen, seenID)
+			}
+		}
+		r.lastClean = now
+	}
+
+	if expiry, exists := r.seen[id]; exists && now.Before(expiry) {
+		return false
+	}
+	r.seen[id] = expiresAt
+	return true
+}
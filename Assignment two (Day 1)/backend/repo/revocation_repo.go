@@ -0,0 +1,49 @@
+package repo
+
+import (
+	"sync"
+	"time"
+)
+
+// RevocationRepository remembers revoked token IDs until the tokens would have expired anyway
+type RevocationRepository struct {
+	revoked map[string]time.Time
+	mu      sync.RWMutex
+}
+
+// NewRevocationRepository creates a new revocation repository instance
+func NewRevocationRepository() *RevocationRepository {
+	return &RevocationRepository{
+		revoked: make(map[string]time.Time),
+	}
+}
+
+// Revoke marks a token ID as revoked until expiresAt
+func (r *RevocationRepository) Revoke(tokenID string, expiresAt time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.revoked[tokenID] = expiresAt
+}
+
+// IsRevoked checks if a token ID has been revoked
+func (r *RevocationRepository) IsRevoked(tokenID string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, revoked := r.revoked[tokenID]
+	return revoked
+}
+
+// CleanExpired forgets revocations for tokens that have expired
+func (r *RevocationRepository) CleanExpired() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for tokenID, expiresAt := range r.revoked {
+		if now.After(expiresAt) {
+			delete(r.revoked, tokenID)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package repo
+
+import (
+	"errors"
+	"jwt-auth-system/backend/domain"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrServiceAccountNotFound is returned when no service account has the given ID
+	ErrServiceAccountNotFound = errors.New("service account not found")
+
+	// ErrAPIKeyNotFound is returned when a service account has no key with the given ID
+	ErrAPIKeyNotFound = errors.New("API key not found")
+)
+
+// ServiceAccountRepository handles storage of service accounts and their API keys
+type ServiceAccountRepository struct {
+	accounts map[string]*domain.ServiceAccount
+	keys     map[string]*domain.APIKey
+	mu       sync.RWMutex
+}
+
+// NewServiceAccountRepository creates a new service account repository instance
+func NewServiceAccountRepository() *ServiceAccountRepository {
+	return &ServiceAccountRepository{
+		accounts: make(map[string]*domain.ServiceAccount),
+		keys:     make(map[string]*domain.APIKey),
+	}
+}
+
+// CreateAccount stores a service account in memory
+func (r *ServiceAccountRepository) CreateAccount(account *domain.ServiceAccount) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.accounts[account.ID]; exists {
+		return errors.New("service account already exists")
+	}
+
+	r.accounts[account.ID] = account
+	return nil
+}
+
+// GetAccount retrieves a service account by ID
+func (r *ServiceAccountRepository) GetAccount(id string) (*domain.ServiceAccount, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	account, exists := r.accounts[id]
+	if !exists {
+		return nil, ErrServiceAccountNotFound
+	}
+
+	return account, nil
+}
+
+// ListAccounts returns every service account ordered by name
+func (r *ServiceAccountRepository) ListAccounts() []*domain.ServiceAccount {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	accounts := make([]*domain.ServiceAccount, 0, len(r.accounts))
+	for _, account := range r.accounts {
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].Name < accounts[j].Name
+	})
+	return accounts
+}
+
+// DeleteAccount removes a service account together with its keys
+func (r *ServiceAccountRepository) DeleteAccount(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.accounts[id]; !exists {
+		return ErrServiceAccountNotFound
+	}
+
+	delete(r.accounts, id)
+	for keyID, key := range r.keys {
+		if key.ServiceAccountID == id {
+			delete(r.keys, keyID)
+		}
+	}
+	return nil
+}
+
+// AddKey stores an API key for an existing service account
+func (r *ServiceAccountRepository) AddKey(key *domain.APIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.accounts[key.ServiceAccountID]; !exists {
+		return ErrServiceAccountNotFound
+	}
+	if _, exists := r.keys[key.ID]; exists {
+		return errors.New("API key already exists")
+	}
+
+	r.keys[key.ID] = key
+	return nil
+}
+
+// GetKey retrieves an API key by ID
+func (r *ServiceAccountRepository) GetKey(id string) (*domain.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	key, exists := r.keys[id]
+	if !exists {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	return key, nil
+}
+
+// ListKeys returns the keys of a service account, oldest first
+func (r *ServiceAccountRepository) ListKeys(accountID string) ([]*domain.APIKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, exists := r.accounts[accountID]; !exists {
+		return nil, ErrServiceAccountNotFound
+	}
+
+	keys := []*domain.APIKey{}
+	for _, key := range r.keys {
+		if key.ServiceAccountID == accountID {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+// UpdateKey applies update to a copy of the account's key and stores the copy
+func (r *ServiceAccountRepository) UpdateKey(accountID, keyID string, update func(key *domain.APIKey)) (*domain.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, exists := r.keys[keyID]
+	if !exists || key.ServiceAccountID != accountID {
+		return nil, ErrAPIKeyNotFound
+	}
+
+	// Replace rather than modify, so readers holding the old record are unaffected
+	updated := *key
+	update(&updated)
+	r.keys[keyID] = &updated
+	return &updated, nil
+}
+
+// TouchKey records that a key was just used
+func (r *ServiceAccountRepository) TouchKey(keyID string, usedAt time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if key, exists := r.keys[keyID]; exists {
+		updated := *key
+		updated.LastUsedAt = &usedAt
+		r.keys[keyID] = &updated
+	}
+}
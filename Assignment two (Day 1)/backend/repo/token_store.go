@@ -0,0 +1,72 @@
+package repo
+
+import (
+	"jwt-auth-system/backend/domain"
+	"sync"
+	"time"
+)
+
+// TokenStore keeps the claims of opaque tokens, keyed by a hash of the token so
+// a leaked store does not leak usable tokens
+type TokenStore struct {
+	tokens map[string]*domain.StoredToken
+	mu     sync.RWMutex
+}
+
+// NewTokenStore creates a new token store instance
+func NewTokenStore() *TokenStore {
+	return &TokenStore{
+		tokens: make(map[string]*domain.StoredToken),
+	}
+}
+
+// Save stores a token record under its hash
+func (r *TokenStore) Save(hash string, token *domain.StoredToken) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tokens[hash] = token
+}
+
+// Get returns a copy of the record stored under hash
+func (r *TokenStore) Get(hash string) (domain.StoredToken, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	token, exists := r.tokens[hash]
+	if !exists {
+		return domain.StoredToken{}, false
+	}
+	return *token, true
+}
+
+// Touch moves the idle expiry of a stored token forward
+func (r *TokenStore) Touch(hash string, idleExpiresAt time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if token, exists := r.tokens[hash]; exists {
+		token.IdleExpiresAt = idleExpiresAt
+	}
+}
+
+// Delete removes a token so it stops validating immediately
+func (r *TokenStore) Delete(hash string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.tokens, hash)
+}
+
+// CleanExpired removes tokens that can no longer validate
+func (r *TokenStore) CleanExpired(leeway time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for hash, token := range r.tokens {
+		if token.Expired(now, leeway) {
+			delete(r.tokens, hash)
+		}
+	}
+}
@@ -1,25 +1,53 @@
 package repo
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"jwt-auth-system/backend/domain"
+	"sort"
 	"sync"
 )
 
+var (
+	// ErrUserNotFound is returned when no user has the given username
+	ErrUserNotFound = errors.New("user not found")
+
+	// ErrRoleHeld is returned by WithoutHolders when users still hold the role
+	ErrRoleHeld = errors.New("role is still held by users")
+)
+
 // UserRepository handles user storage operations
 type UserRepository struct {
-	users map[string]*domain.User
-	mu    sync.RWMutex
+	users     map[string]*domain.User
+	roleCheck func(role string) error
+	mu        sync.RWMutex
+
+	// deleted keeps the last token version of every deleted username, so a user
+	// registered under the name again never matches the old user's tokens
+	deleted map[string]int
 }
 
 // NewUserRepository creates a new user repository instance
 func NewUserRepository() *UserRepository {
 	return &UserRepository{
-		users: make(map[string]*domain.User),
+		users:   make(map[string]*domain.User),
+		deleted: make(map[string]int),
 	}
 }
 
-// RegisterUser stores a user in memory
+// CheckRoles makes every write that gives a user a new role call check
+// first, under the repository's lock, so that together with WithoutHolders a
+// role cannot be assigned while it is being deleted
+func (r *UserRepository) CheckRoles(check func(role string) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.roleCheck = check
+}
+
+// RegisterUser stores a user in memory. A username that was deleted before
+// continues from the deleted user's token version.
 func (r *UserRepository) RegisterUser(user *domain.User) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -27,7 +55,15 @@ func (r *UserRepository) RegisterUser(user *domain.User) error {
 	if _, exists := r.users[user.Username]; exists {
 		return errors.New("user already exists")
 	}
+	if r.roleCheck != nil {
+		if err := r.roleCheck(user.Role); err != nil {
+			return err
+		}
+	}
 
+	if last, wasDeleted := r.deleted[user.Username]; wasDeleted && user.TokenVersion <= last {
+		user.TokenVersion = last + 1
+	}
 	r.users[user.Username] = user
 	return nil
 }
@@ -39,7 +75,7 @@ func (r *UserRepository) GetUser(username string) (*domain.User, error) {
 
 	user, exists := r.users[username]
 	if !exists {
-		return nil, errors.New("user not found")
+		return nil, ErrUserNotFound
 	}
 
 	return user, nil
@@ -54,15 +90,127 @@ func (r *UserRepository) UserExists(username string) bool {
 	return exists
 }
 
-// GetAllUsers returns all registered users (for debugging)
-func (r *UserRepository) GetAllUsers() map[string]*domain.User {
+// ListUsers returns one page of users ordered by username, keeping only those
+// with the given role, when set, and the given attribute values, along with the
+// total number of matching users
+func (r *UserRepository) ListUsers(role string, attributes map[string]string, offset, limit int) ([]*domain.User, int) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var matches []*domain.User
+	for _, user := range r.users {
+		if (role == "" || user.Role == role) && hasAttributes(user, attributes) {
+			matches = append(matches, user)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Username < matches[j].Username
+	})
+
+	total := len(matches)
+	if offset >= total {
+		return []*domain.User{}, total
+	}
+	return matches[offset:min(offset+limit, total)], total
+}
+
+// UpdateUser applies update to the stored user. A change to the role, the
+// disabled flag or the credentials bumps its token version, so tokens issued
+// before stop validating; other changes leave them valid. When update returns
+// an error the user is left unchanged.
+func (r *UserRepository) UpdateUser(username string, update func(user *domain.User) error) (*domain.User, error) {
+	return r.update(username, false, update)
+}
+
+// RevokeUserTokens bumps the user's token version, invalidating every token issued so far
+func (r *UserRepository) RevokeUserTokens(username string) (*domain.User, error) {
+	return r.update(username, true, func(*domain.User) error { return nil })
+}
+
+// update replaces the stored user with its updated copy, bumping the token
+// version when revoke is set or the change is security-relevant
+func (r *UserRepository) update(username string, revoke bool, update func(user *domain.User) error) (*domain.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, exists := r.users[username]
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+
+	// Replace rather than modify, so readers holding the old record are unaffected
+	updated := *user
+	if err := update(&updated); err != nil {
+		return nil, err
+	}
+	updated.Username = user.Username
+	updated.TokenVersion = user.TokenVersion
+	if r.roleCheck != nil && updated.Role != user.Role {
+		if err := r.roleCheck(updated.Role); err != nil {
+			return nil, err
+		}
+	}
+	if revoke || securityChanged(user, &updated) {
+		updated.TokenVersion++
+	}
+	r.users[username] = &updated
+	return &updated, nil
+}
+
+// WithoutHolders runs action, typically deleting role, if no user holds role.
+// No user can be given a role while it runs.
+func (r *UserRepository) WithoutHolders(role string, action func() error) error {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	// Return a copy to prevent external modification
-	usersCopy := make(map[string]*domain.User)
-	for k, v := range r.users {
-		usersCopy[k] = v
+	holders := 0
+	for _, user := range r.users {
+		if user.Role == role {
+			holders++
+		}
+	}
+	if holders > 0 {
+		return fmt.Errorf("%w: %d users hold role %q", ErrRoleHeld, holders, role)
+	}
+	return action()
+}
+
+// DeleteUser removes a user once check, when given, accepts the stored record
+func (r *UserRepository) DeleteUser(username string, check func(user *domain.User) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, exists := r.users[username]
+	if !exists {
+		return ErrUserNotFound
+	}
+	if check != nil {
+		if err := check(user); err != nil {
+			return err
+		}
+	}
+
+	delete(r.users, username)
+	r.deleted[username] = user.TokenVersion
+	return nil
+}
+
+// securityChanged reports whether a change must invalidate the user's tokens:
+// the role or disabled flag changed, or the password or authenticator app did
+func securityChanged(before, after *domain.User) bool {
+	return before.Role != after.Role ||
+		before.Disabled != after.Disabled ||
+		!bytes.Equal(before.PasswordHash, after.PasswordHash) ||
+		before.TOTPSecret != after.TOTPSecret
+}
+
+// hasAttributes reports whether the user's attributes, written as text, equal every wanted value
+func hasAttributes(user *domain.User, wanted map[string]string) bool {
+	for name, value := range wanted {
+		actual, ok := user.Attributes[name]
+		if !ok || fmt.Sprint(actual) != value {
+			return false
+		}
 	}
-	return usersCopy
+	return true
 }
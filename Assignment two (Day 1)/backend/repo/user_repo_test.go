@@ -0,0 +1,158 @@
+package repo
+
+import (
+	"errors"
+	"jwt-auth-system/backend/domain"
+	"testing"
+)
+
+func TestUpdateUserBumpsVersionOnlyForSecurityChanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		update func(user *domain.User)
+		bump   bool
+	}{
+		{"attribute", func(user *domain.User) { user.Attributes = domain.Attributes{"designation": "lead"} }, false},
+		{"nothing", func(user *domain.User) {}, false},
+		{"role", func(user *domain.User) { user.Role = "admin" }, true},
+		{"disabled", func(user *domain.User) { user.Disabled = true }, true},
+		{"password", func(user *domain.User) { user.PasswordHash = []byte("new-hash") }, true},
+		{"authenticator app", func(user *domain.User) { user.TOTPSecret = "" }, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			users := NewUserRepository()
+			users.RegisterUser(&domain.User{
+				Username:     "alice",
+				Role:         "user",
+				Attributes:   domain.Attributes{"designation": "dev"},
+				PasswordHash: []byte("hash"),
+				TOTPSecret:   "SECRET",
+				TokenVersion: 3,
+			})
+
+			user, err := users.UpdateUser("alice", func(user *domain.User) error {
+				test.update(user)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := 3
+			if test.bump {
+				want = 4
+			}
+			if user.TokenVersion != want {
+				t.Errorf("token version = %d, want %d", user.TokenVersion, want)
+			}
+		})
+	}
+}
+
+func TestUpdateUserKeepsVersionItself(t *testing.T) {
+	users := NewUserRepository()
+	users.RegisterUser(&domain.User{Username: "alice", Role: "user", TokenVersion: 3})
+
+	// An update cannot move the version back to revive old tokens
+	user, err := users.UpdateUser("alice", func(user *domain.User) error {
+		user.TokenVersion = 0
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.TokenVersion != 3 {
+		t.Errorf("token version = %d, want 3", user.TokenVersion)
+	}
+
+	if user, _ = users.RevokeUserTokens("alice"); user.TokenVersion != 4 {
+		t.Errorf("after RevokeUserTokens token version = %d, want 4", user.TokenVersion)
+	}
+}
+
+func TestDeletedUsernameKeepsVersion(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested int
+		want      int
+	}{
+		{"new user", 0, 4},
+		{"version of the deleted user", 3, 4},
+		{"later version", 7, 7},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			users := NewUserRepository()
+			users.RegisterUser(&domain.User{Username: "alice", Role: "user", TokenVersion: 3})
+			if err := users.DeleteUser("alice", nil); err != nil {
+				t.Fatal(err)
+			}
+
+			user := &domain.User{Username: "alice", Role: "user", TokenVersion: test.requested}
+			if err := users.RegisterUser(user); err != nil {
+				t.Fatal(err)
+			}
+			if user.TokenVersion != test.want {
+				t.Errorf("token version = %d, want %d", user.TokenVersion, test.want)
+			}
+
+			// Other usernames start from zero
+			bob := &domain.User{Username: "bob", Role: "user"}
+			users.RegisterUser(bob)
+			if bob.TokenVersion != 0 {
+				t.Errorf("bob's token version = %d, want 0", bob.TokenVersion)
+			}
+		})
+	}
+}
+
+func TestRoleCannotBeAssignedWhileDeleted(t *testing.T) {
+	roles := map[string]bool{"user": true, "viewer": true}
+	users := NewUserRepository()
+	users.CheckRoles(func(role string) error {
+		if !roles[role] {
+			return errors.New("unknown role " + role)
+		}
+		return nil
+	})
+	users.RegisterUser(&domain.User{Username: "alice", Role: "viewer"})
+
+	deleteViewer := func() error {
+		delete(roles, "viewer")
+		return nil
+	}
+	if err := users.WithoutHolders("viewer", deleteViewer); !errors.Is(err, ErrRoleHeld) {
+		t.Fatalf("WithoutHolders with a holder = %v, want %v", err, ErrRoleHeld)
+	}
+	if !roles["viewer"] {
+		t.Fatal("role was deleted while held")
+	}
+
+	if _, err := users.UpdateUser("alice", func(user *domain.User) error {
+		user.Role = "user"
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.WithoutHolders("viewer", deleteViewer); err != nil {
+		t.Fatalf("WithoutHolders without holders = %v", err)
+	}
+
+	if _, err := users.UpdateUser("alice", func(user *domain.User) error {
+		user.Role = "viewer"
+		return nil
+	}); err == nil {
+		t.Error("assigned a deleted role")
+	}
+	if err := users.RegisterUser(&domain.User{Username: "bob", Role: "viewer"}); err == nil {
+		t.Error("registered a user with a deleted role")
+	}
+
+	// Other changes to a user whose role is current are not re-checked
+	if _, err := users.UpdateUser("alice", func(user *domain.User) error {
+		user.Disabled = true
+		return nil
+	}); err != nil {
+		t.Errorf("update without a role change: %v", err)
+	}
+}
@@ -0,0 +1,270 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"jwt-auth-system/backend/domain"
+	"math"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Attribute types an attribute schema may declare
+const (
+	AttributeString  = "string"
+	AttributeInteger = "integer"
+	AttributeNumber  = "number"
+	AttributeBoolean = "boolean"
+)
+
+// attributeNamePattern restricts attribute names to lowercase identifiers
+var attributeNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// reservedAttributeNames are claim and field names an attribute would collide with
+var reservedAttributeNames = []string{
+	"username", "role", "scope", "client_id", "act", "cnf", "ver", "disabled", "token_version",
+	"iss", "sub", "aud", "exp", "nbf", "iat", "jti", "active", "token_type", "amr", "acr", "auth_time",
+	"password", "otp",
+}
+
+// AttributeSchema declares the user attributes: their types and constraints,
+// which are required, which only admins may change and which stay out of
+// tokens. The file uses JSON Schema's object keywords, for example
+// {"properties": {"department": {"type": "string", "enum": ["eng", "sales"]}}, "required": ["department"]}
+type AttributeSchema struct {
+	Properties map[string]*AttributeDefinition `json:"properties"`
+	Required   []string                        `json:"required,omitempty"`
+}
+
+// AttributeDefinition declares one attribute with a subset of the JSON Schema validation keywords
+type AttributeDefinition struct {
+	Type        string        `json:"type"`
+	Description string        `json:"description,omitempty"`
+	Enum        []interface{} `json:"enum,omitempty"`
+	MinLength   *int          `json:"minLength,omitempty"`
+	MaxLength   *int          `json:"maxLength,omitempty"`
+	Pattern     string        `json:"pattern,omitempty"`
+	Minimum     *float64      `json:"minimum,omitempty"`
+	Maximum     *float64      `json:"maximum,omitempty"`
+
+	// ReadOnly attributes can only be changed by admins, not by users editing their own record
+	ReadOnly bool `json:"readOnly,omitempty"`
+
+	// Sensitive attributes are stored and validated but never put into tokens
+	Sensitive bool `json:"sensitive,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// DefaultAttributeSchema returns the schema used when no schema file is
+// configured: the designation and age every user has always had
+func DefaultAttributeSchema() *AttributeSchema {
+	one, hundred := 1, 100
+	minAge, maxAge := 1.0, 150.0
+	return &AttributeSchema{
+		Properties: map[string]*AttributeDefinition{
+			"designation": {Type: AttributeString, MinLength: &one, MaxLength: &hundred},
+			"age":         {Type: AttributeInteger, Minimum: &minAge, Maximum: &maxAge},
+		},
+		Required: []string{"designation", "age"},
+	}
+}
+
+// LoadAttributeSchema reads and checks an attribute schema file
+func LoadAttributeSchema(path string) (*AttributeSchema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading attribute schema: %w", err)
+	}
+
+	schema := &AttributeSchema{}
+	if err := json.Unmarshal(data, schema); err != nil {
+		return nil, fmt.Errorf("parsing attribute schema %s: %w", path, err)
+	}
+	if err := schema.compile(); err != nil {
+		return nil, fmt.Errorf("attribute schema %s: %w", path, err)
+	}
+	return schema, nil
+}
+
+// compile checks the definitions and prepares their patterns
+func (s *AttributeSchema) compile() error {
+	for name, definition := range s.Properties {
+		if !attributeNamePattern.MatchString(name) {
+			return fmt.Errorf("attribute name %q must be lowercase letters, digits or _", name)
+		}
+		if slices.Contains(reservedAttributeNames, name) {
+			return fmt.Errorf("attribute name %q is reserved", name)
+		}
+		if definition == nil {
+			return fmt.Errorf("attribute %s has no definition", name)
+		}
+		switch definition.Type {
+		case AttributeString, AttributeInteger, AttributeNumber, AttributeBoolean:
+		default:
+			return fmt.Errorf("attribute %s has unsupported type %q", name, definition.Type)
+		}
+		if definition.Pattern != "" {
+			pattern, err := regexp.Compile(definition.Pattern)
+			if err != nil {
+				return fmt.Errorf("attribute %s: invalid pattern: %w", name, err)
+			}
+			definition.pattern = pattern
+		}
+	}
+	for _, name := range s.Required {
+		if s.Properties[name] == nil {
+			return fmt.Errorf("required attribute %s is not defined", name)
+		}
+	}
+	return nil
+}
+
+// Has reports whether the schema declares the attribute
+func (s *AttributeSchema) Has(name string) bool {
+	return s.Properties[name] != nil
+}
+
+// Validate checks a complete set of attributes, as given at registration, and
+// returns them normalised: strings trimmed and integers stored as int
+func (s *AttributeSchema) Validate(attributes domain.Attributes) (domain.Attributes, error) {
+	normalised := make(domain.Attributes, len(attributes))
+	for name, value := range attributes {
+		definition := s.Properties[name]
+		if definition == nil {
+			return nil, fmt.Errorf("%w: unknown attribute %s", ErrInvalidAttribute, name)
+		}
+		checked, err := definition.check(name, value)
+		if err != nil {
+			return nil, err
+		}
+		normalised[name] = checked
+	}
+
+	for _, name := range s.Required {
+		if _, ok := normalised[name]; !ok {
+			return nil, fmt.Errorf("%w: %s is required", ErrInvalidAttribute, name)
+		}
+	}
+	return normalised, nil
+}
+
+// Apply merges attribute changes into current and validates the result. A nil
+// value removes the attribute. With selfService, read-only attributes cannot change.
+func (s *AttributeSchema) Apply(current, changes domain.Attributes, selfService bool) (domain.Attributes, error) {
+	merged := current.Clone()
+	if merged == nil {
+		merged = make(domain.Attributes)
+	}
+	for name, value := range changes {
+		if definition := s.Properties[name]; selfService && definition != nil && definition.ReadOnly {
+			return nil, fmt.Errorf("%w: %s", ErrReadOnlyAttribute, name)
+		}
+		if value == nil {
+			delete(merged, name)
+		} else {
+			merged[name] = value
+		}
+	}
+	return s.Validate(merged)
+}
+
+// TokenAttributes returns the attributes that go into tokens: every declared
+// attribute that is not sensitive
+func (s *AttributeSchema) TokenAttributes(attributes domain.Attributes) domain.Attributes {
+	projected := make(domain.Attributes)
+	for name, value := range attributes {
+		if definition := s.Properties[name]; definition != nil && !definition.Sensitive {
+			projected[name] = value
+		}
+	}
+	return projected
+}
+
+// Names returns the declared attribute names in order
+func (s *AttributeSchema) Names() []string {
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// check validates one value against the definition and returns it normalised
+func (d *AttributeDefinition) check(name string, value interface{}) (interface{}, error) {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %s %s", ErrInvalidAttribute, name, fmt.Sprintf(format, args...))
+	}
+
+	switch d.Type {
+	case AttributeString:
+		text, ok := value.(string)
+		if !ok {
+			return nil, invalid("must be a string")
+		}
+		text = strings.TrimSpace(text)
+		length := utf8.RuneCountInString(text)
+		if d.MinLength != nil && length < *d.MinLength {
+			return nil, invalid("must be at least %d characters", *d.MinLength)
+		}
+		if d.MaxLength != nil && length > *d.MaxLength {
+			return nil, invalid("must be at most %d characters", *d.MaxLength)
+		}
+		if d.pattern != nil && !d.pattern.MatchString(text) {
+			return nil, invalid("must match %s", d.Pattern)
+		}
+		value = text
+
+	case AttributeInteger, AttributeNumber:
+		number, ok := toFloat(value)
+		if !ok {
+			return nil, invalid("must be a number")
+		}
+		if d.Minimum != nil && number < *d.Minimum {
+			return nil, invalid("must be at least %g", *d.Minimum)
+		}
+		if d.Maximum != nil && number > *d.Maximum {
+			return nil, invalid("must be at most %g", *d.Maximum)
+		}
+		value = number
+		if d.Type == AttributeInteger {
+			if number != math.Trunc(number) || math.Abs(number) > 1<<53 {
+				return nil, invalid("must be a whole number")
+			}
+			value = int(number)
+		}
+
+	case AttributeBoolean:
+		if _, ok := value.(bool); !ok {
+			return nil, invalid("must be true or false")
+		}
+	}
+
+	if len(d.Enum) > 0 && !slices.ContainsFunc(d.Enum, func(allowed interface{}) bool {
+		return fmt.Sprint(allowed) == fmt.Sprint(value)
+	}) {
+		return nil, invalid("must be one of %v", d.Enum)
+	}
+	return value, nil
+}
+
+// toFloat reads a JSON number, whichever Go type it was decoded or stored as
+func toFloat(value interface{}) (float64, bool) {
+	switch number := value.(type) {
+	case float64:
+		return number, true
+	case int:
+		return float64(number), true
+	case int64:
+		return float64(number), true
+	case json.Number:
+		parsed, err := number.Float64()
+		return parsed, err == nil
+	}
+	return 0, false
+}
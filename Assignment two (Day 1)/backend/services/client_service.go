@@ -0,0 +1,124 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/repo"
+	"net/url"
+	"slices"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidClient is returned when client authentication fails
+var ErrInvalidClient = errors.New("invalid client credentials")
+
+// ClientService handles registration and authentication of OAuth clients
+type ClientService struct {
+	clientRepo *repo.ClientRepository
+}
+
+// NewClientService creates a new client service instance
+func NewClientService(clientRepo *repo.ClientRepository) *ClientService {
+	return &ClientService{
+		clientRepo: clientRepo,
+	}
+}
+
+// CreateClient registers a client and returns its secret, which is stored only
+// as a hash. tls_client_auth clients authenticate by certificate and get no secret.
+func (s *ClientService) CreateClient(req domain.CreateClientRequest) (*domain.Client, string, error) {
+	client := &domain.Client{
+		ID:          "client_" + randomToken(12),
+		Name:        req.Name,
+		TokenFormat: req.TokenFormat,
+		AuthMethod:  req.AuthMethod,
+		TLSAuth:     req.TLSAuth,
+		CreatedAt:   time.Now(),
+	}
+
+	secret := ""
+	if client.AuthMethod != domain.AuthMethodTLSClientAuth {
+		secret = randomToken(32)
+		hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, "", err
+		}
+		client.SecretHash = hash
+	}
+	if err := s.clientRepo.RegisterClient(client); err != nil {
+		return nil, "", err
+	}
+
+	fmt.Printf("Client Registered: %s (%s)\n", client.ID, client.Name)
+	fmt.Println("---")
+
+	return client, secret, nil
+}
+
+// GetClient returns a registered client
+func (s *ClientService) GetClient(id string) (*domain.Client, error) {
+	return s.clientRepo.GetClient(id)
+}
+
+// Authenticate checks a client ID and secret
+func (s *ClientService) Authenticate(id, secret string) (*domain.Client, error) {
+	client, err := s.clientRepo.GetClient(id)
+	if err != nil {
+		// Compare against a dummy hash anyway so unknown IDs take as long as wrong secrets
+		bcrypt.CompareHashAndPassword(dummySecretHash, []byte(secret))
+		return nil, ErrInvalidClient
+	}
+
+	if client.SecretHash == nil {
+		bcrypt.CompareHashAndPassword(dummySecretHash, []byte(secret))
+		return nil, ErrInvalidClient
+	}
+	if err := bcrypt.CompareHashAndPassword(client.SecretHash, []byte(secret)); err != nil {
+		return nil, ErrInvalidClient
+	}
+	return client, nil
+}
+
+// AuthenticateCertificate checks that a verified TLS client certificate belongs
+// to a tls_client_auth client (RFC 8705 section 2.1)
+func (s *ClientService) AuthenticateCertificate(id string, cert *x509.Certificate) (*domain.Client, error) {
+	client, err := s.clientRepo.GetClient(id)
+	if err != nil || client.AuthMethod != domain.AuthMethodTLSClientAuth || cert == nil {
+		return nil, ErrInvalidClient
+	}
+
+	tlsAuth := client.TLSAuth
+	matched := false
+	switch {
+	case tlsAuth.SubjectDN != "":
+		matched = cert.Subject.String() == tlsAuth.SubjectDN
+	case tlsAuth.SANDNS != "":
+		matched = slices.Contains(cert.DNSNames, tlsAuth.SANDNS)
+	case tlsAuth.SANURI != "":
+		matched = slices.ContainsFunc(cert.URIs, func(uri *url.URL) bool {
+			return uri.String() == tlsAuth.SANURI
+		})
+	}
+	if !matched {
+		return nil, ErrInvalidClient
+	}
+	return client, nil
+}
+
+// dummySecretHash is a bcrypt hash of a random value used to equalise timing
+var dummySecretHash, _ = bcrypt.GenerateFromPassword([]byte(randomToken(16)), bcrypt.DefaultCost)
+
+// randomToken returns n random bytes encoded as unpadded base64url
+func randomToken(n int) string {
+	bytes := make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"jwt-auth-system/backend/domain"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Authentication methods recorded in the amr claim (RFC 8176)
+const (
+	AMRPassword = "pwd"
+	AMROTP      = "otp"
+)
+
+// Authentication context classes recorded in the acr claim, weakest first
+const (
+	ACRNone         = "none"
+	ACRSingleFactor = "sfa"
+	ACRMultiFactor  = "mfa"
+)
+
+// acrLevels orders the acr values so a stronger level satisfies a weaker requirement
+var acrLevels = []string{ACRNone, ACRSingleFactor, ACRMultiFactor}
+
+// MinPasswordLength is the shortest password a user may set
+const MinPasswordLength = 8
+
+// TOTP parameters (RFC 6238): 6 digits from HMAC-SHA1 over 30-second steps,
+// accepting one step of clock drift either way
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	totpSkew   = 1
+)
+
+// ErrInvalidCredentials is returned when a password or one-time password is wrong or missing
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// SatisfiesACR reports whether a token's acr meets the required level. Levels
+// this server does not know only satisfy themselves.
+func SatisfiesACR(acr, required string) bool {
+	if acr == "" {
+		acr = ACRNone
+	}
+	have, want := slices.Index(acrLevels, acr), slices.Index(acrLevels, required)
+	if have < 0 || want < 0 {
+		return acr == required
+	}
+	return have >= want
+}
+
+// ACRFor returns the acr that a set of authentication methods adds up to
+func ACRFor(methods []string) string {
+	switch {
+	case len(methods) >= 2:
+		return ACRMultiFactor
+	case len(methods) == 1:
+		return ACRSingleFactor
+	}
+	return ACRNone
+}
+
+// HashPassword checks the password policy and returns a bcrypt hash of the password
+func HashPassword(password string) ([]byte, error) {
+	if len(password) < MinPasswordLength {
+		return nil, fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+// Credentials checks the passwords and one-time passwords users present when
+// they ask for a token, and records how they authenticated
+type Credentials struct {
+	issuer string
+
+	// lastSteps remembers each user's last accepted TOTP step so a code cannot be replayed
+	lastSteps map[string]int64
+	mu        sync.Mutex
+}
+
+// NewCredentials creates a credential checker; issuer labels enrollments in authenticator apps
+func NewCredentials(issuer string) *Credentials {
+	return &Credentials{
+		issuer:    issuer,
+		lastSteps: make(map[string]int64),
+	}
+}
+
+// Authenticate checks the credentials sent for user. A user with a password
+// must send it; an OTP is optional, but checked when sent. The result records
+// the methods that succeeded, at the current time.
+func (c *Credentials) Authenticate(user *domain.User, password, otp string) (*domain.Authentication, error) {
+	methods := []string{}
+
+	if user.PasswordHash != nil {
+		if password == "" || bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password)) != nil {
+			return nil, fmt.Errorf("%w: wrong or missing password", ErrInvalidCredentials)
+		}
+		methods = append(methods, AMRPassword)
+	} else if password != "" {
+		return nil, fmt.Errorf("%w: no password is set for %s", ErrInvalidCredentials, user.Username)
+	}
+
+	if otp != "" {
+		if user.TOTPSecret == "" {
+			return nil, fmt.Errorf("%w: %s has no authenticator app enrolled", ErrInvalidCredentials, user.Username)
+		}
+		if !c.verifyTOTP(user.Username, user.TOTPSecret, otp, time.Now()) {
+			return nil, fmt.Errorf("%w: wrong or reused one-time password", ErrInvalidCredentials)
+		}
+		methods = append(methods, AMROTP)
+	}
+
+	return &domain.Authentication{
+		Methods: methods,
+		Level:   ACRFor(methods),
+		Time:    time.Now(),
+	}, nil
+}
+
+// NewTOTPEnrollment creates a TOTP secret for user and the otpauth:// URI that
+// authenticator apps read from a QR code
+func (c *Credentials) NewTOTPEnrollment(username string) domain.OTPEnrollment {
+	secretBytes := make([]byte, 20)
+	if _, err := rand.Read(secretBytes); err != nil {
+		panic(err)
+	}
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secretBytes)
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", c.issuer)
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + c.issuer + ":" + username,
+		RawQuery: query.Encode(),
+	}
+	return domain.OTPEnrollment{Secret: secret, OTPAuthURI: uri.String()}
+}
+
+// verifyTOTP checks a code against the steps around now, refusing steps at or
+// before the last one the user already used
+func (c *Credentials) verifyTOTP(username, secret, code string, now time.Time) bool {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= c.lastSteps[username] {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			c.lastSteps[username] = step
+			return true
+		}
+	}
+	return false
+}
+
+// totpCode computes the HOTP value (RFC 4226) for a counter
+func totpCode(key []byte, counter int64) string {
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulus := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		modulus *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%modulus)
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/repo"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DPoPProofType is the typ header every DPoP proof must carry
+const DPoPProofType = "dpop+jwt"
+
+// DPoPAlgorithms are the proof signing algorithms the verifier accepts
+var DPoPAlgorithms = []string{AlgES256, AlgPS256, AlgRS256, AlgEdDSA}
+
+const (
+	// dpopProofLifetime is how far a proof's iat may be from now
+	dpopProofLifetime = time.Minute
+
+	// dpopNonceLifetime is how long a server-provided nonce stays usable
+	dpopNonceLifetime = 5 * time.Minute
+)
+
+// DPoPClaims are the claims of an RFC 9449 DPoP proof; jti and iat come from RegisteredClaims
+type DPoPClaims struct {
+	Method string `json:"htm"`
+	URI    string `json:"htu"`
+	ATH    string `json:"ath,omitempty"`
+	Nonce  string `json:"nonce,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// DPoPVerifier checks DPoP proofs (RFC 9449) and hands out server nonces
+type DPoPVerifier struct {
+	replays      *repo.ReplayCache
+	nonceKey     []byte
+	requireNonce bool
+	leeway       time.Duration
+}
+
+// NewDPoPVerifier creates a new DPoP verifier. With requireNonce, every proof
+// must echo a nonce issued by NewNonce.
+func NewDPoPVerifier(requireNonce bool, leeway time.Duration) *DPoPVerifier {
+	nonceKey := make([]byte, 32)
+	if _, err := rand.Read(nonceKey); err != nil {
+		panic(err)
+	}
+	return &DPoPVerifier{
+		replays:      repo.NewReplayCache(),
+		nonceKey:     nonceKey,
+		requireNonce: requireNonce,
+		leeway:       leeway,
+	}
+}
+
+// NewNonce returns a fresh server nonce. Nonces are MACed timestamps, so no
+// state is kept for them.
+func (v *DPoPVerifier) NewNonce() string {
+	issued := make([]byte, 8)
+	binary.BigEndian.PutUint64(issued, uint64(time.Now().Unix()))
+	return base64.RawURLEncoding.EncodeToString(append(issued, v.nonceMAC(issued)...))
+}
+
+// RequiresNonce reports whether proofs must carry a server nonce
+func (v *DPoPVerifier) RequiresNonce() bool {
+	return v.requireNonce
+}
+
+// VerifyProof checks a DPoP proof for a request and returns the thumbprint of
+// the proof key. accessToken is the token sent alongside the proof, or "" when
+// the proof accompanies a token request.
+func (v *DPoPVerifier) VerifyProof(proof, method, requestURL, accessToken string) (string, error) {
+	claims := &DPoPClaims{}
+	var jkt string
+	_, err := jwt.ParseWithClaims(proof, claims, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ != DPoPProofType {
+			return nil, fmt.Errorf("typ must be %s", DPoPProofType)
+		}
+
+		key, thumbprint, err := dpopKey(token)
+		if err != nil {
+			return nil, err
+		}
+		jkt = thumbprint
+		return key.PublicKey, nil
+	}, jwt.WithValidMethods(DPoPAlgorithms), jwt.WithoutClaimsValidation())
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidDPoPProof, err)
+	}
+
+	if claims.ID == "" {
+		return "", fmt.Errorf("%w: missing jti", ErrInvalidDPoPProof)
+	}
+	if claims.Method != method {
+		return "", fmt.Errorf("%w: htm %q does not match %s", ErrInvalidDPoPProof, claims.Method, method)
+	}
+	if !sameDPoPURI(claims.URI, requestURL) {
+		return "", fmt.Errorf("%w: htu %q does not match the request", ErrInvalidDPoPProof, claims.URI)
+	}
+
+	window := dpopProofLifetime + v.leeway
+	if claims.IssuedAt == nil {
+		return "", fmt.Errorf("%w: missing iat", ErrInvalidDPoPProof)
+	}
+	issuedAt := claims.IssuedAt.Time
+	if time.Since(issuedAt) > window || time.Until(issuedAt) > window {
+		return "", fmt.Errorf("%w: iat is outside the accepted window", ErrInvalidDPoPProof)
+	}
+
+	if v.requireNonce && !v.validNonce(claims.Nonce) {
+		return "", ErrUseDPoPNonce
+	}
+
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		if claims.ATH != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			return "", fmt.Errorf("%w: ath does not match the access token", ErrInvalidDPoPProof)
+		}
+	}
+
+	// A proof is good for one request only
+	if !v.replays.Remember(jkt+"."+claims.ID, issuedAt.Add(window)) {
+		return "", fmt.Errorf("%w: proof has been used before", ErrInvalidDPoPProof)
+	}
+
+	return jkt, nil
+}
+
+// dpopKey reads the public key from the proof's jwk header and returns it with its thumbprint
+func dpopKey(token *jwt.Token) (*SigningKey, string, error) {
+	raw, ok := token.Header["jwk"].(map[string]interface{})
+	if !ok {
+		return nil, "", fmt.Errorf("missing jwk header")
+	}
+	if _, private := raw["d"]; private {
+		return nil, "", fmt.Errorf("jwk header contains a private key")
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, "", err
+	}
+	var jwk domain.JWK
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, "", fmt.Errorf("invalid jwk header: %w", err)
+	}
+
+	// The key is only good for the algorithm the proof was signed with
+	jwk.Alg = token.Method.Alg()
+	jwk.Kid = ""
+	key, err := VerificationKeyFromJWK(jwk)
+	if err != nil {
+		return nil, "", err
+	}
+	return key, key.KeyID, nil
+}
+
+// validNonce checks the MAC and age of a nonce issued by NewNonce
+func (v *DPoPVerifier) validNonce(nonce string) bool {
+	data, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(data) <= 8 {
+		return false
+	}
+	issued, mac := data[:8], data[8:]
+	if !hmac.Equal(mac, v.nonceMAC(issued)) {
+		return false
+	}
+
+	age := time.Since(time.Unix(int64(binary.BigEndian.Uint64(issued)), 0))
+	return age >= -v.leeway && age <= dpopNonceLifetime
+}
+
+// nonceMAC authenticates a nonce timestamp
+func (v *DPoPVerifier) nonceMAC(issued []byte) []byte {
+	mac := hmac.New(sha256.New, v.nonceKey)
+	mac.Write(issued)
+	return mac.Sum(nil)[:16]
+}
+
+// sameDPoPURI compares htu with the request URL, ignoring query, fragment and
+// the case of scheme and host as RFC 9449 section 4.3 requires
+func sameDPoPURI(htu, requestURL string) bool {
+	normalise := func(raw string) string {
+		parsed, err := url.Parse(raw)
+		if err != nil || parsed.Host == "" {
+			return ""
+		}
+		return strings.ToLower(parsed.Scheme) + "://" + strings.ToLower(parsed.Host) + parsed.EscapedPath()
+	}
+
+	expected := normalise(requestURL)
+	return expected != "" && normalise(htu) == expected
+}
@@ -0,0 +1,92 @@
+package services
+
+import "errors"
+
+var (
+	// ErrMalformedToken is returned when a token cannot be parsed or its signature does not verify
+	ErrMalformedToken = errors.New("token is malformed or its signature is invalid")
+
+	// ErrTokenExpired is returned when the exp claim is in the past (allowing for leeway)
+	ErrTokenExpired = errors.New("token expired")
+
+	// ErrNotYetValid is returned when the nbf claim is in the future (allowing for leeway)
+	ErrNotYetValid = errors.New("token is not valid yet")
+
+	// ErrMissingClaim is returned when a required registered claim is absent
+	ErrMissingClaim = errors.New("token is missing a required claim")
+
+	// ErrWrongIssuer is returned when the iss claim does not match the configured issuer
+	ErrWrongIssuer = errors.New("token has the wrong issuer")
+
+	// ErrWrongAudience is returned when the aud claim names none of the accepted audiences
+	ErrWrongAudience = errors.New("token has the wrong audience")
+
+	// ErrWrongSubject is returned when the sub claim is missing or does not match the username
+	ErrWrongSubject = errors.New("token has the wrong subject")
+
+	// ErrTokenRevoked is returned when the token's jti has been revoked
+	ErrTokenRevoked = errors.New("token has been revoked")
+
+	// ErrStaleToken is returned when the user's account changed after the token was issued
+	ErrStaleToken = errors.New("token was issued before the user's account changed")
+
+	// ErrAccountDisabled is returned when the token's user is disabled or no longer exists,
+	// or its service account was deleted
+	ErrAccountDisabled = errors.New("user account is disabled or no longer exists")
+
+	// ErrRotationUnavailable is returned when rotating a keyring whose keys come from a
+	// PEM file or shared secret, where the new key could not be persisted
+	ErrRotationUnavailable = errors.New("signing keys from a PEM file or shared secret cannot be rotated; use the keystore")
+
+	// ErrInvalidAttribute is returned when user attributes do not satisfy the attribute schema
+	ErrInvalidAttribute = errors.New("invalid attribute")
+
+	// ErrReadOnlyAttribute is returned when users try to change a read-only attribute of their own
+	ErrReadOnlyAttribute = errors.New("attribute can only be changed by an administrator")
+
+	// ErrUnknownRole is returned when a role is not in the role catalog
+	ErrUnknownRole = errors.New("role is not in the role catalog")
+
+	// ErrInvalidRole is returned when a role name or its permissions are malformed
+	ErrInvalidRole = errors.New("invalid role")
+
+	// ErrRoleExists is returned when creating a role that is already in the catalog
+	ErrRoleExists = errors.New("role already exists")
+
+	// ErrRoleInUse is returned when deleting the default role or a role users still hold
+	ErrRoleInUse = errors.New("role is in use")
+
+	// ErrUnknownAudience is returned when a token is requested for an audience that is not configured
+	ErrUnknownAudience = errors.New("unknown audience")
+
+	// ErrExchangeDenied is returned when no exchange policy rule allows a token exchange
+	ErrExchangeDenied = errors.New("token exchange not permitted by policy")
+
+	// ErrInvalidDPoPProof is returned when a DPoP proof is missing, malformed, replayed or does not match the request
+	ErrInvalidDPoPProof = errors.New("invalid DPoP proof")
+
+	// ErrUseDPoPNonce is returned when a DPoP proof lacks a current server-provided nonce
+	ErrUseDPoPNonce = errors.New("DPoP proof must use the server-provided nonce")
+
+	// ErrCertificateMismatch is returned when a certificate-bound token is presented without its certificate
+	ErrCertificateMismatch = errors.New("token is bound to a different client certificate")
+
+	// ErrCertificateUnavailable is returned when a certificate-bound token reaches
+	// the server through a proxy, which does not pass on the client's certificate
+	ErrCertificateUnavailable = errors.New("certificate-bound tokens cannot be checked through forward auth; present the token to this server directly")
+
+	// ErrInvalidScope is returned when a requested scope exceeds what may be granted
+	ErrInvalidScope = errors.New("requested scope is not allowed")
+
+	// ErrInvalidFilter is returned when a SCIM filter cannot be parsed
+	ErrInvalidFilter = errors.New("invalid SCIM filter")
+
+	// ErrInvalidPath is returned when a SCIM PATCH path cannot be parsed
+	ErrInvalidPath = errors.New("invalid SCIM path")
+
+	// ErrInvalidPatch is returned when a SCIM PATCH operation or its value is malformed
+	ErrInvalidPatch = errors.New("invalid SCIM patch operation")
+
+	// ErrNoTarget is returned when the value filter of a SCIM PATCH path matches nothing
+	ErrNoTarget = errors.New("SCIM path matches no value")
+)
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// ExchangeRule allows one kind of token exchange. Empty match lists match anything.
+type ExchangeRule struct {
+	// Clients lists the client IDs or names allowed to use the rule ("*" for any client)
+	Clients []string `json:"clients"`
+
+	// SubjectAudiences restricts which audiences the subject token may be for
+	SubjectAudiences []string `json:"subject_audiences,omitempty"`
+
+	// SubjectRoles restricts which roles the subject may hold
+	SubjectRoles []string `json:"subject_roles,omitempty"`
+
+	// Audience is the audience the new token is issued for
+	Audience string `json:"audience"`
+
+	// Scopes is the most the new token may carry
+	Scopes []string `json:"scopes,omitempty"`
+
+	// Role, when set, replaces the subject's role in the new token. It may only
+	// lower privileges: its permissions must all belong to the subject's role.
+	Role string `json:"role,omitempty"`
+
+	// Actors lists the subjects allowed to present an actor token
+	Actors []string `json:"actors,omitempty"`
+
+	// RequireActor rejects impersonation, i.e. exchanges without an actor token
+	RequireActor bool `json:"require_actor,omitempty"`
+
+	// MaxDelegationDepth caps the length of the act chain (0 means no limit)
+	MaxDelegationDepth int `json:"max_delegation_depth,omitempty"`
+}
+
+// ExchangePolicy decides who may exchange which tokens into what. With no
+// rules every exchange is denied.
+type ExchangePolicy struct {
+	Rules []ExchangeRule `json:"rules"`
+}
+
+// LoadExchangePolicy reads a JSON exchange policy file
+func LoadExchangePolicy(path string) (*ExchangePolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading exchange policy: %w", err)
+	}
+
+	policy := &ExchangePolicy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("parsing exchange policy %s: %w", path, err)
+	}
+	for i, rule := range policy.Rules {
+		if rule.Audience == "" || len(rule.Clients) == 0 {
+			return nil, fmt.Errorf("exchange policy rule %d needs clients and an audience", i)
+		}
+	}
+	return policy, nil
+}
+
+// exchangeMatch is what a rule is checked against
+type exchangeMatch struct {
+	clientID, clientName string
+	subjectAudiences     []string
+	subjectRole          string
+	audience             string
+	actor                string
+	depth                int
+}
+
+// find returns the first rule that allows the exchange
+func (p *ExchangePolicy) find(m exchangeMatch) (*ExchangeRule, error) {
+	if p != nil {
+		for i := range p.Rules {
+			if p.Rules[i].allows(m) {
+				return &p.Rules[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: client %s to audience %q", ErrExchangeDenied, m.clientID, m.audience)
+}
+
+// allows reports whether the rule covers the exchange
+func (r *ExchangeRule) allows(m exchangeMatch) bool {
+	if r.Audience != m.audience {
+		return false
+	}
+	if !slices.Contains(r.Clients, "*") && !slices.Contains(r.Clients, m.clientID) && !slices.Contains(r.Clients, m.clientName) {
+		return false
+	}
+	if len(r.SubjectAudiences) > 0 && !slices.ContainsFunc(m.subjectAudiences, func(audience string) bool {
+		return slices.Contains(r.SubjectAudiences, audience)
+	}) {
+		return false
+	}
+	if len(r.SubjectRoles) > 0 && !slices.Contains(r.SubjectRoles, m.subjectRole) {
+		return false
+	}
+	if m.actor == "" {
+		if r.RequireActor {
+			return false
+		}
+	} else if len(r.Actors) > 0 && !slices.Contains(r.Actors, m.actor) {
+		return false
+	}
+	if r.MaxDelegationDepth > 0 && m.depth > r.MaxDelegationDepth {
+		return false
+	}
+	return true
+}
+
+// grantScopes narrows the requested scopes to what both the rule and the
+// subject token allow; a subject token without scopes allows none. Asking for
+// nothing grants everything allowed.
+func (r *ExchangeRule) grantScopes(requested, subjectScopes []string) ([]string, error) {
+	allowed := slices.DeleteFunc(slices.Clone(r.Scopes), func(scope string) bool {
+		return !slices.Contains(subjectScopes, scope)
+	})
+
+	if len(requested) == 0 {
+		return allowed, nil
+	}
+	for _, scope := range requested {
+		if !slices.Contains(allowed, scope) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+	}
+	return requested, nil
+}
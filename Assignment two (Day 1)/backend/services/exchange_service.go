@@ -0,0 +1,175 @@
+package services
+
+import (
+	"fmt"
+	"jwt-auth-system/backend/domain"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ExchangeRequest is an RFC 8693 token exchange made by an authenticated client
+type ExchangeRequest struct {
+	Client       *domain.Client
+	SubjectToken string
+	ActorToken   string
+	Audience     string
+	Scope        string
+
+	// Confirmation binds the new token to the caller's DPoP key
+	Confirmation *domain.Confirmation
+}
+
+// ExchangeResult is the token issued by an exchange or a client credentials grant
+type ExchangeResult struct {
+	Token     string
+	Scope     string
+	ExpiresIn time.Duration
+
+	// Confirmation is the key binding of the issued token, if any
+	Confirmation *domain.Confirmation
+}
+
+// ExchangeService issues delegated, down-scoped tokens in exchange for existing ones
+type ExchangeService struct {
+	tokenService TokenService
+	config       TokenConfig
+	policy       *ExchangePolicy
+	permissions  *PermissionCatalog
+}
+
+// NewExchangeService creates a new exchange service governed by policy
+func NewExchangeService(tokenService TokenService, config TokenConfig, policy *ExchangePolicy, permissions *PermissionCatalog) *ExchangeService {
+	return &ExchangeService{
+		tokenService: tokenService,
+		config:       config,
+		policy:       policy,
+		permissions:  permissions,
+	}
+}
+
+// Exchange validates the subject (and actor) token, finds a policy rule that
+// allows the exchange and issues the new token. The new token never outlives
+// the subject token.
+func (s *ExchangeService) Exchange(req ExchangeRequest) (*ExchangeResult, error) {
+	subject, err := s.tokenService.ValidateToken(req.SubjectToken)
+	if err != nil {
+		return nil, fmt.Errorf("subject token: %w", err)
+	}
+	if subject.IsServiceAccount() {
+		return nil, fmt.Errorf("%w: service account tokens cannot be exchanged", ErrExchangeDenied)
+	}
+
+	// Delegation adds the actor in front of any existing chain; impersonation keeps the chain as is
+	chain := subject.Act
+	actorSubject := ""
+	if req.ActorToken != "" {
+		actor, err := s.tokenService.ValidateToken(req.ActorToken)
+		if err != nil {
+			return nil, fmt.Errorf("actor token: %w", err)
+		}
+		actorSubject = actor.Subject
+		chain = &domain.Actor{Subject: actor.Subject, Act: subject.Act}
+	}
+
+	rule, err := s.policy.find(exchangeMatch{
+		clientID:         req.Client.ID,
+		clientName:       req.Client.Name,
+		subjectAudiences: subject.Audience,
+		subjectRole:      subject.Role,
+		audience:         req.Audience,
+		actor:            actorSubject,
+		depth:            chain.Depth(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	user := &domain.User{
+		Username:     subject.Username,
+		Role:         subject.Role,
+		Attributes:   subject.Attributes,
+		TokenVersion: subject.TokenVersion,
+	}
+	if rule.Role != "" {
+		if !s.permissions.Covers(subject.Role, rule.Role) {
+			return nil, fmt.Errorf("%w: role %q has permissions role %q lacks", ErrExchangeDenied, rule.Role, subject.Role)
+		}
+		user.Role = rule.Role
+	}
+
+	confirmation, err := exchangeConfirmation(subject.Cnf, req.Confirmation)
+	if err != nil {
+		return nil, err
+	}
+
+	// Scopes are bounded by the rule, the subject token and the permissions of the new role
+	requested := strings.Fields(req.Scope)
+	scopes, err := rule.grantScopes(requested, subject.Scopes())
+	if err != nil {
+		return nil, err
+	}
+	if len(requested) > 0 {
+		if scopes, err = s.permissions.Grant(user.Role, scopes); err != nil {
+			return nil, err
+		}
+	} else {
+		permissions := s.permissions.Permissions(user.Role)
+		scopes = slices.DeleteFunc(slices.Clone(scopes), func(scope string) bool {
+			return !slices.Contains(permissions, scope)
+		})
+	}
+
+	notAfter := subject.ExpiresAt.Time
+	scope := strings.Join(scopes, " ")
+	token, err := s.tokenService.GenerateTokenWithOptions(user, TokenOptions{
+		Audience:       req.Audience,
+		Scope:          scope,
+		ClientID:       req.Client.ID,
+		Actor:          chain,
+		Confirmation:   confirmation,
+		NotAfter:       notAfter,
+		Authentication: subject.Authentication(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	expiresIn := s.config.LifetimeFor(req.Audience)
+	if remaining := time.Until(notAfter); remaining < expiresIn {
+		expiresIn = remaining
+	}
+
+	fmt.Printf("Token Exchanged: %s for %s by client %s (actor: %q, scope: %q, role: %s)\n",
+		subject.Username, req.Audience, req.Client.ID, actorSubject, scope, user.Role)
+	fmt.Println("---")
+
+	return &ExchangeResult{Token: token, Scope: scope, ExpiresIn: expiresIn, Confirmation: confirmation}, nil
+}
+
+// exchangeConfirmation keeps a bound subject token bound: the caller may prove
+// the same DPoP key or certificate, and every binding it does not prove is
+// carried over, so the new token is no use without the subject token's key
+func exchangeConfirmation(subject, presented *domain.Confirmation) (*domain.Confirmation, error) {
+	if subject == nil {
+		return presented, nil
+	}
+
+	cnf := &domain.Confirmation{}
+	if presented != nil {
+		*cnf = *presented
+	}
+	if subject.JKT != "" {
+		if cnf.JKT != "" && cnf.JKT != subject.JKT {
+			return nil, fmt.Errorf("%w: subject token is bound to a different DPoP key", ErrExchangeDenied)
+		}
+		cnf.JKT = subject.JKT
+	}
+	if subject.X5TS256 != "" {
+		if cnf.X5TS256 != "" && cnf.X5TS256 != subject.X5TS256 {
+			return nil, fmt.Errorf("%w: subject token is bound to a different client certificate", ErrExchangeDenied)
+		}
+		cnf.X5TS256 = subject.X5TS256
+	}
+	return cnf, nil
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"errors"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/repo"
+	"testing"
+	"time"
+)
+
+// newTestExchange returns an exchange service for policy and a function that
+// issues subject tokens for users with the given role, scope and binding
+func newTestExchange(t *testing.T, policy *ExchangePolicy) (*ExchangeService, func(role, scope string, cnf *domain.Confirmation) string) {
+	t.Helper()
+
+	users := repo.NewUserRepository()
+	config := DefaultTokenConfig()
+	config.Audiences = map[string]time.Duration{"api": 5 * time.Minute, "reports": 5 * time.Minute}
+	config.DefaultAudience = "api"
+	config.Versions = NewTokenVersions(users, repo.NewServiceAccountRepository(), 0)
+
+	key, err := GenerateSigningKey(AlgHS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := NewJWTServiceWithConfig(NewKeyring(key, time.Hour), config)
+
+	issue := func(role, scope string, cnf *domain.Confirmation) string {
+		user := &domain.User{Username: role + "-user", Role: role}
+		users.RegisterUser(user)
+		token, err := tokens.GenerateTokenWithOptions(user, TokenOptions{Scope: scope, Confirmation: cnf})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	return NewExchangeService(tokens, config, policy, DefaultPermissionCatalog()), issue
+}
+
+func TestExchangeScopes(t *testing.T) {
+	policy := &ExchangePolicy{Rules: []ExchangeRule{{
+		Clients:  []string{"*"},
+		Audience: "reports",
+		Scopes:   []string{"docs:read", "reports:read"},
+	}}}
+	exchange, issue := newTestExchange(t, policy)
+	client := &domain.Client{ID: "client-1"}
+
+	tests := []struct {
+		name         string
+		subjectScope string
+		requested    string
+		want         string
+		wantErr      error
+	}{
+		{"intersection of rule and subject", "docs:read profile:read", "", "docs:read", nil},
+		{"subject without scope grants nothing", "", "", "", nil},
+		{"subject without scope refuses requests", "", "docs:read", "", ErrInvalidScope},
+		{"request within both", "docs:read reports:read", "docs:read", "docs:read", nil},
+		{"request beyond the subject", "docs:read", "reports:read", "", ErrInvalidScope},
+		{"request beyond the rule", "docs:read profile:read", "profile:read", "", ErrInvalidScope},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := exchange.Exchange(ExchangeRequest{
+				Client:       client,
+				SubjectToken: issue("admin", test.subjectScope, nil),
+				Audience:     "reports",
+				Scope:        test.requested,
+			})
+			if !errors.Is(err, test.wantErr) || (test.wantErr == nil && err != nil) {
+				t.Fatalf("Exchange error = %v, want %v", err, test.wantErr)
+			}
+			if err == nil && result.Scope != test.want {
+				t.Errorf("scope = %q, want %q", result.Scope, test.want)
+			}
+		})
+	}
+}
+
+func TestExchangeRoleCannotEscalate(t *testing.T) {
+	policy := &ExchangePolicy{Rules: []ExchangeRule{{
+		Clients:  []string{"*"},
+		Audience: "reports",
+		Scopes:   []string{"docs:read"},
+		Role:     "viewer",
+	}}}
+	exchange, issue := newTestExchange(t, policy)
+	client := &domain.Client{ID: "client-1"}
+
+	// viewer adds reports:read to what user holds, and is a subset of admin
+	if _, err := exchange.Exchange(ExchangeRequest{Client: client, SubjectToken: issue("user", "docs:read", nil), Audience: "reports"}); !errors.Is(err, ErrExchangeDenied) {
+		t.Errorf("user to viewer: error = %v, want %v", err, ErrExchangeDenied)
+	}
+	if _, err := exchange.Exchange(ExchangeRequest{Client: client, SubjectToken: issue("admin", "docs:read", nil), Audience: "reports"}); err != nil {
+		t.Errorf("admin to viewer: %v", err)
+	}
+}
+
+func TestExchangeKeepsBinding(t *testing.T) {
+	policy := &ExchangePolicy{Rules: []ExchangeRule{{Clients: []string{"*"}, Audience: "reports"}}}
+	exchange, issue := newTestExchange(t, policy)
+	client := &domain.Client{ID: "client-1"}
+	bound := &domain.Confirmation{JKT: "key-a"}
+
+	tests := []struct {
+		name      string
+		subject   *domain.Confirmation
+		presented *domain.Confirmation
+		want      *domain.Confirmation
+		wantErr   error
+	}{
+		{"unbound stays unbound", nil, nil, nil, nil},
+		{"unbound takes the caller's key", nil, &domain.Confirmation{JKT: "key-b"}, &domain.Confirmation{JKT: "key-b"}, nil},
+		{"bound without proof carries the binding", bound, nil, bound, nil},
+		{"bound with the same key", bound, &domain.Confirmation{JKT: "key-a"}, bound, nil},
+		{"bound with another key", bound, &domain.Confirmation{JKT: "key-b"}, nil, ErrExchangeDenied},
+		{"certificate binding added to DPoP binding", bound, &domain.Confirmation{X5TS256: "cert"}, &domain.Confirmation{JKT: "key-a", X5TS256: "cert"}, nil},
+		{"bound to another certificate", &domain.Confirmation{X5TS256: "cert"}, &domain.Confirmation{X5TS256: "other"}, nil, ErrExchangeDenied},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := exchange.Exchange(ExchangeRequest{
+				Client:       client,
+				SubjectToken: issue("user", "", test.subject),
+				Audience:     "reports",
+				Confirmation: test.presented,
+			})
+			if !errors.Is(err, test.wantErr) || (test.wantErr == nil && err != nil) {
+				t.Fatalf("Exchange error = %v, want %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			claims, err := exchange.tokenService.ValidateToken(result.Token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (claims.Cnf == nil) != (test.want == nil) || (claims.Cnf != nil && *claims.Cnf != *test.want) {
+				t.Errorf("cnf = %+v, want %+v", claims.Cnf, test.want)
+			}
+		})
+	}
+}
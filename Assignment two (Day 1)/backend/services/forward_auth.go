@@ -0,0 +1,135 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// ForwardAuthRule says what a request forwarded by a reverse proxy needs. Empty
+// match fields match anything.
+type ForwardAuthRule struct {
+	// Hosts lists the hosts the rule covers, exactly or as "*.example.com"
+	Hosts []string `json:"hosts,omitempty"`
+
+	// PathPrefix matches the path itself and everything below it
+	PathPrefix string `json:"path_prefix,omitempty"`
+
+	// Methods lists the HTTP methods the rule covers
+	Methods []string `json:"methods,omitempty"`
+
+	// Public lets requests through without a token
+	Public bool `json:"public,omitempty"`
+
+	// Roles requires the token to hold one of these roles
+	Roles []string `json:"roles,omitempty"`
+
+	// Scopes requires the token to carry every one of these scopes
+	Scopes []string `json:"scopes,omitempty"`
+
+	// ACR requires the user to have authenticated at this level, e.g. "mfa"
+	ACR string `json:"acr,omitempty"`
+}
+
+// ForwardAuthPolicy decides which forwarded requests are allowed. The first
+// matching rule applies; requests no rule matches only need a valid token.
+type ForwardAuthPolicy struct {
+	Rules []ForwardAuthRule `json:"rules"`
+
+	// LoginURL, when set, is where browsers without a valid token are sent.
+	// {url} in it is replaced by the escaped URL they asked for.
+	LoginURL string `json:"login_url,omitempty"`
+}
+
+// LoadForwardAuthPolicy reads a JSON forward-auth policy file
+func LoadForwardAuthPolicy(path string) (*ForwardAuthPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading forward-auth policy: %w", err)
+	}
+
+	policy := &ForwardAuthPolicy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("parsing forward-auth policy %s: %w", path, err)
+	}
+	for i, rule := range policy.Rules {
+		if rule.PathPrefix != "" && !strings.HasPrefix(rule.PathPrefix, "/") {
+			return nil, fmt.Errorf("forward-auth rule %d: path_prefix must start with /", i)
+		}
+		if rule.Public && (len(rule.Roles) > 0 || len(rule.Scopes) > 0 || rule.ACR != "") {
+			return nil, fmt.Errorf("forward-auth rule %d: a public rule cannot require roles, scopes or acr", i)
+		}
+	}
+	return policy, nil
+}
+
+// Match returns the first rule covering the request, or nil when none does.
+// The path is cleaned first so "/public/../admin" cannot slip past an /admin rule.
+func (p *ForwardAuthPolicy) Match(host, requestPath, method string) *ForwardAuthRule {
+	if p == nil {
+		return nil
+	}
+	host = strings.ToLower(host)
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	} else {
+		// No port: an IPv6 literal still comes in brackets
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	}
+	requestPath = path.Clean("/" + requestPath)
+
+	for i := range p.Rules {
+		if p.Rules[i].matches(host, requestPath, method) {
+			return &p.Rules[i]
+		}
+	}
+	return nil
+}
+
+// LoginRedirect returns where to send a browser that asked for original, or ""
+// when no login URL is configured
+func (p *ForwardAuthPolicy) LoginRedirect(original string) string {
+	if p == nil || p.LoginURL == "" {
+		return ""
+	}
+	return strings.ReplaceAll(p.LoginURL, "{url}", url.QueryEscape(original))
+}
+
+// matches reports whether the rule covers the request
+func (r *ForwardAuthRule) matches(host, requestPath, method string) bool {
+	if len(r.Hosts) > 0 && !slices.ContainsFunc(r.Hosts, func(pattern string) bool {
+		return matchHost(strings.ToLower(pattern), host)
+	}) {
+		return false
+	}
+	if r.PathPrefix != "" && !matchPathPrefix(r.PathPrefix, requestPath) {
+		return false
+	}
+	if len(r.Methods) > 0 && !slices.ContainsFunc(r.Methods, func(m string) bool {
+		return strings.EqualFold(m, method)
+	}) {
+		return false
+	}
+	return true
+}
+
+// matchHost matches a host exactly or against a "*.example.com" wildcard, which
+// covers subdomains but not example.com itself
+func matchHost(pattern, host string) bool {
+	if suffix, found := strings.CutPrefix(pattern, "*"); found {
+		return strings.HasPrefix(suffix, ".") && strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return pattern == host
+}
+
+// matchPathPrefix matches the prefix itself and paths below it, so /admin
+// covers /admin/users but not /administrator
+func matchPathPrefix(prefix, requestPath string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || requestPath == prefix || strings.HasPrefix(requestPath, prefix+"/")
+}
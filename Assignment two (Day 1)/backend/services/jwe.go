@@ -0,0 +1,383 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"jwt-auth-system/backend/domain"
+	"os"
+	"strings"
+)
+
+// Supported JWE key management and content encryption algorithms
+const (
+	AlgRSAOAEP256 = "RSA-OAEP-256"
+	AlgECDHES     = "ECDH-ES"
+	EncA256GCM    = "A256GCM"
+)
+
+// ErrCannotDecrypt is returned when an encrypted token is not addressed to any of our keys
+var ErrCannotDecrypt = errors.New("token is encrypted for a key we do not hold")
+
+// ErrNotEncrypted is returned when a plain JWS names an audience whose tokens must be encrypted
+var ErrNotEncrypted = errors.New("token is not encrypted but its audience requires encryption")
+
+// EncryptionKey is a recipient key for nested JWE tokens. PrivateKey is only
+// set when this service can decrypt tokens sent to the recipient.
+type EncryptionKey struct {
+	KeyID      string
+	Algorithm  string
+	PublicKey  interface{}
+	PrivateKey interface{}
+}
+
+// jweHeader is the protected header of a compact JWE
+type jweHeader struct {
+	Alg string      `json:"alg"`
+	Enc string      `json:"enc"`
+	Kid string      `json:"kid,omitempty"`
+	Cty string      `json:"cty,omitempty"`
+	Epk *domain.JWK `json:"epk,omitempty"`
+	Apu string      `json:"apu,omitempty"`
+	Apv string      `json:"apv,omitempty"`
+}
+
+// GenerateEncryptionKey creates a fresh recipient key pair for RSA-OAEP-256 or ECDH-ES
+func GenerateEncryptionKey(algorithm string) (*EncryptionKey, error) {
+	switch algorithm {
+	case AlgRSAOAEP256:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		return NewEncryptionKey(algorithm, &key.PublicKey, key)
+	case AlgECDHES:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return NewEncryptionKey(algorithm, &key.PublicKey, key)
+	default:
+		return nil, fmt.Errorf("unsupported encryption algorithm: %s", algorithm)
+	}
+}
+
+// NewEncryptionKey wraps recipient key material and derives its key ID; private may be nil
+func NewEncryptionKey(algorithm string, public, private interface{}) (*EncryptionKey, error) {
+	switch algorithm {
+	case AlgRSAOAEP256:
+		if _, ok := public.(*rsa.PublicKey); !ok {
+			return nil, fmt.Errorf("%s requires an RSA key", algorithm)
+		}
+	case AlgECDHES:
+		if pub, ok := public.(*ecdsa.PublicKey); !ok || pub.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("%s requires a P-256 key", algorithm)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported encryption algorithm: %s", algorithm)
+	}
+
+	jwk, _ := publicJWK(public)
+	kid, err := jwkThumbprint(jwk)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EncryptionKey{
+		KeyID:      kid,
+		Algorithm:  algorithm,
+		PublicKey:  public,
+		PrivateKey: private,
+	}, nil
+}
+
+// LoadPEMEncryptionKey reads a recipient public key or a private key from a PEM
+// file; the algorithm follows from the key type (RSA or P-256)
+func LoadPEMEncryptionKey(path string) (*EncryptionKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s does not contain a PEM block", path)
+	}
+
+	var public, private interface{}
+	if block.Type == "PUBLIC KEY" {
+		public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	} else {
+		private, err = parsePrivateKey(block)
+		public = publicKeyOf(private)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	switch public.(type) {
+	case *rsa.PublicKey:
+		return NewEncryptionKey(AlgRSAOAEP256, public, private)
+	case *ecdsa.PublicKey:
+		return NewEncryptionKey(AlgECDHES, public, private)
+	default:
+		return nil, fmt.Errorf("%s: only RSA and P-256 keys can be used for encryption", path)
+	}
+}
+
+// ParseEncryptionRecipients parses "reports=RSA-OAEP-256,hr=/keys/hr.pub.pem".
+// An algorithm name generates a local key pair (so this server can also decrypt);
+// a path loads the recipient's PEM key.
+func ParseEncryptionRecipients(spec string) (map[string]*EncryptionKey, error) {
+	recipients := make(map[string]*EncryptionKey)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		audience, source, found := strings.Cut(entry, "=")
+		if !found || audience == "" || source == "" {
+			return nil, fmt.Errorf("invalid encryption recipient %q, expected audience=algorithm or audience=path", entry)
+		}
+
+		var key *EncryptionKey
+		var err error
+		if source == AlgRSAOAEP256 || source == AlgECDHES {
+			key, err = GenerateEncryptionKey(source)
+		} else {
+			key, err = LoadPEMEncryptionKey(source)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("encryption key for %s: %w", audience, err)
+		}
+		recipients[audience] = key
+	}
+	return recipients, nil
+}
+
+// isEncryptedToken reports whether a compact token is a JWE (five parts) rather than a JWS (three)
+func isEncryptedToken(token string) bool {
+	return strings.Count(token, ".") == 4
+}
+
+// encryptJWE wraps a signed JWT in a compact JWE addressed to the recipient key
+func encryptJWE(signedToken string, recipient *EncryptionKey) (string, error) {
+	header := jweHeader{
+		Alg: recipient.Algorithm,
+		Enc: EncA256GCM,
+		Kid: recipient.KeyID,
+		Cty: "JWT",
+	}
+
+	var cek, encryptedKey []byte
+	switch recipient.Algorithm {
+	case AlgRSAOAEP256:
+		cek = make([]byte, 32)
+		if _, err := rand.Read(cek); err != nil {
+			return "", err
+		}
+		var err error
+		encryptedKey, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, recipient.PublicKey.(*rsa.PublicKey), cek, nil)
+		if err != nil {
+			return "", err
+		}
+	case AlgECDHES:
+		// Direct key agreement: the CEK is derived, so the encrypted key part is empty
+		ephemeral, err := ecdh.P256().GenerateKey(rand.Reader)
+		if err != nil {
+			return "", err
+		}
+		recipientKey, err := recipient.PublicKey.(*ecdsa.PublicKey).ECDH()
+		if err != nil {
+			return "", err
+		}
+		shared, err := ephemeral.ECDH(recipientKey)
+		if err != nil {
+			return "", err
+		}
+		cek = concatKDF(shared, EncA256GCM, nil, nil, 256)
+
+		epk, err := ecdhPublicJWK(ephemeral.PublicKey())
+		if err != nil {
+			return "", err
+		}
+		header.Epk = &epk
+	default:
+		return "", fmt.Errorf("unsupported encryption algorithm: %s", recipient.Algorithm)
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	protected := base64.RawURLEncoding.EncodeToString(headerJSON)
+
+	aead, err := newGCM(cek)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+
+	// The protected header is the additional authenticated data
+	sealed := aead.Seal(nil, iv, []byte(signedToken), []byte(protected))
+	ciphertext, tag := sealed[:len(sealed)-aead.Overhead()], sealed[len(sealed)-aead.Overhead():]
+
+	return strings.Join([]string{
+		protected,
+		base64.RawURLEncoding.EncodeToString(encryptedKey),
+		base64.RawURLEncoding.EncodeToString(iv),
+		base64.RawURLEncoding.EncodeToString(ciphertext),
+		base64.RawURLEncoding.EncodeToString(tag),
+	}, "."), nil
+}
+
+// decryptJWE opens a compact JWE with the matching private key and returns the nested JWT
+func decryptJWE(token string, lookup func(kid string) *EncryptionKey) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return "", fmt.Errorf("%w: not a compact JWE", ErrMalformedToken)
+	}
+
+	decoded := make([][]byte, 5)
+	for i, part := range parts {
+		var err error
+		if decoded[i], err = base64.RawURLEncoding.DecodeString(part); err != nil {
+			return "", fmt.Errorf("%w: invalid JWE encoding", ErrMalformedToken)
+		}
+	}
+
+	var header jweHeader
+	if err := json.Unmarshal(decoded[0], &header); err != nil {
+		return "", fmt.Errorf("%w: invalid JWE header", ErrMalformedToken)
+	}
+	if header.Enc != EncA256GCM || header.Cty != "JWT" {
+		return "", fmt.Errorf("%w: unsupported JWE content (enc %q, cty %q)", ErrMalformedToken, header.Enc, header.Cty)
+	}
+
+	key := lookup(header.Kid)
+	if key == nil || key.PrivateKey == nil || key.Algorithm != header.Alg {
+		return "", ErrCannotDecrypt
+	}
+
+	var cek []byte
+	switch key.Algorithm {
+	case AlgRSAOAEP256:
+		var err error
+		cek, err = rsa.DecryptOAEP(sha256.New(), nil, key.PrivateKey.(*rsa.PrivateKey), decoded[1], nil)
+		if err != nil {
+			return "", fmt.Errorf("%w: key unwrap failed", ErrMalformedToken)
+		}
+	case AlgECDHES:
+		if header.Epk == nil || len(decoded[1]) != 0 {
+			return "", fmt.Errorf("%w: invalid ECDH-ES parameters", ErrMalformedToken)
+		}
+		ephemeral, err := ecdhPublicKeyFromJWK(*header.Epk)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrMalformedToken, err)
+		}
+		private, err := key.PrivateKey.(*ecdsa.PrivateKey).ECDH()
+		if err != nil {
+			return "", err
+		}
+		shared, err := private.ECDH(ephemeral)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrMalformedToken, err)
+		}
+		apu, err := base64.RawURLEncoding.DecodeString(header.Apu)
+		if err != nil {
+			return "", fmt.Errorf("%w: invalid apu", ErrMalformedToken)
+		}
+		apv, err := base64.RawURLEncoding.DecodeString(header.Apv)
+		if err != nil {
+			return "", fmt.Errorf("%w: invalid apv", ErrMalformedToken)
+		}
+		cek = concatKDF(shared, EncA256GCM, apu, apv, 256)
+	}
+
+	aead, err := newGCM(cek)
+	if err != nil || len(decoded[2]) != aead.NonceSize() {
+		return "", fmt.Errorf("%w: invalid content encryption parameters", ErrMalformedToken)
+	}
+	plaintext, err := aead.Open(nil, decoded[2], append(decoded[3], decoded[4]...), []byte(parts[0]))
+	if err != nil {
+		return "", fmt.Errorf("%w: decryption failed", ErrMalformedToken)
+	}
+	return string(plaintext), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// concatKDF derives keyBits of key material from the ECDH shared secret (RFC 7518
+// section 4.6.2), with the apu and apv party info from the JWE header
+func concatKDF(shared []byte, algorithmID string, apu, apv []byte, keyBits int) []byte {
+	var otherInfo []byte
+	otherInfo = binary.BigEndian.AppendUint32(otherInfo, uint32(len(algorithmID)))
+	otherInfo = append(otherInfo, algorithmID...)
+	otherInfo = binary.BigEndian.AppendUint32(otherInfo, uint32(len(apu)))
+	otherInfo = append(otherInfo, apu...)
+	otherInfo = binary.BigEndian.AppendUint32(otherInfo, uint32(len(apv)))
+	otherInfo = append(otherInfo, apv...)
+	otherInfo = binary.BigEndian.AppendUint32(otherInfo, uint32(keyBits))
+
+	var key []byte
+	for round := uint32(1); len(key)*8 < keyBits; round++ {
+		hash := sha256.New()
+		hash.Write(binary.BigEndian.AppendUint32(nil, round))
+		hash.Write(shared)
+		hash.Write(otherInfo)
+		key = hash.Sum(key)
+	}
+	return key[:keyBits/8]
+}
+
+// ecdhPublicJWK encodes an ephemeral P-256 key for the epk header
+func ecdhPublicJWK(key *ecdh.PublicKey) (domain.JWK, error) {
+	raw := key.Bytes() // 0x04 || X || Y
+	if len(raw) != 65 {
+		return domain.JWK{}, fmt.Errorf("unexpected P-256 public key length")
+	}
+	return domain.JWK{
+		Kty: "EC",
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(raw[1:33]),
+		Y:   base64.RawURLEncoding.EncodeToString(raw[33:]),
+	}, nil
+}
+
+// ecdhPublicKeyFromJWK decodes the epk header, rejecting points not on P-256
+func ecdhPublicKeyFromJWK(jwk domain.JWK) (*ecdh.PublicKey, error) {
+	if jwk.Kty != "EC" || jwk.Crv != "P-256" {
+		return nil, fmt.Errorf("epk must be a P-256 key")
+	}
+	x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil || len(x) != 32 {
+		return nil, fmt.Errorf("invalid epk x coordinate")
+	}
+	y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+	if err != nil || len(y) != 32 {
+		return nil, fmt.Errorf("invalid epk y coordinate")
+	}
+	return ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...))
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"jwt-auth-system/backend/domain"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newEncryptingService returns a JWT service that encrypts tokens for the hr
+// audience to recipient, and one sharing its keyring that does not encrypt
+func newEncryptingService(t *testing.T, recipient *EncryptionKey) (encrypting, plain *JWTService) {
+	t.Helper()
+
+	key, err := GenerateSigningKey(AlgES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := NewKeyring(key, time.Hour)
+	config := DefaultTokenConfig()
+	config.Issuer = "test-issuer"
+	config.Audiences = map[string]time.Duration{"api": 5 * time.Minute, "hr": 5 * time.Minute}
+	config.DefaultAudience = "api"
+
+	plainConfig := config
+	config.EncryptFor = map[string]*EncryptionKey{"hr": recipient}
+	return NewJWTServiceWithConfig(keyring, config), NewJWTServiceWithConfig(keyring, plainConfig)
+}
+
+// hrClaims returns valid claims addressed to the hr audience
+func hrClaims() *domain.Claims {
+	claims := validClaims()
+	claims.Audience = jwt.ClaimStrings{"hr"}
+	return claims
+}
+
+// jweParts splits a compact JWE and decodes its protected header
+func jweParts(t *testing.T, token string) ([]string, map[string]interface{}) {
+	t.Helper()
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		t.Fatalf("token has %d parts, want 5", len(parts))
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header map[string]interface{}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		t.Fatal(err)
+	}
+	return parts, header
+}
+
+// flipLastBit flips one bit of a base64url-encoded part
+func flipLastBit(part string) string {
+	raw, _ := base64.RawURLEncoding.DecodeString(part)
+	raw[len(raw)-1] ^= 1
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// withHeader re-encodes the protected header after edit
+func withHeader(t *testing.T, header map[string]interface{}, edit func(map[string]interface{})) string {
+	t.Helper()
+
+	copied := make(map[string]interface{}, len(header))
+	for name, value := range header {
+		copied[name] = value
+	}
+	edit(copied)
+	raw, err := json.Marshal(copied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func TestJWERoundTrip(t *testing.T) {
+	for _, algorithm := range []string{AlgRSAOAEP256, AlgECDHES} {
+		t.Run(algorithm, func(t *testing.T) {
+			recipient, err := GenerateEncryptionKey(algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tokens, _ := newEncryptingService(t, recipient)
+
+			token, err := tokens.SignClaims(hrClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			parts, header := jweParts(t, token)
+			if header["alg"] != algorithm || header["enc"] != EncA256GCM || header["kid"] != recipient.KeyID || header["cty"] != "JWT" {
+				t.Errorf("protected header = %v", header)
+			}
+			if _, hasEPK := header["epk"]; hasEPK != (algorithm == AlgECDHES) {
+				t.Errorf("epk present = %v for %s", hasEPK, algorithm)
+			}
+			if (parts[1] == "") != (algorithm == AlgECDHES) {
+				t.Errorf("encrypted key = %q for %s", parts[1], algorithm)
+			}
+
+			claims, err := tokens.ValidateToken(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Username != "alice" || claims.PrimaryAudience() != "hr" {
+				t.Errorf("claims = %+v", claims)
+			}
+
+			// Tokens for audiences without a recipient stay plain JWS
+			plain, err := tokens.SignClaims(validClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if isEncryptedToken(plain) {
+				t.Error("token for the api audience was encrypted")
+			}
+		})
+	}
+}
+
+func TestJWERejects(t *testing.T) {
+	for _, algorithm := range []string{AlgRSAOAEP256, AlgECDHES} {
+		t.Run(algorithm, func(t *testing.T) {
+			recipient, err := GenerateEncryptionKey(algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stranger, err := GenerateEncryptionKey(algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lookup := func(kid string) *EncryptionKey {
+				if kid == recipient.KeyID {
+					return recipient
+				}
+				return nil
+			}
+
+			token, err := encryptJWE("header.payload.signature", recipient)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if nested, err := decryptJWE(token, lookup); err != nil || nested != "header.payload.signature" {
+				t.Fatalf("decryptJWE = %q, %v", nested, err)
+			}
+			forStranger, err := encryptJWE("header.payload.signature", stranger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parts, header := jweParts(t, token)
+			tampered := func(index int, part string) string {
+				edited := append([]string(nil), parts...)
+				edited[index] = part
+				return strings.Join(edited, ".")
+			}
+
+			type rejection struct {
+				name  string
+				token string
+				want  error
+			}
+			tests := []rejection{
+				{"encrypted for a key we do not hold", forStranger, ErrCannotDecrypt},
+				{"kid of another key", tampered(0, withHeader(t, header, func(h map[string]interface{}) { h["kid"] = stranger.KeyID })), ErrCannotDecrypt},
+				{"no kid", tampered(0, withHeader(t, header, func(h map[string]interface{}) { delete(h, "kid") })), ErrCannotDecrypt},
+				{"other key management algorithm", tampered(0, withHeader(t, header, func(h map[string]interface{}) { h["alg"] = "dir" })), ErrCannotDecrypt},
+				{"tampered tag", tampered(4, flipLastBit(parts[4])), ErrMalformedToken},
+				{"tampered ciphertext", tampered(3, flipLastBit(parts[3])), ErrMalformedToken},
+				{"tampered iv", tampered(2, flipLastBit(parts[2])), ErrMalformedToken},
+				{"truncated iv", tampered(2, parts[2][:8]), ErrMalformedToken},
+				{"extra protected header member", tampered(0, withHeader(t, header, func(h map[string]interface{}) { h["zip"] = "DEF" })), ErrMalformedToken},
+				{"other content encryption", tampered(0, withHeader(t, header, func(h map[string]interface{}) { h["enc"] = "A128GCM" })), ErrMalformedToken},
+				{"not base64", tampered(3, "!!!"), ErrMalformedToken},
+				{"four parts", strings.Join(parts[:4], "."), ErrMalformedToken},
+			}
+			if algorithm == AlgRSAOAEP256 {
+				tests = append(tests, rejection{"tampered encrypted key", tampered(1, flipLastBit(parts[1])), ErrMalformedToken})
+			} else {
+				other, err := GenerateEncryptionKey(AlgECDHES)
+				if err != nil {
+					t.Fatal(err)
+				}
+				otherEPK, _ := publicJWK(other.PublicKey)
+				tests = append(tests, []rejection{
+					{"substituted epk", tampered(0, withHeader(t, header, func(h map[string]interface{}) {
+						h["epk"] = map[string]string{"kty": "EC", "crv": "P-256", "x": otherEPK.X, "y": otherEPK.Y}
+					})), ErrMalformedToken},
+					{"epk off the curve", tampered(0, withHeader(t, header, func(h map[string]interface{}) {
+						h["epk"] = map[string]string{"kty": "EC", "crv": "P-256", "x": otherEPK.X, "y": otherEPK.X}
+					})), ErrMalformedToken},
+					{"encrypted key with ECDH-ES", tampered(1, "AAAA"), ErrMalformedToken},
+				}...)
+			}
+			for _, test := range tests {
+				t.Run(test.name, func(t *testing.T) {
+					if _, err := decryptJWE(test.token, lookup); !errors.Is(err, test.want) {
+						t.Errorf("decryptJWE error = %v, want %v", err, test.want)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestPlainTokenForEncryptedAudience(t *testing.T) {
+	recipient, err := GenerateEncryptionKey(AlgECDHES)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, plain := newEncryptingService(t, recipient)
+
+	// Correctly signed by our own key, but the hr claims travelled in the clear
+	token, err := plain.SignClaims(hrClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isEncryptedToken(token) {
+		t.Fatal("plain service encrypted the token")
+	}
+	if _, err := tokens.ValidateToken(token); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("ValidateToken error = %v, want %v", err, ErrNotEncrypted)
+	}
+}
+
+// TestConcatKDF checks the ECDH-ES key agreement example of RFC 7518 Appendix C
+func TestConcatKDF(t *testing.T) {
+	shared := []byte{
+		158, 86, 217, 29, 129, 113, 53, 211, 114, 131, 66, 131, 191, 132, 38, 156,
+		251, 49, 110, 163, 218, 128, 106, 72, 246, 218, 167, 121, 140, 254, 144, 196,
+	}
+	want := []byte{86, 170, 141, 234, 248, 35, 109, 32, 92, 34, 40, 205, 113, 167, 16, 26}
+
+	got := concatKDF(shared, "A128GCM", []byte("Alice"), []byte("Bob"), 128)
+	if !bytes.Equal(got, want) {
+		t.Errorf("concatKDF = %v, want %v", got, want)
+	}
+	if encoded := base64.RawURLEncoding.EncodeToString(got); encoded != "VqqN6vgjbSBcIijNcacQGg" {
+		t.Errorf("concatKDF = %s, want VqqN6vgjbSBcIijNcacQGg", encoded)
+	}
+
+	// Longer keys take further hash rounds
+	if long := concatKDF(shared, "A256GCM", nil, nil, 384); len(long) != 48 {
+		t.Errorf("384-bit concatKDF returned %d bytes", len(long))
+	}
+}
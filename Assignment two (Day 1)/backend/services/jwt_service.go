@@ -1,89 +1,231 @@
 package services
 
 import (
+	"errors"
 	"fmt"
 	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/repo"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// TokenLifetime is how long an issued token stays valid unless its audience says otherwise
+const TokenLifetime = 5 * time.Minute
+
 // JWTService handles JWT operations
 type JWTService struct {
-	secretKey []byte
+	keyring     *Keyring
+	config      TokenConfig
+	revocations *repo.RevocationRepository
 }
 
-// NewJWTService creates a new JWT service instance
+// NewJWTService creates a new JWT service instance that signs with HS256
 func NewJWTService(secretKey string) *JWTService {
+	return NewJWTServiceWithKey(NewHMACSigningKey(DefaultHMACKeyID, secretKey))
+}
+
+// NewJWTServiceWithKey creates a new JWT service instance that signs with the given key
+func NewJWTServiceWithKey(signingKey *SigningKey) *JWTService {
+	config := DefaultTokenConfig()
+	return NewJWTServiceWithConfig(NewKeyring(signingKey, config.MaxLifetime()), config)
+}
+
+// NewJWTServiceWithKeyring creates a new JWT service instance backed by a rotating keyring
+func NewJWTServiceWithKeyring(keyring *Keyring) *JWTService {
+	return NewJWTServiceWithConfig(keyring, DefaultTokenConfig())
+}
+
+// NewJWTServiceWithConfig creates a new JWT service instance with custom claim settings
+func NewJWTServiceWithConfig(keyring *Keyring, config TokenConfig) *JWTService {
 	return &JWTService{
-		secretKey: []byte(secretKey),
+		keyring:     keyring,
+		config:      config,
+		revocations: repo.NewRevocationRepository(),
 	}
 }
 
 // GenerateToken creates a new JWT token for a given user
 func (s *JWTService) GenerateToken(user *domain.User) (string, error) {
-	expirationTime := time.Now().Add(5 * time.Minute)
-	
-	claims := &domain.Claims{
-		Username:    user.Username,
-		Role:        user.Role,
-		Designation: user.Designation,
-		Age:         user.Age,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(s.secretKey)
+	return s.GenerateTokenWithOptions(user, TokenOptions{})
+}
+
+// GenerateTokenWithOptions creates a new JWT token for a given user and audience
+func (s *JWTService) GenerateTokenWithOptions(user *domain.User, opts TokenOptions) (string, error) {
+	claims, err := s.config.newClaims(user, opts)
+	if err != nil {
+		return "", err
+	}
+	audience := claims.PrimaryAudience()
+
+	tokenString, err := s.SignClaims(claims)
 	if err != nil {
 		return "", err
 	}
 
 	// Print to console
 	fmt.Printf("Generated Token: %s\n", tokenString)
-	fmt.Printf("For User: %s\n", user.Username)
-	fmt.Printf("Claims: {\"username\": \"%s\", \"role\": \"%s\", \"designation\": \"%s\", \"age\": %d}\n", 
-		user.Username, user.Role, user.Designation, user.Age)
+	fmt.Printf("For User: %s (audience: %q, expires: %s)\n", claims.Subject, audience, claims.ExpiresAt.Format(time.RFC3339))
+	fmt.Printf("Claims: {\"username\": \"%s\", \"role\": \"%s\", \"attributes\": %s}\n",
+		claims.Username, claims.Role, claims.Attributes)
 	fmt.Println("---")
 
 	return tokenString, nil
 }
 
-// ValidateToken validates a JWT token and returns the claims
-func (s *JWTService) ValidateToken(tokenString string) (*domain.Claims, error) {
-	claims := &domain.Claims{}
+// SignClaims signs the claims as they are with the active key, encrypting the
+// token when its audience has a recipient key
+func (s *JWTService) SignClaims(claims *domain.Claims) (string, error) {
+	signingKey := s.keyring.Active()
+	if signingKey == nil || !signingKey.CanSign() {
+		return "", fmt.Errorf("no signing key available")
+	}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		// Verify signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	token := jwt.NewWithClaims(signingKey.Method(), claims)
+	token.Header["kid"] = signingKey.KeyID
+	tokenString, err := token.SignedString(signingKey.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+
+	// Keep personal claims private from everyone but the audience
+	if recipient := s.config.EncryptFor[claims.PrimaryAudience()]; recipient != nil {
+		tokenString, err = encryptJWE(tokenString, recipient)
+		if err != nil {
+			return "", err
 		}
-		return s.secretKey, nil
-	})
+	}
+	return tokenString, nil
+}
+
+// ValidateToken validates a JWT token for any configured audience and returns the claims
+func (s *JWTService) ValidateToken(tokenString string) (*domain.Claims, error) {
+	return s.validate(tokenString, s.config.acceptedAudiences())
+}
+
+// ValidateTokenForAudience validates a JWT token that must be addressed to the given audience
+func (s *JWTService) ValidateTokenForAudience(tokenString, audience string) (*domain.Claims, error) {
+	return s.validate(tokenString, []string{audience})
+}
+
+// validate checks the signature and every registered claim, returning a typed
+// error that names the check that failed
+func (s *JWTService) validate(tokenString string, audiences []string) (*domain.Claims, error) {
+	claims := &domain.Claims{}
 
+	signedToken, err := s.unwrap(tokenString)
+	var token *jwt.Token
+	if err == nil {
+		token, err = jwt.ParseWithClaims(signedToken, claims, s.verificationKey,
+			jwt.WithLeeway(s.config.Leeway),
+			jwt.WithExpirationRequired(),
+		)
+	}
+	if err == nil && !token.Valid {
+		err = ErrMalformedToken
+	}
+	if err == nil {
+		err = s.config.checkClaims(claims, audiences)
+	}
+	if err == nil && signedToken == tokenString {
+		err = s.config.checkEncrypted(claims)
+	}
+	if err == nil && claims.ID != "" && s.revocations.IsRevoked(claims.ID) {
+		err = ErrTokenRevoked
+	}
 	if err != nil {
-		fmt.Println("Token Invalid:", err.Error())
-		return nil, err
+		err = classifyError(err)
 	}
 
-	if !token.Valid {
-		fmt.Println("Token Invalid: token is not valid")
-		return nil, fmt.Errorf("invalid token")
+	return reportValidation(claims, err)
+}
+
+// classifyError maps golang-jwt errors onto our typed validation errors
+func classifyError(err error) error {
+	switch {
+	case isValidationError(err):
+		return err
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return ErrTokenExpired
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return ErrNotYetValid
+	case errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+		return fmt.Errorf("%w: %v", ErrMissingClaim, err)
+	default:
+		return fmt.Errorf("%w: %v", ErrMalformedToken, err)
 	}
+}
 
-	// Check expiration
-	if claims.ExpiresAt != nil && claims.ExpiresAt.Before(time.Now()) {
-		fmt.Println("Token Invalid: token expired")
-		return nil, fmt.Errorf("token expired")
+// RevokeToken revokes a token we signed until it expires. Tokens that fail
+// signature verification are ignored, as RFC 7009 requires.
+func (s *JWTService) RevokeToken(tokenString string) {
+	signedToken, err := s.unwrap(tokenString)
+	if err != nil {
+		return
 	}
 
-	// Print to console
-	fmt.Println("Token Valid")
-	fmt.Printf("Claims: {\"username\": \"%s\", \"role\": \"%s\", \"designation\": \"%s\", \"age\": %d}\n", 
-		claims.Username, claims.Role, claims.Designation, claims.Age)
+	claims := &domain.Claims{}
+	_, err = jwt.ParseWithClaims(signedToken, claims, s.verificationKey, jwt.WithoutClaimsValidation())
+	if err != nil || claims.ID == "" || claims.ExpiresAt == nil {
+		return
+	}
+
+	s.revocations.Revoke(claims.ID, claims.ExpiresAt.Time.Add(s.config.Leeway))
+	s.revocations.CleanExpired()
+
+	fmt.Printf("Token Revoked: jti %s (user %s)\n", claims.ID, claims.Username)
 	fmt.Println("---")
+}
+
+// CleanExpired forgets revocations of tokens that have expired anyway
+func (s *JWTService) CleanExpired() {
+	s.revocations.CleanExpired()
+}
+
+// unwrap decrypts a nested JWE and returns the signed JWT inside; plain JWTs pass through
+func (s *JWTService) unwrap(tokenString string) (string, error) {
+	if !isEncryptedToken(tokenString) {
+		return tokenString, nil
+	}
+	return decryptJWE(tokenString, s.config.decryptionKey)
+}
+
+// verificationKey selects the key named by the token's kid header
+func (s *JWTService) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, fmt.Errorf("token has no kid header")
+	}
+
+	key, err := s.keyring.Lookup(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the algorithm bound to the key is accepted, so an RS256 public key
+	// can never be misused as an HS256 secret
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.PublicKey, nil
+}
+
+// JWKS returns the public verification keys; HMAC secrets are never published
+func (s *JWTService) JWKS() domain.JWKSet {
+	return s.keyring.JWKS()
+}
+
+// Algorithm returns the algorithm used to sign new tokens
+func (s *JWTService) Algorithm() string {
+	return s.keyring.Active().Algorithm
+}
+
+// Keyring returns the keyring holding the signing keys
+func (s *JWTService) Keyring() *Keyring {
+	return s.keyring
+}
 
-	return claims, nil
+// Config returns the claim settings the service issues and validates with
+func (s *JWTService) Config() TokenConfig {
+	return s.config
 }
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"fmt"
+	"time"
+)
+
+// KeySource describes where the server's signing keys come from. The first
+// configured source wins: PEM file, then shared secret, then keystore.
+type KeySource struct {
+	Algorithm          string
+	PEMFile            string
+	Secret             string
+	SecretKeyID        string
+	KeystorePath       string
+	KeystorePassphrase string
+	DevMode            bool
+}
+
+// LoadKeyring builds the keyring from the configured key source. Outside dev
+// mode it refuses weak HMAC secrets and refuses to run without any key source.
+// Only keystore-backed (and dev mode) keyrings can rotate.
+func LoadKeyring(source KeySource, verifyWindow time.Duration) (*Keyring, error) {
+	switch {
+	case source.PEMFile != "":
+		key, err := LoadPEMSigningKey(source.PEMFile, source.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Loaded signing key %s from %s\n", key.KeyID, source.PEMFile)
+		keyring := NewKeyring(key, verifyWindow)
+		keyring.fixed = true
+		return keyring, nil
+
+	case source.Secret != "":
+		if source.Algorithm != AlgHS256 {
+			return nil, fmt.Errorf("a shared secret can only be used with HS256, not %s", source.Algorithm)
+		}
+		if err := CheckSecretStrength(source.Secret); err != nil && !source.DevMode {
+			return nil, err
+		}
+		keyring := NewKeyring(NewHMACSigningKey(source.SecretKeyID, source.Secret), verifyWindow)
+		keyring.fixed = true
+		return keyring, nil
+
+	case source.KeystorePassphrase != "":
+		return loadKeystoreKeyring(source, verifyWindow)
+
+	case source.DevMode:
+		fmt.Println("WARNING: dev mode, signing keys are not persisted")
+		if source.Algorithm == AlgHS256 {
+			return NewKeyring(NewHMACSigningKey(DefaultHMACKeyID, DefaultDevSecret), verifyWindow), nil
+		}
+		key, err := GenerateSigningKey(source.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		return NewKeyring(key, verifyWindow), nil
+
+	default:
+		return nil, fmt.Errorf("no signing key configured: set a PEM key file, JWT_SECRET, or KEYSTORE_PASSPHRASE (or use -dev)")
+	}
+}
+
+// loadKeystoreKeyring opens the keystore, generating and saving a key on first
+// run, and keeps the file in sync with later rotations
+func loadKeystoreKeyring(source KeySource, verifyWindow time.Duration) (*Keyring, error) {
+	keystore := NewKeystore(source.KeystorePath, source.KeystorePassphrase)
+
+	var keyring *Keyring
+	if keystore.Exists() {
+		keys, err := keystore.Load()
+		if err != nil {
+			return nil, err
+		}
+		keyring, err = NewKeyringFromKeys(keys, verifyWindow)
+		if err != nil {
+			return nil, err
+		}
+		if active := keyring.Active(); active.Algorithm != source.Algorithm {
+			return nil, fmt.Errorf("keystore %s holds %s keys but the server is configured for %s", source.KeystorePath, active.Algorithm, source.Algorithm)
+		}
+		if snapshot := keyring.Snapshot(); len(snapshot) != len(keys) {
+			// Keys pruned while the server was down leave the file too
+			if err := keystore.Save(snapshot); err != nil {
+				return nil, err
+			}
+		}
+		fmt.Printf("Loaded %d signing key(s) from %s\n", len(keys), source.KeystorePath)
+	} else {
+		key, err := GenerateSigningKey(source.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		keyring = NewKeyring(key, verifyWindow)
+		if err := keystore.Save(keyring.Snapshot()); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Generated signing key %s and created %s\n", key.KeyID, source.KeystorePath)
+	}
+
+	keyring.OnChange(func(keys []*ManagedKey) {
+		if err := keystore.Save(keys); err != nil {
+			fmt.Println("Failed to save keystore:", err)
+		}
+	})
+	return keyring, nil
+}
@@ -0,0 +1,318 @@
+package services
+
+import (
+	"fmt"
+	"jwt-auth-system/backend/domain"
+	"sync"
+	"time"
+)
+
+// KeyState describes what a key in the keyring may still be used for
+type KeyState string
+
+const (
+	// KeyStateActive keys sign new tokens and verify existing ones
+	KeyStateActive KeyState = "active"
+
+	// KeyStateVerifyOnly keys no longer sign but still verify tokens issued before rotation
+	KeyStateVerifyOnly KeyState = "verify-only"
+
+	// KeyStateRetired keys accept nothing; they are kept for the record for one
+	// more verify window and then pruned
+	KeyStateRetired KeyState = "retired"
+)
+
+// ManagedKey is a signing key together with its lifecycle state
+type ManagedKey struct {
+	Key       *SigningKey
+	State     KeyState
+	CreatedAt time.Time
+	RetireAt  time.Time
+}
+
+// KeyInfo is the public description of a managed key, without key material
+type KeyInfo struct {
+	KeyID     string     `json:"kid"`
+	Algorithm string     `json:"alg"`
+	State     KeyState   `json:"state"`
+	CreatedAt time.Time  `json:"created_at"`
+	RetireAt  *time.Time `json:"retire_at,omitempty"`
+}
+
+// Keyring holds the signing keys and moves them through active, verify-only and retired
+type Keyring struct {
+	keys         []*ManagedKey
+	verifyWindow time.Duration
+	onChange     func([]*ManagedKey)
+	mu           sync.RWMutex
+
+	// fixed keyrings hold keys from a PEM file or shared secret, which the
+	// server cannot write back, so rotating them would lose the new key on restart
+	fixed bool
+
+	// changeMu serialises rotations and the saves that follow them, so an older
+	// snapshot never overwrites a newer one
+	changeMu sync.Mutex
+}
+
+// NewKeyring creates a keyring whose first active key is initial. After rotation
+// the previous key stays verifiable for verifyWindow, which should be at least
+// the longest token lifetime.
+func NewKeyring(initial *SigningKey, verifyWindow time.Duration) *Keyring {
+	return &Keyring{
+		keys: []*ManagedKey{{
+			Key:       initial,
+			State:     KeyStateActive,
+			CreatedAt: time.Now(),
+		}},
+		verifyWindow: verifyWindow,
+	}
+}
+
+// NewKeyringFromKeys restores a keyring from previously persisted keys
+func NewKeyringFromKeys(keys []*ManagedKey, verifyWindow time.Duration) (*Keyring, error) {
+	keyring := &Keyring{
+		keys:         keys,
+		verifyWindow: verifyWindow,
+	}
+	keyring.retireExpired(time.Now())
+	if keyring.Active() == nil {
+		return nil, fmt.Errorf("keyring has no active key")
+	}
+	return keyring, nil
+}
+
+// NewVerificationKeyring creates a keyring that only verifies, for example with
+// keys taken from another server's JWKS. Its keys never retire.
+func NewVerificationKeyring(keys []*SigningKey) *Keyring {
+	keyring := &Keyring{}
+	for _, key := range keys {
+		keyring.keys = append(keyring.keys, &ManagedKey{
+			Key:       key,
+			State:     KeyStateVerifyOnly,
+			CreatedAt: time.Now(),
+		})
+	}
+	return keyring
+}
+
+// OnChange registers a callback that receives a snapshot of the keys after every change
+func (k *Keyring) OnChange(fn func([]*ManagedKey)) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.onChange = fn
+}
+
+// Snapshot returns a copy of every managed key
+func (k *Keyring) Snapshot() []*ManagedKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.snapshot()
+}
+
+// Active returns the key used to sign new tokens
+func (k *Keyring) Active() *SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for _, managed := range k.keys {
+		if managed.State == KeyStateActive {
+			return managed.Key
+		}
+	}
+	return nil
+}
+
+// Lookup returns the key with the given ID if it may still verify tokens
+func (k *Keyring) Lookup(kid string) (*SigningKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.retireExpired(time.Now())
+
+	for _, managed := range k.keys {
+		if managed.Key.KeyID != kid {
+			continue
+		}
+		if managed.State == KeyStateRetired {
+			return nil, fmt.Errorf("signing key %s has been retired", kid)
+		}
+		return managed.Key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key: %s", kid)
+}
+
+// CanRotate reports whether rotated keys would outlive the process
+func (k *Keyring) CanRotate() bool {
+	return !k.fixed
+}
+
+// Rotate generates a new active key with the same algorithm as the current one
+func (k *Keyring) Rotate() (*SigningKey, error) {
+	if k.fixed {
+		return nil, ErrRotationUnavailable
+	}
+
+	k.changeMu.Lock()
+	defer k.changeMu.Unlock()
+
+	current := k.Active()
+	if current == nil {
+		return nil, fmt.Errorf("keyring has no active key")
+	}
+
+	next, err := GenerateSigningKey(current.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	k.add(next, KeyStateActive)
+	return next, nil
+}
+
+// Add places a key into the keyring. Adding an active key demotes the current
+// active key to verify-only for the configured verify window.
+func (k *Keyring) Add(key *SigningKey, state KeyState) {
+	k.changeMu.Lock()
+	defer k.changeMu.Unlock()
+
+	k.add(key, state)
+}
+
+// add places a key into the keyring and hands the snapshot to the change
+// callback; caller must hold changeMu
+func (k *Keyring) add(key *SigningKey, state KeyState) {
+	k.mu.Lock()
+
+	now := time.Now()
+	if state == KeyStateActive {
+		for _, managed := range k.keys {
+			if managed.State == KeyStateActive {
+				managed.State = KeyStateVerifyOnly
+				managed.RetireAt = now.Add(k.verifyWindow)
+
+				fmt.Printf("Signing key %s is now verify-only until %s\n",
+					managed.Key.KeyID, managed.RetireAt.Format(time.RFC3339))
+			}
+		}
+	}
+
+	k.keys = append(k.keys, &ManagedKey{
+		Key:       key,
+		State:     state,
+		CreatedAt: now,
+	})
+	fmt.Printf("Signing key %s added as %s (%s)\n", key.KeyID, state, key.Algorithm)
+
+	onChange, snapshot := k.onChange, k.snapshot()
+	k.mu.Unlock()
+
+	if onChange != nil {
+		onChange(snapshot)
+	}
+}
+
+// VerificationKeys returns every key that may still verify tokens
+func (k *Keyring) VerificationKeys() []*SigningKey {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.retireExpired(time.Now())
+
+	keys := make([]*SigningKey, 0, len(k.keys))
+	for _, managed := range k.keys {
+		if managed.State != KeyStateRetired {
+			keys = append(keys, managed.Key)
+		}
+	}
+	return keys
+}
+
+// JWKS returns the public halves of every key that may still verify tokens;
+// HMAC secrets are never published
+func (k *Keyring) JWKS() domain.JWKSet {
+	set := domain.JWKSet{Keys: []domain.JWK{}}
+	for _, key := range k.VerificationKeys() {
+		if jwk, ok := key.PublicJWK(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
+
+// Keys describes every key in the keyring, including retired ones
+func (k *Keyring) Keys() []KeyInfo {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.retireExpired(time.Now())
+
+	infos := make([]KeyInfo, 0, len(k.keys))
+	for _, managed := range k.keys {
+		info := KeyInfo{
+			KeyID:     managed.Key.KeyID,
+			Algorithm: managed.Key.Algorithm,
+			State:     managed.State,
+			CreatedAt: managed.CreatedAt,
+		}
+		if !managed.RetireAt.IsZero() {
+			retireAt := managed.RetireAt
+			info.RetireAt = &retireAt
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// StartRotation rotates the active key every interval until the returned stop function is called
+func (k *Keyring) StartRotation(interval time.Duration) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := k.Rotate(); err != nil {
+					fmt.Println("Scheduled key rotation failed:", err)
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// retireExpired moves verify-only keys past their window to retired and prunes
+// retired keys a further window later, when every token they signed has long
+// expired; keys with no retirement time stay verify-only. Caller must hold the lock.
+func (k *Keyring) retireExpired(now time.Time) {
+	kept := make([]*ManagedKey, 0, len(k.keys))
+	for _, managed := range k.keys {
+		if managed.State == KeyStateVerifyOnly && !managed.RetireAt.IsZero() && now.After(managed.RetireAt) {
+			managed.State = KeyStateRetired
+			fmt.Printf("Signing key %s retired\n", managed.Key.KeyID)
+		}
+		if managed.State == KeyStateRetired && now.After(managed.RetireAt.Add(k.verifyWindow)) {
+			fmt.Printf("Signing key %s pruned\n", managed.Key.KeyID)
+			continue
+		}
+		kept = append(kept, managed)
+	}
+	k.keys = kept
+}
+
+// snapshot copies the managed keys; caller must hold the lock
+func (k *Keyring) snapshot() []*ManagedKey {
+	keys := make([]*ManagedKey, 0, len(k.keys))
+	for _, managed := range k.keys {
+		copied := *managed
+		keys = append(keys, &copied)
+	}
+	return keys
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// tokenKeyID returns the kid a token was signed under
+func tokenKeyID(t *testing.T, token string) string {
+	t.Helper()
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+// keyState returns the state of kid in the keyring, or "" once it has been pruned
+func keyState(keyring *Keyring, kid string) KeyState {
+	for _, managed := range keyring.Snapshot() {
+		if managed.Key.KeyID == kid {
+			return managed.State
+		}
+	}
+	return ""
+}
+
+func TestKeyLifecycle(t *testing.T) {
+	first, err := GenerateSigningKey(AlgES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := NewKeyring(first, time.Minute)
+	tokens := newSigningTestService(keyring)
+
+	before, err := tokens.SignClaims(validClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := tokenKeyID(t, before); kid != first.KeyID {
+		t.Fatalf("token signed with %s, want the active key %s", kid, first.KeyID)
+	}
+
+	second, err := keyring.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotatedAt := time.Now()
+
+	// The old key only verifies; new tokens come from the new key
+	if state := keyState(keyring, first.KeyID); state != KeyStateVerifyOnly {
+		t.Fatalf("old key state = %q, want %q", state, KeyStateVerifyOnly)
+	}
+	after, err := tokens.SignClaims(validClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := tokenKeyID(t, after); kid != second.KeyID {
+		t.Errorf("token signed with %s after rotation, want %s", kid, second.KeyID)
+	}
+	if _, err := tokens.ValidateToken(before); err != nil {
+		t.Errorf("token from the verify-only key: %v", err)
+	}
+
+	// Past the verify window the old key is retired and its tokens are refused
+	keyring.mu.Lock()
+	keyring.retireExpired(rotatedAt.Add(90 * time.Second))
+	keyring.mu.Unlock()
+
+	if state := keyState(keyring, first.KeyID); state != KeyStateRetired {
+		t.Fatalf("old key state = %q, want %q", state, KeyStateRetired)
+	}
+	if _, err := keyring.Lookup(first.KeyID); err == nil {
+		t.Error("Lookup of a retired key succeeded")
+	}
+	if _, err := tokens.ValidateToken(before); !errors.Is(err, ErrMalformedToken) {
+		t.Errorf("token from a retired key: error = %v, want %v", err, ErrMalformedToken)
+	}
+	for _, key := range keyring.JWKS().Keys {
+		if key.Kid == first.KeyID {
+			t.Error("retired key is still published in the JWKS")
+		}
+	}
+
+	// A second window later it is pruned
+	keyring.mu.Lock()
+	keyring.retireExpired(rotatedAt.Add(3 * time.Minute))
+	keyring.mu.Unlock()
+
+	if state := keyState(keyring, first.KeyID); state != "" {
+		t.Errorf("old key state = %q, want it pruned", state)
+	}
+	if state := keyState(keyring, second.KeyID); state != KeyStateActive {
+		t.Errorf("new key state = %q, want %q", state, KeyStateActive)
+	}
+	if _, err := tokens.ValidateToken(after); err != nil {
+		t.Errorf("token from the active key: %v", err)
+	}
+}
+
+func TestRotationNeedsPersistentKeys(t *testing.T) {
+	keystore := filepath.Join(t.TempDir(), "keystore.json")
+
+	tests := []struct {
+		name   string
+		source KeySource
+		want   error
+	}{
+		{"shared secret", KeySource{Algorithm: AlgHS256, Secret: "a-long-enough-secret-for-the-tests-0123456789", SecretKeyID: "secret", DevMode: true}, ErrRotationUnavailable},
+		{"keystore", KeySource{Algorithm: AlgES256, KeystorePath: keystore, KeystorePassphrase: testPassphrase}, nil},
+		{"dev mode", KeySource{Algorithm: AlgES256, DevMode: true}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keyring, err := LoadKeyring(test.source, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if keyring.CanRotate() != (test.want == nil) {
+				t.Errorf("CanRotate = %v", keyring.CanRotate())
+			}
+			_, err = keyring.Rotate()
+			if !errors.Is(err, test.want) || (test.want == nil && err != nil) {
+				t.Fatalf("Rotate error = %v, want %v", err, test.want)
+			}
+			if err != nil && len(keyring.Keys()) != 1 {
+				t.Errorf("refused rotation left %d keys", len(keyring.Keys()))
+			}
+		})
+	}
+
+	// The rotated keystore key survives a restart
+	keys, err := NewKeystore(keystore, testPassphrase).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Errorf("keystore holds %d keys after rotation, want 2", len(keys))
+	}
+}
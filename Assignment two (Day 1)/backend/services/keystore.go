@@ -0,0 +1,263 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// scrypt parameters for deriving the keystore encryption key
+const (
+	keystoreVersion = 1
+	scryptN         = 1 << 15
+	scryptR         = 8
+	scryptP         = 1
+)
+
+// Bounds on the scrypt parameters a keystore file may ask for: weaker ones would
+// make the passphrase cheap to guess, stronger ones could exhaust memory or CPU
+const (
+	minScryptN = 1 << 14
+	maxScryptN = 1 << 20
+	maxScryptR = 32
+	maxScryptP = 16
+)
+
+// keystoreAAD binds the ciphertext to this file format
+var keystoreAAD = []byte("jwt-auth-system keystore v1")
+
+// ErrWrongPassphrase is returned when the keystore cannot be decrypted
+var ErrWrongPassphrase = errors.New("keystore passphrase is incorrect or the file is corrupted")
+
+// Keystore persists signing keys to disk, encrypted with a passphrase-derived key
+// (scrypt + AES-256-GCM)
+type Keystore struct {
+	path       string
+	passphrase []byte
+}
+
+// keystoreFile is the on-disk envelope; everything but the KDF parameters is encrypted
+type keystoreFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Salt       string `json:"salt"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// storedKey is the plaintext form of a managed key inside the keystore
+type storedKey struct {
+	KeyID      string    `json:"kid"`
+	Algorithm  string    `json:"alg"`
+	State      KeyState  `json:"state"`
+	CreatedAt  time.Time `json:"created_at"`
+	RetireAt   time.Time `json:"retire_at"`
+	PrivateKey string    `json:"private_key"`
+}
+
+// NewKeystore creates a keystore backed by the file at path
+func NewKeystore(path, passphrase string) *Keystore {
+	return &Keystore{
+		path:       path,
+		passphrase: []byte(passphrase),
+	}
+}
+
+// Exists reports whether the keystore file has been created
+func (ks *Keystore) Exists() bool {
+	_, err := os.Stat(ks.path)
+	return err == nil
+}
+
+// Load decrypts the keystore and returns its keys
+func (ks *Keystore) Load() ([]*ManagedKey, error) {
+	data, err := os.ReadFile(ks.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file keystoreFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse keystore: %w", err)
+	}
+	if file.Version != keystoreVersion || file.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported keystore version %d (%s)", file.Version, file.KDF)
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(file.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse keystore: %w", err)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(file.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse keystore: %w", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(file.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse keystore: %w", err)
+	}
+
+	if file.N < minScryptN || file.N > maxScryptN || file.R < 1 || file.R > maxScryptR || file.P < 1 || file.P > maxScryptP {
+		return nil, fmt.Errorf("keystore scrypt parameters out of bounds (n=%d r=%d p=%d)", file.N, file.R, file.P)
+	}
+	aead, err := ks.cipher(salt, file.N, file.R, file.P)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, ErrWrongPassphrase
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, keystoreAAD)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	var stored []storedKey
+	if err := json.Unmarshal(plaintext, &stored); err != nil {
+		return nil, fmt.Errorf("failed to parse keystore contents: %w", err)
+	}
+
+	keys := make([]*ManagedKey, 0, len(stored))
+	for _, entry := range stored {
+		key, err := entry.signingKey()
+		if err != nil {
+			return nil, fmt.Errorf("keystore entry %s: %w", entry.KeyID, err)
+		}
+		keys = append(keys, &ManagedKey{
+			Key:       key,
+			State:     entry.State,
+			CreatedAt: entry.CreatedAt,
+			RetireAt:  entry.RetireAt,
+		})
+	}
+	return keys, nil
+}
+
+// Save encrypts the keys with a fresh salt and nonce and atomically replaces the keystore file
+func (ks *Keystore) Save(keys []*ManagedKey) error {
+	stored := make([]storedKey, 0, len(keys))
+	for _, managed := range keys {
+		entry, err := newStoredKey(managed)
+		if err != nil {
+			return err
+		}
+		stored = append(stored, entry)
+	}
+
+	plaintext, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	aead, err := ks.cipher(salt, scryptN, scryptR, scryptP)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(keystoreFile{
+		Version:    keystoreVersion,
+		KDF:        "scrypt",
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		N:          scryptN,
+		R:          scryptR,
+		P:          scryptP,
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, plaintext, keystoreAAD)),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a half-written keystore
+	tmp, err := os.CreateTemp(filepath.Dir(ks.path), ".keystore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), ks.path)
+}
+
+// cipher derives the AES-256-GCM key from the passphrase
+func (ks *Keystore) cipher(salt []byte, n, r, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key(ks.passphrase, salt, n, r, p, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func newStoredKey(managed *ManagedKey) (storedKey, error) {
+	entry := storedKey{
+		KeyID:     managed.Key.KeyID,
+		Algorithm: managed.Key.Algorithm,
+		State:     managed.State,
+		CreatedAt: managed.CreatedAt,
+		RetireAt:  managed.RetireAt,
+	}
+
+	if managed.Key.IsSymmetric() {
+		entry.PrivateKey = base64.StdEncoding.EncodeToString(managed.Key.PrivateKey.([]byte))
+		return entry, nil
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(managed.Key.PrivateKey)
+	if err != nil {
+		return storedKey{}, err
+	}
+	entry.PrivateKey = base64.StdEncoding.EncodeToString(der)
+	return entry, nil
+}
+
+func (entry storedKey) signingKey() (*SigningKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(entry.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.Algorithm == AlgHS256 {
+		return NewHMACSigningKey(entry.KeyID, string(raw)), nil
+	}
+
+	private, err := x509.ParsePKCS8PrivateKey(raw)
+	if err != nil {
+		return nil, err
+	}
+	return NewSigningKey(entry.Algorithm, private, publicKeyOf(private))
+}
@@ -0,0 +1,192 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testPassphrase = "correct horse battery staple"
+
+// newTestKeystore saves a keyring with an active and a verify-only key
+func newTestKeystore(t *testing.T) *Keystore {
+	t.Helper()
+
+	first, err := GenerateSigningKey(AlgES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := NewKeyring(first, time.Hour)
+	if _, err := keyring.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	keystore := NewKeystore(filepath.Join(t.TempDir(), "keystore.json"), testPassphrase)
+	if err := keystore.Save(keyring.Snapshot()); err != nil {
+		t.Fatal(err)
+	}
+	return keystore
+}
+
+// rewriteKeystore edits the on-disk envelope of keystore
+func rewriteKeystore(t *testing.T, keystore *Keystore, edit func(*keystoreFile)) {
+	t.Helper()
+
+	data, err := os.ReadFile(keystore.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file keystoreFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	edit(&file)
+	if data, err = json.Marshal(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keystore.path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeystoreRoundTrip(t *testing.T) {
+	for _, algorithm := range []string{AlgHS256, AlgRS256, AlgES256, AlgEdDSA} {
+		t.Run(algorithm, func(t *testing.T) {
+			key, err := GenerateSigningKey(algorithm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keyring := NewKeyring(key, time.Hour)
+			if _, err := keyring.Rotate(); err != nil {
+				t.Fatal(err)
+			}
+			saved := keyring.Snapshot()
+
+			keystore := NewKeystore(filepath.Join(t.TempDir(), "keystore.json"), testPassphrase)
+			if err := keystore.Save(saved); err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(keystore.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0600 {
+				t.Errorf("keystore file mode = %v, want 0600", info.Mode().Perm())
+			}
+
+			loaded, err := keystore.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(loaded) != len(saved) {
+				t.Fatalf("loaded %d keys, want %d", len(loaded), len(saved))
+			}
+			for i, want := range saved {
+				got := loaded[i]
+				if got.Key.KeyID != want.Key.KeyID || got.Key.Algorithm != want.Key.Algorithm || got.State != want.State ||
+					!got.CreatedAt.Equal(want.CreatedAt) || !got.RetireAt.Equal(want.RetireAt) {
+					t.Errorf("key %d = %+v, want %+v", i, got, want)
+				}
+			}
+
+			// The restored key still signs tokens the original verifies
+			restored, err := NewKeyringFromKeys(loaded, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := newSigningTestService(restored).SignClaims(validClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := newSigningTestService(keyring).ValidateToken(token); err != nil {
+				t.Errorf("token from the restored key: %v", err)
+			}
+		})
+	}
+}
+
+func TestKeystoreWrongPassphrase(t *testing.T) {
+	keystore := newTestKeystore(t)
+
+	if _, err := NewKeystore(keystore.path, "wrong passphrase").Load(); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Load error = %v, want %v", err, ErrWrongPassphrase)
+	}
+}
+
+func TestKeystoreCorrupted(t *testing.T) {
+	flip := func(encoded string) string {
+		raw, _ := base64.StdEncoding.DecodeString(encoded)
+		raw[len(raw)-1] ^= 1
+		return base64.StdEncoding.EncodeToString(raw)
+	}
+
+	tests := []struct {
+		name string
+		edit func(*keystoreFile)
+		want error
+	}{
+		{"tampered ciphertext", func(file *keystoreFile) { file.Ciphertext = flip(file.Ciphertext) }, ErrWrongPassphrase},
+		{"tampered salt", func(file *keystoreFile) { file.Salt = flip(file.Salt) }, ErrWrongPassphrase},
+		{"tampered nonce", func(file *keystoreFile) { file.Nonce = flip(file.Nonce) }, ErrWrongPassphrase},
+		{"short nonce", func(file *keystoreFile) { file.Nonce = "AAAA" }, ErrWrongPassphrase},
+		{"truncated ciphertext", func(file *keystoreFile) { file.Ciphertext = file.Ciphertext[:8] }, ErrWrongPassphrase},
+		{"ciphertext that is not base64", func(file *keystoreFile) { file.Ciphertext = "not base64!" }, nil},
+		{"unknown version", func(file *keystoreFile) { file.Version = 2 }, nil},
+		{"unknown KDF", func(file *keystoreFile) { file.KDF = "pbkdf2" }, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keystore := newTestKeystore(t)
+			rewriteKeystore(t, keystore, test.edit)
+
+			keys, err := keystore.Load()
+			if err == nil {
+				t.Fatalf("Load of a corrupted keystore returned %d keys", len(keys))
+			}
+			if test.want != nil && !errors.Is(err, test.want) {
+				t.Errorf("Load error = %v, want %v", err, test.want)
+			}
+		})
+	}
+
+	t.Run("not JSON", func(t *testing.T) {
+		keystore := newTestKeystore(t)
+		if err := os.WriteFile(keystore.path, []byte("{\"version\": 1, "), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := keystore.Load(); err == nil {
+			t.Error("Load of a truncated file succeeded")
+		}
+	})
+}
+
+func TestKeystoreScryptBounds(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(*keystoreFile)
+	}{
+		{"n below the minimum", func(file *keystoreFile) { file.N = minScryptN / 2 }},
+		{"n above the maximum", func(file *keystoreFile) { file.N = maxScryptN * 2 }},
+		{"huge n", func(file *keystoreFile) { file.N = 1 << 30 }},
+		{"r of zero", func(file *keystoreFile) { file.R = 0 }},
+		{"r above the maximum", func(file *keystoreFile) { file.R = maxScryptR + 1 }},
+		{"p of zero", func(file *keystoreFile) { file.P = 0 }},
+		{"p above the maximum", func(file *keystoreFile) { file.P = maxScryptP + 1 }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			keystore := newTestKeystore(t)
+			rewriteKeystore(t, keystore, test.edit)
+
+			// Out-of-bounds parameters are refused before any key is derived
+			_, err := keystore.Load()
+			if err == nil || errors.Is(err, ErrWrongPassphrase) {
+				t.Errorf("Load error = %v, want the parameters refused", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"jwt-auth-system/backend/domain"
+)
+
+// PeerCertificate returns the verified client certificate of a TLS connection,
+// or nil when the connection is not TLS or the client sent no certificate
+func PeerCertificate(state *tls.ConnectionState) *x509.Certificate {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return state.VerifiedChains[0][0]
+}
+
+// CertificateThumbprint returns the x5t#S256 value for a certificate
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// CertificateConfirmation binds a token to the client certificate of the
+// connection it is issued over (RFC 8705), adding to any DPoP binding
+func CertificateConfirmation(cnf *domain.Confirmation, peer *x509.Certificate) *domain.Confirmation {
+	if peer == nil {
+		return cnf
+	}
+	if cnf == nil {
+		cnf = &domain.Confirmation{}
+	}
+	cnf.X5TS256 = CertificateThumbprint(peer)
+	return cnf
+}
+
+// CheckConfirmation rejects a certificate-bound token presented over a
+// connection without the certificate it is bound to; peer is the verified
+// client certificate of the connection, or nil
+func CheckConfirmation(claims *domain.Claims, peer *x509.Certificate) error {
+	if claims.Cnf == nil || claims.Cnf.X5TS256 == "" {
+		return nil
+	}
+	if peer == nil || CertificateThumbprint(peer) != claims.Cnf.X5TS256 {
+		return ErrCertificateMismatch
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/repo"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// testCA issues client certificates for the tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// issue signs a client certificate for subject with the given SANs
+func (ca *testCA) issue(t *testing.T, subject pkix.Name, dnsNames []string, uris []string) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      subject,
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, raw := range uris {
+		uri, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.URIs = append(template.URIs, uri)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// peerOver makes a request with cert (if any) to a server that trusts ca and
+// returns the client certificate PeerCertificate found on the server side
+func peerOver(t *testing.T, ca *testCA, cert *tls.Certificate) *x509.Certificate {
+	t.Helper()
+
+	var peer *x509.Certificate
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer = PeerCertificate(r.TLS)
+	}))
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	server.TLS = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+	server.StartTLS()
+	defer server.Close()
+
+	client := server.Client()
+	if cert != nil {
+		client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{*cert}
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return peer
+}
+
+func TestAuthenticateCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	subject := pkix.Name{CommonName: "billing", Organization: []string{"Example Corp"}}
+	cert := ca.issue(t, subject, []string{"billing.example.com"}, []string{"spiffe://example.com/billing"})
+	otherCert := ca.issue(t, pkix.Name{CommonName: "reports"}, []string{"reports.example.com"}, []string{"spiffe://example.com/reports"})
+
+	clients := NewClientService(repo.NewClientRepository())
+	register := func(auth domain.TLSAuth) string {
+		client, _, err := clients.CreateClient(domain.CreateClientRequest{Name: "billing", AuthMethod: domain.AuthMethodTLSClientAuth, TLSAuth: auth})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client.ID
+	}
+	secretClient, _, err := clients.CreateClient(domain.CreateClientRequest{Name: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byDN := register(domain.TLSAuth{SubjectDN: subject.String()})
+	byDNS := register(domain.TLSAuth{SANDNS: "billing.example.com"})
+	byURI := register(domain.TLSAuth{SANURI: "spiffe://example.com/billing"})
+
+	tests := []struct {
+		name   string
+		client string
+		cert   *x509.Certificate
+		want   bool
+	}{
+		{"subject DN", byDN, cert.Leaf, true},
+		{"other subject DN", byDN, otherCert.Leaf, false},
+		{"SAN DNS", byDNS, cert.Leaf, true},
+		{"other SAN DNS", byDNS, otherCert.Leaf, false},
+		{"SAN URI", byURI, cert.Leaf, true},
+		{"other SAN URI", byURI, otherCert.Leaf, false},
+		{"no certificate", byDN, nil, false},
+		{"client without tls_client_auth", secretClient.ID, cert.Leaf, false},
+		{"unknown client", "client_unknown", cert.Leaf, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, err := clients.AuthenticateCertificate(test.client, test.cert)
+			if test.want && (err != nil || client.ID != test.client) {
+				t.Fatalf("AuthenticateCertificate = %v, %v; want client %s", client, err, test.client)
+			}
+			if !test.want && !errors.Is(err, ErrInvalidClient) {
+				t.Fatalf("AuthenticateCertificate error = %v, want %v", err, ErrInvalidClient)
+			}
+		})
+	}
+}
+
+func TestCertificateBinding(t *testing.T) {
+	ca := newTestCA(t)
+	cert := ca.issue(t, pkix.Name{CommonName: "billing"}, nil, nil)
+	otherCert := ca.issue(t, pkix.Name{CommonName: "billing"}, nil, nil)
+
+	peer := peerOver(t, ca, &cert)
+	if peer == nil || !peer.Equal(cert.Leaf) {
+		t.Fatal("PeerCertificate did not return the verified client certificate")
+	}
+	if peerOver(t, ca, nil) != nil {
+		t.Fatal("PeerCertificate returned a certificate for a connection without one")
+	}
+
+	// The binding adds to a DPoP binding rather than replacing it
+	cnf := CertificateConfirmation(&domain.Confirmation{JKT: "key-a"}, peer)
+	if cnf.JKT != "key-a" || cnf.X5TS256 != CertificateThumbprint(cert.Leaf) {
+		t.Fatalf("CertificateConfirmation = %+v", cnf)
+	}
+	if CertificateConfirmation(nil, nil) != nil {
+		t.Fatal("CertificateConfirmation bound a token without a certificate")
+	}
+
+	claims := &domain.Claims{Cnf: cnf}
+	tests := []struct {
+		name string
+		peer *x509.Certificate
+		want error
+	}{
+		{"same certificate", peer, nil},
+		{"another certificate with the same subject", otherCert.Leaf, ErrCertificateMismatch},
+		{"no certificate", nil, ErrCertificateMismatch},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := CheckConfirmation(claims, test.peer); !errors.Is(err, test.want) || (test.want == nil && err != nil) {
+				t.Errorf("CheckConfirmation = %v, want %v", err, test.want)
+			}
+		})
+	}
+
+	if err := CheckConfirmation(&domain.Claims{Cnf: &domain.Confirmation{JKT: "key-a"}}, nil); err != nil {
+		t.Errorf("CheckConfirmation of a token without a certificate binding = %v", err)
+	}
+}
+
+func TestUntrustedCertificateIsRefused(t *testing.T) {
+	ca := newTestCA(t)
+	untrusted := newTestCA(t).issue(t, pkix.Name{CommonName: "billing"}, nil, nil)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	server.TLS = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	client := server.Client()
+	client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{untrusted}
+	if resp, err := client.Get(server.URL); err == nil {
+		resp.Body.Close()
+		t.Fatal("handshake with a certificate from another CA succeeded")
+	}
+}
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/repo"
+	"strings"
+	"time"
+)
+
+// OpaqueTokenPrefix marks reference tokens so they can be told apart from JWTs and PASETOs
+const OpaqueTokenPrefix = "ot_"
+
+// OpaqueService issues random reference tokens whose claims stay in a server-side
+// store. Nothing about the user can be read from the token itself, and revoking
+// it deletes the record, so it stops working at once.
+type OpaqueService struct {
+	store  *repo.TokenStore
+	config TokenConfig
+
+	// sliding, when non-zero, expires tokens after this long without use; the
+	// audience lifetime is then the absolute limit
+	sliding time.Duration
+}
+
+// NewOpaqueService creates a new opaque token service; sliding of zero disables idle expiry
+func NewOpaqueService(store *repo.TokenStore, config TokenConfig, sliding time.Duration) *OpaqueService {
+	return &OpaqueService{
+		store:   store,
+		config:  config,
+		sliding: sliding,
+	}
+}
+
+// GenerateToken creates a new opaque token for a given user
+func (s *OpaqueService) GenerateToken(user *domain.User) (string, error) {
+	return s.GenerateTokenWithOptions(user, TokenOptions{})
+}
+
+// GenerateTokenWithOptions creates a new opaque token for a given user and audience
+func (s *OpaqueService) GenerateTokenWithOptions(user *domain.User, opts TokenOptions) (string, error) {
+	claims, err := s.config.newClaims(user, opts)
+	if err != nil {
+		return "", err
+	}
+
+	tokenString := OpaqueTokenPrefix + randomToken(32)
+	record := &domain.StoredToken{Claims: claims.Clone()}
+	if s.sliding > 0 {
+		record.IdleExpiresAt = time.Now().Add(s.sliding)
+	}
+	s.store.Save(hashOpaqueToken(tokenString), record)
+
+	// Print to console
+	fmt.Printf("Generated Opaque Token for User: %s (audience: %q, expires: %s)\n",
+		claims.Subject, claims.PrimaryAudience(), claims.ExpiresAt.Format(time.RFC3339))
+	fmt.Printf("Claims: {\"username\": \"%s\", \"role\": \"%s\", \"attributes\": %s}\n",
+		claims.Username, claims.Role, claims.Attributes)
+	fmt.Println("---")
+
+	return tokenString, nil
+}
+
+// ValidateToken looks an opaque token up for any configured audience and returns the claims
+func (s *OpaqueService) ValidateToken(tokenString string) (*domain.Claims, error) {
+	return s.validate(tokenString, s.config.acceptedAudiences())
+}
+
+// ValidateTokenForAudience looks an opaque token up and requires the given audience
+func (s *OpaqueService) ValidateTokenForAudience(tokenString, audience string) (*domain.Claims, error) {
+	return s.validate(tokenString, []string{audience})
+}
+
+// validate looks the token up, checks expiry and audience, and slides the idle expiry on success
+func (s *OpaqueService) validate(tokenString string, audiences []string) (*domain.Claims, error) {
+	if !IsOpaqueToken(tokenString) {
+		return reportValidation(nil, fmt.Errorf("%w: not an opaque token", ErrMalformedToken))
+	}
+
+	hash := hashOpaqueToken(tokenString)
+	record, ok := s.store.Get(hash)
+	if !ok {
+		// Unknown, revoked and cleaned-up tokens are indistinguishable
+		return reportValidation(nil, fmt.Errorf("%w: unknown token", ErrMalformedToken))
+	}
+
+	now := time.Now()
+	var err error
+	switch {
+	case record.Expired(now, s.config.Leeway):
+		s.store.Delete(hash)
+		err = ErrTokenExpired
+	case record.Claims.NotBefore != nil && now.Add(s.config.Leeway).Before(record.Claims.NotBefore.Time):
+		err = ErrNotYetValid
+	default:
+		err = s.config.checkClaims(record.Claims, audiences)
+	}
+	if err != nil {
+		return reportValidation(nil, err)
+	}
+
+	if s.sliding > 0 {
+		s.store.Touch(hash, now.Add(s.sliding))
+	}
+
+	// Hand out a deep copy so callers cannot change the stored claims
+	return reportValidation(record.Claims.Clone(), nil)
+}
+
+// RevokeToken deletes the token's record; unknown tokens are ignored as RFC 7009 requires
+func (s *OpaqueService) RevokeToken(tokenString string) {
+	hash := hashOpaqueToken(tokenString)
+	record, ok := s.store.Get(hash)
+	if !ok {
+		return
+	}
+
+	s.store.Delete(hash)
+	s.store.CleanExpired(s.config.Leeway)
+
+	fmt.Printf("Token Revoked: jti %s (user %s)\n", record.Claims.ID, record.Claims.Username)
+	fmt.Println("---")
+}
+
+// CleanExpired drops the records of tokens that can no longer validate
+func (s *OpaqueService) CleanExpired() {
+	s.store.CleanExpired(s.config.Leeway)
+}
+
+// IsOpaqueToken reports whether a token is an opaque reference token
+func IsOpaqueToken(tokenString string) bool {
+	return strings.HasPrefix(tokenString, OpaqueTokenPrefix)
+}
+
+// hashOpaqueToken returns the store key for a token
+func hashOpaqueToken(tokenString string) string {
+	sum := sha256.Sum256([]byte(tokenString))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
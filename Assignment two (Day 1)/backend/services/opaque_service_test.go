@@ -0,0 +1,71 @@
+package services
+
+import (
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/repo"
+	"testing"
+	"time"
+)
+
+func TestOpaqueValidationReturnsDeepCopy(t *testing.T) {
+	store := repo.NewTokenStore()
+	service := NewOpaqueService(store, DefaultTokenConfig(), 0)
+
+	user := &domain.User{Username: "alice", Role: "user", Attributes: domain.Attributes{"designation": "dev"}}
+	token, err := service.GenerateTokenWithOptions(user, TokenOptions{
+		Confirmation: &domain.Confirmation{JKT: "key-a"},
+		Actor:        &domain.Actor{Subject: "svc", Act: &domain.Actor{Subject: "gateway"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Attributes["designation"] = "changed after issuing"
+
+	claims, err := service.ValidateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims.Attributes["designation"] = "tampered"
+	claims.Cnf.JKT = "key-b"
+	claims.Act.Act.Subject = "mallory"
+	claims.ExpiresAt.Time = claims.ExpiresAt.Add(time.Hour)
+
+	again, err := service.ValidateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Attributes["designation"] != "dev" {
+		t.Errorf("stored attribute = %v, want dev", again.Attributes["designation"])
+	}
+	if again.Cnf.JKT != "key-a" {
+		t.Errorf("stored cnf.jkt = %s, want key-a", again.Cnf.JKT)
+	}
+	if again.Act.Act.Subject != "gateway" {
+		t.Errorf("stored actor = %s, want gateway", again.Act.Act.Subject)
+	}
+	if again.ExpiresAt.Equal(claims.ExpiresAt.Time) {
+		t.Error("stored expiry changed with the returned copy")
+	}
+}
+
+func TestOpaqueCleanExpired(t *testing.T) {
+	store := repo.NewTokenStore()
+	config := DefaultTokenConfig()
+	config.Leeway = 0
+	service := NewOpaqueService(store, config, time.Millisecond)
+
+	user := &domain.User{Username: "alice", Role: "user"}
+	token, err := service.GenerateToken(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.Get(hashOpaqueToken(token)); !ok {
+		t.Fatal("token was not stored")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	service.CleanExpired()
+	if _, ok := store.Get(hashOpaqueToken(token)); ok {
+		t.Error("idle-expired token is still stored after CleanExpired")
+	}
+}
@@ -0,0 +1,424 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/repo"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+// PASETO v4 purposes
+const (
+	PasetoPublic = "public"
+	PasetoLocal  = "local"
+)
+
+// pasetoTimeClaims are written as RFC 3339 strings in PASETO rather than NumericDate
+var pasetoTimeClaims = []string{"exp", "nbf", "iat"}
+
+// pasetoLocalKeyInfo is the HKDF info that derives v4.local keys from keyring secrets
+const pasetoLocalKeyInfo = "paseto-v4-local"
+
+// PasetoService issues and validates PASETO v4 tokens carrying the same claims as
+// JWTService. v4.public signs with the keyring's Ed25519 keys; v4.local encrypts
+// with a key derived from its symmetric keys, so the same secret is never used
+// for both HS256 and PASETO. The key ID travels in the footer.
+type PasetoService struct {
+	purpose     string
+	header      string
+	keyring     *Keyring
+	config      TokenConfig
+	revocations *repo.RevocationRepository
+}
+
+// pasetoFooter is the unencrypted, authenticated footer of every token we issue
+type pasetoFooter struct {
+	Kid string `json:"kid"`
+}
+
+// NewPasetoService creates a PASETO v4 service for the given purpose ("public" or "local")
+func NewPasetoService(purpose string, keyring *Keyring, config TokenConfig) (*PasetoService, error) {
+	if purpose != PasetoPublic && purpose != PasetoLocal {
+		return nil, fmt.Errorf("unsupported PASETO purpose: %s", purpose)
+	}
+
+	s := &PasetoService{
+		purpose:     purpose,
+		header:      "v4." + purpose + ".",
+		keyring:     keyring,
+		config:      config,
+		revocations: repo.NewRevocationRepository(),
+	}
+	if err := s.checkKey(keyring.Active()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// GenerateToken creates a new PASETO token for a given user
+func (s *PasetoService) GenerateToken(user *domain.User) (string, error) {
+	return s.GenerateTokenWithOptions(user, TokenOptions{})
+}
+
+// GenerateTokenWithOptions creates a new PASETO token for a given user and audience
+func (s *PasetoService) GenerateTokenWithOptions(user *domain.User, opts TokenOptions) (string, error) {
+	claims, err := s.config.newClaims(user, opts)
+	if err != nil {
+		return "", err
+	}
+
+	tokenString, err := s.SignClaims(claims)
+	if err != nil {
+		return "", err
+	}
+
+	// Print to console
+	fmt.Printf("Generated Token: %s\n", tokenString)
+	fmt.Printf("For User: %s (audience: %q, expires: %s)\n", claims.Subject, claims.PrimaryAudience(), claims.ExpiresAt.Format(time.RFC3339))
+	fmt.Printf("Claims: {\"username\": \"%s\", \"role\": \"%s\", \"attributes\": %s}\n",
+		claims.Username, claims.Role, claims.Attributes)
+	fmt.Println("---")
+
+	return tokenString, nil
+}
+
+// SignClaims signs or encrypts the claims as they are with the active key
+func (s *PasetoService) SignClaims(claims *domain.Claims) (string, error) {
+	message, err := encodePasetoClaims(claims)
+	if err != nil {
+		return "", err
+	}
+
+	key := s.keyring.Active()
+	if err := s.checkKey(key); err != nil {
+		return "", err
+	}
+	footer, err := json.Marshal(pasetoFooter{Kid: key.KeyID})
+	if err != nil {
+		return "", err
+	}
+
+	var body []byte
+	if s.purpose == PasetoPublic {
+		body = pasetoSign(s.header, key.PrivateKey.(ed25519.PrivateKey), message, footer, nil)
+	} else {
+		nonce := make([]byte, 32)
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		localKey, err := pasetoLocalKey(key.PrivateKey.([]byte))
+		if err != nil {
+			return "", err
+		}
+		body, err = pasetoEncrypt(s.header, localKey, nonce, message, footer, nil)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return s.header + base64.RawURLEncoding.EncodeToString(body) + "." + base64.RawURLEncoding.EncodeToString(footer), nil
+}
+
+// ValidateToken validates a PASETO token for any configured audience and returns the claims
+func (s *PasetoService) ValidateToken(tokenString string) (*domain.Claims, error) {
+	return s.validate(tokenString, s.config.acceptedAudiences())
+}
+
+// ValidateTokenForAudience validates a PASETO token that must be addressed to the given audience
+func (s *PasetoService) ValidateTokenForAudience(tokenString, audience string) (*domain.Claims, error) {
+	return s.validate(tokenString, []string{audience})
+}
+
+// RevokeToken revokes a token we issued until it expires; unverifiable tokens are ignored
+func (s *PasetoService) RevokeToken(tokenString string) {
+	claims, err := s.open(tokenString)
+	if err != nil || claims.ID == "" || claims.ExpiresAt == nil {
+		return
+	}
+
+	s.revocations.Revoke(claims.ID, claims.ExpiresAt.Time.Add(s.config.Leeway))
+	s.revocations.CleanExpired()
+
+	fmt.Printf("Token Revoked: jti %s (user %s)\n", claims.ID, claims.Username)
+	fmt.Println("---")
+}
+
+// CleanExpired forgets revocations of tokens that have expired anyway
+func (s *PasetoService) CleanExpired() {
+	s.revocations.CleanExpired()
+}
+
+// validate verifies or decrypts the token, then checks the time-based and registered claims
+func (s *PasetoService) validate(tokenString string, audiences []string) (*domain.Claims, error) {
+	claims, err := s.open(tokenString)
+	if err == nil {
+		err = s.checkTimes(claims)
+	}
+	if err == nil {
+		err = s.config.checkClaims(claims, audiences)
+	}
+	if err == nil && claims.ID != "" && s.revocations.IsRevoked(claims.ID) {
+		err = ErrTokenRevoked
+	}
+
+	return reportValidation(claims, err)
+}
+
+// open authenticates the token with the key named in its footer and decodes the claims
+func (s *PasetoService) open(tokenString string) (*domain.Claims, error) {
+	if !strings.HasPrefix(tokenString, s.header) {
+		return nil, fmt.Errorf("%w: expected a %s token", ErrMalformedToken, strings.TrimSuffix(s.header, "."))
+	}
+
+	parts := strings.Split(strings.TrimPrefix(tokenString, s.header), ".")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("%w: token must carry a key ID footer", ErrMalformedToken)
+	}
+	body, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid payload encoding", ErrMalformedToken)
+	}
+	footer, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid footer encoding", ErrMalformedToken)
+	}
+
+	var meta pasetoFooter
+	if err := json.Unmarshal(footer, &meta); err != nil || meta.Kid == "" {
+		return nil, fmt.Errorf("%w: footer has no kid", ErrMalformedToken)
+	}
+	key, err := s.keyring.Lookup(meta.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedToken, err)
+	}
+	if err := s.checkKey(key); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedToken, err)
+	}
+
+	var message []byte
+	if s.purpose == PasetoPublic {
+		message, err = pasetoVerify(s.header, key.PublicKey.(ed25519.PublicKey), body, footer, nil)
+	} else {
+		var localKey []byte
+		if localKey, err = pasetoLocalKey(key.PrivateKey.([]byte)); err == nil {
+			message, err = pasetoDecrypt(s.header, localKey, body, footer, nil)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodePasetoClaims(message)
+}
+
+// checkTimes enforces exp (required) and nbf with the configured leeway
+func (s *PasetoService) checkTimes(claims *domain.Claims) error {
+	now := time.Now()
+	if claims.ExpiresAt == nil {
+		return fmt.Errorf("%w: exp", ErrMissingClaim)
+	}
+	if now.After(claims.ExpiresAt.Add(s.config.Leeway)) {
+		return ErrTokenExpired
+	}
+	if claims.NotBefore != nil && now.Add(s.config.Leeway).Before(claims.NotBefore.Time) {
+		return ErrNotYetValid
+	}
+	return nil
+}
+
+// checkKey makes sure a keyring key suits the purpose: Ed25519 for public, a
+// symmetric key of at least 32 bytes for local
+func (s *PasetoService) checkKey(key *SigningKey) error {
+	if s.purpose == PasetoPublic {
+		if key.Algorithm != AlgEdDSA {
+			return fmt.Errorf("v4.public requires an EdDSA (Ed25519) key, not %s", key.Algorithm)
+		}
+		return nil
+	}
+
+	secret, ok := key.PrivateKey.([]byte)
+	if !ok || len(secret) < 32 {
+		return fmt.Errorf("v4.local requires a symmetric key of at least 32 bytes")
+	}
+	return nil
+}
+
+// pasetoLocalKey derives the v4.local key from a keyring secret with HKDF-SHA256
+func pasetoLocalKey(secret []byte) ([]byte, error) {
+	return hkdf.Key(sha256.New, secret, nil, pasetoLocalKeyInfo, 32)
+}
+
+// pasetoSign implements v4.public signing and returns the message followed by its signature
+func pasetoSign(header string, key ed25519.PrivateKey, message, footer, implicit []byte) []byte {
+	signature := ed25519.Sign(key, pae([]byte(header), message, footer, implicit))
+	return append(slices.Clip(message), signature...)
+}
+
+// pasetoVerify implements v4.public verification and returns the signed message
+func pasetoVerify(header string, key ed25519.PublicKey, body, footer, implicit []byte) ([]byte, error) {
+	if len(body) < ed25519.SignatureSize {
+		return nil, fmt.Errorf("%w: token is too short", ErrMalformedToken)
+	}
+	message, signature := body[:len(body)-ed25519.SignatureSize], body[len(body)-ed25519.SignatureSize:]
+	if !ed25519.Verify(key, pae([]byte(header), message, footer, implicit), signature) {
+		return nil, fmt.Errorf("%w: signature is invalid", ErrMalformedToken)
+	}
+	return message, nil
+}
+
+// pasetoEncrypt implements v4.local encryption: XChaCha20 with BLAKE2b-MAC
+func pasetoEncrypt(header string, key, nonce, message, footer, implicit []byte) ([]byte, error) {
+	encryptionKey, counterNonce, authKey, err := pasetoLocalKeys(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext := make([]byte, len(message))
+	stream.XORKeyStream(ciphertext, message)
+
+	tag, err := pasetoMAC(authKey, pae([]byte(header), nonce, ciphertext, footer, implicit))
+	if err != nil {
+		return nil, err
+	}
+
+	body := append(slices.Clip(nonce), ciphertext...)
+	return append(body, tag...), nil
+}
+
+// pasetoDecrypt implements v4.local decryption, checking the tag before decrypting
+func pasetoDecrypt(header string, key, body, footer, implicit []byte) ([]byte, error) {
+	if len(body) < 64 {
+		return nil, fmt.Errorf("%w: token is too short", ErrMalformedToken)
+	}
+	nonce, ciphertext, tag := body[:32], body[32:len(body)-32], body[len(body)-32:]
+
+	encryptionKey, counterNonce, authKey, err := pasetoLocalKeys(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	expected, err := pasetoMAC(authKey, pae([]byte(header), nonce, ciphertext, footer, implicit))
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(tag, expected) {
+		return nil, fmt.Errorf("%w: authentication tag is invalid", ErrMalformedToken)
+	}
+
+	stream, err := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+	if err != nil {
+		return nil, err
+	}
+	message := make([]byte, len(ciphertext))
+	stream.XORKeyStream(message, ciphertext)
+	return message, nil
+}
+
+// pasetoLocalKeys splits the key into encryption and authentication keys for this nonce
+func pasetoLocalKeys(key, nonce []byte) (encryptionKey, counterNonce, authKey []byte, err error) {
+	derive, err := blake2b.New(56, key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	derive.Write([]byte("paseto-encryption-key"))
+	derive.Write(nonce)
+	tmp := derive.Sum(nil)
+
+	auth, err := blake2b.New256(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	auth.Write([]byte("paseto-auth-key-for-aead"))
+	auth.Write(nonce)
+
+	return tmp[:32], tmp[32:], auth.Sum(nil), nil
+}
+
+func pasetoMAC(authKey, preAuth []byte) ([]byte, error) {
+	mac, err := blake2b.New256(authKey)
+	if err != nil {
+		return nil, err
+	}
+	mac.Write(preAuth)
+	return mac.Sum(nil), nil
+}
+
+// pae is PASETO's pre-authentication encoding of a list of byte strings
+func pae(pieces ...[]byte) []byte {
+	out := binary.LittleEndian.AppendUint64(nil, uint64(len(pieces)))
+	for _, piece := range pieces {
+		out = binary.LittleEndian.AppendUint64(out, uint64(len(piece))&^(1<<63))
+		out = append(out, piece...)
+	}
+	return out
+}
+
+// encodePasetoClaims serialises the claims, writing times as RFC 3339 and a
+// single audience as a string as PASETO expects
+func encodePasetoClaims(claims *domain.Claims) ([]byte, error) {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, err
+	}
+
+	for _, name := range pasetoTimeClaims {
+		if seconds, ok := payload[name].(float64); ok {
+			payload[name] = time.Unix(int64(seconds), 0).UTC().Format(time.RFC3339)
+		}
+	}
+	if audiences, ok := payload["aud"].([]interface{}); ok && len(audiences) == 1 {
+		payload["aud"] = audiences[0]
+	}
+
+	return json.Marshal(payload)
+}
+
+// decodePasetoClaims reverses encodePasetoClaims
+func decodePasetoClaims(message []byte) (*domain.Claims, error) {
+	var payload map[string]interface{}
+	if err := json.Unmarshal(message, &payload); err != nil {
+		return nil, fmt.Errorf("%w: invalid claims", ErrMalformedToken)
+	}
+
+	for _, name := range pasetoTimeClaims {
+		value, ok := payload[name].(string)
+		if !ok {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid %s claim", ErrMalformedToken, name)
+		}
+		payload[name] = parsed.Unix()
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	claims := &domain.Claims{}
+	if err := json.Unmarshal(data, claims); err != nil {
+		return nil, fmt.Errorf("%w: invalid claims", ErrMalformedToken)
+	}
+	return claims, nil
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+// pasetoVector is one entry of the official PASETO v4 test vectors
+// (github.com/paseto-standard/test-vectors, v4.json)
+type pasetoVector struct {
+	Name       string `json:"name"`
+	ExpectFail bool   `json:"expect-fail"`
+	Nonce      string `json:"nonce"`
+	Key        string `json:"key"`
+	PublicKey  string `json:"public-key"`
+	SecretKey  string `json:"secret-key"`
+	Token      string `json:"token"`
+	Payload    string `json:"payload"`
+	Footer     string `json:"footer"`
+	Implicit   string `json:"implicit-assertion"`
+}
+
+func loadPasetoVectors(t *testing.T) []pasetoVector {
+	t.Helper()
+
+	data, err := os.ReadFile("testdata/paseto_v4.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file struct {
+		Tests []pasetoVector `json:"tests"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	return file.Tests
+}
+
+func decodeHex(t *testing.T, value string) []byte {
+	t.Helper()
+
+	decoded, err := hex.DecodeString(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+// splitPaseto returns the decoded body and footer of a token with the given header
+func splitPaseto(t *testing.T, token, header string) (body, footer []byte) {
+	t.Helper()
+
+	if !strings.HasPrefix(token, header) {
+		t.Fatalf("token does not start with %s", header)
+	}
+	encodedBody, encodedFooter, _ := strings.Cut(strings.TrimPrefix(token, header), ".")
+	body, err := base64.RawURLEncoding.DecodeString(encodedBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	footer, err = base64.RawURLEncoding.DecodeString(encodedFooter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body, footer
+}
+
+// encodePaseto assembles a token the way the vectors write it: no footer part when the footer is empty
+func encodePaseto(header string, body, footer []byte) string {
+	token := header + base64.RawURLEncoding.EncodeToString(body)
+	if len(footer) > 0 {
+		token += "." + base64.RawURLEncoding.EncodeToString(footer)
+	}
+	return token
+}
+
+func TestPasetoV4LocalVectors(t *testing.T) {
+	for _, vector := range loadPasetoVectors(t) {
+		if vector.Key == "" {
+			continue
+		}
+		t.Run(vector.Name, func(t *testing.T) {
+			const header = "v4.local."
+			key, nonce := decodeHex(t, vector.Key), decodeHex(t, vector.Nonce)
+
+			body, footer := splitPaseto(t, vector.Token, header)
+			message, err := pasetoDecrypt(header, key, body, footer, []byte(vector.Implicit))
+			if vector.ExpectFail {
+				if err == nil {
+					t.Fatal("decrypt succeeded, want failure")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decrypt: %v", err)
+			}
+			if string(message) != vector.Payload {
+				t.Errorf("payload = %s, want %s", message, vector.Payload)
+			}
+
+			encrypted, err := pasetoEncrypt(header, key, nonce, []byte(vector.Payload), []byte(vector.Footer), []byte(vector.Implicit))
+			if err != nil {
+				t.Fatalf("encrypt: %v", err)
+			}
+			if got := encodePaseto(header, encrypted, []byte(vector.Footer)); got != vector.Token {
+				t.Errorf("token = %s, want %s", got, vector.Token)
+			}
+
+			if _, err := pasetoDecrypt(header, key, body, footer, []byte("wrong implicit assertion")); err == nil {
+				t.Error("decrypt with a different implicit assertion succeeded")
+			}
+		})
+	}
+}
+
+func TestPasetoV4PublicVectors(t *testing.T) {
+	for _, vector := range loadPasetoVectors(t) {
+		if vector.PublicKey == "" {
+			continue
+		}
+		t.Run(vector.Name, func(t *testing.T) {
+			const header = "v4.public."
+			public := ed25519.PublicKey(decodeHex(t, vector.PublicKey))
+			secret := ed25519.PrivateKey(decodeHex(t, vector.SecretKey))
+
+			body, footer := splitPaseto(t, vector.Token, header)
+			message, err := pasetoVerify(header, public, body, footer, []byte(vector.Implicit))
+			if vector.ExpectFail {
+				if err == nil {
+					t.Fatal("verify succeeded, want failure")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verify: %v", err)
+			}
+			if string(message) != vector.Payload {
+				t.Errorf("payload = %s, want %s", message, vector.Payload)
+			}
+
+			signed := pasetoSign(header, secret, []byte(vector.Payload), []byte(vector.Footer), []byte(vector.Implicit))
+			if got := encodePaseto(header, signed, []byte(vector.Footer)); got != vector.Token {
+				t.Errorf("token = %s, want %s", got, vector.Token)
+			}
+
+			if _, err := pasetoVerify(header, public, body, []byte(`{"kid":"other"}`), []byte(vector.Implicit)); err == nil {
+				t.Error("verify with a different footer succeeded")
+			}
+		})
+	}
+}
+
+func TestPasetoLocalKeyIsDerived(t *testing.T) {
+	secret := []byte(strings.Repeat("k", 32))
+
+	derived, err := pasetoLocalKey(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(derived) != 32 {
+		t.Fatalf("derived key has %d bytes, want 32", len(derived))
+	}
+	if string(derived) == string(secret) {
+		t.Fatal("v4.local key is the HS256 secret itself")
+	}
+
+	again, _ := pasetoLocalKey(secret)
+	if string(again) != string(derived) {
+		t.Fatal("derivation is not deterministic")
+	}
+}
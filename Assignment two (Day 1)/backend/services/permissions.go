@@ -0,0 +1,198 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"jwt-auth-system/backend/domain"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// DefaultRole is the role self-registered users receive unless the catalog names another
+const DefaultRole = "user"
+
+// rolePattern restricts role names to short lowercase identifiers
+var rolePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+// PermissionCatalog maps each role to the permissions (scopes) its tokens may
+// carry. It is the catalog of roles that exist: admins manage it at runtime
+// and tokens are only issued for roles it holds.
+type PermissionCatalog struct {
+	Roles map[string][]string `json:"roles"`
+
+	// DefaultRole is given to every self-registered user
+	DefaultRole string `json:"default_role,omitempty"`
+
+	mu sync.RWMutex
+}
+
+// DefaultPermissionCatalog returns the catalog used when no catalog file is configured
+func DefaultPermissionCatalog() *PermissionCatalog {
+	return &PermissionCatalog{
+		Roles: map[string][]string{
+			"admin":  {"profile:read", "docs:read", "docs:write", "reports:read", "reports:export", "users:read", "users:write"},
+			"user":   {"profile:read", "docs:read"},
+			"viewer": {"profile:read", "docs:read", "reports:read"},
+		},
+		DefaultRole: DefaultRole,
+	}
+}
+
+// LoadPermissionCatalog reads a JSON catalog such as
+// {"roles": {"admin": ["docs:read"], "user": []}, "default_role": "user"}
+func LoadPermissionCatalog(path string) (*PermissionCatalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading permission catalog: %w", err)
+	}
+
+	catalog := &PermissionCatalog{}
+	if err := json.Unmarshal(data, catalog); err != nil {
+		return nil, fmt.Errorf("parsing permission catalog %s: %w", path, err)
+	}
+	for name, permissions := range catalog.Roles {
+		if err := checkRole(name, permissions); err != nil {
+			return nil, fmt.Errorf("permission catalog %s: %w", path, err)
+		}
+	}
+	if catalog.DefaultRole == "" {
+		catalog.DefaultRole = DefaultRole
+	}
+	if _, ok := catalog.Roles[catalog.DefaultRole]; !ok {
+		return nil, fmt.Errorf("permission catalog %s: default role %q is not in the catalog", path, catalog.DefaultRole)
+	}
+	return catalog, nil
+}
+
+// Permissions returns a copy of the permissions granted to role; unknown roles get none
+func (c *PermissionCatalog) Permissions(role string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return slices.Clone(c.Roles[role])
+}
+
+// HasRole reports whether role exists in the catalog
+func (c *PermissionCatalog) HasRole(role string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	_, ok := c.Roles[role]
+	return ok
+}
+
+// CheckRole returns ErrUnknownRole unless role exists in the catalog
+func (c *PermissionCatalog) CheckRole(role string) error {
+	if !c.HasRole(role) {
+		return fmt.Errorf("%w: %q", ErrUnknownRole, role)
+	}
+	return nil
+}
+
+// Covers reports whether role holds every permission of other, so that
+// switching from role to other grants nothing new
+func (c *PermissionCatalog) Covers(role, other string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if _, ok := c.Roles[other]; !ok {
+		return false
+	}
+	for _, permission := range c.Roles[other] {
+		if !slices.Contains(c.Roles[role], permission) {
+			return false
+		}
+	}
+	return true
+}
+
+// ListRoles returns every role with its permissions, ordered by name
+func (c *PermissionCatalog) ListRoles() []domain.Role {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	roles := make([]domain.Role, 0, len(c.Roles))
+	for name, permissions := range c.Roles {
+		roles = append(roles, domain.Role{Name: name, Permissions: slices.Clone(permissions)})
+	}
+	sort.Slice(roles, func(i, j int) bool {
+		return roles[i].Name < roles[j].Name
+	})
+	return roles
+}
+
+// AddRole creates a role with the given permissions
+func (c *PermissionCatalog) AddRole(name string, permissions []string) error {
+	if err := checkRole(name, permissions); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.Roles[name]; exists {
+		return fmt.Errorf("%w: %q", ErrRoleExists, name)
+	}
+	if c.Roles == nil {
+		c.Roles = make(map[string][]string)
+	}
+	c.Roles[name] = slices.Clone(permissions)
+	return nil
+}
+
+// DeleteRole removes a role; the default role cannot be removed. Callers make
+// sure no user holds it, with UserRepository.WithoutHolders.
+func (c *PermissionCatalog) DeleteRole(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.Roles[name]; !exists {
+		return fmt.Errorf("%w: %q", ErrUnknownRole, name)
+	}
+	if name == c.DefaultRole {
+		return fmt.Errorf("%w: %q is the default role", ErrRoleInUse, name)
+	}
+	delete(c.Roles, name)
+	return nil
+}
+
+// Grant returns the scopes a token for role may carry. Asking for nothing
+// grants every permission of the role; asking for more than that is an error.
+func (c *PermissionCatalog) Grant(role string, requested []string) ([]string, error) {
+	permissions := c.Permissions(role)
+	if len(requested) == 0 {
+		return permissions, nil
+	}
+	for _, scope := range requested {
+		if !slices.Contains(permissions, scope) {
+			return nil, fmt.Errorf("%w: %s for role %q", ErrInvalidScope, scope, role)
+		}
+	}
+	return slices.Clone(requested), nil
+}
+
+// checkRole checks a role name and its permissions
+func checkRole(name string, permissions []string) error {
+	if !rolePattern.MatchString(name) {
+		return fmt.Errorf("%w: %q must be lowercase letters, digits, _ or -", ErrInvalidRole, name)
+	}
+	for _, permission := range permissions {
+		if permission == "" || !validScope(permission) {
+			return fmt.Errorf("%w: invalid permission %q for role %q", ErrInvalidRole, permission, name)
+		}
+	}
+	return nil
+}
+
+// validScope reports whether s can appear in a space-separated scope claim (RFC 6749 section 3.3)
+func validScope(s string) bool {
+	for _, r := range s {
+		if r <= ' ' || r == '"' || r == '\\' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
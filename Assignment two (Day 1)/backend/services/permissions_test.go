@@ -0,0 +1,79 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLoadPermissionCatalogValidates(t *testing.T) {
+	tests := []struct {
+		name    string
+		catalog string
+		wantErr bool
+	}{
+		{"valid", `{"roles": {"admin": ["docs:read"], "user": []}}`, false},
+		{"role name with spaces", `{"roles": {"super admin": ["docs:read"], "user": []}}`, true},
+		{"upper case role name", `{"roles": {"Admin": [], "user": []}}`, true},
+		{"empty permission", `{"roles": {"user": [""]}}`, true},
+		{"permission with a space", `{"roles": {"user": ["docs read"]}}`, true},
+		{"permission with a quote", `{"roles": {"user": ["docs\"read"]}}`, true},
+		{"missing default role", `{"roles": {"admin": []}}`, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "permissions.json")
+			if err := os.WriteFile(path, []byte(test.catalog), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadPermissionCatalog(path)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("LoadPermissionCatalog error = %v, want error: %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestPermissionCatalogReturnsCopies(t *testing.T) {
+	catalog := DefaultPermissionCatalog()
+
+	permissions := catalog.Permissions("user")
+	permissions[0] = "users:write"
+	if slices.Contains(catalog.Permissions("user"), "users:write") {
+		t.Error("changing the result of Permissions changed the catalog")
+	}
+
+	granted, err := catalog.Grant("user", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	granted[0] = "users:write"
+	if slices.Contains(catalog.Permissions("user"), "users:write") {
+		t.Error("changing the result of Grant changed the catalog")
+	}
+
+	requested := []string{"docs:read"}
+	granted, err = catalog.Grant("user", requested)
+	if err != nil {
+		t.Fatal(err)
+	}
+	granted[0] = "profile:read"
+	if requested[0] != "docs:read" {
+		t.Error("changing the result of Grant changed the request")
+	}
+}
+
+func TestCheckRole(t *testing.T) {
+	catalog := DefaultPermissionCatalog()
+	if err := catalog.CheckRole("viewer"); err != nil {
+		t.Errorf("CheckRole(viewer) = %v", err)
+	}
+	if err := catalog.DeleteRole("viewer"); err != nil {
+		t.Fatal(err)
+	}
+	if err := catalog.CheckRole("viewer"); !errors.Is(err, ErrUnknownRole) {
+		t.Errorf("CheckRole of a deleted role = %v, want %v", err, ErrUnknownRole)
+	}
+}
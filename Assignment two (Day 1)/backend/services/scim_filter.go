@@ -0,0 +1,583 @@
+package services
+
+import (
+	"fmt"
+	"jwt-auth-system/backend/domain"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// SCIMFilter is a parsed SCIM filter (RFC 7644 section 3.4.2.2), such as
+// userName eq "alice" or members[value eq "bob"]. It is evaluated against a
+// resource rendered as a JSON object; attribute names and string comparisons
+// are case-insensitive.
+type SCIMFilter interface {
+	Matches(resource map[string]interface{}) bool
+}
+
+// SCIMPath is an attribute path of a filter or PATCH operation, such as
+// userName, members[value eq "bob"].display or
+// urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:role
+type SCIMPath struct {
+	// Schema is the extension URN the attribute belongs to, or "" for core attributes
+	Schema string
+
+	// Attribute is the top-level attribute; it is "" when the path names a whole extension
+	Attribute string
+
+	// Filter, when set, selects elements of a multi-valued attribute
+	Filter SCIMFilter
+
+	// SubAttribute is the sub-attribute of a complex attribute, or ""
+	SubAttribute string
+}
+
+// scimExtensions are the extension URNs a path can start with
+var scimExtensions = []string{domain.SCIMEnterpriseUserSchema}
+
+// scimCoreSchemas are core schema URNs, which paths may spell out in front of an attribute
+var scimCoreSchemas = []string{domain.SCIMUserSchema, domain.SCIMGroupSchema}
+
+// ParseSCIMFilter parses a filter expression
+func ParseSCIMFilter(expression string) (SCIMFilter, error) {
+	p, err := newSCIMParser(expression)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFilter, err)
+	}
+	filter, err := p.parseOr()
+	if err == nil && !p.done() {
+		err = fmt.Errorf("unexpected %q", p.peek().text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFilter, err)
+	}
+	return filter, nil
+}
+
+// ParseSCIMPath parses the path of a PATCH operation
+func ParseSCIMPath(expression string) (*SCIMPath, error) {
+	p, err := newSCIMParser(expression)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPath, err)
+	}
+	path, err := p.parsePath(true)
+	if err == nil && !p.done() {
+		err = fmt.Errorf("unexpected %q", p.peek().text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPath, err)
+	}
+	return path, nil
+}
+
+// Values returns the values the path points at in resource. Multi-valued
+// attributes contribute each element, and elements the path filters out are left out.
+func (p *SCIMPath) Values(resource map[string]interface{}) []interface{} {
+	container := resource
+	if p.Schema != "" {
+		container, _ = resource[lookupKey(resource, p.Schema)].(map[string]interface{})
+	}
+	if p.Attribute == "" {
+		if container == nil {
+			return nil
+		}
+		return []interface{}{container}
+	}
+
+	value, ok := container[lookupKey(container, p.Attribute)]
+	if !ok || value == nil {
+		return nil
+	}
+	elements, multiValued := value.([]interface{})
+	if !multiValued {
+		elements = []interface{}{value}
+	}
+
+	var values []interface{}
+	for _, element := range elements {
+		complexValue, isComplex := element.(map[string]interface{})
+		if p.Filter != nil && (!isComplex || !p.Filter.Matches(complexValue)) {
+			continue
+		}
+		if p.SubAttribute == "" {
+			values = append(values, element)
+			continue
+		}
+		if sub, ok := complexValue[lookupKey(complexValue, p.SubAttribute)]; ok && sub != nil {
+			values = append(values, sub)
+		}
+	}
+	return values
+}
+
+// String writes the path back in filter syntax
+func (p *SCIMPath) String() string {
+	var b strings.Builder
+	b.WriteString(p.Schema)
+	if p.Schema != "" && p.Attribute != "" {
+		b.WriteByte(':')
+	}
+	b.WriteString(p.Attribute)
+	if p.Filter != nil {
+		b.WriteString("[...]")
+	}
+	if p.SubAttribute != "" {
+		b.WriteString("." + p.SubAttribute)
+	}
+	return b.String()
+}
+
+// scimCompare is an attribute compared with a literal: eq, ne, co, sw, ew, gt, ge, lt or le
+type scimCompare struct {
+	path    *SCIMPath
+	op      string
+	literal interface{}
+}
+
+// Matches reports whether any value of the attribute satisfies the comparison.
+// Complex values are compared by their value sub-attribute; ne matches when no value is equal.
+func (c *scimCompare) Matches(resource map[string]interface{}) bool {
+	values := c.path.Values(resource)
+	if c.literal == nil {
+		return (c.op == "eq") == (len(values) == 0)
+	}
+	if c.op == "ne" {
+		return !(&scimCompare{path: c.path, op: "eq", literal: c.literal}).Matches(resource)
+	}
+	for _, value := range values {
+		if complexValue, ok := value.(map[string]interface{}); ok {
+			value = complexValue["value"]
+		}
+		if compareSCIMValue(value, c.op, c.literal) {
+			return true
+		}
+	}
+	return false
+}
+
+// compareSCIMValue compares one attribute value with a literal of the same type
+func compareSCIMValue(value interface{}, op string, literal interface{}) bool {
+	switch literal := literal.(type) {
+	case string:
+		text, ok := value.(string)
+		if !ok {
+			return false
+		}
+		text, literal = strings.ToLower(text), strings.ToLower(literal)
+		switch op {
+		case "eq":
+			return text == literal
+		case "co":
+			return strings.Contains(text, literal)
+		case "sw":
+			return strings.HasPrefix(text, literal)
+		case "ew":
+			return strings.HasSuffix(text, literal)
+		}
+		return orderedMatch(strings.Compare(text, literal), op)
+
+	case float64:
+		number, ok := toFloat(value)
+		if !ok {
+			return false
+		}
+		switch {
+		case number < literal:
+			return orderedMatch(-1, op)
+		case number > literal:
+			return orderedMatch(1, op)
+		}
+		return orderedMatch(0, op)
+
+	case bool:
+		flag, ok := value.(bool)
+		return ok && op == "eq" && flag == literal
+	}
+	return false
+}
+
+// orderedMatch reports whether a comparison result satisfies eq, gt, ge, lt or le
+func orderedMatch(result int, op string) bool {
+	switch op {
+	case "eq":
+		return result == 0
+	case "gt":
+		return result > 0
+	case "ge":
+		return result >= 0
+	case "lt":
+		return result < 0
+	case "le":
+		return result <= 0
+	}
+	return false
+}
+
+// scimPresent matches resources where the attribute has a non-empty value
+type scimPresent struct {
+	path *SCIMPath
+}
+
+// Matches reports whether the attribute has a value other than null or ""
+func (f *scimPresent) Matches(resource map[string]interface{}) bool {
+	for _, value := range f.path.Values(resource) {
+		if value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// scimLogical combines two filters with and or or
+type scimLogical struct {
+	and         bool
+	left, right SCIMFilter
+}
+
+// Matches applies the logical operator
+func (f *scimLogical) Matches(resource map[string]interface{}) bool {
+	if f.and {
+		return f.left.Matches(resource) && f.right.Matches(resource)
+	}
+	return f.left.Matches(resource) || f.right.Matches(resource)
+}
+
+// scimNot negates a filter
+type scimNot struct {
+	filter SCIMFilter
+}
+
+// Matches reports whether the inner filter does not match
+func (f *scimNot) Matches(resource map[string]interface{}) bool {
+	return !f.filter.Matches(resource)
+}
+
+// scimValuePath matches resources where an element of a multi-valued attribute
+// matches the filter in brackets
+type scimValuePath struct {
+	path *SCIMPath
+}
+
+// Matches reports whether any element passes the path's filter
+func (f *scimValuePath) Matches(resource map[string]interface{}) bool {
+	return len(f.path.Values(resource)) > 0
+}
+
+// scimToken is a token of a filter: a word, a quoted string or one of ( ) [ ]
+type scimToken struct {
+	text   string
+	quoted bool
+}
+
+// scimParser is a recursive descent parser over the tokens of a filter or path
+type scimParser struct {
+	tokens []scimToken
+	pos    int
+}
+
+// newSCIMParser splits the expression into tokens
+func newSCIMParser(expression string) (*scimParser, error) {
+	p := &scimParser{}
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		switch r := runes[i]; {
+		case unicode.IsSpace(r):
+			i++
+		case strings.ContainsRune("()[]", r):
+			p.tokens = append(p.tokens, scimToken{text: string(r)})
+			i++
+		case r == '"':
+			end := i + 1
+			for ; end < len(runes) && runes[end] != '"'; end++ {
+				if runes[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			text, err := strconv.Unquote(string(runes[i : end+1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s", string(runes[i:end+1]))
+			}
+			p.tokens = append(p.tokens, scimToken{text: text, quoted: true})
+			i = end + 1
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && !strings.ContainsRune("()[]\"", runes[end]) {
+				end++
+			}
+			p.tokens = append(p.tokens, scimToken{text: string(runes[i:end])})
+			i = end
+		}
+	}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	return p, nil
+}
+
+// done reports whether every token was consumed
+func (p *scimParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+// peek returns the next token without consuming it
+func (p *scimParser) peek() scimToken {
+	if p.done() {
+		return scimToken{}
+	}
+	return p.tokens[p.pos]
+}
+
+// next consumes and returns the next token
+func (p *scimParser) next() scimToken {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+// keyword reports whether the next token is the unquoted word, and consumes it if so
+func (p *scimParser) keyword(word string) bool {
+	if token := p.peek(); !token.quoted && strings.EqualFold(token.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// expect consumes the punctuation token or fails
+func (p *scimParser) expect(text string) error {
+	if token := p.next(); token.quoted || token.text != text {
+		return fmt.Errorf("expected %q", text)
+	}
+	return nil
+}
+
+// parseOr parses filters joined by or, which binds loosest
+func (p *scimParser) parseOr() (SCIMFilter, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.keyword("or") {
+		var right SCIMFilter
+		if right, err = p.parseAnd(); err == nil {
+			left = &scimLogical{left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+// parseAnd parses filters joined by and
+func (p *scimParser) parseAnd() (SCIMFilter, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.keyword("and") {
+		var right SCIMFilter
+		if right, err = p.parseUnary(); err == nil {
+			left = &scimLogical{and: true, left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+// parseUnary parses not (...), a parenthesised filter or an attribute expression
+func (p *scimParser) parseUnary() (SCIMFilter, error) {
+	if p.keyword("not") {
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		filter, err := p.parseGroup()
+		if err != nil {
+			return nil, err
+		}
+		return &scimNot{filter: filter}, nil
+	}
+	if token := p.peek(); !token.quoted && token.text == "(" {
+		p.pos++
+		return p.parseGroup()
+	}
+	return p.parseAttributeExpression()
+}
+
+// parseGroup parses the rest of a parenthesised filter
+func (p *scimParser) parseGroup() (SCIMFilter, error) {
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	return filter, p.expect(")")
+}
+
+// parseAttributeExpression parses attr pr, attr op value or attr[filter]
+func (p *scimParser) parseAttributeExpression() (SCIMFilter, error) {
+	path, err := p.parsePath(false)
+	if err != nil {
+		return nil, err
+	}
+	if path.Filter != nil {
+		return &scimValuePath{path: path}, nil
+	}
+	if path.Attribute == "" {
+		return nil, fmt.Errorf("%s is not an attribute", path.Schema)
+	}
+
+	operator := p.next()
+	op := strings.ToLower(operator.text)
+	switch {
+	case operator.quoted:
+		return nil, fmt.Errorf("expected an operator after %s", path)
+	case op == "pr":
+		return &scimPresent{path: path}, nil
+	case op == "eq" || op == "ne" || op == "co" || op == "sw" || op == "ew" || op == "gt" || op == "ge" || op == "lt" || op == "le":
+	default:
+		return nil, fmt.Errorf("unknown operator %q after %s", operator.text, path)
+	}
+
+	literal, err := p.parseLiteral()
+	if err != nil {
+		return nil, err
+	}
+	switch literal.(type) {
+	case string:
+	case nil, bool:
+		if op != "eq" && op != "ne" {
+			return nil, fmt.Errorf("%s needs a string or number", op)
+		}
+	default:
+		if op == "co" || op == "sw" || op == "ew" {
+			return nil, fmt.Errorf("%s needs a string", op)
+		}
+	}
+	return &scimCompare{path: path, op: op, literal: literal}, nil
+}
+
+// parseLiteral parses a comparison value: a string, number, true, false or null
+func (p *scimParser) parseLiteral() (interface{}, error) {
+	token := p.next()
+	if token.quoted {
+		return token.text, nil
+	}
+	switch strings.ToLower(token.text) {
+	case "":
+		return nil, fmt.Errorf("expected a value")
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	number, err := strconv.ParseFloat(token.text, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q", token.text)
+	}
+	return number, nil
+}
+
+// parsePath parses an attribute path. A PATCH path may go on from a value
+// filter to a sub-attribute, as in members[value eq "bob"].display.
+func (p *scimParser) parsePath(patch bool) (*SCIMPath, error) {
+	token := p.next()
+	if token.quoted || token.text == "" || strings.ContainsAny(token.text, "()[]") {
+		return nil, fmt.Errorf("expected an attribute name")
+	}
+	path, err := splitSCIMPath(token.text)
+	if err != nil {
+		return nil, err
+	}
+
+	if next := p.peek(); next.quoted || next.text != "[" {
+		return path, nil
+	}
+	if path.SubAttribute != "" || path.Attribute == "" {
+		return nil, fmt.Errorf("a value filter must follow a top-level attribute")
+	}
+	p.pos++
+	if path.Filter, err = p.parseOr(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("]"); err != nil {
+		return nil, err
+	}
+
+	if next := p.peek(); patch && !next.quoted && strings.HasPrefix(next.text, ".") {
+		p.pos++
+		path.SubAttribute = strings.TrimPrefix(next.text, ".")
+		if !validSCIMName(path.SubAttribute) {
+			return nil, fmt.Errorf("invalid sub-attribute %q", next.text)
+		}
+	}
+	return path, nil
+}
+
+// splitSCIMPath splits [urn:]attribute[.subAttribute], dropping core schema URNs
+func splitSCIMPath(text string) (*SCIMPath, error) {
+	path := &SCIMPath{}
+	if strings.HasPrefix(strings.ToLower(text), "urn:") {
+		for _, extension := range scimExtensions {
+			if strings.EqualFold(text, extension) {
+				return &SCIMPath{Schema: extension}, nil
+			}
+		}
+		colon := strings.LastIndex(text, ":")
+		schema := text[:colon]
+		text = text[colon+1:]
+		switch {
+		case containsFold(scimCoreSchemas, schema):
+		case containsFold(scimExtensions, schema):
+			for _, extension := range scimExtensions {
+				if strings.EqualFold(schema, extension) {
+					path.Schema = extension
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unknown schema %s", schema)
+		}
+	}
+
+	var hasSub bool
+	path.Attribute, path.SubAttribute, hasSub = strings.Cut(text, ".")
+	if !validSCIMName(path.Attribute) || (hasSub && !validSCIMName(path.SubAttribute)) {
+		return nil, fmt.Errorf("invalid attribute name %q", text)
+	}
+	return path, nil
+}
+
+// validSCIMName checks an attribute name: a letter followed by letters, digits, _, - or $ (RFC 7643 section 2.1)
+func validSCIMName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		letter := r < unicode.MaxASCII && unicode.IsLetter(r)
+		if i == 0 && !letter && r != '$' {
+			return false
+		}
+		if !letter && !unicode.IsDigit(r) && r != '_' && r != '-' && r != '$' {
+			return false
+		}
+	}
+	return true
+}
+
+// containsFold reports whether list holds s, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupKey returns the key of object that equals name ignoring case, or name when there is none
+func lookupKey(object map[string]interface{}, name string) string {
+	if _, ok := object[name]; ok {
+		return name
+	}
+	for key := range object {
+		if strings.EqualFold(key, name) {
+			return key
+		}
+	}
+	return name
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// scimResource decodes a resource the way the handlers render one
+func scimResource(t *testing.T, data string) map[string]interface{} {
+	t.Helper()
+
+	var resource map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &resource); err != nil {
+		t.Fatal(err)
+	}
+	return resource
+}
+
+func TestSCIMFilterMatches(t *testing.T) {
+	resource := scimResource(t, `{
+		"userName": "Alice",
+		"active": true,
+		"emails": [{"value": "alice@example.com", "type": "work"}, {"value": "a@home.org", "type": "home"}],
+		"groups": [{"value": "admin", "display": "admin"}],
+		"meta": {"resourceType": "User"},
+		"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": {"role": "admin", "age": 30, "designation": ""}
+	}`)
+
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{`userName eq "alice"`, true},
+		{`USERNAME EQ "ALICE"`, true},
+		{`userName ne "alice"`, false},
+		{`userName ne "bob"`, true},
+		{`userName co "lic"`, true},
+		{`userName sw "al"`, true},
+		{`userName ew "ce"`, true},
+		{`userName sw "ce"`, false},
+		{`userName gt "aaa"`, true},
+		{`userName lt "aaa"`, false},
+		{`userName eq "al\"ice"`, false},
+		{`active eq true`, true},
+		{`active eq false`, false},
+		{`userName pr`, true},
+		{`title pr`, false},
+		{`title eq null`, true},
+		{`userName ne null`, true},
+		{`meta.resourceType eq "User"`, true},
+		{`emails.value ew "@home.org"`, true},
+		{`emails.type eq "other"`, false},
+		{`emails[type eq "work" and value co "example"]`, true},
+		{`emails[type eq "home" and value co "example"]`, false},
+		{`groups eq "admin"`, true},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "alice"`, true},
+		{`urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:role eq "admin"`, true},
+		{`urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:age ge 30`, true},
+		{`urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:age gt 30`, false},
+		{`urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:designation pr`, false},
+		{`not (userName eq "bob")`, true},
+		{`not (userName eq "alice")`, false},
+		// and binds tighter than or
+		{`userName eq "bob" or userName eq "alice" and active eq false`, false},
+		{`(userName eq "bob" or userName eq "alice") and active eq true`, true},
+		{`userName eq "alice" or userName eq "bob" and active eq false`, true},
+	}
+	for _, test := range tests {
+		t.Run(test.filter, func(t *testing.T) {
+			filter, err := ParseSCIMFilter(test.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := filter.Matches(resource); got != test.want {
+				t.Errorf("Matches = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSCIMFilterRejects(t *testing.T) {
+	tests := []string{
+		``,
+		`userName`,
+		`userName eq`,
+		`userName zz "alice"`,
+		`userName eq "alice`,
+		`userName eq alice`,
+		`(userName eq "alice"`,
+		`userName eq "alice")`,
+		`userName eq "alice" extra`,
+		`userName co 5`,
+		`userName gt true`,
+		`userName co null`,
+		`not userName eq "alice"`,
+		`emails[type eq "work"`,
+		`emails.value[type eq "work"]`,
+		`1name eq "alice"`,
+		`urn:example:unknown:User:name eq "alice"`,
+		`urn:ietf:params:scim:schemas:extension:enterprise:2.0:User eq "admin"`,
+		`userName eq "alice" and`,
+	}
+	for _, expression := range tests {
+		t.Run(expression, func(t *testing.T) {
+			if _, err := ParseSCIMFilter(expression); !errors.Is(err, ErrInvalidFilter) {
+				t.Errorf("ParseSCIMFilter error = %v, want %v", err, ErrInvalidFilter)
+			}
+		})
+	}
+}
+
+func TestParseSCIMPath(t *testing.T) {
+	tests := []struct {
+		path         string
+		schema       string
+		attribute    string
+		filter       bool
+		subAttribute string
+		wantErr      error
+	}{
+		{path: "userName", attribute: "userName"},
+		{path: "name.givenName", attribute: "name", subAttribute: "givenName"},
+		{path: `members[value eq "alice"]`, attribute: "members", filter: true},
+		{path: `members[value eq "alice"].display`, attribute: "members", filter: true, subAttribute: "display"},
+		{path: "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User", schema: "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"},
+		{path: "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:role", schema: "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User", attribute: "role"},
+		{path: "urn:ietf:params:scim:schemas:core:2.0:User:userName", attribute: "userName"},
+		{path: `members[value eq "alice"`, wantErr: ErrInvalidPath},
+		{path: `members[value eq "alice"] extra`, wantErr: ErrInvalidPath},
+		{path: `members[value eq "alice"].`, wantErr: ErrInvalidPath},
+		{path: "name.", wantErr: ErrInvalidPath},
+		{path: "", wantErr: ErrInvalidPath},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			path, err := ParseSCIMPath(test.path)
+			if !errors.Is(err, test.wantErr) || (test.wantErr == nil && err != nil) {
+				t.Fatalf("ParseSCIMPath error = %v, want %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if path.Schema != test.schema || path.Attribute != test.attribute || (path.Filter != nil) != test.filter || path.SubAttribute != test.subAttribute {
+				t.Errorf("ParseSCIMPath = %+v", path)
+			}
+		})
+	}
+}
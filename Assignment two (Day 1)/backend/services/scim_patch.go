@@ -0,0 +1,219 @@
+package services
+
+import (
+	"fmt"
+	"jwt-auth-system/backend/domain"
+	"slices"
+	"strings"
+)
+
+// ApplySCIMPatch applies PATCH operations (RFC 7644 section 3.5.2) in order to
+// a resource rendered as a JSON object. It changes resource in place, so
+// callers patch a copy and only keep it when every operation succeeded.
+func ApplySCIMPatch(resource map[string]interface{}, operations []domain.SCIMPatchOperation) error {
+	for i, operation := range operations {
+		if err := applySCIMOperation(resource, operation); err != nil {
+			return fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// applySCIMOperation applies one operation; without a path each member of the
+// value is applied as if it were the path
+func applySCIMOperation(resource map[string]interface{}, operation domain.SCIMPatchOperation) error {
+	op := strings.ToLower(operation.Op)
+	if op != "add" && op != "replace" && op != "remove" {
+		return fmt.Errorf("%w: unknown op %q", ErrInvalidPatch, operation.Op)
+	}
+
+	if operation.Path != "" {
+		path, err := ParseSCIMPath(operation.Path)
+		if err != nil {
+			return err
+		}
+		return applySCIMPath(resource, op, path, operation.Value)
+	}
+
+	if op == "remove" {
+		return fmt.Errorf("%w: remove needs a path", ErrNoTarget)
+	}
+	members, ok := operation.Value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%w: %s without a path needs an object value", ErrInvalidPatch, op)
+	}
+	for name, value := range members {
+		path, err := ParseSCIMPath(name)
+		if err != nil {
+			return err
+		}
+		if err := applySCIMPath(resource, op, path, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applySCIMPath applies add, replace or remove at one path
+func applySCIMPath(resource map[string]interface{}, op string, path *SCIMPath, value interface{}) error {
+	if op != "remove" && value == nil {
+		return fmt.Errorf("%w: %s %s needs a value", ErrInvalidPatch, op, path)
+	}
+
+	container := resource
+	if path.Schema != "" {
+		key := lookupKey(resource, path.Schema)
+		extension, _ := resource[key].(map[string]interface{})
+		if path.Attribute == "" {
+			if op == "remove" {
+				delete(resource, key)
+				return nil
+			}
+			if extension == nil {
+				extension = make(map[string]interface{})
+				resource[key] = extension
+			}
+			return mergeSCIMObject(extension, value, op)
+		}
+		if extension == nil {
+			if op == "remove" {
+				return nil
+			}
+			extension = make(map[string]interface{})
+			resource[key] = extension
+		}
+		container = extension
+	}
+
+	name := lookupKey(container, path.Attribute)
+	if path.Filter != nil {
+		return applySCIMValueFilter(container, name, op, path, value)
+	}
+
+	if path.SubAttribute != "" {
+		complexValue, _ := container[name].(map[string]interface{})
+		if complexValue == nil {
+			if op == "remove" {
+				return nil
+			}
+			complexValue = make(map[string]interface{})
+			container[name] = complexValue
+		}
+		sub := lookupKey(complexValue, path.SubAttribute)
+		if op == "remove" {
+			delete(complexValue, sub)
+		} else {
+			complexValue[sub] = value
+		}
+		return nil
+	}
+
+	current, exists := container[name]
+	switch op {
+	case "remove":
+		// A value on remove lists the elements of a multi-valued attribute to take out
+		elements, multiValued := current.([]interface{})
+		if value == nil || !multiValued {
+			delete(container, name)
+			return nil
+		}
+		unwanted := asSCIMList(value)
+		container[name] = slices.DeleteFunc(elements, func(element interface{}) bool {
+			return slices.ContainsFunc(unwanted, func(u interface{}) bool { return sameSCIMValue(element, u) })
+		})
+
+	case "add":
+		elements, multiValued := current.([]interface{})
+		if _, adding := value.([]interface{}); multiValued || (!exists && adding) {
+			// Adding to a multi-valued attribute appends the elements it lacks
+			for _, element := range asSCIMList(value) {
+				if !slices.ContainsFunc(elements, func(e interface{}) bool { return sameSCIMValue(e, element) }) {
+					elements = append(elements, element)
+				}
+			}
+			container[name] = elements
+			return nil
+		}
+		if complexValue, ok := current.(map[string]interface{}); ok {
+			return mergeSCIMObject(complexValue, value, op)
+		}
+		container[name] = value
+
+	case "replace":
+		if complexValue, ok := current.(map[string]interface{}); ok {
+			return mergeSCIMObject(complexValue, value, op)
+		}
+		container[name] = value
+	}
+	return nil
+}
+
+// applySCIMValueFilter applies an operation to the elements of a multi-valued
+// attribute that a path such as members[value eq "bob"] selects
+func applySCIMValueFilter(container map[string]interface{}, name, op string, path *SCIMPath, value interface{}) error {
+	elements, _ := container[name].([]interface{})
+	var kept []interface{}
+	matched := false
+	for _, element := range elements {
+		complexValue, ok := element.(map[string]interface{})
+		if !ok || !path.Filter.Matches(complexValue) {
+			kept = append(kept, element)
+			continue
+		}
+		matched = true
+
+		switch {
+		case path.SubAttribute != "" && op == "remove":
+			delete(complexValue, lookupKey(complexValue, path.SubAttribute))
+		case path.SubAttribute != "":
+			complexValue[lookupKey(complexValue, path.SubAttribute)] = value
+		case op == "remove":
+			continue
+		default:
+			if err := mergeSCIMObject(complexValue, value, op); err != nil {
+				return err
+			}
+		}
+		kept = append(kept, complexValue)
+	}
+	if !matched {
+		return fmt.Errorf("%w: %s", ErrNoTarget, path)
+	}
+	container[name] = kept
+	return nil
+}
+
+// mergeSCIMObject sets each member of value on a complex attribute, leaving
+// members value does not mention unchanged
+func mergeSCIMObject(target map[string]interface{}, value interface{}, op string) error {
+	members, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%w: %s of a complex attribute needs an object value", ErrInvalidPatch, op)
+	}
+	for name, member := range members {
+		target[lookupKey(target, name)] = member
+	}
+	return nil
+}
+
+// asSCIMList returns the elements of a multi-valued value, or the value as a single element
+func asSCIMList(value interface{}) []interface{} {
+	if elements, ok := value.([]interface{}); ok {
+		return elements
+	}
+	return []interface{}{value}
+}
+
+// sameSCIMValue reports whether two elements of a multi-valued attribute are
+// the same: complex elements are compared by their value sub-attribute
+func sameSCIMValue(a, b interface{}) bool {
+	return fmt.Sprint(scimElementValue(a)) == fmt.Sprint(scimElementValue(b))
+}
+
+// scimElementValue returns the value sub-attribute of a complex element, or the element itself
+func scimElementValue(element interface{}) interface{} {
+	if complexValue, ok := element.(map[string]interface{}); ok {
+		return complexValue[lookupKey(complexValue, "value")]
+	}
+	return element
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"jwt-auth-system/backend/domain"
+	"testing"
+)
+
+func TestApplySCIMPatch(t *testing.T) {
+	group := `{
+		"displayName": "admin",
+		"members": [{"value": "alice", "display": "Alice"}, {"value": "bob", "display": "bob"}]
+	}`
+	user := `{
+		"userName": "alice",
+		"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": {"role": "user", "designation": "dev"}
+	}`
+
+	tests := []struct {
+		name       string
+		resource   string
+		operations string
+		want       string
+		wantErr    error
+	}{
+		{"remove a member by value path", group,
+			`[{"op": "remove", "path": "members[value eq \"alice\"]"}]`,
+			`{"displayName": "admin", "members": [{"value": "bob", "display": "bob"}]}`, nil},
+		{"replace a sub-attribute of the matching member", group,
+			`[{"op": "replace", "path": "members[value eq \"bob\"].display", "value": "Bob"}]`,
+			`{"displayName": "admin", "members": [{"value": "alice", "display": "Alice"}, {"value": "bob", "display": "Bob"}]}`, nil},
+		{"merge into the matching member", group,
+			`[{"op": "replace", "path": "members[value eq \"alice\"]", "value": {"display": "A"}}]`,
+			`{"displayName": "admin", "members": [{"value": "alice", "display": "A"}, {"value": "bob", "display": "bob"}]}`, nil},
+		{"value path matches case-insensitively", group,
+			`[{"op": "remove", "path": "MEMBERS[VALUE eq \"ALICE\"]"}]`,
+			`{"displayName": "admin", "members": [{"value": "bob", "display": "bob"}]}`, nil},
+		{"value path with or removes every match", group,
+			`[{"op": "remove", "path": "members[value eq \"alice\" or value eq \"bob\"]"}]`,
+			`{"displayName": "admin", "members": null}`, nil},
+		{"add appends only new members", group,
+			`[{"op": "add", "path": "members", "value": [{"value": "carol"}, {"value": "bob"}]}]`,
+			`{"displayName": "admin", "members": [{"value": "alice", "display": "Alice"}, {"value": "bob", "display": "bob"}, {"value": "carol"}]}`, nil},
+		{"remove with a value takes out the listed members", group,
+			`[{"op": "remove", "path": "members", "value": [{"value": "alice"}]}]`,
+			`{"displayName": "admin", "members": [{"value": "bob", "display": "bob"}]}`, nil},
+		{"operations apply in order", group,
+			`[{"op": "add", "path": "members", "value": [{"value": "carol"}]}, {"op": "remove", "path": "members[value ne \"carol\"]"}]`,
+			`{"displayName": "admin", "members": [{"value": "carol"}]}`, nil},
+		{"extension attribute", user,
+			`[{"op": "replace", "path": "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:designation", "value": "lead"}]`,
+			`{"userName": "alice", "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": {"role": "user", "designation": "lead"}}`, nil},
+		{"operation without a path", user,
+			`[{"op": "replace", "value": {"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:role": "admin", "userName": "alice"}}]`,
+			`{"userName": "alice", "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": {"role": "admin", "designation": "dev"}}`, nil},
+		{"value path without a match", group,
+			`[{"op": "remove", "path": "members[value eq \"zed\"]"}]`, "", ErrNoTarget},
+		{"remove without a path", group,
+			`[{"op": "remove"}]`, "", ErrNoTarget},
+		{"unknown op", group,
+			`[{"op": "move", "path": "members"}]`, "", ErrInvalidPatch},
+		{"add without a value", group,
+			`[{"op": "add", "path": "members"}]`, "", ErrInvalidPatch},
+		{"merge needs an object", group,
+			`[{"op": "replace", "path": "members[value eq \"bob\"]", "value": "bob"}]`, "", ErrInvalidPatch},
+		{"invalid path", group,
+			`[{"op": "remove", "path": "members[value eq \"bob\""}]`, "", ErrInvalidPath},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resource := scimResource(t, test.resource)
+			var operations []domain.SCIMPatchOperation
+			if err := json.Unmarshal([]byte(test.operations), &operations); err != nil {
+				t.Fatal(err)
+			}
+
+			err := ApplySCIMPatch(resource, operations)
+			if !errors.Is(err, test.wantErr) || (test.wantErr == nil && err != nil) {
+				t.Fatalf("ApplySCIMPatch error = %v, want %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			got, _ := json.Marshal(resource)
+			want, _ := json.Marshal(scimResource(t, test.want))
+			if string(got) != string(want) {
+				t.Errorf("patched resource = %s, want %s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultDevSecret is the HMAC secret the server used to ship with; it is only
+// accepted in dev mode
+const DefaultDevSecret = "my-secret-key-change-in-production"
+
+// minSecretLength is the shortest HMAC secret accepted outside dev mode (256 bits)
+const minSecretLength = 32
+
+// ErrWeakSecret is returned when an HMAC secret is a known default or too short
+var ErrWeakSecret = errors.New("signing secret is a known default or shorter than 32 bytes")
+
+var knownWeakSecrets = []string{
+	DefaultDevSecret,
+	"secret",
+	"changeme",
+	"your-256-bit-secret",
+}
+
+// ReadSecret reads a secret from the environment variable name, or from the file
+// named by name_FILE (for example a mounted Docker or Kubernetes secret)
+func ReadSecret(name string) (string, error) {
+	if value := os.Getenv(name); value != "" {
+		return value, nil
+	}
+
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return "", nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// CheckSecretStrength rejects known default secrets and secrets that are too short
+func CheckSecretStrength(secret string) error {
+	for _, weak := range knownWeakSecrets {
+		if secret == weak {
+			return ErrWeakSecret
+		}
+	}
+	if len(secret) < minSecretLength {
+		return ErrWeakSecret
+	}
+	return nil
+}
+
+// LoadPEMSigningKey reads a PKCS#8, PKCS#1 or SEC 1 private key from a PEM file.
+// A PKIX public key gives a key that can only verify.
+func LoadPEMSigningKey(path, algorithm string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s does not contain a PEM block", path)
+	}
+
+	if block.Type == "PUBLIC KEY" {
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		return NewSigningKey(algorithm, nil, public)
+	}
+
+	private, err := parsePrivateKey(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	return NewSigningKey(algorithm, private, publicKeyOf(private))
+}
+
+func parsePrivateKey(block *pem.Block) (interface{}, error) {
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type: %s", block.Type)
+	}
+}
+
+func publicKeyOf(private interface{}) interface{} {
+	switch key := private.(type) {
+	case *rsa.PrivateKey:
+		return &key.PublicKey
+	case *ecdsa.PrivateKey:
+		return &key.PublicKey
+	case ed25519.PrivateKey:
+		return key.Public()
+	default:
+		return nil
+	}
+}
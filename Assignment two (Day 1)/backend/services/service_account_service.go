@@ -0,0 +1,259 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/repo"
+	"slices"
+	"strings"
+	"time"
+)
+
+// APIKeyPrefix starts every API key so leaked keys are easy to recognise and
+// scan for. A key reads sak_<key id>_<secret>.
+const APIKeyPrefix = "sak_"
+
+// ClientCredentialsRequest is an RFC 6749 section 4.4 client credentials grant
+// made by a service account with one of its API keys
+type ClientCredentialsRequest struct {
+	ServiceAccountID string
+	APIKey           string
+	Audience         string
+	Scope            string
+
+	// Confirmation binds the new token to the caller's DPoP key or TLS certificate
+	Confirmation *domain.Confirmation
+}
+
+// ServiceAccountService manages service accounts and their API keys and issues
+// their tokens
+type ServiceAccountService struct {
+	accounts      *repo.ServiceAccountRepository
+	tokenService  TokenService
+	config        TokenConfig
+	tokenLifetime time.Duration
+}
+
+// NewServiceAccountService creates a new service account service; its tokens
+// live for at most tokenLifetime, however long their audience allows
+func NewServiceAccountService(accounts *repo.ServiceAccountRepository, tokenService TokenService, config TokenConfig, tokenLifetime time.Duration) *ServiceAccountService {
+	return &ServiceAccountService{
+		accounts:      accounts,
+		tokenService:  tokenService,
+		config:        config,
+		tokenLifetime: tokenLifetime,
+	}
+}
+
+// CreateAccount creates a service account without any keys
+func (s *ServiceAccountService) CreateAccount(req domain.CreateServiceAccountRequest) (*domain.ServiceAccount, error) {
+	account := &domain.ServiceAccount{
+		ID:          "sa_" + randomToken(12),
+		Name:        req.Name,
+		Description: req.Description,
+		CreatedAt:   time.Now(),
+	}
+	if err := s.accounts.CreateAccount(account); err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Service Account Created: %s (%s)\n", account.ID, account.Name)
+	fmt.Println("---")
+
+	return account, nil
+}
+
+// GetAccount returns a service account
+func (s *ServiceAccountService) GetAccount(id string) (*domain.ServiceAccount, error) {
+	return s.accounts.GetAccount(id)
+}
+
+// ListAccounts returns every service account
+func (s *ServiceAccountService) ListAccounts() []*domain.ServiceAccount {
+	return s.accounts.ListAccounts()
+}
+
+// DeleteAccount removes a service account and its keys
+func (s *ServiceAccountService) DeleteAccount(id string) error {
+	return s.accounts.DeleteAccount(id)
+}
+
+// ListKeys returns the keys of a service account without their secrets
+func (s *ServiceAccountService) ListKeys(accountID string) ([]*domain.APIKey, error) {
+	return s.accounts.ListKeys(accountID)
+}
+
+// CreateKey issues an API key for the account and returns it; only its hash is stored
+func (s *ServiceAccountService) CreateKey(accountID string, scopes []string, expiresAt *time.Time) (*domain.APIKey, string, error) {
+	if len(scopes) == 0 {
+		return nil, "", errors.New("an API key needs at least one scope")
+	}
+	for _, scope := range scopes {
+		if scope == "" || !validScope(scope) {
+			return nil, "", fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", errors.New("expires_at must be in the future")
+	}
+
+	// Key IDs are hex so the _ after them unambiguously starts the secret
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", err
+	}
+	prefix := APIKeyPrefix + hex.EncodeToString(id)
+	secret := prefix + "_" + randomToken(32)
+
+	key := &domain.APIKey{
+		ID:               hex.EncodeToString(id),
+		ServiceAccountID: accountID,
+		Prefix:           prefix,
+		Hash:             hashAPIKey(secret),
+		Scopes:           slices.Clone(scopes),
+		CreatedAt:        time.Now(),
+		ExpiresAt:        expiresAt,
+	}
+	if err := s.accounts.AddKey(key); err != nil {
+		return nil, "", err
+	}
+
+	fmt.Printf("API Key Created: %s for %s (scopes: %q)\n", key.Prefix, accountID, strings.Join(scopes, " "))
+	fmt.Println("---")
+
+	return key, secret, nil
+}
+
+// RotateKey issues a replacement with the same scopes and revokes the old key
+// once the grace period is over
+func (s *ServiceAccountService) RotateKey(accountID, keyID string, grace time.Duration, expiresAt *time.Time) (*domain.APIKey, string, error) {
+	old, err := s.accounts.GetKey(keyID)
+	if err != nil || old.ServiceAccountID != accountID {
+		return nil, "", repo.ErrAPIKeyNotFound
+	}
+	if !old.Usable(time.Now()) {
+		return nil, "", fmt.Errorf("API key %s is already revoked or expired", old.Prefix)
+	}
+
+	key, secret, err := s.CreateKey(accountID, old.Scopes, expiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := s.revokeKey(accountID, keyID, time.Now().Add(grace)); err != nil {
+		return nil, "", err
+	}
+	return key, secret, nil
+}
+
+// RevokeKey revokes an API key at once; tokens it already obtained stay valid until they expire
+func (s *ServiceAccountService) RevokeKey(accountID, keyID string) (*domain.APIKey, error) {
+	return s.revokeKey(accountID, keyID, time.Now())
+}
+
+// revokeKey makes the key unusable from at, keeping an earlier revocation time
+func (s *ServiceAccountService) revokeKey(accountID, keyID string, at time.Time) (*domain.APIKey, error) {
+	key, err := s.accounts.UpdateKey(accountID, keyID, func(key *domain.APIKey) {
+		if key.RevokedAt == nil || key.RevokedAt.After(at) {
+			key.RevokedAt = &at
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("API Key Revoked: %s for %s (from %s)\n", key.Prefix, accountID, key.RevokedAt.Format(time.RFC3339))
+	fmt.Println("---")
+
+	return key, nil
+}
+
+// Authenticate checks an API key against the service account it must belong to
+// and records its use
+func (s *ServiceAccountService) Authenticate(accountID, apiKey string) (*domain.ServiceAccount, *domain.APIKey, error) {
+	account, key, err := s.AuthenticateKey(apiKey)
+	if err != nil || account.ID != accountID {
+		return nil, nil, ErrInvalidClient
+	}
+	return account, key, nil
+}
+
+// AuthenticateKey checks an API key presented on its own, as a bearer
+// credential, and records its use. The key ID inside it names the account.
+func (s *ServiceAccountService) AuthenticateKey(apiKey string) (*domain.ServiceAccount, *domain.APIKey, error) {
+	rest, found := strings.CutPrefix(apiKey, APIKeyPrefix)
+	keyID, _, _ := strings.Cut(rest, "_")
+	if !found || keyID == "" {
+		return nil, nil, ErrInvalidClient
+	}
+
+	key, err := s.accounts.GetKey(keyID)
+	if err != nil || subtle.ConstantTimeCompare(key.Hash, hashAPIKey(apiKey)) != 1 {
+		return nil, nil, ErrInvalidClient
+	}
+	now := time.Now()
+	if !key.Usable(now) {
+		return nil, nil, ErrInvalidClient
+	}
+	account, err := s.accounts.GetAccount(key.ServiceAccountID)
+	if err != nil {
+		return nil, nil, ErrInvalidClient
+	}
+
+	s.accounts.TouchKey(key.ID, now)
+	return account, key, nil
+}
+
+// ClientCredentials authenticates the service account and issues a short-lived
+// token carrying the requested scopes, or every scope of the key when none are requested
+func (s *ServiceAccountService) ClientCredentials(req ClientCredentialsRequest) (*ExchangeResult, error) {
+	account, key, err := s.Authenticate(req.ServiceAccountID, req.APIKey)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := strings.Fields(req.Scope)
+	if len(scopes) == 0 {
+		scopes = key.Scopes
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(key.Scopes, scope) {
+			return nil, fmt.Errorf("%w: %s for API key %s", ErrInvalidScope, scope, key.Prefix)
+		}
+	}
+
+	audience := req.Audience
+	if audience == "" {
+		audience = s.config.DefaultAudience
+	}
+	expiresIn := min(s.config.LifetimeFor(audience), s.tokenLifetime)
+
+	scope := strings.Join(scopes, " ")
+	token, err := s.tokenService.GenerateTokenWithOptions(nil, TokenOptions{
+		Audience:       audience,
+		Scope:          scope,
+		ServiceAccount: account.ID,
+		Confirmation:   req.Confirmation,
+		NotAfter:       time.Now().Add(expiresIn),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Client Credentials Token: %s (%s) with key %s (audience: %q, scope: %q)\n",
+		account.ID, account.Name, key.Prefix, audience, scope)
+	fmt.Println("---")
+
+	return &ExchangeResult{Token: token, Scope: scope, ExpiresIn: expiresIn}, nil
+}
+
+// hashAPIKey returns the SHA-256 of an API key. Keys are long random values,
+// so a fast hash is enough and lets them be checked on every request.
+func hashAPIKey(apiKey string) []byte {
+	sum := sha256.Sum256([]byte(apiKey))
+	return sum[:]
+}
@@ -0,0 +1,303 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"jwt-auth-system/backend/domain"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported signing algorithms
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgPS256 = "PS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+)
+
+// SigningKey holds the key material and the single algorithm it may be used with
+type SigningKey struct {
+	KeyID      string
+	Algorithm  string
+	PrivateKey interface{}
+	PublicKey  interface{}
+}
+
+// DefaultHMACKeyID is the key ID of a shared secret when none is configured
+const DefaultHMACKeyID = "shared-secret"
+
+// NewHMACSigningKey creates an HS256 signing key from a shared secret. The key
+// ID travels in every token header, so it is never derived from the secret.
+func NewHMACSigningKey(keyID, secret string) *SigningKey {
+	if keyID == "" {
+		keyID = DefaultHMACKeyID
+	}
+	return &SigningKey{
+		KeyID:      keyID,
+		Algorithm:  AlgHS256,
+		PrivateKey: []byte(secret),
+		PublicKey:  []byte(secret),
+	}
+}
+
+// GenerateSigningKey creates fresh key material for the given algorithm
+func GenerateSigningKey(algorithm string) (*SigningKey, error) {
+	var private, public interface{}
+
+	switch algorithm {
+	case AlgHS256:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		keyID := make([]byte, 8)
+		if _, err := rand.Read(keyID); err != nil {
+			return nil, err
+		}
+		return NewHMACSigningKey(base64.RawURLEncoding.EncodeToString(keyID), string(secret)), nil
+	case AlgRS256, AlgPS256:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		private, public = key, &key.PublicKey
+	case AlgES256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		private, public = key, &key.PublicKey
+	case AlgEdDSA:
+		pub, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		private, public = key, pub
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", algorithm)
+	}
+
+	return NewSigningKey(algorithm, private, public)
+}
+
+// NewSigningKey wraps existing asymmetric key material and derives its key ID.
+// private may be nil for a key that only verifies.
+func NewSigningKey(algorithm string, private, public interface{}) (*SigningKey, error) {
+	key := &SigningKey{
+		Algorithm:  algorithm,
+		PrivateKey: private,
+		PublicKey:  public,
+	}
+
+	if err := key.checkKeyType(); err != nil {
+		return nil, err
+	}
+
+	jwk, _ := key.PublicJWK()
+	kid, err := jwkThumbprint(jwk)
+	if err != nil {
+		return nil, err
+	}
+	key.KeyID = kid
+
+	return key, nil
+}
+
+// VerificationKeyFromJWK builds a verify-only key from a published JWK, keeping
+// its kid. Without an alg member the algorithm is inferred from the key type.
+func VerificationKeyFromJWK(jwk domain.JWK) (*SigningKey, error) {
+	var public interface{}
+	algorithm := jwk.Alg
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA exponent: %w", err)
+		}
+		public = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if algorithm == "" {
+			algorithm = AlgRS256
+		}
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve: %s", jwk.Crv)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+		y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if errX != nil || errY != nil {
+			return nil, fmt.Errorf("invalid EC coordinates")
+		}
+		point := append([]byte{4}, append(x, y...)...)
+		key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+		if err != nil {
+			return nil, err
+		}
+		public = key
+		if algorithm == "" {
+			algorithm = AlgES256
+		}
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || jwk.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key")
+		}
+		public = ed25519.PublicKey(x)
+		if algorithm == "" {
+			algorithm = AlgEdDSA
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", jwk.Kty)
+	}
+
+	key, err := NewSigningKey(algorithm, nil, public)
+	if err != nil {
+		return nil, err
+	}
+	if jwk.Kid != "" {
+		key.KeyID = jwk.Kid
+	}
+	return key, nil
+}
+
+// CanSign reports whether the key holds private (or shared) key material
+func (k *SigningKey) CanSign() bool {
+	return k.PrivateKey != nil
+}
+
+// Method returns the golang-jwt signing method for the key's algorithm
+func (k *SigningKey) Method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// IsSymmetric reports whether the key is a shared secret that must never be published
+func (k *SigningKey) IsSymmetric() bool {
+	return k.Algorithm == AlgHS256
+}
+
+// PublicJWK returns the public half of the key as a JWK; symmetric keys have none
+func (k *SigningKey) PublicJWK() (domain.JWK, bool) {
+	jwk, ok := publicJWK(k.PublicKey)
+	if !ok {
+		return domain.JWK{}, false
+	}
+
+	jwk.Use, jwk.Kid, jwk.Alg = "sig", k.KeyID, k.Algorithm
+	return jwk, true
+}
+
+// publicJWK encodes an RSA, P-256 or Ed25519 public key as a JWK without use, kid or alg
+func publicJWK(public interface{}) (domain.JWK, bool) {
+	var jwk domain.JWK
+
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encodeBigInt(pub.N)
+		jwk.E = encodeBigInt(big.NewInt(int64(pub.E)))
+	case *ecdsa.PublicKey:
+		jwk.Kty = "EC"
+		jwk.Crv = "P-256"
+		jwk.X = encodeFixed(pub.X, 32)
+		jwk.Y = encodeFixed(pub.Y, 32)
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	default:
+		return domain.JWK{}, false
+	}
+
+	return jwk, true
+}
+
+// checkKeyType makes sure the key material matches the declared algorithm,
+// looking at the public key when there is no private key
+func (k *SigningKey) checkKeyType() error {
+	ok := false
+	switch k.Algorithm {
+	case AlgRS256, AlgPS256:
+		if k.PrivateKey == nil {
+			_, ok = k.PublicKey.(*rsa.PublicKey)
+		} else {
+			_, ok = k.PrivateKey.(*rsa.PrivateKey)
+		}
+	case AlgES256:
+		var key *ecdsa.PublicKey
+		if k.PrivateKey == nil {
+			key, ok = k.PublicKey.(*ecdsa.PublicKey)
+		} else if private, isEC := k.PrivateKey.(*ecdsa.PrivateKey); isEC {
+			key, ok = &private.PublicKey, true
+		}
+		ok = ok && key.Curve == elliptic.P256()
+	case AlgEdDSA:
+		if k.PrivateKey == nil {
+			_, ok = k.PublicKey.(ed25519.PublicKey)
+		} else {
+			_, ok = k.PrivateKey.(ed25519.PrivateKey)
+		}
+	}
+
+	if !ok {
+		return fmt.Errorf("key material does not match algorithm %s", k.Algorithm)
+	}
+	return nil
+}
+
+// jwkThumbprint computes the RFC 7638 thumbprint used as the key ID
+func jwkThumbprint(jwk domain.JWK) (string, error) {
+	// Members must be in lexicographic order with no whitespace
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	default:
+		return "", fmt.Errorf("unsupported key type: %s", jwk.Kty)
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func encodeBigInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+func encodeFixed(n *big.Int, size int) string {
+	return base64.RawURLEncoding.EncodeToString(n.FillBytes(make([]byte, size)))
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestNewSigningKeyBindsAlgorithm(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		algorithm string
+		private   interface{}
+		public    interface{}
+		wantErr   bool
+	}{
+		{"RS256 with an RSA key", AlgRS256, rsaKey, &rsaKey.PublicKey, false},
+		{"PS256 with an RSA key", AlgPS256, rsaKey, &rsaKey.PublicKey, false},
+		{"RS256 verify-only", AlgRS256, nil, &rsaKey.PublicKey, false},
+		{"ES256 with a P-256 key", AlgES256, ecKey, &ecKey.PublicKey, false},
+		{"EdDSA with an Ed25519 key", AlgEdDSA, edKey, edPublic, false},
+		{"RSA public key as an HS256 secret", AlgHS256, nil, &rsaKey.PublicKey, true},
+		{"RSA private key as an HS256 secret", AlgHS256, rsaKey, &rsaKey.PublicKey, true},
+		{"RS256 with an EC key", AlgRS256, ecKey, &ecKey.PublicKey, true},
+		{"ES256 with an RSA key", AlgES256, nil, &rsaKey.PublicKey, true},
+		{"ES256 with a P-384 key", AlgES256, p384Key, &p384Key.PublicKey, true},
+		{"EdDSA with an EC key", AlgEdDSA, nil, &ecKey.PublicKey, true},
+		{"none", "none", nil, &rsaKey.PublicKey, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := NewSigningKey(test.algorithm, test.private, test.public)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("NewSigningKey error = %v, want error: %v", err, test.wantErr)
+			}
+			if err == nil && (key.Algorithm != test.algorithm || key.KeyID == "") {
+				t.Errorf("NewSigningKey = alg %q kid %q", key.Algorithm, key.KeyID)
+			}
+		})
+	}
+}
+
+// newSigningTestService returns a JWT service whose claims match validClaims
+func newSigningTestService(keyring *Keyring) *JWTService {
+	config := DefaultTokenConfig()
+	config.Issuer = "test-issuer"
+	config.Audiences = map[string]time.Duration{"api": 5 * time.Minute}
+	config.DefaultAudience = "api"
+	return NewJWTServiceWithConfig(keyring, config)
+}
+
+// signWith signs validClaims with method and key under the given header
+func signWith(t *testing.T, method jwt.SigningMethod, key interface{}, header map[string]interface{}) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, validClaims())
+	for name, value := range header {
+		token.Header[name] = value
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestAlgorithmConfusionIsRejected(t *testing.T) {
+	key, err := GenerateSigningKey(AlgRS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := newSigningTestService(NewKeyring(key, time.Hour))
+
+	// Whatever form of the public key an attacker uses as the HMAC secret, the
+	// kid binds the token to RS256
+	der, err := x509.MarshalPKIXPublicKey(key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	jwk, _ := key.PublicJWK()
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"HS256 keyed with the PEM public key", signWith(t, jwt.SigningMethodHS256, pemKey, map[string]interface{}{"kid": key.KeyID})},
+		{"HS256 keyed with the DER public key", signWith(t, jwt.SigningMethodHS256, der, map[string]interface{}{"kid": key.KeyID})},
+		{"HS256 keyed with the JWK modulus", signWith(t, jwt.SigningMethodHS256, []byte(jwk.N), map[string]interface{}{"kid": key.KeyID})},
+		{"PS256 with the RS256 key", signWith(t, jwt.SigningMethodPS256, key.PrivateKey, map[string]interface{}{"kid": key.KeyID})},
+		{"alg none", signWith(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, map[string]interface{}{"kid": key.KeyID})},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := tokens.ValidateToken(test.token); !errors.Is(err, ErrMalformedToken) {
+				t.Errorf("ValidateToken error = %v, want %v", err, ErrMalformedToken)
+			}
+		})
+	}
+
+	genuine := signWith(t, jwt.SigningMethodRS256, key.PrivateKey, map[string]interface{}{"kid": key.KeyID})
+	if _, err := tokens.ValidateToken(genuine); err != nil {
+		t.Errorf("ValidateToken of a genuine RS256 token = %v", err)
+	}
+}
+
+func TestVerificationKeyIsChosenByKid(t *testing.T) {
+	first, err := GenerateSigningKey(AlgES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := NewKeyring(first, time.Hour)
+	second, err := keyring.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := GenerateSigningKey(AlgES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := newSigningTestService(keyring)
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"signed by the active key", signWith(t, jwt.SigningMethodES256, second.PrivateKey, map[string]interface{}{"kid": second.KeyID}), nil},
+		{"signed by the verify-only key", signWith(t, jwt.SigningMethodES256, first.PrivateKey, map[string]interface{}{"kid": first.KeyID}), nil},
+		{"kid of the other key", signWith(t, jwt.SigningMethodES256, first.PrivateKey, map[string]interface{}{"kid": second.KeyID}), ErrMalformedToken},
+		{"unknown kid", signWith(t, jwt.SigningMethodES256, other.PrivateKey, map[string]interface{}{"kid": other.KeyID}), ErrMalformedToken},
+		{"no kid", signWith(t, jwt.SigningMethodES256, second.PrivateKey, nil), ErrMalformedToken},
+		{"kid that is not a string", signWith(t, jwt.SigningMethodES256, second.PrivateKey, map[string]interface{}{"kid": 7}), ErrMalformedToken},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := tokens.ValidateToken(test.token); !errors.Is(err, test.want) || (test.want == nil && err != nil) {
+				t.Errorf("ValidateToken error = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
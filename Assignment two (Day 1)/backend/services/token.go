@@ -0,0 +1,90 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"jwt-auth-system/backend/domain"
+	"time"
+)
+
+// TokenOptions customises a single issued token
+type TokenOptions struct {
+	// Audience selects the aud claim and lifetime; empty means the default audience
+	Audience string
+
+	// Scope is written to the scope claim as a space-separated list
+	Scope string
+
+	// ClientID records the client the token was issued to
+	ClientID string
+
+	// Actor records the delegation chain in the act claim
+	Actor *domain.Actor
+
+	// Confirmation binds the token to a client key through the cnf claim
+	Confirmation *domain.Confirmation
+
+	// NotAfter, when set, caps the expiry, e.g. at the expiry of an exchanged token
+	NotAfter time.Time
+
+	// Authentication records how and when the user authenticated in the amr,
+	// acr and auth_time claims
+	Authentication *domain.Authentication
+
+	// ServiceAccount issues the token to this service account instead of a
+	// user: sub and client_id name the account, and the user may be nil
+	ServiceAccount string
+}
+
+// TokenIssuer issues tokens carrying a user's claims
+type TokenIssuer interface {
+	GenerateToken(user *domain.User) (string, error)
+	GenerateTokenWithOptions(user *domain.User, opts TokenOptions) (string, error)
+}
+
+// TokenVerifier validates tokens and returns their claims
+type TokenVerifier interface {
+	ValidateToken(tokenString string) (*domain.Claims, error)
+	ValidateTokenForAudience(tokenString, audience string) (*domain.Claims, error)
+}
+
+// TokenService is a complete token format: issuing, validating and revoking.
+// JWTService and PasetoService both implement it.
+type TokenService interface {
+	TokenIssuer
+	TokenVerifier
+	RevokeToken(tokenString string)
+}
+
+// isValidationError reports whether err is already one of our typed validation errors
+func isValidationError(err error) bool {
+	for _, typed := range []error{
+		ErrMalformedToken, ErrTokenExpired, ErrNotYetValid, ErrMissingClaim,
+		ErrWrongIssuer, ErrWrongAudience, ErrWrongSubject, ErrTokenRevoked, ErrCannotDecrypt,
+		ErrNotEncrypted, ErrStaleToken, ErrAccountDisabled,
+	} {
+		if errors.Is(err, typed) {
+			return true
+		}
+	}
+	return false
+}
+
+// reportValidation logs the outcome of a validation to the console
+func reportValidation(claims *domain.Claims, err error) (*domain.Claims, error) {
+	if err != nil {
+		if !isValidationError(err) {
+			err = fmt.Errorf("%w: %v", ErrMalformedToken, err)
+		}
+		fmt.Println("Token Invalid:", err.Error())
+		return nil, err
+	}
+
+	// Print to console
+	fmt.Println("Token Valid")
+	fmt.Printf("Claims: {\"username\": \"%s\", \"role\": \"%s\", \"attributes\": %s}\n",
+		claims.Username, claims.Role, claims.Attributes)
+	fmt.Println("---")
+
+	return claims, nil
+}
@@ -0,0 +1,230 @@
+package services
+
+import (
+	"fmt"
+	"jwt-auth-system/backend/domain"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TokenConfig controls the registered claims put into tokens and how they are checked
+type TokenConfig struct {
+	// Issuer is written to iss and required on validation when set
+	Issuer string
+
+	// DefaultAudience is used when a token is requested without an audience
+	DefaultAudience string
+
+	// Audiences maps each accepted audience to the lifetime of its tokens. When
+	// empty, tokens carry no aud claim and the audience is not checked.
+	Audiences map[string]time.Duration
+
+	// DefaultLifetime applies to audiences without a lifetime of their own
+	DefaultLifetime time.Duration
+
+	// Leeway absorbs clock skew when checking exp and nbf
+	Leeway time.Duration
+
+	// RequireSubject rejects tokens whose sub claim does not match the username claim
+	RequireSubject bool
+
+	// EncryptFor maps an audience to the recipient key its tokens are encrypted
+	// for, producing a signed-then-encrypted (JWS inside JWE) token
+	EncryptFor map[string]*EncryptionKey
+
+	// DecryptionKeys are private keys for encrypted tokens addressed to this service
+	DecryptionKeys []*EncryptionKey
+
+	// Versions, when set, rejects tokens issued before the user's last account
+	// change and tokens of disabled users
+	Versions *TokenVersions
+
+	// Roles, when set, refuses to issue tokens for roles missing from the catalog
+	Roles *PermissionCatalog
+
+	// Attributes, when set, decides which user attributes are projected into
+	// tokens; sensitive and undeclared attributes are left out. When nil every
+	// attribute is included.
+	Attributes *AttributeSchema
+}
+
+// DefaultTokenConfig returns the settings the server uses when nothing is configured
+func DefaultTokenConfig() TokenConfig {
+	return TokenConfig{
+		Issuer:          "jwt-auth-system",
+		DefaultLifetime: TokenLifetime,
+		Leeway:          30 * time.Second,
+		RequireSubject:  true,
+	}
+}
+
+// LifetimeFor returns how long tokens for the audience stay valid
+func (c TokenConfig) LifetimeFor(audience string) time.Duration {
+	if lifetime, ok := c.Audiences[audience]; ok && lifetime > 0 {
+		return lifetime
+	}
+	return c.DefaultLifetime
+}
+
+// MaxLifetime returns the longest time any issued token may still be accepted,
+// which is how long a rotated signing key must stay verifiable
+func (c TokenConfig) MaxLifetime() time.Duration {
+	longest := c.DefaultLifetime
+	for _, lifetime := range c.Audiences {
+		if lifetime > longest {
+			longest = lifetime
+		}
+	}
+	return longest + c.Leeway
+}
+
+// newClaims builds the claims for a token issued to user, whatever its format
+func (c TokenConfig) newClaims(user *domain.User, opts TokenOptions) (*domain.Claims, error) {
+	audience := opts.Audience
+	if audience == "" {
+		audience = c.DefaultAudience
+	}
+	if len(c.Audiences) > 0 && !c.acceptsAudience(audience) {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownAudience, audience)
+	}
+
+	if opts.ServiceAccount == "" && c.Roles != nil && !c.Roles.HasRole(user.Role) {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownRole, user.Role)
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(c.LifetimeFor(audience))
+	if !opts.NotAfter.IsZero() && opts.NotAfter.Before(expiresAt) {
+		expiresAt = opts.NotAfter
+	}
+
+	claims := &domain.Claims{
+		Scope:    opts.Scope,
+		ClientID: opts.ClientID,
+		Act:      opts.Actor,
+		Cnf:      opts.Confirmation,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    c.Issuer,
+			ID:        randomToken(16),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+	if audience != "" {
+		claims.Audience = jwt.ClaimStrings{audience}
+	}
+
+	// RFC 9068 section 2.2: without a resource owner, sub identifies the client
+	if opts.ServiceAccount != "" {
+		claims.Subject = opts.ServiceAccount
+		claims.ClientID = opts.ServiceAccount
+		return claims, nil
+	}
+
+	claims.Username = user.Username
+	claims.Role = user.Role
+	claims.Subject = user.Username
+	claims.TokenVersion = user.TokenVersion
+	if auth := opts.Authentication; auth != nil {
+		claims.AMR = auth.Methods
+		claims.ACR = auth.Level
+		claims.AuthTime = jwt.NewNumericDate(auth.Time)
+	}
+	claims.Attributes = user.Attributes.Clone()
+	if c.Attributes != nil {
+		claims.Attributes = c.Attributes.TokenAttributes(user.Attributes)
+	}
+	return claims, nil
+}
+
+// checkClaims verifies iss, aud, sub and the token version against the configuration
+func (c TokenConfig) checkClaims(claims *domain.Claims, audiences []string) error {
+	if c.Issuer != "" && claims.Issuer != c.Issuer {
+		return fmt.Errorf("%w: %q", ErrWrongIssuer, claims.Issuer)
+	}
+
+	if len(audiences) > 0 && !slices.ContainsFunc(audiences, func(audience string) bool {
+		return slices.Contains(claims.Audience, audience)
+	}) {
+		return fmt.Errorf("%w: %v", ErrWrongAudience, []string(claims.Audience))
+	}
+
+	if c.RequireSubject && !claims.IsServiceAccount() && (claims.Subject == "" || claims.Subject != claims.Username) {
+		return fmt.Errorf("%w: %q", ErrWrongSubject, claims.Subject)
+	}
+
+	if c.Versions != nil {
+		return c.Versions.Check(claims)
+	}
+	return nil
+}
+
+// acceptedAudiences lists every audience ValidateToken accepts
+func (c TokenConfig) acceptedAudiences() []string {
+	audiences := make([]string, 0, len(c.Audiences))
+	for audience := range c.Audiences {
+		audiences = append(audiences, audience)
+	}
+	return audiences
+}
+
+// acceptsAudience reports whether tokens may be issued for or accepted from the audience
+func (c TokenConfig) acceptsAudience(audience string) bool {
+	_, ok := c.Audiences[audience]
+	return ok
+}
+
+// ParseAudienceLifetimes parses "api=5m,reports=1h,mobile" into per-audience
+// lifetimes; an audience without "=duration" gets the default lifetime
+func ParseAudienceLifetimes(spec string) (map[string]time.Duration, error) {
+	audiences := make(map[string]time.Duration)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, value, found := strings.Cut(entry, "=")
+		if !found {
+			audiences[name] = 0
+			continue
+		}
+
+		lifetime, err := time.ParseDuration(value)
+		if err != nil || lifetime <= 0 {
+			return nil, fmt.Errorf("invalid lifetime for audience %s: %q", name, value)
+		}
+		audiences[name] = lifetime
+	}
+	return audiences, nil
+}
+
+// decryptionKey finds a key able to decrypt tokens encrypted for kid
+func (c TokenConfig) decryptionKey(kid string) *EncryptionKey {
+	for _, key := range c.DecryptionKeys {
+		if key.KeyID == kid && key.PrivateKey != nil {
+			return key
+		}
+	}
+	for _, key := range c.EncryptFor {
+		if key.KeyID == kid && key.PrivateKey != nil {
+			return key
+		}
+	}
+	return nil
+}
+
+// checkEncrypted refuses a plain JWS addressed to an audience configured for
+// encryption, so its claims cannot be handed around in the clear
+func (c TokenConfig) checkEncrypted(claims *domain.Claims) error {
+	for _, audience := range claims.Audience {
+		if c.EncryptFor[audience] != nil {
+			return fmt.Errorf("%w: %s", ErrNotEncrypted, audience)
+		}
+	}
+	return nil
+}
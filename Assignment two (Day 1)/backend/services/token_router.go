@@ -0,0 +1,123 @@
+package services
+
+import (
+	"jwt-auth-system/backend/domain"
+	"time"
+)
+
+// expiryCleaner is a token service that keeps state about tokens until they expire
+type expiryCleaner interface {
+	CleanExpired()
+}
+
+// TokenRouter issues opaque tokens for selected audiences and clients and
+// self-contained tokens for everyone else, and sends each token to the
+// service that can validate it. The client's token format applies to tokens
+// issued to a client, i.e. with TokenOptions.ClientID set by the token
+// endpoint; tokens from /generate have no client, so only the audience decides.
+type TokenRouter struct {
+	selfContained TokenService
+	opaque        *OpaqueService
+	config        TokenConfig
+	clientService *ClientService
+
+	opaqueAudiences map[string]bool
+}
+
+// NewTokenRouter creates a router that uses opaque tokens for the given
+// audiences and for clients registered with the opaque token format
+func NewTokenRouter(selfContained TokenService, opaque *OpaqueService, config TokenConfig, clientService *ClientService, opaqueAudiences []string) *TokenRouter {
+	router := &TokenRouter{
+		selfContained:   selfContained,
+		opaque:          opaque,
+		config:          config,
+		clientService:   clientService,
+		opaqueAudiences: make(map[string]bool),
+	}
+	for _, audience := range opaqueAudiences {
+		router.opaqueAudiences[audience] = true
+	}
+	return router
+}
+
+// GenerateToken creates a new token for a given user
+func (r *TokenRouter) GenerateToken(user *domain.User) (string, error) {
+	return r.GenerateTokenWithOptions(user, TokenOptions{})
+}
+
+// GenerateTokenWithOptions creates a token in the format chosen for the audience or client
+func (r *TokenRouter) GenerateTokenWithOptions(user *domain.User, opts TokenOptions) (string, error) {
+	if r.usesOpaque(opts) {
+		return r.opaque.GenerateTokenWithOptions(user, opts)
+	}
+	return r.selfContained.GenerateTokenWithOptions(user, opts)
+}
+
+// ValidateToken validates a token of either format
+func (r *TokenRouter) ValidateToken(tokenString string) (*domain.Claims, error) {
+	return r.serviceFor(tokenString).ValidateToken(tokenString)
+}
+
+// ValidateTokenForAudience validates a token of either format for the given audience
+func (r *TokenRouter) ValidateTokenForAudience(tokenString, audience string) (*domain.Claims, error) {
+	return r.serviceFor(tokenString).ValidateTokenForAudience(tokenString, audience)
+}
+
+// RevokeToken revokes a token of either format
+func (r *TokenRouter) RevokeToken(tokenString string) {
+	r.serviceFor(tokenString).RevokeToken(tokenString)
+}
+
+// CleanExpired drops opaque token records and revocations of tokens that have expired
+func (r *TokenRouter) CleanExpired() {
+	r.opaque.CleanExpired()
+	if cleaner, ok := r.selfContained.(expiryCleaner); ok {
+		cleaner.CleanExpired()
+	}
+}
+
+// StartCleanup cleans expired token state every interval until the returned stop function is called
+func (r *TokenRouter) StartCleanup(interval time.Duration) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				r.CleanExpired()
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// usesOpaque reports whether a token with these options should be opaque
+func (r *TokenRouter) usesOpaque(opts TokenOptions) bool {
+	audience := opts.Audience
+	if audience == "" {
+		audience = r.config.DefaultAudience
+	}
+	if r.opaqueAudiences[audience] {
+		return true
+	}
+
+	if opts.ClientID != "" {
+		if client, err := r.clientService.GetClient(opts.ClientID); err == nil {
+			return client.TokenFormat == domain.TokenFormatOpaque
+		}
+	}
+	return false
+}
+
+// serviceFor picks the service that issued a token
+func (r *TokenRouter) serviceFor(tokenString string) TokenService {
+	if IsOpaqueToken(tokenString) {
+		return r.opaque
+	}
+	return r.selfContained
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"errors"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/repo"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// claimsSigner is a token format that can also sign hand-made claims
+type claimsSigner interface {
+	TokenService
+	SignClaims(claims *domain.Claims) (string, error)
+}
+
+// newTestFormats returns every token format, sharing one configuration whose
+// token versions come from users
+func newTestFormats(t *testing.T, users *repo.UserRepository) map[string]claimsSigner {
+	t.Helper()
+
+	config := DefaultTokenConfig()
+	config.Issuer = "test-issuer"
+	config.Audiences = map[string]time.Duration{"api": 5 * time.Minute}
+	config.DefaultAudience = "api"
+	config.Versions = NewTokenVersions(users, repo.NewServiceAccountRepository(), 0)
+
+	keyring := func(algorithm string) *Keyring {
+		key, err := GenerateSigningKey(algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewKeyring(key, time.Hour)
+	}
+
+	public, err := NewPasetoService(PasetoPublic, keyring(AlgEdDSA), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := NewPasetoService(PasetoLocal, keyring(AlgHS256), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]claimsSigner{
+		"jwt":           NewJWTServiceWithConfig(keyring(AlgHS256), config),
+		"paseto-public": public,
+		"paseto-local":  local,
+	}
+}
+
+// validClaims returns claims that every format accepts for alice
+func validClaims() *domain.Claims {
+	now := time.Now()
+	return &domain.Claims{
+		Username:     "alice",
+		Role:         "user",
+		TokenVersion: 1,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "test-issuer",
+			Subject:   "alice",
+			Audience:  jwt.ClaimStrings{"api"},
+			ID:        randomToken(16),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+}
+
+func TestTokenValidationAcrossFormats(t *testing.T) {
+	users := repo.NewUserRepository()
+	users.RegisterUser(&domain.User{Username: "alice", Role: "user", TokenVersion: 1})
+	users.RegisterUser(&domain.User{Username: "carol", Role: "user", Disabled: true})
+
+	tests := []struct {
+		name   string
+		modify func(claims *domain.Claims)
+		want   error
+	}{
+		{"valid", func(claims *domain.Claims) {}, nil},
+		{"expired", func(claims *domain.Claims) {
+			claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+		}, ErrTokenExpired},
+		{"expired within leeway", func(claims *domain.Claims) {
+			claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-5 * time.Second))
+		}, nil},
+		{"no expiry", func(claims *domain.Claims) {
+			claims.ExpiresAt = nil
+		}, ErrMissingClaim},
+		{"not yet valid", func(claims *domain.Claims) {
+			claims.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Hour))
+		}, ErrNotYetValid},
+		{"wrong issuer", func(claims *domain.Claims) {
+			claims.Issuer = "someone-else"
+		}, ErrWrongIssuer},
+		{"wrong audience", func(claims *domain.Claims) {
+			claims.Audience = jwt.ClaimStrings{"reports"}
+		}, ErrWrongAudience},
+		{"subject differs from username", func(claims *domain.Claims) {
+			claims.Subject = "mallory"
+		}, ErrWrongSubject},
+		{"stale token version", func(claims *domain.Claims) {
+			claims.TokenVersion = 0
+		}, ErrStaleToken},
+		{"token version ahead of the user's", func(claims *domain.Claims) {
+			claims.TokenVersion = 2
+		}, ErrStaleToken},
+		{"disabled user", func(claims *domain.Claims) {
+			claims.Username, claims.Subject = "carol", "carol"
+		}, ErrAccountDisabled},
+		{"deleted user", func(claims *domain.Claims) {
+			claims.Username, claims.Subject = "dave", "dave"
+		}, ErrAccountDisabled},
+	}
+
+	for format, service := range newTestFormats(t, users) {
+		for _, test := range tests {
+			t.Run(format+"/"+test.name, func(t *testing.T) {
+				claims := validClaims()
+				test.modify(claims)
+				token, err := service.SignClaims(claims)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				validated, err := service.ValidateToken(token)
+				if !errors.Is(err, test.want) || (test.want == nil && err != nil) {
+					t.Fatalf("ValidateToken error = %v, want %v", err, test.want)
+				}
+				if err == nil && (validated.Username != claims.Username || validated.ID != claims.ID) {
+					t.Errorf("claims = %+v, want %+v", validated, claims)
+				}
+			})
+		}
+
+		t.Run(format+"/revoked", func(t *testing.T) {
+			token, err := service.SignClaims(validClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := service.ValidateToken(token); err != nil {
+				t.Fatalf("before revocation: %v", err)
+			}
+
+			service.RevokeToken(token)
+			if _, err := service.ValidateToken(token); !errors.Is(err, ErrTokenRevoked) {
+				t.Fatalf("after revocation error = %v, want %v", err, ErrTokenRevoked)
+			}
+		})
+
+		t.Run(format+"/audience", func(t *testing.T) {
+			token, err := service.SignClaims(validClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := service.ValidateTokenForAudience(token, "api"); err != nil {
+				t.Errorf("ValidateTokenForAudience(api) = %v", err)
+			}
+			if _, err := service.ValidateTokenForAudience(token, "reports"); !errors.Is(err, ErrWrongAudience) {
+				t.Errorf("ValidateTokenForAudience(reports) = %v, want %v", err, ErrWrongAudience)
+			}
+		})
+
+		t.Run(format+"/tampered", func(t *testing.T) {
+			token, err := service.SignClaims(validClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			tampered := []byte(token)
+			tampered[len(tampered)/2] ^= 0x01
+			if _, err := service.ValidateToken(string(tampered)); !errors.Is(err, ErrMalformedToken) {
+				t.Errorf("ValidateToken(tampered) = %v, want %v", err, ErrMalformedToken)
+			}
+		})
+	}
+}
+
+func TestReregisteredUserRejectsOldTokens(t *testing.T) {
+	users := repo.NewUserRepository()
+	for format, service := range newTestFormats(t, users) {
+		t.Run(format, func(t *testing.T) {
+			signFor := func(user *domain.User) string {
+				claims := validClaims()
+				claims.TokenVersion = user.TokenVersion
+				token, err := service.SignClaims(claims)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return token
+			}
+
+			original := &domain.User{Username: "alice", Role: "user"}
+			if err := users.RegisterUser(original); err != nil {
+				t.Fatal(err)
+			}
+			old := signFor(original)
+			if _, err := service.ValidateToken(old); err != nil {
+				t.Fatalf("before deletion: %v", err)
+			}
+
+			// Deleting alice and registering the name afresh must not revive her tokens
+			if err := users.DeleteUser("alice", nil); err != nil {
+				t.Fatal(err)
+			}
+			fresh := &domain.User{Username: "alice", Role: "user"}
+			if err := users.RegisterUser(fresh); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := service.ValidateToken(old); !errors.Is(err, ErrStaleToken) {
+				t.Errorf("old token after re-registration: error = %v, want %v", err, ErrStaleToken)
+			}
+			if _, err := service.ValidateToken(signFor(fresh)); err != nil {
+				t.Errorf("new token after re-registration: %v", err)
+			}
+
+			users.DeleteUser("alice", nil)
+		})
+	}
+}
+
+func TestTokenFormatsRejectEachOther(t *testing.T) {
+	formats := newTestFormats(t, repo.NewUserRepository())
+	for issuer, issuing := range formats {
+		token, err := issuing.SignClaims(validClaims())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for verifier, verifying := range formats {
+			if verifier == issuer {
+				continue
+			}
+			if _, err := verifying.ValidateToken(token); !errors.Is(err, ErrMalformedToken) {
+				t.Errorf("%s accepted a %s token: %v", verifier, issuer, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"fmt"
+	"jwt-auth-system/backend/domain"
+	"jwt-auth-system/backend/repo"
+	"sync"
+	"time"
+)
+
+// TokenVersions checks the ver claim of a token against the user's current
+// token version, and that service accounts still exist. Lookups are cached for
+// a short TTL so validation does not hit the stores on every request.
+type TokenVersions struct {
+	users    *repo.UserRepository
+	accounts *repo.ServiceAccountRepository
+	ttl      time.Duration
+	cache    map[principal]userVersion
+	mu       sync.Mutex
+}
+
+// principal keys the cache, keeping users and service accounts apart
+type principal struct {
+	name           string
+	serviceAccount bool
+}
+
+// userVersion is a cached user lookup
+type userVersion struct {
+	version   int
+	active    bool
+	fetchedAt time.Time
+}
+
+// NewTokenVersions creates a version checker; a ttl of 0 looks the user up on every validation
+func NewTokenVersions(users *repo.UserRepository, accounts *repo.ServiceAccountRepository, ttl time.Duration) *TokenVersions {
+	return &TokenVersions{
+		users:    users,
+		accounts: accounts,
+		ttl:      ttl,
+		cache:    make(map[principal]userVersion),
+	}
+}
+
+// Check rejects tokens of disabled or deleted users and deleted service
+// accounts, and tokens whose ver is not the user's current token version
+func (v *TokenVersions) Check(claims *domain.Claims) error {
+	if claims.IsServiceAccount() {
+		if !v.lookup(principal{name: claims.Subject, serviceAccount: true}).active {
+			return fmt.Errorf("%w: service account %q", ErrAccountDisabled, claims.Subject)
+		}
+		return nil
+	}
+
+	current := v.lookup(principal{name: claims.Username})
+	if !current.active {
+		return fmt.Errorf("%w: %q", ErrAccountDisabled, claims.Username)
+	}
+	if claims.TokenVersion != current.version {
+		return fmt.Errorf("%w: version %d, current %d", ErrStaleToken, claims.TokenVersion, current.version)
+	}
+	return nil
+}
+
+// Forget drops the cached lookup for a user so a change takes effect at once
+func (v *TokenVersions) Forget(username string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	delete(v.cache, principal{name: username})
+}
+
+// ForgetServiceAccount drops the cached lookup for a service account
+func (v *TokenVersions) ForgetServiceAccount(id string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	delete(v.cache, principal{name: id, serviceAccount: true})
+}
+
+// lookup returns the principal's version from the cache, refreshing it once the TTL has passed
+func (v *TokenVersions) lookup(p principal) userVersion {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := time.Now()
+	if cached, ok := v.cache[p]; ok && now.Sub(cached.fetchedAt) < v.ttl {
+		return cached
+	}
+
+	current := userVersion{fetchedAt: now}
+	if p.serviceAccount {
+		_, err := v.accounts.GetAccount(p.name)
+		current.active = err == nil
+	} else if user, err := v.users.GetUser(p.name); err == nil {
+		current.version = user.TokenVersion
+		current.active = !user.Disabled
+	}
+	v.cache[p] = current
+	return current
+}